@@ -1,9 +1,12 @@
 package auth_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"testing"
+
+	"github.com/rogpeppe/misc/auth"
 )
 
 func BenchmarkAuthorizationWithAuthenticationMacaroon(b *testing.B) {
@@ -45,3 +48,17 @@ func BenchmarkAuthorizationWithAuthenticationMacaroon(b *testing.B) {
 		resp.Body.Close()
 	}
 }
+
+func BenchmarkCanonicalOps(b *testing.B) {
+	ops := make([]auth.Op, 0, 1000)
+	for i := 0; i < cap(ops); i++ {
+		ops = append(ops, auth.Op{
+			Entity: fmt.Sprintf("path-/e%d", i%500),
+			Action: "GET",
+		})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		auth.CanonicalOps(ops)
+	}
+}
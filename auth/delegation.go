@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"strconv"
+
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery/checkers"
+)
+
+// CondDelegationDepth is the name of the first party caveat condition
+// that limits how many more times a capability can be used to obtain
+// further capabilities. See ServiceParams.MaxDelegationDepth.
+//
+// The condition is interpreted by the Authorizer itself, so it is never
+// passed to ServiceParams.CaveatChecker.
+const CondDelegationDepth = "delegation-depth"
+
+// DelegationDepthCaveat returns a first party caveat that allows
+// a capability to be used to obtain further capabilities at most
+// depth times in succession. A depth of zero means that the
+// capability cannot be used to obtain any further capabilities.
+func DelegationDepthCaveat(depth int) checkers.Caveat {
+	return checkers.Caveat{
+		Condition: CondDelegationDepth + " " + strconv.Itoa(depth),
+	}
+}
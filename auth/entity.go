@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"strings"
+)
+
+// MatchEntity reports whether the given entity is matched by the given
+// entity pattern.
+//
+// Entity names often form a hierarchy separated by slashes, and by
+// convention an ACL held for an entity pattern covers all the entities
+// that the pattern matches, so that access can be granted to a whole
+// subtree at once. The package itself never looks up ACLs, so it is up
+// to the UserChecker to follow the convention if it wishes to.
+//
+// Within a pattern, a segment "*" matches any single non-empty segment
+// and a final segment "**" matches one or more trailing segments. A
+// pattern without any wildcard segments matches only the identical
+// entity.
+//
+// For example, "path-/bob/*" matches "path-/bob/child" but not
+// "path-/bob/child/grandchild", and "path-/bob/**" matches both,
+// but neither matches "path-/bob" itself.
+func MatchEntity(pattern, entity string) bool {
+	if pattern == entity {
+		return true
+	}
+	pelems := strings.Split(pattern, "/")
+	eelems := strings.Split(entity, "/")
+	for i, pe := range pelems {
+		if pe == "**" && i == len(pelems)-1 {
+			return len(eelems) > i && eelems[i] != ""
+		}
+		if i >= len(eelems) {
+			return false
+		}
+		switch pe {
+		case "*":
+			if eelems[i] == "" {
+				return false
+			}
+		case eelems[i]:
+		default:
+			return false
+		}
+	}
+	return len(pelems) == len(eelems)
+}
+
+// CoveringEntities returns the entity followed by the patterns
+// that cover it by replacing its final segment with "*" or
+// any number of its final segments with "**", most specific first.
+// This is useful when ACLs are held in a store that can only be
+// queried by exact name: the first pattern found in the store is
+// the one that applies.
+//
+// For example, CoveringEntities("path-/bob/child") returns
+//
+//	path-/bob/child
+//	path-/bob/*
+//	path-/bob/**
+//	path-/**
+//
+// Patterns with "*" in segments other than the last are
+// not returned.
+func CoveringEntities(entity string) []string {
+	elems := strings.Split(entity, "/")
+	if len(elems) == 1 {
+		return []string{entity}
+	}
+	patterns := []string{strings.Join(elems[:len(elems)-1], "/") + "/*"}
+	for i := len(elems) - 1; i > 0; i-- {
+		patterns = append(patterns, strings.Join(elems[:i], "/")+"/**")
+	}
+	entities := []string{entity}
+	for _, p := range patterns {
+		if MatchEntity(p, entity) {
+			entities = append(entities, p)
+		}
+	}
+	return entities
+}
@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/rogpeppe/misc/auth"
+)
+
+type entitySuite struct{}
+
+var _ = gc.Suite(&entitySuite{})
+
+var matchEntityTests = []struct {
+	pattern string
+	entity  string
+	expect  bool
+}{
+	{"path-/bob", "path-/bob", true},
+	{"path-/bob", "path-/bobby", false},
+	{"path-/bob", "path-/bob/child", false},
+	{"path-/bob/*", "path-/bob/child", true},
+	{"path-/bob/*", "path-/bob/child/grandchild", false},
+	{"path-/bob/*", "path-/bob", false},
+	{"path-/bob/*", "path-/bob/", false},
+	{"path-/bob/*", "path-/alice/child", false},
+	{"path-/*/child", "path-/bob/child", true},
+	{"path-/*/child", "path-/bob/other", false},
+	{"path-/*/*", "path-/bob/child", true},
+	{"path-/bob/**", "path-/bob/child", true},
+	{"path-/bob/**", "path-/bob/child/grandchild", true},
+	{"path-/bob/**", "path-/bob", false},
+	{"path-/bob/**", "path-/bob/", false},
+	{"path-/**", "path-/bob/child", true},
+	{"path-/*/**", "path-/bob/child/grandchild", true},
+	{"path-/*/**", "path-/bob", false},
+	// "**" is only special as the final segment.
+	{"path-/**/child", "path-/bob/child", false},
+	{"path-/**/child", "path-/**/child", true},
+}
+
+func (*entitySuite) TestMatchEntity(c *gc.C) {
+	for i, test := range matchEntityTests {
+		c.Logf("test %d: %q %q", i, test.pattern, test.entity)
+		c.Check(auth.MatchEntity(test.pattern, test.entity), gc.Equals, test.expect)
+	}
+}
+
+var coveringEntitiesTests = []struct {
+	entity string
+	expect []string
+}{{
+	entity: "login",
+	expect: []string{"login"},
+}, {
+	entity: "path-/bob",
+	expect: []string{"path-/bob", "path-/*", "path-/**"},
+}, {
+	entity: "path-/bob/child",
+	expect: []string{"path-/bob/child", "path-/bob/*", "path-/bob/**", "path-/**"},
+}, {
+	entity: "path-/",
+	expect: []string{"path-/"},
+}}
+
+func (*entitySuite) TestCoveringEntities(c *gc.C) {
+	for i, test := range coveringEntitiesTests {
+		c.Logf("test %d: %q", i, test.entity)
+		entities := auth.CoveringEntities(test.entity)
+		c.Check(entities, gc.DeepEquals, test.expect)
+		for _, e := range entities {
+			c.Check(auth.MatchEntity(e, test.entity), gc.Equals, true)
+		}
+	}
+}
+
+func (*authSuite) TestWildcardACL(c *gc.C) {
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker: allCheckers,
+		UserChecker: &aclUserChecker{ACLMap{
+			"path-/bob/*":      {"GET": {Everyone}},
+			"path-/alice/**":   {"GET": {Everyone}},
+			"path-/alice/priv": {"GET": {"alice"}},
+		}},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  newMacaroonStore(),
+	})
+	tests := []struct {
+		entity string
+		expect bool
+	}{
+		{"path-/bob/child", true},
+		{"path-/bob/child/grandchild", false},
+		{"path-/bob", false},
+		{"path-/alice/child", true},
+		{"path-/alice/child/grandchild", true},
+		// The most specific entry takes precedence.
+		{"path-/alice/priv", false},
+	}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.entity)
+		authorizer := service.NewAuthorizer(nil)
+		_, err := authorizer.Allow(context.TODO(), []auth.Op{{Entity: test.entity, Action: "GET"}})
+		if test.expect {
+			c.Check(err, gc.IsNil)
+			continue
+		}
+		_, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+		c.Check(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	}
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"fmt"
+
 	errgo "gopkg.in/errgo.v1"
 
 	"gopkg.in/macaroon-bakery.v2-unstable/bakery/checkers"
@@ -30,6 +32,16 @@ type DischargeRequiredError struct {
 	// Caveats holds the caveats that must be added
 	// to macaroons that authorize the above operations.
 	Caveats []checkers.Caveat
+
+	// MacaroonErrors holds an error for each of the macaroons
+	// provided to the Authorizer that could not be used, ordered
+	// by macaroon index. An HTTP server might respond with a 400
+	// Bad Request status when one has the cause
+	// ErrInvalidSignature rather than asking for a discharge
+	// that would not help, and a client that sees
+	// ErrMacaroonExpired knows that it should obtain a fresh
+	// discharge rather than retrying with the same macaroons.
+	MacaroonErrors []*MacaroonError
 }
 
 func (e *DischargeRequiredError) Error() string {
@@ -41,6 +53,52 @@ func isDischargeRequiredError(err error) bool {
 	return ok
 }
 
+// PermissionDeniedError is returned when an authenticated user has been
+// denied permission to perform some operations and no discharged
+// macaroon could change that. Its cause is ErrPermissionDenied.
+type PermissionDeniedError struct {
+	// DeniedOps holds the operations that were not allowed.
+	DeniedOps []Op
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return ErrPermissionDenied.Error()
+}
+
+// Cause implements errgo.Causer by returning ErrPermissionDenied,
+// so existing checks against errgo.Cause(err) continue to work.
+func (e *PermissionDeniedError) Cause() error {
+	return ErrPermissionDenied
+}
+
+func isPermissionDeniedError(err error) bool {
+	_, ok := err.(*PermissionDeniedError)
+	return ok
+}
+
+// MacaroonError describes why a macaroon provided to an Authorizer
+// could not be used. Its cause is ErrMacaroonExpired,
+// ErrInvalidSignature, ErrUnknownRootKey or
+// ErrMacaroonVersionTooOld when the reason is one of those.
+type MacaroonError struct {
+	// Index holds the index of the macaroon in the
+	// slice passed to Service.NewAuthorizer.
+	Index int
+
+	// Err holds the reason the macaroon could not be used.
+	Err error
+}
+
+func (e *MacaroonError) Error() string {
+	return fmt.Sprintf("macaroon %d: %v", e.Index, e.Err)
+}
+
+// Cause implements errgo.Causer by returning
+// the cause of the underlying error.
+func (e *MacaroonError) Cause() error {
+	return errgo.Cause(e.Err)
+}
+
 type verificationError struct {
 	error
 }
@@ -53,4 +111,27 @@ func isVerificationError(err error) bool {
 var (
 	ErrNotFound            = errgo.New("not found")
 	ErrCaveatResultUnknown = errgo.New("caveat result not known")
+	ErrVersionTooOld       = errgo.New("client version too old")
+
+	// ErrMacaroonExpired is the cause of a MacaroonError when a
+	// time-before caveat in the macaroon has expired.
+	ErrMacaroonExpired = errgo.New("macaroon expired")
+
+	// ErrInvalidSignature is the cause of a MacaroonError when
+	// the macaroon or its discharges could not be verified.
+	ErrInvalidSignature = errgo.New("macaroon signature invalid")
+
+	// ErrUnknownRootKey is the cause of a MacaroonError when
+	// the macaroon's root key could not be found.
+	ErrUnknownRootKey = errgo.New("macaroon root key not found")
+
+	// ErrMacaroonVersionTooOld is the cause of a MacaroonError when
+	// the macaroon's format is older than ServiceParams.MinVersion
+	// allows.
+	ErrMacaroonVersionTooOld = errgo.New("macaroon version too old")
+
+	// ErrDelegationDepthExceeded is the cause of the error returned
+	// when granting a capability would exceed the delegation depth
+	// of the capabilities used to obtain it.
+	ErrDelegationDepthExceeded = errgo.New("capability delegation depth exceeded")
 )
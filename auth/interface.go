@@ -33,3 +33,27 @@ type Identity interface {
 	// directly with the identity provider.
 	Domain() string
 }
+
+// Anonymous is the identity passed to UserChecker.Allow when the client
+// has not authenticated. It allows a UserChecker to make its own
+// decisions about unauthenticated access (for example to allow public
+// read access but not write access) rather than treating everything
+// that isn't public as equally unauthorized. Compare against it
+// directly to find out whether a user is anonymous:
+//
+//	if id == auth.Anonymous {
+//		...
+//	}
+//
+// Its Id and Domain methods both return the empty string.
+var Anonymous Identity = anonymous{}
+
+type anonymous struct{}
+
+func (anonymous) Id() string {
+	return ""
+}
+
+func (anonymous) Domain() string {
+	return ""
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+	errgo "gopkg.in/errgo.v1"
+	macaroon "gopkg.in/macaroon.v2-unstable"
+
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery"
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery/checkers"
+)
+
+// MemMacaroonStore is an in-memory implementation of MacaroonStore
+// that also knows how to create macaroons. It is suitable for
+// tests and small deployments where macaroons need not survive a
+// restart.
+//
+// Each macaroon id holds the operations associated with the
+// macaroon, so no per-macaroon state is stored.
+type MemMacaroonStore struct {
+	// Locator is used to find the public keys of third parties
+	// when adding third party caveats. If it is nil, only
+	// first party caveats may be added.
+	Locator bakery.ThirdPartyLocator
+
+	key *bakery.KeyPair
+
+	mu sync.Mutex
+	// rootKeys maps from root key id to root key.
+	rootKeys map[string][]byte
+	// currentId holds the id of the root key
+	// used for new macaroons.
+	currentId string
+	// nextId holds the id to be used for the next root key.
+	nextId int
+}
+
+// memMacaroonId holds the information encoded in
+// the id of a macaroon created by MemMacaroonStore.
+type memMacaroonId struct {
+	RootKeyId string
+	// Nonce makes the macaroon id unique even
+	// when the root key is shared.
+	Nonce []byte
+	Ops   []Op
+}
+
+// NewMemMacaroonStore returns a new MemMacaroonStore
+// with a newly generated root key.
+func NewMemMacaroonStore() (*MemMacaroonStore, error) {
+	key, err := bakery.GenerateKey()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot generate key")
+	}
+	s := &MemMacaroonStore{
+		key:      key,
+		rootKeys: make(map[string][]byte),
+	}
+	if err := s.RotateRootKey(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return s, nil
+}
+
+// RotateRootKey generates a new root key to be used
+// for all subsequently created macaroons. Macaroons
+// created with earlier root keys remain valid until
+// their root keys are removed with RemoveRootKeys.
+func (s *MemMacaroonStore) RotateRootKey() error {
+	rootKey := make([]byte, 24)
+	if _, err := rand.Read(rootKey); err != nil {
+		return errgo.Notef(err, "cannot generate root key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strconv.Itoa(s.nextId)
+	s.nextId++
+	s.rootKeys[id] = rootKey
+	s.currentId = id
+	return nil
+}
+
+// RemoveRootKeys removes all root keys except the current one,
+// invalidating all macaroons created before the most recent call
+// to RotateRootKey.
+func (s *MemMacaroonStore) RemoveRootKeys() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.rootKeys {
+		if id != s.currentId {
+			delete(s.rootKeys, id)
+		}
+	}
+}
+
+// NewMacaroon returns a new macaroon associated with the given
+// operations and with the given caveats added.
+func (s *MemMacaroonStore) NewMacaroon(ops []Op, caveats []checkers.Caveat) (*macaroon.Macaroon, error) {
+	return s.NewMacaroonWithVersion(ops, caveats, macaroon.LatestVersion)
+}
+
+// NewMacaroonWithVersion is like NewMacaroon except that
+// the macaroon is created with the given format version.
+func (s *MemMacaroonStore) NewMacaroonWithVersion(ops []Op, caveats []checkers.Caveat, version macaroon.Version) (*macaroon.Macaroon, error) {
+	s.mu.Lock()
+	rootKeyId, rootKey := s.currentId, s.rootKeys[s.currentId]
+	s.mu.Unlock()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	data, err := json.Marshal(memMacaroonId{
+		RootKeyId: rootKeyId,
+		Nonce:     nonce,
+		Ops:       ops,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	m, err := macaroon.New(rootKey, data, "", version)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, cav := range caveats {
+		if cav.Location != "" && s.Locator == nil {
+			return nil, errgo.Newf("cannot add third party caveat for %q: no locator", cav.Location)
+		}
+		if err := bakery.AddCaveat(s.key, s.Locator, m, cav); err != nil {
+			return nil, errgo.Notef(err, "cannot add caveat")
+		}
+	}
+	return m, nil
+}
+
+// MacaroonIdInfo implements MacaroonStore.MacaroonIdInfo.
+func (s *MemMacaroonStore) MacaroonIdInfo(ctxt context.Context, id []byte) (rootKey []byte, ops []Op, err error) {
+	var mid memMacaroonId
+	if err := json.Unmarshal(id, &mid); err != nil {
+		return nil, nil, errgo.Notef(err, "bad macaroon id")
+	}
+	s.mu.Lock()
+	rootKey, ok := s.rootKeys[mid.RootKeyId]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, errgo.WithCausef(nil, ErrNotFound, "cannot find root key %q", mid.RootKeyId)
+	}
+	return rootKey, mid.Ops, nil
+}
@@ -0,0 +1,117 @@
+package auth_test
+
+import (
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2-unstable"
+
+	"github.com/rogpeppe/misc/auth"
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2-unstable/httpbakery"
+)
+
+type memStoreSuite struct{}
+
+var _ = gc.Suite(&memStoreSuite{})
+
+func (*memStoreSuite) TestIdRoundTrip(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}, {Entity: "path-/alice", Action: "PUT"}}
+	m, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+
+	rootKey, gotOps, err := store.MacaroonIdInfo(context.TODO(), m.Id())
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotOps, gc.DeepEquals, ops)
+	err = m.Verify(rootKey, func(string) error { return nil }, nil)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*memStoreSuite) TestIdsAreUnique(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}}
+	m1, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+	m2, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(m1.Id()), gc.Not(gc.Equals), string(m2.Id()))
+}
+
+func (*memStoreSuite) TestNewMacaroonWithVersion(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	m, err := store.NewMacaroonWithVersion([]auth.Op{{Entity: "e", Action: "a"}}, nil, macaroon.V1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Version(), gc.Equals, macaroon.V1)
+}
+
+func (*memStoreSuite) TestBadId(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	_, _, err = store.MacaroonIdInfo(context.TODO(), []byte("bad id"))
+	c.Assert(err, gc.ErrorMatches, "bad macaroon id: .*")
+}
+
+func (*memStoreSuite) TestKeyRotation(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}}
+	oldM, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+	oldKey, _, err := store.MacaroonIdInfo(context.TODO(), oldM.Id())
+	c.Assert(err, gc.IsNil)
+
+	err = store.RotateRootKey()
+	c.Assert(err, gc.IsNil)
+	newM, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+	newKey, _, err := store.MacaroonIdInfo(context.TODO(), newM.Id())
+	c.Assert(err, gc.IsNil)
+	c.Assert(newKey, gc.Not(gc.DeepEquals), oldKey)
+
+	// Macaroons created with the old key remain valid.
+	rootKey, gotOps, err := store.MacaroonIdInfo(context.TODO(), oldM.Id())
+	c.Assert(err, gc.IsNil)
+	c.Assert(rootKey, gc.DeepEquals, oldKey)
+	c.Assert(gotOps, gc.DeepEquals, ops)
+
+	// Until the old keys are removed.
+	store.RemoveRootKeys()
+	_, _, err = store.MacaroonIdInfo(context.TODO(), oldM.Id())
+	c.Assert(err, gc.ErrorMatches, `cannot find root key "0"`)
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrNotFound)
+
+	rootKey, _, err = store.MacaroonIdInfo(context.TODO(), newM.Id())
+	c.Assert(err, gc.IsNil)
+	c.Assert(rootKey, gc.DeepEquals, newKey)
+}
+
+func (*memStoreSuite) TestThirdPartyCaveatWithoutLocator(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	_, err = store.NewMacaroon([]auth.Op{{Entity: "e", Action: "a"}}, []checkers.Caveat{{
+		Location:  "https://identity.example.com",
+		Condition: "is-authenticated-user",
+	}})
+	c.Assert(err, gc.ErrorMatches, `cannot add third party caveat for "https://identity.example.com": no locator`)
+}
+
+func (*authSuite) TestServiceWithMemMacaroonStore(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	locator := httpbakery.NewThirdPartyLocator(nil, nil)
+	locator.AllowInsecure()
+	store.Locator = locator
+	infoStore := auth.NewMacaroonInfoStore(store)
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}}
+	m, err := store.NewMacaroon(ops, []checkers.Caveat{checkers.DeclaredCaveat("username", "bob")})
+	c.Assert(err, gc.IsNil)
+
+	gotOps, conds, err := infoStore.MacaroonInfo(context.TODO(), macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotOps, gc.DeepEquals, ops)
+	c.Assert(conds, gc.HasLen, 1)
+}
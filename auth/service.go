@@ -45,7 +45,9 @@
 package auth
 
 import (
+	"fmt"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -60,6 +62,12 @@ import (
 
 var logger = loggo.GetLogger("bakery.auth")
 
+// Logger is used by the service to log information about
+// authorization decisions. It is implemented by loggo.Logger.
+type Logger interface {
+	Debugf(f string, a ...interface{})
+}
+
 // TODO think about a consistent approach to error reporting for macaroons.
 
 // TODO should we really pass in explicit expiry times on each call to Allow?
@@ -90,6 +98,121 @@ type ServiceParams struct {
 	// MacaroonStore is used to retrieve macaroon root keys
 	// and other associated information.
 	MacaroonStore MacaroonStore
+
+	// RevocationChecker is used to check whether a macaroon has
+	// been revoked before its expiry time. If it is nil, macaroons
+	// are never considered to be revoked.
+	RevocationChecker RevocationChecker
+
+	// Logger is used to log details of authorization decisions.
+	// If it is nil, the "bakery.auth" loggo logger is used.
+	Logger Logger
+
+	// RecheckMembership specifies that capabilities granted to
+	// an authenticated user should require a discharge from the
+	// identity service every time they are used, so that a
+	// capability granted because of the user's group membership
+	// stops working when the user is removed from the group.
+	// This costs a discharge round trip for each use of the
+	// capability, so it is off by default.
+	//
+	// When it is set, IdentityClient must implement MembershipCaveater
+	// and capabilities should be obtained with
+	// Authorizer.AllowCapabilityCaveats.
+	RecheckMembership bool
+
+	// MinVersion holds the lowest bakery protocol version that
+	// clients must support. Macaroons presented in a format older
+	// than the one used by that version are ignored (with a
+	// MacaroonError whose cause is ErrMacaroonVersionTooOld), and
+	// Service.MacaroonVersion returns an error for clients
+	// that advertise an older version. If it is zero, all
+	// versions are allowed.
+	MinVersion bakery.Version
+
+	// OnAuthorize, if non-nil, is called after every call to
+	// Authorizer.Allow or Authorizer.AllowAny with the operations
+	// that were requested, whether each one was allowed, the
+	// outcome of the call and how long it took. It can be used to
+	// record metrics such as counts of denied requests and
+	// authorization latency. It is called even when authorization
+	// fails with an error, in which case allowed may be nil.
+	OnAuthorize func(ops []Op, allowed []bool, outcome AuthorizeOutcome, d time.Duration)
+
+	// MaxDelegationDepth, if positive, limits how many times in
+	// succession a capability can be used to obtain a further
+	// capability. A capability that's granted without using another
+	// capability has a delegation depth of MaxDelegationDepth, and
+	// one granted by using capabilities has a depth one less than
+	// the smallest depth of those capabilities.
+	// AllowCapabilityCaveats refuses to grant a capability
+	// whose depth would be negative.
+	//
+	// The depth is recorded in a first party caveat (see
+	// DelegationDepthCaveat) in the conditions returned by
+	// AllowCapabilityCaveats, so capabilities that already have a
+	// depth remain limited even if MaxDelegationDepth is later
+	// set to zero.
+	MaxDelegationDepth int
+}
+
+// AuthorizeOutcome describes the result of an authorization request
+// as passed to ServiceParams.OnAuthorize.
+type AuthorizeOutcome int
+
+const (
+	// AuthorizeAllowed means that all the operations were allowed.
+	AuthorizeAllowed AuthorizeOutcome = iota
+
+	// AuthorizeDenied means that some operations were denied
+	// to an authenticated user (a *PermissionDeniedError was returned).
+	AuthorizeDenied
+
+	// AuthorizeDischargeRequired means that some operations
+	// require a discharge (a *DischargeRequiredError was returned).
+	AuthorizeDischargeRequired
+
+	// AuthorizeError means that authorization failed because
+	// of some other error.
+	AuthorizeError
+)
+
+var authorizeOutcomeNames = []string{
+	AuthorizeAllowed:           "allowed",
+	AuthorizeDenied:            "denied",
+	AuthorizeDischargeRequired: "discharge-required",
+	AuthorizeError:             "error",
+}
+
+func (o AuthorizeOutcome) String() string {
+	if o >= 0 && int(o) < len(authorizeOutcomeNames) {
+		return authorizeOutcomeNames[o]
+	}
+	return fmt.Sprintf("AuthorizeOutcome(%d)", int(o))
+}
+
+// MembershipCaveater may be implemented by an IdentityService to
+// support ServiceParams.RecheckMembership.
+type MembershipCaveater interface {
+	// MembershipCaveats returns third party caveats, addressed to
+	// the identity service, that will be discharged only if the given
+	// identity is still a member of the groups it belongs to now.
+	MembershipCaveats(id Identity) []checkers.Caveat
+}
+
+// RevocationChecker is used to find out whether a macaroon has
+// been revoked.
+//
+// Macaroons are identified by their id, so for revocation to
+// be useful, the MacaroonStore must create a unique id for every
+// macaroon (for example by including a random nonce), rather
+// than using the root key id alone, which may be shared
+// between many macaroons.
+type RevocationChecker interface {
+	// IsRevoked reports whether the macaroon with the given
+	// id has been revoked. It should return an error only when
+	// the revocation status cannot be determined.
+	IsRevoked(ctxt context.Context, id []byte) (bool, error)
 }
 
 // Op holds an entity and action to be authorized on that entity.
@@ -107,22 +230,109 @@ type Op struct {
 	Entity string
 }
 
+// CanonicalOps returns the given operations with duplicates removed,
+// sorted by action and then by entity, so that operations that share
+// an action are grouped together. The original slice is not modified.
+//
+// A capability may cover hundreds of entities, so it's a good idea to
+// use CanonicalOps on the operations before storing them with a
+// capability macaroon, which keeps the stored operations small and
+// means that equivalent capabilities always store identical
+// operations.
+func CanonicalOps(ops []Op) []Op {
+	canon := make([]Op, len(ops))
+	copy(canon, ops)
+	sort.Slice(canon, func(i, j int) bool {
+		if canon[i].Action != canon[j].Action {
+			return canon[i].Action < canon[j].Action
+		}
+		return canon[i].Entity < canon[j].Entity
+	})
+	j := 0
+	for i, op := range canon {
+		if i > 0 && op == canon[j-1] {
+			continue
+		}
+		canon[j] = op
+		j++
+	}
+	return canon[:j]
+}
+
 // MacaroonStore defines persistent storage for macaroon root keys.
+// See NewMemMacaroonStore for a simple in-memory implementation.
 type MacaroonStore interface {
 	// MacaroonIdInfo returns information on the id of a macaroon.
 	// TODO define some error type so we can distinguish storage errors
 	// from bad ids and macaroon-not-found errors.
-	// TODO this method isn't in a position to verify the macaroon
+	//
+	// This method isn't in a position to verify the macaroon
 	// because it only has the id, which means that the information
 	// in the id isn't verified before being acted on (for example to
-	// get associated operatons from a persistent store).
-	// Perhaps this method would be better as:
-	// 	MacaroonInfo(ctxt context.Context, m *macaroon.Macaroon) ([]Op, []string, error)
-	// where the method both verifies the macaroon and returns its caveat conditions
-	// and the associared operations.
+	// get associated operations from a persistent store).
+	// Stores that can do better should also implement
+	// MacaroonInfoStore.
 	MacaroonIdInfo(ctxt context.Context, id []byte) (rootKey []byte, ops []Op, err error)
 }
 
+// MacaroonInfoStore may optionally be implemented by a MacaroonStore
+// to verify macaroons before returning any information associated
+// with them. If the MacaroonStore provided to NewService implements
+// this interface, it is used in preference to MacaroonIdInfo.
+type MacaroonInfoStore interface {
+	// MacaroonInfo verifies the signature of the given macaroon
+	// and its discharges (ms[0] holds the primary macaroon) and
+	// returns the operations associated with it and the
+	// first party caveat conditions that must be checked.
+	// The caveat conditions themselves are not checked.
+	//
+	// The discharge macaroons are required because the primary
+	// macaroon cannot be verified without them.
+	//
+	// If the root key cannot be found, the returned error
+	// should have the cause ErrUnknownRootKey; if the
+	// macaroon cannot be verified, it should have the
+	// cause ErrInvalidSignature.
+	MacaroonInfo(ctxt context.Context, ms macaroon.Slice) (ops []Op, conditions []string, err error)
+}
+
+// NewMacaroonInfoStore returns a MacaroonInfoStore that uses the given
+// store. If the store does not implement MacaroonInfoStore, the
+// returned value obtains the root key and operations with
+// MacaroonIdInfo and then verifies the macaroon with the root key
+// before returning them.
+func NewMacaroonInfoStore(store MacaroonStore) MacaroonInfoStore {
+	if store, ok := store.(MacaroonInfoStore); ok {
+		return store
+	}
+	return idInfoStore{store}
+}
+
+// idInfoStore implements MacaroonInfoStore in terms of
+// MacaroonStore.MacaroonIdInfo.
+type idInfoStore struct {
+	store MacaroonStore
+}
+
+// MacaroonInfo implements MacaroonInfoStore.MacaroonInfo.
+func (s idInfoStore) MacaroonInfo(ctxt context.Context, ms macaroon.Slice) ([]Op, []string, error) {
+	if len(ms) == 0 {
+		return nil, nil, errgo.New("no macaroons in slice")
+	}
+	rootKey, ops, err := s.store.MacaroonIdInfo(ctxt, ms[0].Id())
+	if err != nil {
+		if errgo.Cause(err) == ErrNotFound {
+			return nil, nil, errgo.WithCausef(err, ErrUnknownRootKey, "cannot get macaroon id info")
+		}
+		return nil, nil, errgo.Notef(err, "cannot get macaroon id info")
+	}
+	conditions, err := verifyIgnoringCaveats(ms, rootKey)
+	if err != nil {
+		return nil, nil, errgo.WithCausef(err, ErrInvalidSignature, "cannot verify macaroon")
+	}
+	return ops, conditions, nil
+}
+
 // IdentityService represents the interactions of the authenticator with a
 // trusted third party identity service.
 type IdentityService interface {
@@ -163,24 +373,33 @@ type AuthInfo struct {
 type Service struct {
 	p             ServiceParams
 	caveatChecker bakery.FirstPartyCaveatChecker
+	infoStore     MacaroonInfoStore
 }
 
 func NewService(p ServiceParams) *Service {
+	if p.Logger == nil {
+		p.Logger = logger
+	}
 	checker := checkers.New(p.CaveatChecker)
 	return &Service{
 		p:             p,
 		caveatChecker: checker,
+		infoStore:     NewMacaroonInfoStore(p.MacaroonStore),
 	}
 }
 
 // UserChecker is used to check whether a given user is allowed
 // to perform a set of operations.
 type UserChecker interface {
-	// Allow checks whether the given identity (which will be nil
-	// when there is no authenticated user) is allowed to perform
-	// the given operations. It should return an error only when
-	// some underlying database operation has failed, not when the
-	// user has been denied access.
+	// Allow checks whether the given identity is allowed to perform
+	// the given operations. When there is no authenticated user, id
+	// will be Anonymous; it is never nil. It should return an error
+	// only when some underlying database operation has failed, not
+	// when the user has been denied access.
+	//
+	// If an operation is denied for the Anonymous identity, the
+	// client will be asked to authenticate; if it is denied for an
+	// authenticated user, ErrPermissionDenied will be returned.
 	//
 	// On success, each element of allowed holds whether the respective
 	// element of ops has been allowed, and caveats holds any additional
@@ -216,9 +435,22 @@ type Authorizer struct {
 	initOnce   sync.Once
 	initError  error
 	identity   Identity
+	// identityIndex holds the index into macaroons of the
+	// authentication macaroon that identity was taken from.
+	identityIndex int
 	// authIndexes holds for each potentially authorized operation
 	// the indexes of the macaroons that authorize it.
 	authIndexes map[Op][]int
+	// initErrors holds the errors for the macaroons
+	// that were found to be unusable by init.
+	initErrors []*MacaroonError
+
+	// mu guards userAllowed.
+	mu sync.Mutex
+	// userAllowed caches the results of calls to UserChecker.Allow
+	// for operations that did not require any extra caveats,
+	// so that repeated operations are only checked once.
+	userAllowed map[Op]bool
 }
 
 func (a *Authorizer) init(ctxt context.Context) error {
@@ -231,53 +463,114 @@ func (a *Authorizer) init(ctxt context.Context) error {
 func (a *Authorizer) initOnceFunc(ctxt context.Context) error {
 	a.authIndexes = make(map[Op][]int)
 	a.conditions = make([][]string, len(a.macaroons))
+	minVersion := bakery.MacaroonVersion(a.service.p.MinVersion)
 	for i, ms := range a.macaroons {
+		if err := ctxt.Err(); err != nil {
+			return err
+		}
 		if len(ms) == 0 {
 			continue
 		}
-		rootKey, ops, err := a.service.p.MacaroonStore.MacaroonIdInfo(ctxt, ms[0].Id())
-		if err != nil {
-			logger.Infof("cannot get macaroon id info for %q\n", ms[0].Id())
-			// TODO log error - if it's a storage error, return early here.
+		if ms[0].Version() < minVersion {
+			a.service.p.Logger.Debugf("ignoring macaroon %q with old version %v", ms[0].Id(), ms[0].Version())
+			a.initErrors = append(a.initErrors, &MacaroonError{
+				Index: i,
+				Err:   errgo.WithCausef(nil, ErrMacaroonVersionTooOld, "macaroon version %d is older than minimum version %d", ms[0].Version(), minVersion),
+			})
 			continue
 		}
-		conditions, err := verifyIgnoringCaveats(ms, rootKey)
+		ops, conditions, err := a.service.infoStore.MacaroonInfo(ctxt, ms)
 		if err != nil {
-			logger.Infof("cannot verify %q: %v\n", ms[0].Id())
-			// TODO log verification error
+			if err := ctxt.Err(); err != nil {
+				// The store probably failed because the context
+				// was cancelled, so the macaroon may be fine.
+				return err
+			}
+			a.service.p.Logger.Debugf("cannot get macaroon info for %q: %v", ms[0].Id(), err)
+			// TODO if it's a storage error, return early here.
+			a.initErrors = append(a.initErrors, &MacaroonError{
+				Index: i,
+				Err:   err,
+			})
 			continue
 		}
+		if rc := a.service.p.RevocationChecker; rc != nil {
+			revoked, err := rc.IsRevoked(ctxt, ms[0].Id())
+			if err != nil {
+				if err := ctxt.Err(); err != nil {
+					return err
+				}
+				return errgo.Notef(err, "cannot check macaroon revocation")
+			}
+			if revoked {
+				a.service.p.Logger.Debugf("macaroon %q has been revoked", ms[0].Id())
+				continue
+			}
+		}
 		// It's a valid macaroon (in principle - we haven't checked first party caveats).
 		if len(ops) == 1 && ops[0] == LoginOp {
 			// It's an authn macaroon
 			declared, err := a.checkConditions(ctxt, LoginOp, conditions)
 			if err != nil {
-				logger.Infof("caveat check failed, id %q: %v\n", ms[0].Id(), err)
-				// TODO log error
+				if err := ctxt.Err(); err != nil {
+					return err
+				}
+				a.service.p.Logger.Debugf("caveat check failed, id %q: %v", ms[0].Id(), err)
+				a.initErrors = append(a.initErrors, &MacaroonError{
+					Index: i,
+					Err:   err,
+				})
 				continue
 			}
 			if a.identity != nil {
-				logger.Infof("duplicate authentication macaroon")
+				a.service.p.Logger.Debugf("duplicate authentication macaroon")
 				// TODO log duplicate authn-macaroon error
 				continue
 			}
 			identity, err := a.service.p.IdentityClient.DeclaredIdentity(declared)
 			if err != nil {
-				logger.Infof("cannot decode declared identity: %v", err)
+				a.service.p.Logger.Debugf("cannot decode declared identity: %v", err)
 				// TODO log user-decode error
 				continue
 			}
 			a.identity = identity
+			a.identityIndex = i
+			a.conditions[i] = conditions
+			continue
 		}
 		a.conditions[i] = conditions
 		for _, op := range ops {
+			if op == LoginOp {
+				// LoginOp cannot be combined with other operations
+				// in the same macaroon, so ignore it if it is.
+				continue
+			}
 			a.authIndexes[op] = append(a.authIndexes[op], i)
 		}
 	}
-	logger.Infof("after init, identity: %#v, authIndexes %v", a.identity, a.authIndexes)
+	a.service.p.Logger.Debugf("after init, identity: %#v, authIndexes %v", a.identity, a.authIndexes)
 	return nil
 }
 
+// MacaroonVersion returns the macaroon format that should be used
+// when minting macaroons for a client that supports the given bakery
+// protocol version (as returned by httpbakery.RequestVersion, for
+// example). This is the latest format supported by both the client
+// and the service.
+//
+// If the client's version is older than ServiceParams.MinVersion,
+// MacaroonVersion returns an error with an ErrVersionTooOld cause
+// rather than a format that would be ignored when presented.
+func (s *Service) MacaroonVersion(clientVersion bakery.Version) (macaroon.Version, error) {
+	if clientVersion < s.p.MinVersion {
+		return 0, errgo.WithCausef(nil, ErrVersionTooOld, "client bakery version %d is older than minimum version %d", clientVersion, s.p.MinVersion)
+	}
+	if clientVersion > bakery.LatestVersion {
+		clientVersion = bakery.LatestVersion
+	}
+	return bakery.MacaroonVersion(clientVersion), nil
+}
+
 // Allow checks that the authorizer's request is authorized to
 // perform all the given operations. Note that Allow does not check
 // first party caveats - if there is more than one macaroon that may
@@ -295,7 +588,14 @@ func (a *Authorizer) initOnceFunc(ctxt context.Context) error {
 // If an operation was not allowed, an error will be returned which may
 // be *DischargeRequiredError holding the operations that remain to
 // be authorized in order to allow authorization to
-// proceed.
+// proceed, or *PermissionDeniedError holding the operations that
+// the authenticated user is not allowed to perform.
+//
+// If the context is cancelled or its deadline passes before the
+// decision is made, Allow returns early with an error whose cause is
+// the context's error. The macaroons are only examined once, so an
+// Authorizer interrupted while doing that will continue to return the
+// same error.
 func (a *Authorizer) Allow(ctxt context.Context, ops []Op) (*AuthInfo, error) {
 	authInfo, _, err := a.AllowAny(ctxt, ops)
 	if err != nil {
@@ -313,9 +613,12 @@ type authInfo struct {
 // operations as possible without requiring any to be authorized. If all
 // the operations succeeded, the returned error and slice will be nil.
 //
-// If any the operations failed, the returned error will be the same
+// If any of the operations failed, the returned error will be the same
 // that Allow would return and each element in the returned slice will
-// hold whether its respective operation was allowed.
+// hold whether its respective operation was allowed. This means that a
+// caller performing a bulk operation can go ahead with the allowed
+// operations without the failure of the others aborting the whole
+// request.
 //
 // If all the operations succeeded, the returned slice will be nil.
 //
@@ -324,10 +627,45 @@ type authInfo struct {
 // The LoginOp operation is treated specially - it is always required if
 // present in ops.
 func (a *Authorizer) AllowAny(ctxt context.Context, ops []Op) (*AuthInfo, []bool, error) {
+	onAuthorize := a.service.p.OnAuthorize
+	var t0 time.Time
+	if onAuthorize != nil {
+		t0 = time.Now()
+	}
 	authed, used, err := a.allowAny(ctxt, ops)
+	if onAuthorize != nil {
+		allowed := authed
+		if err == nil && allowed == nil {
+			allowed = make([]bool, len(ops))
+			for i := range allowed {
+				allowed[i] = true
+			}
+		}
+		onAuthorize(ops, allowed, authorizeOutcome(err), time.Since(t0))
+	}
 	return a.newAuthInfo(used), authed, err
 }
 
+// isContextError reports whether err is one of the errors
+// returned by context.Context.Err.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// authorizeOutcome returns the outcome corresponding
+// to an error returned from allowAny.
+func authorizeOutcome(err error) AuthorizeOutcome {
+	switch {
+	case err == nil:
+		return AuthorizeAllowed
+	case isPermissionDeniedError(err):
+		return AuthorizeDenied
+	case isDischargeRequiredError(err):
+		return AuthorizeDischargeRequired
+	}
+	return AuthorizeError
+}
+
 func (a *Authorizer) newAuthInfo(used []bool) *AuthInfo {
 	info := &AuthInfo{
 		Identity:  a.identity,
@@ -347,23 +685,36 @@ func (a *Authorizer) newAuthInfo(used []bool) *AuthInfo {
 // have been used in the authorization.
 func (a *Authorizer) allowAny(ctxt context.Context, ops []Op) (authed, used []bool, err error) {
 	if err := a.init(ctxt); err != nil {
-		return nil, nil, errgo.Mask(err)
+		return nil, nil, errgo.Mask(err, isContextError)
 	}
-	logger.Infof("after authorizer init, identity %#v", a.identity)
+	a.service.p.Logger.Debugf("after authorizer init, identity %#v", a.identity)
 	used = make([]bool, len(a.macaroons))
 	authed = make([]bool, len(ops))
 	numAuthed := 0
+	// failed holds the errors from any authorization
+	// macaroons that failed their caveat checks.
+	var failed []*MacaroonError
 	for i, op := range ops {
-		if op == LoginOp && len(ops) > 1 {
-			// LoginOp cannot be combined with other operations in the
-			// same macaroon, so ignore it if it is.
+		if op == LoginOp {
+			// LoginOp can only be authorized by the authentication
+			// macaroon, whose caveats have already been checked by init.
+			if a.identity != nil {
+				authed[i] = true
+				numAuthed++
+			}
 			continue
 		}
 		for _, mindex := range a.authIndexes[op] {
 			_, err := a.checkConditions(ctxt, op, a.conditions[mindex])
 			if err != nil {
-				logger.Infof("caveat check failed: %v", err)
-				// log error?
+				if err := ctxt.Err(); err != nil {
+					return nil, nil, err
+				}
+				a.service.p.Logger.Debugf("caveat check failed: %v", err)
+				failed = append(failed, &MacaroonError{
+					Index: mindex,
+					Err:   err,
+				})
 				continue
 			}
 			authed[i] = true
@@ -375,17 +726,8 @@ func (a *Authorizer) allowAny(ctxt context.Context, ops []Op) (authed, used []bo
 	if a.identity != nil {
 		// We've authenticated as a user, so even if the operations didn't
 		// specifically require it, we add the authn macaroon and its
-		// conditions to the macaroons used and its con
-		indexes := a.authIndexes[LoginOp]
-		if len(indexes) == 0 {
-			// Should never happen because init ensures it's there.
-			panic("no macaroon info found for login op")
-		}
-		// Note: because we never issue a macaroon which combines LoginOp
-		// with other operations, if the login op macaroon is used, we
-		// know that it's already checked out successfully with LoginOp,
-		// so no need to check again.
-		used[indexes[0]] = true
+		// conditions to the macaroons used.
+		used[a.identityIndex] = true
 	}
 	if numAuthed == len(ops) {
 		// All operations allowed.
@@ -394,23 +736,29 @@ func (a *Authorizer) allowAny(ctxt context.Context, ops []Op) (authed, used []bo
 	// There are some unauthorized operations.
 	need := make([]Op, 0, len(ops)-numAuthed)
 	needIndex := make([]int, cap(need))
+	needLogin := false
 	for i, ok := range authed {
-		if !ok {
+		switch {
+		case ok:
+		case ops[i] == LoginOp:
+			// The user checker can't authorize LoginOp.
+			needLogin = true
+		default:
 			needIndex[len(need)] = i
 			need = append(need, ops[i])
 		}
 	}
-	logger.Infof("operations needed after authz macaroons: %#v", need)
+	a.service.p.Logger.Debugf("operations needed after authz macaroons: %#v", need)
 	// Try to authorize the operations even even if we haven't got an authenticated user.
-	oks, caveats, err := a.service.p.UserChecker.Allow(ctxt, a.identity, need)
+	oks, caveats, err := a.userAllow(ctxt, need)
 	if err != nil {
-		return authed, used, errgo.Notef(err, "cannot check permissions")
-	}
-	if len(oks) != len(need) {
-		return authed, used, errgo.Newf("unexpected slice length returned from Allow (got %d; want %d)", len(oks), len(need))
+		return authed, used, errgo.Mask(err, isContextError)
 	}
 
-	stillNeed := make([]Op, 0, len(need))
+	stillNeed := make([]Op, 0, len(need)+1)
+	if needLogin {
+		stillNeed = append(stillNeed, LoginOp)
+	}
 	for i, ok := range oks {
 		if ok {
 			authed[needIndex[i]] = true
@@ -422,25 +770,116 @@ func (a *Authorizer) allowAny(ctxt context.Context, ops []Op) (authed, used []bo
 		// No more ops need to be authenticated and no caveats to be discharged.
 		return authed, used, nil
 	}
-	logger.Infof("operations still needed after auth check: %#v", stillNeed)
+	a.service.p.Logger.Debugf("operations still needed after auth check: %#v", stillNeed)
 	if a.identity == nil {
 		// User hasn't authenticated - ask them to do so.
 		return authed, used, &DischargeRequiredError{
-			Message: "authentication required",
-			Ops:     []Op{LoginOp},
-			Caveats: a.service.p.IdentityClient.IdentityCaveats(),
+			Message:        "authentication required",
+			Ops:            []Op{LoginOp},
+			Caveats:        a.service.p.IdentityClient.IdentityCaveats(),
+			MacaroonErrors: a.macaroonErrors(failed, used),
 		}
 	}
 	if len(caveats) == 0 {
-		return authed, used, ErrPermissionDenied
+		return authed, used, &PermissionDeniedError{
+			DeniedOps: stillNeed,
+		}
 	}
 	return authed, used, &DischargeRequiredError{
-		Message: "some operations have extra caveats",
-		Ops:     ops,
-		Caveats: caveats,
+		Message:        "some operations have extra caveats",
+		Ops:            ops,
+		Caveats:        caveats,
+		MacaroonErrors: a.macaroonErrors(failed, used),
 	}
 }
 
+// macaroonErrors returns the errors found by init together with
+// the given caveat check failures, ordered by macaroon index. Only
+// the first error for each macaroon is included, and macaroons
+// that were used to authorize some other operation are omitted.
+func (a *Authorizer) macaroonErrors(failed []*MacaroonError, used []bool) []*MacaroonError {
+	var errs []*MacaroonError
+	seen := make(map[int]bool)
+	for _, errs1 := range [][]*MacaroonError{a.initErrors, failed} {
+		for _, err := range errs1 {
+			if seen[err.Index] || used[err.Index] {
+				continue
+			}
+			seen[err.Index] = true
+			errs = append(errs, err)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Index < errs[j].Index
+	})
+	return errs
+}
+
+// userAllow is like UserChecker.Allow except that each distinct
+// operation is only checked once for the lifetime of the authorizer,
+// unless the check produced extra caveats.
+func (a *Authorizer) userAllow(ctxt context.Context, ops []Op) ([]bool, []checkers.Caveat, error) {
+	a.mu.Lock()
+	known := make(map[Op]bool)
+	queryIndex := make(map[Op]int)
+	var query []Op
+	for _, op := range ops {
+		if allowed, ok := a.userAllowed[op]; ok {
+			known[op] = allowed
+			continue
+		}
+		if _, ok := queryIndex[op]; !ok {
+			queryIndex[op] = len(query)
+			query = append(query, op)
+		}
+	}
+	a.mu.Unlock()
+	var oks []bool
+	var caveats []checkers.Caveat
+	if len(query) > 0 {
+		if err := ctxt.Err(); err != nil {
+			return nil, nil, err
+		}
+		id := a.identity
+		if id == nil {
+			id = Anonymous
+		}
+		var err error
+		oks, caveats, err = a.service.p.UserChecker.Allow(ctxt, id, query)
+		if err != nil {
+			if err := ctxt.Err(); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, errgo.Notef(err, "cannot check permissions")
+		}
+		if len(oks) != len(query) {
+			return nil, nil, errgo.Newf("unexpected slice length returned from Allow (got %d; want %d)", len(oks), len(query))
+		}
+	}
+	allowed := make([]bool, len(ops))
+	for i, op := range ops {
+		if j, ok := queryIndex[op]; ok {
+			allowed[i] = oks[j]
+		} else {
+			allowed[i] = known[op]
+		}
+	}
+	if len(caveats) == 0 && len(query) > 0 {
+		// The caveats aren't associated with any particular
+		// operation, so we can only cache the results when
+		// there are none.
+		a.mu.Lock()
+		if a.userAllowed == nil {
+			a.userAllowed = make(map[Op]bool)
+		}
+		for op, j := range queryIndex {
+			a.userAllowed[op] = oks[j]
+		}
+		a.mu.Unlock()
+	}
+	return allowed, caveats, nil
+}
+
 // AllowCapability checks that the user is allowed to perform all the
 // given operations. If not, the error will be as returned from Allow.
 //
@@ -450,7 +889,33 @@ func (a *Authorizer) allowAny(ctxt context.Context, ops []Op) (authed, used []bo
 //
 // If ops contains LoginOp, the user must have been authenticated with a
 // macaroon associated with the single operation LoginOp only.
+//
+// The operations stored with the capability macaroon should be
+// those returned by CanonicalOps(ops) (without LoginOp).
+//
+// If ServiceParams.RecheckMembership is set, AllowCapability returns
+// an error if any third party caveats are required; use
+// AllowCapabilityCaveats instead.
 func (a *Authorizer) AllowCapability(ctxt context.Context, ops []Op) ([]string, error) {
+	conditions, caveats, err := a.AllowCapabilityCaveats(ctxt, ops)
+	if err != nil {
+		if isPermissionDeniedError(err) {
+			return nil, err
+		}
+		return nil, errgo.Mask(err, isDischargeRequiredError, isContextError, errgo.Is(ErrDelegationDepthExceeded))
+	}
+	if len(caveats) > 0 {
+		return nil, errgo.Newf("capability requires third party caveats")
+	}
+	return conditions, nil
+}
+
+// AllowCapabilityCaveats is like AllowCapability except that it also
+// returns any third party caveats that must be added to a macaroon
+// granting the capability. Currently the only such caveats are
+// those that re-check group membership when
+// ServiceParams.RecheckMembership is set.
+func (a *Authorizer) AllowCapabilityCaveats(ctxt context.Context, ops []Op) ([]string, []checkers.Caveat, error) {
 	nops := 0
 	for _, op := range ops {
 		if op != LoginOp {
@@ -458,12 +923,16 @@ func (a *Authorizer) AllowCapability(ctxt context.Context, ops []Op) ([]string,
 		}
 	}
 	if nops == 0 {
-		return nil, errgo.Newf("no non-login operations required in capability")
+		return nil, nil, errgo.Newf("no non-login operations required in capability")
 	}
-	_, used, err := a.allowAny(ctxt, ops)
+	// There's no need to check the same operation more than once.
+	_, used, err := a.allowAny(ctxt, CanonicalOps(ops))
 	if err != nil {
-		logger.Infof("allowAny returned used %v; err %v", used, err)
-		return nil, errgo.Mask(err, isDischargeRequiredError)
+		a.service.p.Logger.Debugf("allowAny returned used %v; err %v", used, err)
+		if isPermissionDeniedError(err) {
+			return nil, nil, err
+		}
+		return nil, nil, errgo.Mask(err, isDischargeRequiredError, isContextError)
 	}
 	var squasher caveatSquasher
 	for i, isUsed := range used {
@@ -474,7 +943,19 @@ func (a *Authorizer) AllowCapability(ctxt context.Context, ops []Op) ([]string,
 			squasher.add(cond)
 		}
 	}
-	return squasher.final(), nil
+	var caveats []checkers.Caveat
+	if a.service.p.RecheckMembership && a.identity != nil {
+		mc, ok := a.service.p.IdentityClient.(MembershipCaveater)
+		if !ok {
+			return nil, nil, errgo.Newf("identity client does not support membership caveats")
+		}
+		caveats = mc.MembershipCaveats(a.identity)
+	}
+	conditions, err := squasher.final(a.service.p.MaxDelegationDepth)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrDelegationDepthExceeded))
+	}
+	return conditions, caveats, nil
 }
 
 // caveatSquasher rationalizes first party caveats created for a capability
@@ -484,10 +965,16 @@ func (a *Authorizer) AllowCapability(ctxt context.Context, ops []Op) ([]string,
 //	virtue of the operations associated with the macaroon).
 //	- removing declared caveats.
 //	- removing duplicates.
+//	- replacing delegation-depth caveats with a single
+//	caveat holding a depth one less than the smallest.
 type caveatSquasher struct {
 	expiry time.Time
-	prev   string
-	conds  []string
+	// depth holds the smallest delegation depth
+	// found. It is only valid if hasDepth is true.
+	depth    int
+	hasDepth bool
+	prev     string
+	conds    []string
 }
 
 func (c *caveatSquasher) add(cond string) {
@@ -502,12 +989,25 @@ func (c *caveatSquasher) add(cond string) {
 	}
 }
 
-func (c *caveatSquasher) final() []string {
+// final returns the squashed conditions. If no delegation-depth
+// caveat has been added, the conditions hold a delegation depth of
+// maxDepth, or no depth at all if maxDepth is zero. It returns an
+// error with an ErrDelegationDepthExceeded cause if a condition with
+// a depth of zero has been added.
+func (c *caveatSquasher) final(maxDepth int) ([]string, error) {
 	if !c.expiry.IsZero() {
 		c.conds = append(c.conds, checkers.TimeBeforeCaveat(c.expiry).Condition)
 	}
+	switch {
+	case c.hasDepth && c.depth <= 0:
+		return nil, errgo.WithCausef(nil, ErrDelegationDepthExceeded, "capability delegation depth exceeded")
+	case c.hasDepth:
+		c.conds = append(c.conds, DelegationDepthCaveat(c.depth-1).Condition)
+	case maxDepth > 0:
+		c.conds = append(c.conds, DelegationDepthCaveat(maxDepth).Condition)
+	}
 	if len(c.conds) == 0 {
-		return nil
+		return nil, nil
 	}
 	// Make deterministic and eliminate duplicates.
 	sort.Strings(c.conds)
@@ -520,7 +1020,7 @@ func (c *caveatSquasher) final() []string {
 			j++
 		}
 	}
-	return c.conds
+	return c.conds, nil
 }
 
 func (c *caveatSquasher) add0(cond string) bool {
@@ -540,6 +1040,18 @@ func (c *caveatSquasher) add0(cond string) bool {
 			c.expiry = et
 		}
 		return false
+	case CondDelegationDepth:
+		depth, err := strconv.Atoi(args)
+		if err != nil || depth < 0 {
+			// Be safe - if we can't parse the depth,
+			// don't allow any further delegation.
+			depth = 0
+		}
+		if !c.hasDepth || depth < c.depth {
+			c.depth = depth
+			c.hasDepth = true
+		}
+		return false
 	case checkers.CondAllow,
 		checkers.CondDeny,
 		checkers.CondDeclared:
@@ -549,12 +1061,21 @@ func (c *caveatSquasher) add0(cond string) bool {
 }
 
 func (a *Authorizer) checkConditions(ctxt context.Context, op Op, conds []string) (map[string]string, error) {
-	logger.Infof("checking conditions %q", conds)
+	a.service.p.Logger.Debugf("checking conditions %q", conds)
 	declared := checkers.InferDeclaredFromConditions(conds)
 	ctxt = checkers.ContextWithOperations(ctxt, op.Action)
 	ctxt = checkers.ContextWithDeclared(ctxt, declared)
 	for _, cond := range conds {
+		name, _, _ := checkers.ParseCaveat(cond)
+		if name == CondDelegationDepth {
+			// The delegation depth only restricts the
+			// granting of capabilities, not their use.
+			continue
+		}
 		if err := a.service.caveatChecker.CheckFirstPartyCaveat(ctxt, cond); err != nil {
+			if name == checkers.CondTimeBefore {
+				return nil, errgo.WithCausef(err, ErrMacaroonExpired, "")
+			}
 			return nil, errgo.Mask(err)
 		}
 	}
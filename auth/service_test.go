@@ -210,6 +210,74 @@ func (*authSuite) TestCombineCapabilities(c *gc.C) {
 	h.assertSuccess(c, resp, "GET", "/alice")
 }
 
+func (*authSuite) TestCapabilityDelegationDepth(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker: allCheckers,
+		UserChecker: &aclUserChecker{ACLMap{
+			"path-/bob": {"GET": {"bob"}},
+		}},
+		IdentityClient:     testIdentityClient{},
+		MacaroonStore:      store,
+		MaxDelegationDepth: 1,
+	})
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}}
+	newMacaroon := func(ops []auth.Op, conds []string) macaroon.Slice {
+		m, err := store.NewMacaroon(ops, nil)
+		c.Assert(err, gc.IsNil)
+		for _, cond := range conds {
+			err := m.AddFirstPartyCaveat(cond)
+			c.Assert(err, gc.IsNil)
+		}
+		return macaroon.Slice{m}
+	}
+	loginMacaroon := newMacaroon([]auth.Op{auth.LoginOp}, []string{
+		checkers.DeclaredCaveat("username", "bob").Condition,
+	})
+
+	// A capability granted to an authenticated user
+	// gets the maximum delegation depth.
+	conds, err := service.NewAuthorizer([]macaroon.Slice{loginMacaroon}).AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	c.Assert(conds, gc.DeepEquals, []string{auth.DelegationDepthCaveat(1).Condition})
+	cap1 := newMacaroon(ops, conds)
+
+	// The capability can be used on its own...
+	authorizer := service.NewAuthorizer([]macaroon.Slice{cap1})
+	_, err = authorizer.Allow(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+
+	// ... and to obtain a further capability with a smaller depth.
+	conds, err = authorizer.AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	c.Assert(conds, gc.DeepEquals, []string{auth.DelegationDepthCaveat(0).Condition})
+	cap2 := newMacaroon(ops, conds)
+
+	// That capability can be used but not delegated further.
+	authorizer = service.NewAuthorizer([]macaroon.Slice{cap2})
+	_, err = authorizer.Allow(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	_, err = authorizer.AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.ErrorMatches, `capability delegation depth exceeded`)
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrDelegationDepthExceeded)
+
+	// The depth of an existing capability is still enforced
+	// when there is no maximum.
+	service = auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    &aclUserChecker{ACLMap{}},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	_, err = service.NewAuthorizer([]macaroon.Slice{cap2}).AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.ErrorMatches, `capability delegation depth exceeded`)
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrDelegationDepthExceeded)
+	conds, err = service.NewAuthorizer([]macaroon.Slice{cap1}).AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	c.Assert(conds, gc.DeepEquals, []string{auth.DelegationDepthCaveat(0).Condition})
+}
+
 func (*authSuite) TestAuthnWithAuthz(c *gc.C) {
 	h := testHandler{}
 	s := newTestServers(h, ACLMap{
@@ -228,6 +296,74 @@ func (*authSuite) TestAuthnWithAuthz(c *gc.C) {
 	h.assertSuccess(c, resp, "GET", "/bob")
 }
 
+func (*authSuite) TestRevokedCapability(c *gc.C) {
+	h := testHandler{}
+	s := newTestServers(h, ACLMap{
+		"path-/bob": {
+			"GET": {"bob"},
+		},
+	})
+	defer s.Close()
+
+	ms := getCapability(c, s.idmSrv.Client("bob"), "GET", s.svc.URL+"/bob")
+	resp := doWithCapabilities(c, http.DefaultClient, "GET", s.svc.URL+"/bob", ms)
+	h.assertSuccess(c, resp, "GET", "/bob")
+
+	// Once the capability has been revoked, it should
+	// no longer authorize the request.
+	s.revoked.revoke(ms[0].Id())
+	resp = doWithCapabilities(c, http.DefaultClient, "GET", s.svc.URL+"/bob", ms)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Not(gc.Equals), successBody("GET", "/bob"))
+	c.Assert(resp.StatusCode, gc.Not(gc.Equals), http.StatusOK)
+
+	// A new capability is still OK.
+	ms = getCapability(c, s.idmSrv.Client("bob"), "GET", s.svc.URL+"/bob")
+	resp = doWithCapabilities(c, http.DefaultClient, "GET", s.svc.URL+"/bob", ms)
+	h.assertSuccess(c, resp, "GET", "/bob")
+}
+
+func (*authSuite) TestMacaroonInfoStoreAdapter(c *gc.C) {
+	store := newMacaroonStore()
+	infoStore := auth.NewMacaroonInfoStore(store)
+	ops := []auth.Op{{Entity: "path-/bob", Action: "GET"}}
+	m, err := store.NewMacaroon(ops, nil)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat("some condition")
+	c.Assert(err, gc.IsNil)
+
+	gotOps, conds, err := infoStore.MacaroonInfo(context.TODO(), macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotOps, gc.DeepEquals, ops)
+	c.Assert(conds, gc.DeepEquals, []string{"some condition"})
+
+	// A macaroon with the same id but the wrong root key
+	// should not be trusted.
+	forged, err := macaroon.New([]byte("wrong key"), m.Id(), "", macaroon.LatestVersion)
+	c.Assert(err, gc.IsNil)
+	gotOps, _, err = infoStore.MacaroonInfo(context.TODO(), macaroon.Slice{forged})
+	c.Assert(err, gc.ErrorMatches, "cannot verify macaroon: .*")
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrInvalidSignature)
+	c.Assert(gotOps, gc.IsNil)
+}
+
+func (*authSuite) TestNewMacaroonInfoStoreUsesStoreImplementation(c *gc.C) {
+	store := verifyingMacaroonStore{newMacaroonStore()}
+	c.Assert(auth.NewMacaroonInfoStore(store), gc.Equals, auth.MacaroonInfoStore(store))
+}
+
+// verifyingMacaroonStore implements auth.MacaroonInfoStore
+// as well as auth.MacaroonStore.
+type verifyingMacaroonStore struct {
+	*macaroonStore
+}
+
+func (s verifyingMacaroonStore) MacaroonInfo(ctxt context.Context, ms macaroon.Slice) ([]auth.Op, []string, error) {
+	return nil, nil, errgo.New("not implemented")
+}
+
 func (*authSuite) TestAuthWithThirdPartyCaveats(c *gc.C) {
 	checked := 0
 	thirdParty := bakerytest.NewDischarger(nil, httpbakery.ThirdPartyCheckerFunc(
@@ -259,12 +395,600 @@ func (*authSuite) TestAuthWithThirdPartyCaveats(c *gc.C) {
 	c.Assert(checked, gc.Equals, 1)
 }
 
+func (*authSuite) TestCapabilityWithMembershipRecheck(c *gc.C) {
+	checked := 0
+	thirdParty := bakerytest.NewDischarger(nil, httpbakery.ThirdPartyCheckerFunc(
+		func(req *http.Request, info *bakery.ThirdPartyCaveatInfo) ([]checkers.Caveat, error) {
+			checked++
+			c.Check(info.Condition, gc.Equals, "is-still-member bob")
+			return nil, nil
+		},
+	))
+	defer thirdParty.Close()
+	idmSrv := idmtest.NewServer()
+	defer idmSrv.Close()
+	h := testHandler{}
+	svc := newAuthHTTPService(h, ACLMap{
+		"path-/bob": {
+			"GET": {"bob"},
+		},
+	}, auth.ServiceParams{
+		IdentityClient: membershipIdmClientShim{
+			idmClientShim: idmClientShim{idmSrv.IDMClient("auth-user")},
+			location:      thirdParty.Location(),
+		},
+		CaveatChecker:     allCheckers,
+		RecheckMembership: true,
+	})
+	defer svc.Close()
+
+	ms := getCapability(c, idmSrv.Client("bob"), "GET", svc.URL+"/bob")
+	c.Assert(checked, gc.Equals, 1)
+
+	// The capability should hold a third party caveat
+	// addressed to the membership checker.
+	found := false
+	for _, cav := range ms[0].Caveats() {
+		if cav.Location == thirdParty.Location() {
+			found = true
+		}
+	}
+	c.Assert(found, gc.Equals, true)
+
+	resp := doWithCapabilities(c, http.DefaultClient, "GET", svc.URL+"/bob", ms)
+	h.assertSuccess(c, resp, "GET", "/bob")
+
+	// Without the discharge, the capability is no good.
+	resp = doWithCapabilities(c, http.DefaultClient, "GET", svc.URL+"/bob", ms[:1])
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Not(gc.Equals), http.StatusOK)
+}
+
+func (*authSuite) TestUserCheckerResultsCachedInAuthorizer(c *gc.C) {
+	getACLCount := 0
+	getACL := func(context.Context, auth.Op) (ACL, []checkers.Caveat, error) {
+		getACLCount++
+		return ACL{Everyone}, nil, nil
+	}
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker: allCheckers,
+		UserChecker:   &aclUserChecker{ACLGetterFunc(getACL)},
+		MacaroonStore: newMacaroonStore(),
+	})
+	authorizer := service.NewAuthorizer(nil)
+	op0 := auth.Op{Entity: "path-/bob", Action: "GET"}
+	op1 := auth.Op{Entity: "path-/alice", Action: "GET"}
+
+	// Duplicate operations are only checked once.
+	_, err := authorizer.Allow(context.TODO(), []auth.Op{op0, op0, op1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(getACLCount, gc.Equals, 2)
+
+	// Operations that have already been checked aren't checked again.
+	_, err = authorizer.Allow(context.TODO(), []auth.Op{op1, op0})
+	c.Assert(err, gc.IsNil)
+	c.Assert(getACLCount, gc.Equals, 2)
+
+	// A new authorizer doesn't share the cache.
+	_, err = service.NewAuthorizer(nil).Allow(context.TODO(), []auth.Op{op0})
+	c.Assert(err, gc.IsNil)
+	c.Assert(getACLCount, gc.Equals, 3)
+}
+
 func (*authSuite) TestLoginOpIgnoredIfCombined(c *gc.C) {
-	// TODO
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    &aclUserChecker{ACLMap{"path-/bob": {"GET": {"bob"}}}},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	op := auth.Op{Entity: "path-/bob", Action: "GET"}
+
+	// A macaroon that combines LoginOp with another operation
+	// declares a user but must not authenticate as that user.
+	m, err := store.NewMacaroon([]auth.Op{auth.LoginOp, op}, nil)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", "bob").Condition)
+	c.Assert(err, gc.IsNil)
+	authorizer := service.NewAuthorizer([]macaroon.Slice{{m}})
+
+	authInfo, err := authorizer.Allow(context.TODO(), []auth.Op{auth.LoginOp})
+	c.Assert(authInfo, gc.IsNil)
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.Ops, gc.DeepEquals, []auth.Op{auth.LoginOp})
+
+	// The other operation is still authorized by the macaroon.
+	authInfo, err = authorizer.Allow(context.TODO(), []auth.Op{op})
+	c.Assert(err, gc.IsNil)
+	c.Assert(authInfo.Identity, gc.IsNil)
+	c.Assert(authInfo.Macaroons, gc.HasLen, 1)
 }
 
 func (*authSuite) TestLoginMacaroonWithFirstPartyCaveats(c *gc.C) {
-	// TODO
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker: allCheckers,
+		UserChecker: &aclUserChecker{ACLGetterFunc(func(context.Context, auth.Op) (ACL, []checkers.Caveat, error) {
+			// Allow everything so that we can check that
+			// LoginOp is not granted by the user checker.
+			return ACL{Everyone, "bob"}, nil, nil
+		})},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	op := auth.Op{Entity: "path-/bob", Action: "GET"}
+	loginMacaroon := func(conds ...string) macaroon.Slice {
+		m, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+		c.Assert(err, gc.IsNil)
+		conds = append(conds, checkers.DeclaredCaveat("username", "bob").Condition)
+		for _, cond := range conds {
+			err := m.AddFirstPartyCaveat(cond)
+			c.Assert(err, gc.IsNil)
+		}
+		return macaroon.Slice{m}
+	}
+
+	// A login macaroon with a time-before caveat in the
+	// future authenticates the user.
+	expiry := time.Now().Add(time.Hour).Round(time.Millisecond).UTC()
+	authorizer := service.NewAuthorizer([]macaroon.Slice{
+		loginMacaroon(checkers.TimeBeforeCaveat(expiry).Condition),
+	})
+	authInfo, err := authorizer.Allow(context.TODO(), []auth.Op{auth.LoginOp, op})
+	c.Assert(err, gc.IsNil)
+	c.Assert(authInfo.Identity, gc.NotNil)
+	c.Assert(authInfo.Identity.Id(), gc.Equals, "bob")
+	c.Assert(authInfo.Macaroons, gc.HasLen, 1)
+
+	// A capability derived from the login macaroon must not
+	// outlive it.
+	conds, err := authorizer.AllowCapability(context.TODO(), []auth.Op{op})
+	c.Assert(err, gc.IsNil)
+	c.Assert(conds, gc.DeepEquals, []string{checkers.TimeBeforeCaveat(expiry).Condition})
+
+	// Once the time-before caveat has expired, the macaroon
+	// no longer authenticates the user.
+	authorizer = service.NewAuthorizer([]macaroon.Slice{
+		loginMacaroon(checkers.TimeBeforeCaveat(time.Now().Add(-time.Second)).Condition),
+	})
+	authInfo, err = authorizer.Allow(context.TODO(), []auth.Op{auth.LoginOp})
+	c.Assert(authInfo, gc.IsNil)
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.Ops, gc.DeepEquals, []auth.Op{auth.LoginOp})
+
+	// Neither does a login macaroon with a caveat that
+	// isn't recognized.
+	authorizer = service.NewAuthorizer([]macaroon.Slice{
+		loginMacaroon("unknown-condition"),
+	})
+	_, err = authorizer.Allow(context.TODO(), []auth.Op{auth.LoginOp})
+	_, ok = errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+}
+
+func (*authSuite) TestAnonymousIdentity(c *gc.C) {
+	var seen []auth.Identity
+	userChecker := userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+		seen = append(seen, id)
+		allowed := make([]bool, len(ops))
+		for i, op := range ops {
+			switch {
+			case id == auth.Anonymous:
+				// Anonymous users can read but not write.
+				allowed[i] = op.Action == "read"
+			case id.Id() == "bob":
+				allowed[i] = true
+			}
+		}
+		return allowed, nil, nil
+	})
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    userChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	readOp := auth.Op{Entity: "x", Action: "read"}
+	writeOp := auth.Op{Entity: "x", Action: "write"}
+
+	// A public operation is allowed without authentication,
+	// and the user checker sees the anonymous identity
+	// rather than nil.
+	authInfo, err := service.NewAuthorizer(nil).Allow(context.TODO(), []auth.Op{readOp})
+	c.Assert(err, gc.IsNil)
+	c.Assert(authInfo.Identity, gc.IsNil)
+	c.Assert(seen, gc.DeepEquals, []auth.Identity{auth.Anonymous})
+	c.Assert(auth.Anonymous.Id(), gc.Equals, "")
+
+	// An operation that isn't allowed anonymously
+	// requires authentication.
+	_, err = service.NewAuthorizer(nil).Allow(context.TODO(), []auth.Op{writeOp})
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.Ops, gc.DeepEquals, []auth.Op{auth.LoginOp})
+
+	loginAs := func(username string) *auth.Authorizer {
+		m, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+		c.Assert(err, gc.IsNil)
+		err = m.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", username).Condition)
+		c.Assert(err, gc.IsNil)
+		return service.NewAuthorizer([]macaroon.Slice{{m}})
+	}
+
+	// An authenticated user that isn't allowed to perform
+	// the operation is denied permission rather than being
+	// asked to authenticate.
+	_, err = loginAs("alice").Allow(context.TODO(), []auth.Op{writeOp})
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrPermissionDenied)
+
+	authInfo, err = loginAs("bob").Allow(context.TODO(), []auth.Op{writeOp})
+	c.Assert(err, gc.IsNil)
+	c.Assert(authInfo.Identity.Id(), gc.Equals, "bob")
+}
+
+func (*authSuite) TestPermissionDeniedError(c *gc.C) {
+	userChecker := userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+		allowed := make([]bool, len(ops))
+		for i, op := range ops {
+			// Everyone can read; only bob can write.
+			allowed[i] = op.Action == "read" || id.Id() == "bob"
+		}
+		return allowed, nil, nil
+	})
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    userChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	m, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", "alice").Condition)
+	c.Assert(err, gc.IsNil)
+	authorizer := service.NewAuthorizer([]macaroon.Slice{{m}})
+
+	ops := []auth.Op{
+		{Entity: "x", Action: "read"},
+		{Entity: "x", Action: "write"},
+		{Entity: "y", Action: "read"},
+		{Entity: "y", Action: "write"},
+	}
+	_, authed, err := authorizer.AllowAny(context.TODO(), ops)
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrPermissionDenied)
+	c.Assert(err, gc.ErrorMatches, "permission denied")
+	perr, ok := err.(*auth.PermissionDeniedError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(perr.DeniedOps, gc.DeepEquals, []auth.Op{
+		{Entity: "x", Action: "write"},
+		{Entity: "y", Action: "write"},
+	})
+	c.Assert(authed, gc.DeepEquals, []bool{true, false, true, false})
+
+	// The denied operations are reported by AllowCapability too.
+	_, err = authorizer.AllowCapability(context.TODO(), ops[:2])
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrPermissionDenied)
+	perr, ok = err.(*auth.PermissionDeniedError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(perr.DeniedOps, gc.DeepEquals, []auth.Op{
+		{Entity: "x", Action: "write"},
+	})
+}
+
+type authorizeRecord struct {
+	ops     []auth.Op
+	allowed []bool
+	outcome auth.AuthorizeOutcome
+}
+
+func (*authSuite) TestCancelledContext(c *gc.C) {
+	op := auth.Op{Entity: "path-/bob", Action: "GET"}
+	// blockingUserChecker blocks until the context is done.
+	called := make(chan struct{}, 1)
+	blockingUserChecker := userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+		called <- struct{}{}
+		<-ctxt.Done()
+		return nil, nil, errgo.Notef(ctxt.Err(), "cannot get ACLs")
+	})
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    blockingUserChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+
+	// The context is cancelled while the user checker is running.
+	ctxt, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-called
+		cancel()
+	}()
+	done := make(chan error)
+	go func() {
+		_, err := service.NewAuthorizer(nil).Allow(ctxt, []auth.Op{op})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		c.Assert(errgo.Cause(err), gc.Equals, context.Canceled)
+	case <-time.After(5 * time.Second):
+		c.Fatalf("authorization not aborted after context cancelled")
+	}
+
+	// The context is cancelled while the macaroons are being examined.
+	ctxt, cancel = context.WithCancel(context.Background())
+	service = auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    blockingUserChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  blockingMacaroonStore{cancel},
+	})
+	m, err := store.NewMacaroon([]auth.Op{op}, nil)
+	c.Assert(err, gc.IsNil)
+	_, err = service.NewAuthorizer([]macaroon.Slice{{m}}).Allow(ctxt, []auth.Op{op})
+	c.Assert(errgo.Cause(err), gc.Equals, context.Canceled)
+	select {
+	case <-called:
+		c.Fatalf("user checker called after context cancelled")
+	default:
+	}
+
+	// A context with an expired deadline aborts the
+	// authorization before the user checker is called.
+	ctxt, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err = service.NewAuthorizer(nil).Allow(ctxt, []auth.Op{op})
+	c.Assert(errgo.Cause(err), gc.Equals, context.DeadlineExceeded)
+	select {
+	case <-called:
+		c.Fatalf("user checker called after deadline passed")
+	default:
+	}
+}
+
+// blockingMacaroonStore implements auth.MacaroonStore by calling
+// cancel and then waiting for the context to be done.
+type blockingMacaroonStore struct {
+	cancel func()
+}
+
+func (s blockingMacaroonStore) MacaroonIdInfo(ctxt context.Context, id []byte) ([]byte, []auth.Op, error) {
+	s.cancel()
+	<-ctxt.Done()
+	return nil, nil, errgo.Notef(ctxt.Err(), "cannot get root key")
+}
+
+func (*authSuite) TestOnAuthorize(c *gc.C) {
+	userChecker := userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+		allowed := make([]bool, len(ops))
+		for i, op := range ops {
+			if op.Entity == "broken" {
+				return nil, nil, errgo.New("database failure")
+			}
+			// Everyone can read; only bob can write.
+			allowed[i] = op.Action == "read" || id.Id() == "bob"
+		}
+		return allowed, nil, nil
+	})
+	var records []authorizeRecord
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    userChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+		OnAuthorize: func(ops []auth.Op, allowed []bool, outcome auth.AuthorizeOutcome, d time.Duration) {
+			c.Check(d >= 0, gc.Equals, true)
+			records = append(records, authorizeRecord{ops, allowed, outcome})
+		},
+	})
+	m, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", "alice").Condition)
+	c.Assert(err, gc.IsNil)
+	aliceAuthorizer := service.NewAuthorizer([]macaroon.Slice{{m}})
+	anonAuthorizer := service.NewAuthorizer(nil)
+
+	readOp := auth.Op{Entity: "x", Action: "read"}
+	writeOp := auth.Op{Entity: "x", Action: "write"}
+	brokenOp := auth.Op{Entity: "broken", Action: "read"}
+
+	_, err = anonAuthorizer.Allow(context.TODO(), []auth.Op{readOp})
+	c.Assert(err, gc.IsNil)
+	_, err = anonAuthorizer.Allow(context.TODO(), []auth.Op{readOp, writeOp})
+	c.Assert(errgo.Cause(err), gc.FitsTypeOf, (*auth.DischargeRequiredError)(nil))
+	_, _, err = aliceAuthorizer.AllowAny(context.TODO(), []auth.Op{readOp, writeOp})
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrPermissionDenied)
+	_, err = aliceAuthorizer.Allow(context.TODO(), []auth.Op{brokenOp})
+	c.Assert(err, gc.ErrorMatches, "cannot check permissions: database failure")
+
+	c.Assert(records, gc.DeepEquals, []authorizeRecord{{
+		ops:     []auth.Op{readOp},
+		allowed: []bool{true},
+		outcome: auth.AuthorizeAllowed,
+	}, {
+		ops:     []auth.Op{readOp, writeOp},
+		allowed: []bool{true, false},
+		outcome: auth.AuthorizeDischargeRequired,
+	}, {
+		ops:     []auth.Op{readOp, writeOp},
+		allowed: []bool{true, false},
+		outcome: auth.AuthorizeDenied,
+	}, {
+		ops:     []auth.Op{brokenOp},
+		allowed: []bool{false},
+		outcome: auth.AuthorizeError,
+	}})
+	c.Assert(auth.AuthorizeDischargeRequired.String(), gc.Equals, "discharge-required")
+}
+
+var macaroonVersionTests = []struct {
+	about         string
+	minVersion    bakery.Version
+	clientVersion bakery.Version
+	expectVersion macaroon.Version
+	expectError   string
+}{{
+	about:         "no minimum, old client",
+	clientVersion: bakery.Version1,
+	expectVersion: macaroon.V1,
+}, {
+	about:         "no minimum, latest client",
+	clientVersion: bakery.LatestVersion,
+	expectVersion: macaroon.LatestVersion,
+}, {
+	about:         "client newer than service",
+	clientVersion: bakery.LatestVersion + 1,
+	expectVersion: macaroon.LatestVersion,
+}, {
+	about:         "client at minimum",
+	minVersion:    bakery.Version2,
+	clientVersion: bakery.Version2,
+	expectVersion: macaroon.V2,
+}, {
+	about:         "client below minimum",
+	minVersion:    bakery.Version2,
+	clientVersion: bakery.Version1,
+	expectError:   `client bakery version 1 is older than minimum version 2`,
+}}
+
+func (*authSuite) TestMacaroonVersion(c *gc.C) {
+	for i, test := range macaroonVersionTests {
+		c.Logf("test %d: %s", i, test.about)
+		service := auth.NewService(auth.ServiceParams{
+			CaveatChecker: allCheckers,
+			MacaroonStore: newMacaroonStore(),
+			MinVersion:    test.minVersion,
+		})
+		v, err := service.MacaroonVersion(test.clientVersion)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), gc.Equals, auth.ErrVersionTooOld)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(v, gc.Equals, test.expectVersion)
+	}
+}
+
+func (*authSuite) TestOldMacaroonVersionIgnored(c *gc.C) {
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker: allCheckers,
+		UserChecker: userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+			return make([]bool, len(ops)), nil, nil
+		}),
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+		MinVersion:     bakery.Version2,
+	})
+	op := auth.Op{Entity: "x", Action: "read"}
+
+	// A macaroon in the old format isn't used, and
+	// the reason is reported.
+	m, err := store.NewMacaroonWithVersion([]auth.Op{op}, nil, macaroon.V1)
+	c.Assert(err, gc.IsNil)
+	_, err = service.NewAuthorizer([]macaroon.Slice{{m}}).Allow(context.TODO(), []auth.Op{op})
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.MacaroonErrors, gc.HasLen, 1)
+	c.Assert(derr.MacaroonErrors[0].Index, gc.Equals, 0)
+	c.Assert(derr.MacaroonErrors[0], gc.ErrorMatches, `macaroon 0: macaroon version 1 is older than minimum version 2`)
+	c.Assert(errgo.Cause(derr.MacaroonErrors[0]), gc.Equals, auth.ErrMacaroonVersionTooOld)
+
+	// A macaroon in the new format is.
+	m, err = store.NewMacaroonWithVersion([]auth.Op{op}, nil, macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	_, err = service.NewAuthorizer([]macaroon.Slice{{m}}).Allow(context.TODO(), []auth.Op{op})
+	c.Assert(err, gc.IsNil)
+}
+
+func (*authSuite) TestCanonicalOps(c *gc.C) {
+	ops := []auth.Op{
+		{Entity: "e2", Action: "write"},
+		{Entity: "e1", Action: "read"},
+		{Entity: "e2", Action: "read"},
+		{Entity: "e1", Action: "read"},
+		{Entity: "e1", Action: "write"},
+		{Entity: "e2", Action: "write"},
+	}
+	orig := append([]auth.Op(nil), ops...)
+	c.Assert(auth.CanonicalOps(ops), gc.DeepEquals, []auth.Op{
+		{Entity: "e1", Action: "read"},
+		{Entity: "e2", Action: "read"},
+		{Entity: "e1", Action: "write"},
+		{Entity: "e2", Action: "write"},
+	})
+	c.Assert(ops, gc.DeepEquals, orig)
+	c.Assert(auth.CanonicalOps(nil), gc.HasLen, 0)
+}
+
+func (*authSuite) TestCapabilityWithManyOps(c *gc.C) {
+	getACLCount := 0
+	getACL := func(context.Context, auth.Op) (ACL, []checkers.Caveat, error) {
+		getACLCount++
+		return ACL{"bob"}, nil, nil
+	}
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    &aclUserChecker{ACLGetterFunc(getACL)},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	// Ask for a capability covering many entities,
+	// mentioning each operation twice.
+	const numEntities = 500
+	var ops []auth.Op
+	for i := 0; i < 2; i++ {
+		for j := 0; j < numEntities; j++ {
+			ops = append(ops, auth.Op{
+				Entity: fmt.Sprintf("path-/e%d", j),
+				Action: "GET",
+			})
+		}
+	}
+	loginMacaroon, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+	c.Assert(err, gc.IsNil)
+	err = loginMacaroon.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", "bob").Condition)
+	c.Assert(err, gc.IsNil)
+	authorizer := service.NewAuthorizer([]macaroon.Slice{{loginMacaroon}})
+	conds, err := authorizer.AllowCapability(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	// Each distinct operation is only checked once.
+	c.Assert(getACLCount, gc.Equals, numEntities)
+
+	capOps := auth.CanonicalOps(ops)
+	c.Assert(capOps, gc.HasLen, numEntities)
+	m, err := store.NewMacaroon(capOps, nil)
+	c.Assert(err, gc.IsNil)
+	for _, cond := range conds {
+		err := m.AddFirstPartyCaveat(cond)
+		c.Assert(err, gc.IsNil)
+	}
+
+	// The capability alone authorizes all the original
+	// operations without consulting the user checker.
+	getACLCount = 0
+	authorizer = service.NewAuthorizer([]macaroon.Slice{{m}})
+	authInfo, err := authorizer.Allow(context.TODO(), ops)
+	c.Assert(err, gc.IsNil)
+	c.Assert(authInfo.Identity, gc.IsNil)
+	c.Assert(authInfo.Macaroons, gc.HasLen, 1)
+	c.Assert(getACLCount, gc.Equals, 0)
+
+	// It doesn't authorize anything else.
+	_, err = authorizer.Allow(context.TODO(), []auth.Op{{
+		Entity: fmt.Sprintf("path-/e%d", numEntities),
+		Action: "GET",
+	}})
+	c.Assert(err, gc.NotNil)
 }
 
 func (*authSuite) TestAuthorizationMacaroonWithFirstPartyCaveats(c *gc.C) {
@@ -288,27 +1012,194 @@ func (*authSuite) TestAuthorizeWithEmptyMacaroonSlice(c *gc.C) {
 }
 
 func (*authSuite) TestMacaroonWithCorruptedSignature(c *gc.C) {
-	// TODO
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    &aclUserChecker{ACLMap{}},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	op := auth.Op{Entity: "path-/bob", Action: "GET"}
+	m, err := store.NewMacaroon([]auth.Op{op}, nil)
+	c.Assert(err, gc.IsNil)
+	corrupted, err := macaroon.New([]byte("wrong key"), m.Id(), "", macaroon.LatestVersion)
+	c.Assert(err, gc.IsNil)
+
+	authorizer := service.NewAuthorizer([]macaroon.Slice{{corrupted}})
+	_, err = authorizer.Allow(context.TODO(), []auth.Op{op})
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.MacaroonErrors, gc.HasLen, 1)
+	c.Assert(derr.MacaroonErrors[0].Index, gc.Equals, 0)
+	c.Assert(errgo.Cause(derr.MacaroonErrors[0]), gc.Equals, auth.ErrInvalidSignature)
+}
+
+func (*authSuite) TestMacaroonErrors(c *gc.C) {
+	store, err := auth.NewMemMacaroonStore()
+	c.Assert(err, gc.IsNil)
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    &aclUserChecker{ACLMap{}},
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	op := auth.Op{Entity: "path-/bob", Action: "GET"}
+	newMacaroon := func(ops []auth.Op, conds ...string) macaroon.Slice {
+		m, err := store.NewMacaroon(ops, nil)
+		c.Assert(err, gc.IsNil)
+		for _, cond := range conds {
+			err := m.AddFirstPartyCaveat(cond)
+			c.Assert(err, gc.IsNil)
+		}
+		return macaroon.Slice{m}
+	}
+	expired := checkers.TimeBeforeCaveat(time.Now().Add(-time.Second)).Condition
+	declared := checkers.DeclaredCaveat("username", "bob").Condition
+
+	tests := []struct {
+		about       string
+		macaroons   func() []macaroon.Slice
+		ops         []auth.Op
+		expectCause error
+	}{{
+		about: "expired login macaroon",
+		macaroons: func() []macaroon.Slice {
+			return []macaroon.Slice{newMacaroon([]auth.Op{auth.LoginOp}, expired, declared)}
+		},
+		ops:         []auth.Op{auth.LoginOp},
+		expectCause: auth.ErrMacaroonExpired,
+	}, {
+		about: "expired authorization macaroon",
+		macaroons: func() []macaroon.Slice {
+			return []macaroon.Slice{newMacaroon([]auth.Op{op}, expired)}
+		},
+		ops:         []auth.Op{op},
+		expectCause: auth.ErrMacaroonExpired,
+	}, {
+		about: "unknown root key",
+		macaroons: func() []macaroon.Slice {
+			ms := newMacaroon([]auth.Op{op})
+			err := store.RotateRootKey()
+			c.Assert(err, gc.IsNil)
+			store.RemoveRootKeys()
+			return []macaroon.Slice{ms}
+		},
+		ops:         []auth.Op{op},
+		expectCause: auth.ErrUnknownRootKey,
+	}, {
+		about: "other caveat failure",
+		macaroons: func() []macaroon.Slice {
+			return []macaroon.Slice{newMacaroon([]auth.Op{op}, "unknown-condition")}
+		},
+		ops: []auth.Op{op},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		authorizer := service.NewAuthorizer(test.macaroons())
+		_, err := authorizer.Allow(context.TODO(), test.ops)
+		derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+		c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+		c.Assert(derr.MacaroonErrors, gc.HasLen, 1)
+		c.Assert(derr.MacaroonErrors[0].Index, gc.Equals, 0)
+		cause := errgo.Cause(derr.MacaroonErrors[0])
+		if test.expectCause != nil {
+			c.Assert(cause, gc.Equals, test.expectCause)
+			continue
+		}
+		for _, known := range []error{auth.ErrMacaroonExpired, auth.ErrInvalidSignature, auth.ErrUnknownRootKey} {
+			c.Assert(cause, gc.Not(gc.Equals), known)
+		}
+	}
+
+	// Macaroons that are used in the authorization
+	// aren't reported even if other macaroons fail.
+	authorizer := service.NewAuthorizer([]macaroon.Slice{
+		newMacaroon([]auth.Op{op}, expired),
+		newMacaroon([]auth.Op{op}),
+	})
+	_, err = authorizer.Allow(context.TODO(), []auth.Op{op, auth.LoginOp})
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.MacaroonErrors, gc.HasLen, 1)
+	c.Assert(derr.MacaroonErrors[0].Index, gc.Equals, 0)
+	c.Assert(errgo.Cause(derr.MacaroonErrors[0]), gc.Equals, auth.ErrMacaroonExpired)
 }
 
 func (*authSuite) TestAllowAny(c *gc.C) {
-	// TODO
+	userChecker := userCheckerFunc(func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+		allowed := make([]bool, len(ops))
+		for i, op := range ops {
+			// Only alice can access y.
+			allowed[i] = op.Entity == "y" && id.Id() == "alice"
+		}
+		return allowed, nil, nil
+	})
+	store := newMacaroonStore()
+	service := auth.NewService(auth.ServiceParams{
+		CaveatChecker:  allCheckers,
+		UserChecker:    userChecker,
+		IdentityClient: testIdentityClient{},
+		MacaroonStore:  store,
+	})
+	xRead := auth.Op{Entity: "x", Action: "read"}
+	yRead := auth.Op{Entity: "y", Action: "read"}
+	zRead := auth.Op{Entity: "z", Action: "read"}
+	zWrite := auth.Op{Entity: "z", Action: "write"}
+
+	// An authorization macaroon allows x.
+	authzMacaroon, err := store.NewMacaroon([]auth.Op{xRead}, nil)
+	c.Assert(err, gc.IsNil)
+	loginMacaroon, err := store.NewMacaroon([]auth.Op{auth.LoginOp}, nil)
+	c.Assert(err, gc.IsNil)
+	err = loginMacaroon.AddFirstPartyCaveat(checkers.DeclaredCaveat("username", "alice").Condition)
+	c.Assert(err, gc.IsNil)
+
+	// The operations that are allowed are reported
+	// even though some are denied.
+	authorizer := service.NewAuthorizer([]macaroon.Slice{{authzMacaroon}, {loginMacaroon}})
+	authInfo, authed, err := authorizer.AllowAny(context.TODO(), []auth.Op{xRead, yRead, zRead, zWrite})
+	c.Assert(errgo.Cause(err), gc.Equals, auth.ErrPermissionDenied)
+	c.Assert(authed, gc.DeepEquals, []bool{true, true, false, false})
+	c.Assert(authInfo.Identity.Id(), gc.Equals, "alice")
+	c.Assert(authInfo.Macaroons, gc.HasLen, 2)
+
+	// When all the operations are allowed, the
+	// returned slice is nil.
+	authInfo, authed, err = authorizer.AllowAny(context.TODO(), []auth.Op{xRead, yRead})
+	c.Assert(err, gc.IsNil)
+	c.Assert(authed, gc.IsNil)
+	c.Assert(authInfo.Identity.Id(), gc.Equals, "alice")
+
+	// Without authentication, operations allowed
+	// by the authorization macaroon are still reported.
+	authorizer = service.NewAuthorizer([]macaroon.Slice{{authzMacaroon}})
+	authInfo, authed, err = authorizer.AllowAny(context.TODO(), []auth.Op{xRead, yRead})
+	derr, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("error %#v", err))
+	c.Assert(derr.Ops, gc.DeepEquals, []auth.Op{auth.LoginOp})
+	c.Assert(authed, gc.DeepEquals, []bool{true, false})
+	c.Assert(authInfo.Identity, gc.IsNil)
+	c.Assert(authInfo.Macaroons, gc.HasLen, 1)
 }
 
 type testServers struct {
-	idmSrv *idmtest.Server
-	svc    *httptest.Server
+	idmSrv  *idmtest.Server
+	svc     *httptest.Server
+	revoked *revocationSet
 }
 
 func newTestServers(h AuthHTTPHandler, acls ACLGetter) *testServers {
 	idmSrv := idmtest.NewServer()
+	revoked := new(revocationSet)
 	return &testServers{
 		idmSrv: idmSrv,
-		svc: newAuthHTTPService(h,
-			idmClientShim{idmSrv.IDMClient("auth-user")},
-			acls,
-			allCheckers,
-		),
+		svc: newAuthHTTPService(h, acls, auth.ServiceParams{
+			IdentityClient:    idmClientShim{idmSrv.IDMClient("auth-user")},
+			CaveatChecker:     allCheckers,
+			RevocationChecker: revoked,
+		}),
+		revoked: revoked,
 	}
 }
 
@@ -325,6 +1216,59 @@ func (c idmClientShim) DeclaredIdentity(attrs map[string]string) (auth.Identity,
 	return c.IdentityClient.DeclaredIdentity(attrs)
 }
 
+// membershipIdmClientShim implements auth.MembershipCaveater
+// by returning a caveat addressed to the given location.
+type membershipIdmClientShim struct {
+	idmClientShim
+	location string
+}
+
+func (c membershipIdmClientShim) MembershipCaveats(id auth.Identity) []checkers.Caveat {
+	return []checkers.Caveat{{
+		Location:  c.location,
+		Condition: "is-still-member " + id.Id(),
+	}}
+}
+
+// testIdentityClient implements auth.IdentityService by
+// taking the identity from the "username" declared attribute.
+type testIdentityClient struct{}
+
+func (testIdentityClient) IdentityCaveats() []checkers.Caveat {
+	return []checkers.Caveat{{
+		Location:  "https://identity.example.com",
+		Condition: "is-authenticated-user",
+	}}
+}
+
+func (testIdentityClient) DeclaredIdentity(declared map[string]string) (auth.Identity, error) {
+	username := declared["username"]
+	if username == "" {
+		return nil, errgo.New("no declared user")
+	}
+	return testIdentity(username), nil
+}
+
+// testIdentity implements auth.Identity and idmclient.ACLUser.
+type testIdentity string
+
+func (id testIdentity) Id() string {
+	return string(id)
+}
+
+func (id testIdentity) Domain() string {
+	return ""
+}
+
+func (id testIdentity) Allow(acl []string) (bool, error) {
+	for _, g := range acl {
+		if g == string(id) || g == Everyone {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 type httpDoer interface {
 	Do(*http.Request) (*http.Response, error)
 }
@@ -419,19 +1363,17 @@ func successBody(method, path string) string {
 }
 
 // newAuthHTTPService returns a new HTTP service that serves requests from the given handler.
-// The entities map holds an entry for each known entity holding a map from action to ACL.
-// The checker is used to check first party caveats and may be nil.
-func newAuthHTTPService(handler AuthHTTPHandler, idm auth.IdentityClient, acls ACLGetter, caveatChecker checkers.Checker) *httptest.Server {
-	if caveatChecker == nil {
-		caveatChecker = checkers.New()
+// The acls value is used to find the ACL for each operation.
+// The UserChecker and MacaroonStore fields in p are filled in by newAuthHTTPService;
+// if p.CaveatChecker is nil, no first party caveats will be recognized.
+func newAuthHTTPService(handler AuthHTTPHandler, acls ACLGetter, p auth.ServiceParams) *httptest.Server {
+	if p.CaveatChecker == nil {
+		p.CaveatChecker = checkers.New()
 	}
 	store := newMacaroonStore()
-	service := auth.NewService(auth.ServiceParams{
-		CaveatChecker:  caveatChecker,
-		UserChecker:    &aclUserChecker{acls},
-		IdentityClient: idm,
-		MacaroonStore:  store,
-	})
+	p.UserChecker = &aclUserChecker{acls}
+	p.MacaroonStore = store
+	service := auth.NewService(p)
 	return httptest.NewServer(checkHTTPAuth(service, store, handler))
 }
 
@@ -440,9 +1382,16 @@ type ACL []string
 type ACLMap map[string]map[string]ACL
 
 // GetACL implements ACLGetter.GetACL by returning the ACL from
-// the map.
+// the map. Entries for entity patterns apply to all the entities
+// they cover, as described by auth.MatchEntity.
 func (e ACLMap) GetACL(_ context.Context, op auth.Op) (ACL, []checkers.Caveat, error) {
-	acl := e[op.Entity][op.Action]
+	var acl ACL
+	for _, entity := range auth.CoveringEntities(op.Entity) {
+		if acls, ok := e[entity]; ok {
+			acl = acls[op.Action]
+			break
+		}
+	}
 	logger.Infof("getting ACLs for %#v -> %#v", op, acl)
 	return acl, nil, nil
 }
@@ -472,20 +1421,24 @@ func (s *httpAuthChecker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	logger.Infof("%d macaroons in request", len(mss))
 	authorizer := s.service.NewAuthorizer(mss)
 
-	//	version := httpbakery.RequestVersion(req)
 	if req.Method == "AUTH" {
 		// Special HTTP method to ask for a capability.
 		// TODO what's the best way of requesting this in in reality?
+		version, err := s.service.MacaroonVersion(httpbakery.RequestVersion(req))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		req1 := *req
 		req1.Method = req.Header.Get("AuthMethod")
 		ops := s.h.EndpointAuth(&req1)
 		logger.Infof("asking for capability for %#v", ops)
-		conditions, err := authorizer.AllowCapability(context.TODO(), ops)
+		conditions, caveats, err := authorizer.AllowCapabilityCaveats(context.TODO(), ops)
 		if err != nil {
 			s.writeError(w, err, req)
 			return
 		}
-		m, err := s.store.NewMacaroon(withoutLoginOp(ops), nil)
+		m, err := s.store.NewMacaroonWithVersion(auth.CanonicalOps(withoutLoginOp(ops)), caveats, version)
 		if err != nil {
 			panic("cannot make new macaroon: " + err.Error())
 		}
@@ -524,10 +1477,13 @@ func (s *httpAuthChecker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *httpAuthChecker) writeError(w http.ResponseWriter, err error, req *http.Request) {
+	if err1, ok := err.(*auth.PermissionDeniedError); ok {
+		http.Error(w, fmt.Sprintf("permission denied for operations %v", err1.DeniedOps), http.StatusForbidden)
+		return
+	}
 	err1, ok := errgo.Cause(err).(*auth.DischargeRequiredError)
 	if !ok {
 		logger.Infof("error when authorizing: %#v", err)
-		// TODO permission denied error.
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -558,6 +1514,12 @@ type ACLGetter interface {
 	GetACL(context.Context, auth.Op) (ACL, []checkers.Caveat, error)
 }
 
+type userCheckerFunc func(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error)
+
+func (f userCheckerFunc) Allow(ctxt context.Context, id auth.Identity, ops []auth.Op) ([]bool, []checkers.Caveat, error) {
+	return f(ctxt, id, ops)
+}
+
 type aclUserChecker struct {
 	aclGetter ACLGetter
 }
@@ -567,7 +1529,7 @@ func (a *aclUserChecker) Allow(ctxt context.Context, id auth.Identity, ops []aut
 		logger.Infof("aclUserChecker.Allow(id %#v, ops %#v -> %v, %v, %v", id, ops, allowed, caveats, err)
 	}()
 	u, ok := id.(idmclient.ACLUser)
-	if id != nil && !ok {
+	if id != auth.Anonymous && !ok {
 		logger.Infof("warning: user %T is not ACLUser", id)
 	}
 	allowed = make([]bool, len(ops))
@@ -1,7 +1,9 @@
 package auth_test
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"sync"
 
 	"golang.org/x/net/context"
 	errgo "gopkg.in/errgo.v1"
@@ -36,22 +38,36 @@ func newMacaroonStore() *macaroonStore {
 }
 
 type macaroonId struct {
-	Id  []byte
-	Ops []auth.Op
+	Id []byte
+	// Nonce makes the macaroon id unique even
+	// when the root key is shared.
+	Nonce []byte
+	Ops   []auth.Op
 }
 
 func (s *macaroonStore) NewMacaroon(ops []auth.Op, caveats []checkers.Caveat) (*macaroon.Macaroon, error) {
+	return s.NewMacaroonWithVersion(ops, caveats, macaroon.LatestVersion)
+}
+
+// NewMacaroonWithVersion is like NewMacaroon except that
+// the macaroon is created with the given format version.
+func (s *macaroonStore) NewMacaroonWithVersion(ops []auth.Op, caveats []checkers.Caveat, version macaroon.Version) (*macaroon.Macaroon, error) {
 	rootKey, id, err := s.store.RootKey()
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errgo.Mask(err)
+	}
 	mid := macaroonId{
-		Id:  id,
-		Ops: ops,
+		Id:    id,
+		Nonce: nonce,
+		Ops:   ops,
 	}
 	data, _ := json.Marshal(mid)
-	m, err := macaroon.New(rootKey, data, "", macaroon.LatestVersion)
+	m, err := macaroon.New(rootKey, data, "", version)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -95,3 +111,26 @@ func withoutLoginOp(ops []auth.Op) []auth.Op {
 	}
 	return newOps
 }
+
+// revocationSet implements auth.RevocationChecker
+// by holding a set of revoked macaroon ids.
+type revocationSet struct {
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+func (s *revocationSet) revoke(id []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revoked == nil {
+		s.revoked = make(map[string]bool)
+	}
+	s.revoked[string(id)] = true
+}
+
+// IsRevoked implements auth.RevocationChecker.IsRevoked.
+func (s *revocationSet) IsRevoked(ctxt context.Context, id []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[string(id)], nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery"
+	"gopkg.in/macaroon-bakery.v2-unstable/httpbakery"
+)
+
+// addBrowserHandlers adds handlers to mux that let the target
+// service be used from javascript in a web browser. The page
+// served at /browser/ makes requests to the target service;
+// when a request fails with a discharge-required error, it asks
+// the /browser/discharge endpoint to gather the discharge
+// macaroons, stores the result as a cookie and retries the
+// request.
+//
+// This does not demonstrate a browser discharge flow: the
+// discharges are acquired by /browser/discharge, a server-side
+// helper, not by the browser, because doing it in the browser
+// would require implementing macaroon binding in javascript.
+// Any interaction required by the third party is carried out by
+// opening a browser window on the machine running the example,
+// not the one displaying the page, so this is only suitable for
+// running locally. The helper will only discharge caveats addressed to
+// the example's own authorization service at authEndpoint, so
+// that it can't be used to make requests to arbitrary third
+// parties. A real service should not provide such an endpoint.
+func addBrowserHandlers(mux *http.ServeMux, authEndpoint string) {
+	mux.HandleFunc("/browser/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, browserPage)
+	})
+	mux.HandleFunc("/browser/discharge", func(w http.ResponseWriter, req *http.Request) {
+		serveDischarge(w, req, authEndpoint)
+	})
+}
+
+// serveDischarge reads a macaroon from the request body,
+// discharges all its third party caveats, which must all
+// be addressed to authEndpoint, and writes the resulting
+// macaroon slice as JSON.
+func serveDischarge(w http.ResponseWriter, req *http.Request, authEndpoint string) {
+	if req.Method != "POST" {
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var m bakery.Macaroon
+	if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+		fail(w, http.StatusBadRequest, "cannot unmarshal macaroon: %v", err)
+		return
+	}
+	// Any third party caveats in the discharge macaroons are
+	// added by the authorization service itself, so it's
+	// sufficient to check the caveats in the primary macaroon.
+	for _, cav := range m.M().Caveats() {
+		if cav.Location != "" && cav.Location != authEndpoint {
+			fail(w, http.StatusForbidden, "third party caveat location %q not allowed", cav.Location)
+			return
+		}
+	}
+	client := httpbakery.NewClient()
+	client.WebPageVisitor = httpbakery.WebBrowserVisitor
+	ms, err := client.DischargeAll(context.TODO(), &m)
+	if err != nil {
+		fail(w, http.StatusForbidden, "cannot discharge macaroon: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ms)
+}
+
+const browserPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>bakery example</title>
+<script>
+// get fetches the given path, gathering discharges
+// and retrying if the target service requires them.
+async function get(path) {
+	let resp = await fetch(path, {credentials: "same-origin"});
+	if (resp.status !== 401) {
+		return resp;
+	}
+	const err = await resp.json();
+	if (err.Code !== "macaroon discharge required") {
+		throw new Error(err.Message);
+	}
+	log("discharge required; asking the server to gather discharges");
+	const dresp = await fetch("/browser/discharge", {
+		method: "POST",
+		body: JSON.stringify(err.Info.Macaroon),
+	});
+	if (!dresp.ok) {
+		throw new Error(await dresp.text());
+	}
+	const ms = await dresp.json();
+	let name = "macaroon-" + (err.Info.CookieNameSuffix || "auth");
+	let cookiePath = err.Info.MacaroonPath || "/";
+	document.cookie = name + "=" + btoa(JSON.stringify(ms)) + "; path=" + cookiePath;
+	return fetch(path, {credentials: "same-origin"});
+}
+
+function log(msg) {
+	const li = document.createElement("li");
+	li.textContent = msg;
+	document.getElementById("log").appendChild(li);
+}
+
+async function request(path) {
+	log("GET " + path);
+	try {
+		const resp = await get(path);
+		log(resp.status + ": " + await resp.text());
+	} catch (e) {
+		log("error: " + e.message);
+	}
+}
+</script>
+</head>
+<body>
+<button onclick="request('/gold/')">get gold</button>
+<button onclick="request('/silver/')">get silver</button>
+<ul id="log"></ul>
+</body>
+</html>
+`
@@ -14,7 +14,12 @@
 // In a real system, these three components would
 // live on different machines; the client component
 // could also be a web browser.
-// (TODO: write javascript discharge gatherer)
+//
+// After the programmatic client has run, the example
+// prints a URL that can be opened in a web browser
+// to try the same requests from javascript. The
+// discharges for those requests are still gathered
+// by the example server rather than the browser.
 package main
 
 import (
@@ -44,6 +49,9 @@ func main() {
 		log.Fatalf("client failed: %v", err)
 	}
 	fmt.Printf("client success: %q\n", resp)
+	fmt.Printf("open %s/browser/ in a web browser on this machine to make the same requests from javascript (discharges are gathered by the server)\n", serverEndpoint)
+	fmt.Printf("interrupt to exit\n")
+	select {}
 }
 
 func mustServe(newHandler func(string) (http.Handler, error)) (endpointURL string) {
@@ -52,6 +52,7 @@ func targetService(endpoint, authEndpoint string) (http.Handler, error) {
 	}
 	mux.Handle("/gold/", srv.auth(http.HandlerFunc(srv.serveGold)))
 	mux.Handle("/silver/", srv.auth(http.HandlerFunc(srv.serveSilver)))
+	addBrowserHandlers(mux, authEndpoint)
 	return mux, nil
 }
 
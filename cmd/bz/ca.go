@@ -20,6 +20,7 @@ type BZ struct {
 	r            int
 	m0           int
 	xsize, ysize int
+	wrap         bool // treat the grid as a torus
 }
 
 type Cell struct {
@@ -29,13 +30,17 @@ type Cell struct {
 	x, y      float32 // random point within cell (only used to calculate neighbours)
 }
 
-func NewBZ(xsize, ysize, n, r, m0 int) *BZ {
+// NewBZ returns a new BZ automaton of the given size. If wrap is true,
+// the neighborhood of cells at the edges wraps around to the opposite
+// edge; otherwise the edges are hard boundaries.
+func NewBZ(xsize, ysize, n, r, m0 int, wrap bool) *BZ {
 	bz := &BZ{
 		xsize: xsize,
 		ysize: ysize,
 		n:     n,
 		r:     r,
 		m0:    m0,
+		wrap:  wrap,
 		cells: make([]Cell, xsize*ysize),
 	}
 	bz.assignPositions()
@@ -76,10 +81,11 @@ func (bz *BZ) calcNeighbors() {
 			neighbors = neighbors[:0]
 			for j := y - bz.r; j <= y+bz.r; j++ {
 				for i := x - bz.r; i <= x+bz.r; i++ {
-					if j < 0 || j >= bz.ysize || i < 0 || i >= bz.xsize {
+					ni, nj, ok := bz.cellPos(i, j)
+					if !ok {
 						continue
 					}
-					ncell := &bz.cells[j*bz.xsize+i]
+					ncell := &bz.cells[nj*bz.xsize+ni]
 					xdelta := float32(i) + ncell.x - xpos
 					ydelta := float32(j) + ncell.y - ypos
 					dist2 := xdelta*xdelta + ydelta*ydelta
@@ -96,6 +102,27 @@ func (bz *BZ) calcNeighbors() {
 	}
 }
 
+// cellPos returns the position of the cell at (x, y),
+// wrapping around the edges if bz.wrap is set.
+// It reports false if there is no such cell.
+func (bz *BZ) cellPos(x, y int) (int, int, bool) {
+	if bz.wrap {
+		return mod(x, bz.xsize), mod(y, bz.ysize), true
+	}
+	if y < 0 || y >= bz.ysize || x < 0 || x >= bz.xsize {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+func mod(x, n int) int {
+	x %= n
+	if x < 0 {
+		x += n
+	}
+	return x
+}
+
 func (bz *BZ) Step() {
 	for i := range bz.cells {
 		bz.cellStep1(&bz.cells[i])
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCornerNeighbors(t *testing.T) {
+	fixed := cornerNeighbors(false)
+	wrapped := cornerNeighbors(true)
+	want := image.Pt(4, 0)
+	if fixed[want] {
+		t.Errorf("fixed boundary: corner cell unexpectedly has neighbor at %v", want)
+	}
+	if !wrapped[want] {
+		t.Errorf("toroidal boundary: corner cell has no neighbor at %v", want)
+	}
+	if len(wrapped) <= len(fixed) {
+		t.Errorf("toroidal boundary: got %d neighbors, want more than %d", len(wrapped), len(fixed))
+	}
+}
+
+// cornerNeighbors returns the positions of the neighbors
+// of the top left cell in a small grid.
+func cornerNeighbors(wrap bool) map[image.Point]bool {
+	bz := NewBZ(5, 5, 6, 2, 1, wrap)
+	// Put all cells at their centres so that the
+	// neighborhood is deterministic.
+	for i := range bz.cells {
+		bz.cells[i].x = 0.5
+		bz.cells[i].y = 0.5
+		bz.cells[i].neighbors = nil
+	}
+	bz.calcNeighbors()
+	found := make(map[image.Point]bool)
+	for _, n := range bz.cells[0].neighbors {
+		for i := range bz.cells {
+			if n == &bz.cells[i] {
+				found[image.Pt(i%bz.xsize, i/bz.xsize)] = true
+			}
+		}
+	}
+	return found
+}
@@ -21,7 +21,10 @@ import (
 	"gioui.org/unit"
 )
 
-var fillFlag = flag.String("fill", "rand", "initial state; one of rand, spiral[1234]")
+var (
+	fillFlag = flag.String("fill", "rand", "initial state; one of rand, spiral[1234]")
+	wrapFlag = flag.Bool("wrap", false, "treat the grid as toroidal rather than having fixed edges")
+)
 
 func main() {
 	flag.Usage = func() {
@@ -53,7 +56,7 @@ func main() {
 		}
 	}
 	imgc := make(chan draw.Image, 1)
-	go renderer(imgc, xsize, ysize, 3, filler)
+	go renderer(imgc, xsize, ysize, 3, *wrapFlag, filler)
 	go func() {
 		w := app.NewWindow(app.Size(unit.Dp(float32(xsize)), unit.Dp(float32(ysize))))
 		if err := loop(w, imgc); err != nil {
@@ -99,10 +102,10 @@ func loop(w *app.Window, imgc <-chan draw.Image) error {
 	}
 }
 
-func renderer(imgc chan<- draw.Image, xsize, ysize, width int, fill func(*BZ, int)) {
+func renderer(imgc chan<- draw.Image, xsize, ysize, width int, wrap bool, fill func(*BZ, int)) {
 	const n = 6
 	size := image.Pt(xsize, ysize)
-	bz := NewBZ(size.X, size.Y, n, 3, 1)
+	bz := NewBZ(size.X, size.Y, n, 3, 1, wrap)
 	palette := make([]color.RGBA, bz.NStates())
 	greyInterval := float64(255) / float64(len(palette)-1)
 	for i := range palette {
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// computation holds a parsed -compute expression of the form
+//
+//	name = expr
+//
+// where expr is an arithmetic expression using the binary
+// operators + - * / and %, unary minus, parentheses, numbers and
+// column references. A column is referenced either as $N, where N is
+// its zero-indexed column number in the input, or, with -headers, by
+// its name in the header record, which must be a Go-like identifier.
+//
+// As in fc, the expression is held as a sequence of operations
+// on a stack of numbers.
+type computation struct {
+	// name holds the name of the computed column.
+	name string
+
+	// prog holds the operations in postfix order. Each is
+	// a float64 constant, a *colRef, or a unary or binary
+	// function of float64.
+	prog []interface{}
+
+	stack []float64
+}
+
+// colRef refers to a column of the input records.
+type colRef struct {
+	// name holds the header name of the column,
+	// or the empty string if it was referred to by number.
+	name string
+
+	// index holds the column number. For named columns,
+	// it's valid only after computation.bind has been called.
+	index int
+}
+
+func (r *colRef) String() string {
+	if r.name != "" {
+		return strconv.Quote(r.name)
+	}
+	return "$" + strconv.Itoa(r.index)
+}
+
+// parseComputation parses a -compute expression.
+func parseComputation(s string) (*computation, error) {
+	eq := strings.Index(s, "=")
+	if eq == -1 {
+		return nil, fmt.Errorf("compute expression %q is not of the form name = expr", s)
+	}
+	c := &computation{
+		name: strings.TrimSpace(s[0:eq]),
+	}
+	if c.name == "" {
+		return nil, fmt.Errorf("compute expression %q has no column name", s)
+	}
+	p := &exprParser{
+		s: s[eq+1:],
+	}
+	p.next()
+	if err := p.expr(); err != nil {
+		return nil, fmt.Errorf("bad compute expression %q: %v", s, err)
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("bad compute expression %q: unexpected %q", s, p.tok)
+	}
+	c.prog = p.prog
+	return c, nil
+}
+
+// bind resolves any column names in the expression
+// using the given header record.
+func (c *computation) bind(names []string) error {
+	for _, o := range c.prog {
+		ref, ok := o.(*colRef)
+		if !ok || ref.name == "" {
+			continue
+		}
+		if names == nil {
+			return fmt.Errorf("column name %q used without -headers", ref.name)
+		}
+		ref.index = -1
+		for i, name := range names {
+			if name == ref.name {
+				ref.index = i
+				break
+			}
+		}
+		if ref.index == -1 {
+			return fmt.Errorf("unknown column %q", ref.name)
+		}
+	}
+	return nil
+}
+
+// eval evaluates the expression over the given record and returns
+// the result formatted as a decimal number. It returns an error if any
+// referenced column is missing or doesn't hold a number.
+func (c *computation) eval(rec []string) (string, error) {
+	c.stack = c.stack[:0]
+	for _, o := range c.prog {
+		switch f := o.(type) {
+		case float64:
+			c.push(f)
+		case *colRef:
+			if f.index >= len(rec) {
+				return "", fmt.Errorf("column %v: no value", f)
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(rec[f.index]), 64)
+			if err != nil {
+				return "", fmt.Errorf("column %v: non-numeric value %q", f, rec[f.index])
+			}
+			c.push(v)
+		case func(float64) float64:
+			c.push(f(c.pop()))
+		case func(float64, float64) float64:
+			y, x := c.pop(), c.pop()
+			c.push(f(x, y))
+		default:
+			panic(fmt.Errorf("unknown operation type: %T", f))
+		}
+	}
+	return strconv.FormatFloat(c.pop(), 'f', -1, 64), nil
+}
+
+func (c *computation) push(v float64) {
+	c.stack = append(c.stack, v)
+}
+
+func (c *computation) pop() float64 {
+	v := c.stack[len(c.stack)-1]
+	c.stack = c.stack[0 : len(c.stack)-1]
+	return v
+}
+
+var binaryOps = map[string]func(float64, float64) float64{
+	"+": func(x, y float64) float64 { return x + y },
+	"-": func(x, y float64) float64 { return x - y },
+	"*": func(x, y float64) float64 { return x * y },
+	"/": func(x, y float64) float64 { return x / y },
+	"%": math.Mod,
+}
+
+func uminus(x float64) float64 {
+	return -x
+}
+
+// exprParser is a recursive descent parser for compute expressions
+// that produces operations in postfix order. The grammar is:
+//
+//	expr := term {("+" | "-") term}
+//	term := unary {("*" | "/" | "%") unary}
+//	unary := "-" unary | primary
+//	primary := number | "$" digits | identifier | "(" expr ")"
+type exprParser struct {
+	s    string
+	tok  string
+	prog []interface{}
+}
+
+func (p *exprParser) expr() error {
+	if err := p.term(); err != nil {
+		return err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok
+		p.next()
+		if err := p.term(); err != nil {
+			return err
+		}
+		p.prog = append(p.prog, binaryOps[op])
+	}
+	return nil
+}
+
+func (p *exprParser) term() error {
+	if err := p.unary(); err != nil {
+		return err
+	}
+	for p.tok == "*" || p.tok == "/" || p.tok == "%" {
+		op := p.tok
+		p.next()
+		if err := p.unary(); err != nil {
+			return err
+		}
+		p.prog = append(p.prog, binaryOps[op])
+	}
+	return nil
+}
+
+func (p *exprParser) unary() error {
+	if p.tok != "-" {
+		return p.primary()
+	}
+	p.next()
+	if err := p.unary(); err != nil {
+		return err
+	}
+	p.prog = append(p.prog, uminus)
+	return nil
+}
+
+func (p *exprParser) primary() error {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		p.next()
+		if err := p.expr(); err != nil {
+			return err
+		}
+		if p.tok != ")" {
+			return fmt.Errorf("missing )")
+		}
+	case tok[0] == '$':
+		n, err := strconv.Atoi(tok[1:])
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad column number %q", tok)
+		}
+		p.prog = append(p.prog, &colRef{index: n})
+	case isIdentStart(rune(tok[0])):
+		p.prog = append(p.prog, &colRef{name: tok})
+	case tok[0] == '.' || '0' <= tok[0] && tok[0] <= '9':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return fmt.Errorf("bad number %q", tok)
+		}
+		p.prog = append(p.prog, v)
+	default:
+		return fmt.Errorf("unexpected %q", tok)
+	}
+	p.next()
+	return nil
+}
+
+// next reads the next token into p.tok, which is
+// set to the empty string at the end of the input.
+func (p *exprParser) next() {
+	p.s = strings.TrimLeftFunc(p.s, unicode.IsSpace)
+	if p.s == "" {
+		p.tok = ""
+		return
+	}
+	n := 1
+	switch c := rune(p.s[0]); {
+	case c == '$':
+		n += strings.IndexFunc(p.s[1:], func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+	case isIdentStart(c):
+		n = strings.IndexFunc(p.s, func(r rune) bool {
+			return !isIdentStart(r) && !unicode.IsDigit(r)
+		})
+	case c == '.' || unicode.IsDigit(c):
+		n = strings.IndexFunc(p.s, func(r rune) bool {
+			return r != '.' && !unicode.IsDigit(r)
+		})
+	}
+	if n <= 0 {
+		n = len(p.s)
+	}
+	p.tok, p.s = p.s[0:n], p.s[n:]
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+var computationTests = []struct {
+	expr        string
+	names       []string
+	rec         []string
+	expect      string
+	expectError string
+}{{
+	expr:   "x = 1 + 2 * 3",
+	expect: "7",
+}, {
+	expr:   "x = (1 + 2) * 3",
+	expect: "9",
+}, {
+	expr:   "x = 10 - 4 - 3",
+	expect: "3",
+}, {
+	expr:   "x = 7 % 4 / 2",
+	expect: "1.5",
+}, {
+	expr:   "x = --2 * -3",
+	expect: "-6",
+}, {
+	expr:   "percent = $1 * 100",
+	rec:    []string{"a", "0.25"},
+	expect: "25",
+}, {
+	expr:   "x=$0/$1",
+	rec:    []string{" 3 ", "4"},
+	expect: "0.75",
+}, {
+	expr:   "total = price * qty + _x1",
+	names:  []string{"qty", "price", "_x1"},
+	rec:    []string{"3", "1.5", ".5"},
+	expect: "5",
+}, {
+	expr:        "x = $1 + 1",
+	rec:         []string{"1", "two"},
+	expectError: `column $1: non-numeric value "two"`,
+}, {
+	expr:        "x = $1 + 1",
+	rec:         []string{"1", ""},
+	expectError: `column $1: non-numeric value ""`,
+}, {
+	expr:        "x = $3",
+	rec:         []string{"1", "2"},
+	expectError: `column $3: no value`,
+}, {
+	expr:        "x = price",
+	names:       []string{"price"},
+	rec:         []string{"cheap"},
+	expectError: `column "price": non-numeric value "cheap"`,
+}}
+
+func TestComputation(t *testing.T) {
+	for _, test := range computationTests {
+		c, err := parseComputation(test.expr)
+		if err != nil {
+			t.Errorf("%s: cannot parse: %v", test.expr, err)
+			continue
+		}
+		if test.names != nil {
+			if err := c.bind(test.names); err != nil {
+				t.Errorf("%s: cannot bind: %v", test.expr, err)
+				continue
+			}
+		}
+		got, err := c.eval(test.rec)
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("%s: unexpected error; got %v want %q", test.expr, err, test.expectError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.expr, err)
+			continue
+		}
+		if got != test.expect {
+			t.Errorf("%s: got %q want %q", test.expr, got, test.expect)
+		}
+	}
+}
+
+var parseComputationErrorTests = []struct {
+	expr        string
+	expectError string
+}{{
+	expr:        "$1 * 2",
+	expectError: `compute expression "$1 * 2" is not of the form name = expr`,
+}, {
+	expr:        " = 1",
+	expectError: `compute expression " = 1" has no column name`,
+}, {
+	expr:        "x = ",
+	expectError: `bad compute expression "x = ": unexpected end of expression`,
+}, {
+	expr:        "x = (1 + 2",
+	expectError: `bad compute expression "x = (1 + 2": missing )`,
+}, {
+	expr:        "x = 1 2",
+	expectError: `bad compute expression "x = 1 2": unexpected "2"`,
+}, {
+	expr:        "x = $ + 1",
+	expectError: `bad compute expression "x = $ + 1": bad column number "$"`,
+}, {
+	expr:        "x = 1..2",
+	expectError: `bad compute expression "x = 1..2": bad number "1..2"`,
+}, {
+	expr:        "x = 1 * * 2",
+	expectError: `bad compute expression "x = 1 * * 2": unexpected "*"`,
+}}
+
+func TestParseComputationError(t *testing.T) {
+	for _, test := range parseComputationErrorTests {
+		_, err := parseComputation(test.expr)
+		if err == nil || err.Error() != test.expectError {
+			t.Errorf("%s: unexpected error; got %v want %q", test.expr, err, test.expectError)
+		}
+	}
+}
+
+const computeInput = `item,price,qty
+apple,0.5,4
+pear,n/a,2
+plum,2,
+fig
+`
+
+var copyRecordsComputeTests = []struct {
+	testName    string
+	expr        string
+	fields      []int
+	headers     bool
+	strict      bool
+	expect      string
+	expectError string
+}{{
+	testName: "by-number",
+	expr:     "cost = $1 * $2",
+	expect: `item,price,qty,
+apple,0.5,4,2
+pear,n/a,2,
+plum,2,,
+fig,
+`,
+}, {
+	testName: "by-name",
+	expr:     "cost = price * qty",
+	headers:  true,
+	fields:   []int{0},
+	expect: `item,cost
+apple,2
+pear,
+plum,
+fig,
+`,
+}, {
+	testName:    "strict-non-numeric",
+	expr:        "cost = price * qty",
+	headers:     true,
+	strict:      true,
+	expectError: `line 3: column "price": non-numeric value "n/a"`,
+	expect: `item,price,qty,cost
+apple,0.5,4,2
+`,
+}, {
+	testName:    "unknown-name",
+	expr:        "cost = price * amount",
+	headers:     true,
+	expectError: `unknown column "amount"`,
+}, {
+	testName:    "name-without-headers",
+	expr:        "cost = price * qty",
+	expectError: `column name "price" used without -headers`,
+}}
+
+func TestCopyRecordsCompute(t *testing.T) {
+	defer func() {
+		*headers, *strict = false, false
+	}()
+	for _, test := range copyRecordsComputeTests {
+		*headers, *strict = test.headers, test.strict
+		c, err := parseComputation(test.expr)
+		if err != nil {
+			t.Fatalf("%s: cannot parse: %v", test.testName, err)
+		}
+		r := csv.NewReader(strings.NewReader(computeInput))
+		r.FieldsPerRecord = -1
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		err = copyRecords(w, r, test.fields, c, nil)
+		w.Flush()
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("%s: unexpected error; got %v want %q", test.testName, err, test.expectError)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.testName, err)
+		}
+		if got := buf.String(); got != test.expect {
+			t.Errorf("%s: unexpected output; got %q want %q", test.testName, got, test.expect)
+		}
+	}
+}
+
+func TestJSONWriterCompute(t *testing.T) {
+	c, err := parseComputation("cost = $1 * $2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(strings.NewReader("apple,0.5,4\n"))
+	var buf bytes.Buffer
+	fields := []int{2, 0}
+	w := newJSONWriter(&buf, fields, false, false)
+	w.computed = c.name
+	if err := copyRecords(w, r, fields, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"2":"4","0":"apple","cost":"2"}`+"\n"; got != want {
+		t.Fatalf("unexpected output; got %q want %q", got, want)
+	}
+}
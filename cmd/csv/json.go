@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// jsonWriter writes records as JSON objects, either one per line
+// (newline-delimited JSON) or as elements of a single JSON array.
+// Records are written as they arrive, so memory use is bounded
+// in both forms.
+type jsonWriter struct {
+	w      *bufio.Writer
+	fields []int
+	array  bool
+
+	// headers holds whether the first record holds
+	// the keys to use for the objects.
+	headers bool
+
+	// names holds the keys read from the header record.
+	names []string
+
+	// computed holds the name of the column added by -compute,
+	// if any, which is always the last field of a record.
+	computed string
+
+	// n holds the number of records written so far.
+	n int
+}
+
+// newJSONWriter returns a writer that writes records to w. The objects
+// are keyed by the values in the first record if headers is true, or
+// by column number otherwise, where fields holds the column numbers of
+// the selected fields, if any. If array is true, the objects are
+// written as a JSON array; otherwise one object is written per line.
+//
+// The Close method must be called after all records have been
+// written.
+func newJSONWriter(w io.Writer, fields []int, headers, array bool) *jsonWriter {
+	return &jsonWriter{
+		w:       bufio.NewWriter(w),
+		fields:  fields,
+		headers: headers,
+		array:   array,
+	}
+}
+
+// Write writes a single record.
+func (w *jsonWriter) Write(rec []string) error {
+	if w.headers && w.names == nil {
+		w.names = append([]string{}, rec...)
+		return nil
+	}
+	switch {
+	case !w.array:
+	case w.n == 0:
+		w.w.WriteString("[\n")
+	default:
+		w.w.WriteString(",\n")
+	}
+	w.n++
+	w.w.WriteByte('{')
+	for i, val := range rec {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+		if i == len(rec)-1 && w.computed != "" {
+			w.writeString(w.computed)
+		} else {
+			w.writeString(w.key(i))
+		}
+		w.w.WriteByte(':')
+		w.writeString(val)
+	}
+	w.w.WriteByte('}')
+	if !w.array {
+		w.w.WriteByte('\n')
+	}
+	// Any write error is sticky, so it's sufficient to check
+	// it here rather than after each write.
+	_, err := w.w.Write(nil)
+	return err
+}
+
+// key returns the key for the i'th field of a record.
+func (w *jsonWriter) key(i int) string {
+	switch {
+	case i < len(w.names):
+		return w.names[i]
+	case i < len(w.fields):
+		return strconv.Itoa(w.fields[i])
+	}
+	return strconv.Itoa(i)
+}
+
+func (w *jsonWriter) writeString(s string) {
+	data, _ := json.Marshal(s)
+	w.w.Write(data)
+}
+
+// Close finishes the output and flushes it
+// to the underlying writer.
+func (w *jsonWriter) Close() error {
+	if w.array {
+		if w.n == 0 {
+			w.w.WriteString("[")
+		}
+		w.w.WriteString("\n]\n")
+	}
+	return w.w.Flush()
+}
@@ -14,8 +14,20 @@ var (
 	sep        = flag.String("sep", ",", "separator character (must be one character)")
 	lazyQuotes = flag.Bool("lazyquotes", false, "allow lazy quotes: a quote may appear in an unquoted field and a non-doubled quote may appear in a quoted field.")
 	outSep     = flag.String("outsep", ",", "separator character on output")
+	headers    = flag.Bool("headers", false, "treat the first record as column headers")
+	statsFlag  = flag.Bool("stats", false, "print statistics for each column instead of the records")
+	maxCard    = flag.Int("maxcard", 10000, "maximum number of distinct values to count per column in -stats mode")
+	pad        = flag.Int("pad", 0, "pad or truncate every output record to exactly this many fields (not counting any -compute column)")
+	strict     = flag.Bool("strict", false, "fail if any record has a different number of fields from the first or a -compute column cannot be calculated")
+	compute    = flag.String("compute", "", "append a column calculated from each record by an expression like 'total = $1 * 100' using + - * / % and parentheses; $N refers to zero-indexed input column N and, with -headers, columns may also be referred to by name. The value is empty if any column used is missing or non-numeric")
+	outFormat  = flag.String("o", "csv", "output format: csv, json (one JSON object per line, keyed by header name with -headers or by column number otherwise) or jsonarray (a JSON array of objects)")
 )
 
+// recordWriter is implemented by *csv.Writer and *jsonWriter.
+type recordWriter interface {
+	Write(rec []string) error
+}
+
 func main() {
 	flag.Parse()
 	flag.Usage = func() {
@@ -40,22 +52,92 @@ func main() {
 		fields[i] = f
 	}
 
+	if *pad < 0 {
+		log.Fatalf("negative -pad value")
+	}
+	var comp *computation
+	if *compute != "" {
+		c, err := parseComputation(*compute)
+		if err != nil {
+			log.Fatal(err)
+		}
+		comp = c
+	}
+
 	r := csv.NewReader(os.Stdin)
 	r.LazyQuotes = true
 	r.Comma = sepr[0]
+	// We check the number of fields ourselves.
+	r.FieldsPerRecord = -1
 
-	w := csv.NewWriter(os.Stdout)
-	w.Comma = outSepr[0]
+	var w recordWriter
+	var closeWriter func() error
+	switch *outFormat {
+	case "csv":
+		cw := csv.NewWriter(os.Stdout)
+		cw.Comma = outSepr[0]
+		w, closeWriter = cw, func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json", "jsonarray":
+		jw := newJSONWriter(os.Stdout, fields, *headers, *outFormat == "jsonarray")
+		if comp != nil {
+			jw.computed = comp.name
+		}
+		w, closeWriter = jw, jw.Close
+	default:
+		log.Fatalf("unknown output format %q", *outFormat)
+	}
+
+	var st *stats
+	if *statsFlag {
+		st = newStats(*maxCard)
+	}
+	err := copyRecords(w, r, fields, comp, st)
+	if st != nil {
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := st.write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err1 := closeWriter(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
+// copyRecords copies records from r to w, selecting the given fields
+// (all of them if fields is empty) and applying the -pad and -strict
+// flags. If comp is non-nil, the column it computes is appended to
+// each record. If st is non-nil, the records are added to it instead
+// of being written.
+func copyRecords(w recordWriter, r *csv.Reader, fields []int, comp *computation, st *stats) error {
 	outRec := make([]string, len(fields))
-	for {
+	nfields := 0
+	if comp != nil && !*headers {
+		if err := comp.bind(nil); err != nil {
+			return err
+		}
+	}
+	for first := true; ; first = false {
 		rec, err := r.Read()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			w.Flush()
-			log.Fatal(err)
+			return err
+		}
+		if first {
+			nfields = len(rec)
+		} else if *strict && len(rec) != nfields {
+			line, _ := r.FieldPos(0)
+			return fmt.Errorf("line %d: record has %d fields; want %d", line, len(rec), nfields)
 		}
 		var out []string
 		if len(fields) == 0 {
@@ -70,12 +152,48 @@ func main() {
 				}
 			}
 		}
+		if *pad > 0 {
+			out = padRecord(out, *pad)
+		}
+		if comp != nil {
+			var val string
+			if first && *headers {
+				if err := comp.bind(rec); err != nil {
+					return err
+				}
+				val = comp.name
+			} else if val, err = comp.eval(rec); err != nil {
+				if *strict {
+					line, _ := r.FieldPos(0)
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+				val = ""
+			}
+			// Don't append to out directly because it
+			// may share its backing array with rec or outRec.
+			out = append(out[:len(out):len(out)], val)
+		}
+		if st != nil {
+			if first && *headers {
+				st.names = append([]string(nil), out...)
+			} else {
+				st.add(out)
+			}
+			continue
+		}
 		if err := w.Write(out); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
-	w.Flush()
-	if err := w.Error(); err != nil {
-		log.Fatal(err)
+}
+
+// padRecord returns rec truncated or padded with empty
+// fields so that it has exactly n fields.
+func padRecord(rec []string, n int) []string {
+	if len(rec) >= n {
+		return rec[:n]
 	}
+	padded := make([]string, n)
+	copy(padded, rec)
+	return padded
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+const raggedInput = `a,b,c
+1,2
+3,4,5,6
+`
+
+var copyRecordsTests = []struct {
+	testName    string
+	input       string
+	fields      []int
+	pad         int
+	strict      bool
+	expect      string
+	expectError string
+}{{
+	testName: "passthrough",
+	expect:   raggedInput,
+}, {
+	testName: "fields",
+	fields:   []int{2, 0},
+	expect: `c,a
+,1
+5,3
+`,
+}, {
+	testName: "pad",
+	pad:      3,
+	expect: `a,b,c
+1,2,
+3,4,5
+`,
+}, {
+	testName: "pad-wider",
+	pad:      5,
+	expect: `a,b,c,,
+1,2,,,
+3,4,5,6,
+`,
+}, {
+	testName: "pad-with-fields",
+	fields:   []int{3},
+	pad:      2,
+	expect: `,
+,
+6,
+`,
+}, {
+	testName:    "strict",
+	strict:      true,
+	expect:      "a,b,c\n",
+	expectError: "line 2: record has 2 fields; want 3",
+}, {
+	testName: "strict-rectangular",
+	input:    "a,b\n1,2\n",
+	strict:   true,
+	fields:   []int{1},
+	expect:   "b\n2\n",
+}}
+
+func TestCopyRecords(t *testing.T) {
+	defer func() {
+		*pad, *strict = 0, false
+	}()
+	for _, test := range copyRecordsTests {
+		*pad, *strict = test.pad, test.strict
+		input := test.input
+		if input == "" {
+			input = raggedInput
+		}
+		r := csv.NewReader(strings.NewReader(input))
+		r.FieldsPerRecord = -1
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		err := copyRecords(w, r, test.fields, nil, nil)
+		w.Flush()
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("%s: unexpected error; got %v want %q", test.testName, err, test.expectError)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.testName, err)
+		}
+		if got := buf.String(); got != test.expect {
+			t.Errorf("%s: unexpected output; got %q want %q", test.testName, got, test.expect)
+		}
+	}
+}
+
+const quotedInput = `name,"desc, long",n
+"Smith, J","said ""hi""",1
+O'Brien,"two
+lines",2
+`
+
+var jsonWriterTests = []struct {
+	testName string
+	fields   []int
+	headers  bool
+	array    bool
+	expect   string
+}{{
+	testName: "ndjson-headers",
+	headers:  true,
+	expect: `{"name":"Smith, J","desc, long":"said \"hi\"","n":"1"}
+{"name":"O'Brien","desc, long":"two\nlines","n":"2"}
+`,
+}, {
+	testName: "ndjson-column-numbers",
+	fields:   []int{2, 0},
+	expect: `{"2":"n","0":"name"}
+{"2":"1","0":"Smith, J"}
+{"2":"2","0":"O'Brien"}
+`,
+}, {
+	testName: "array-headers",
+	headers:  true,
+	array:    true,
+	expect: `[
+{"name":"Smith, J","desc, long":"said \"hi\"","n":"1"},
+{"name":"O'Brien","desc, long":"two\nlines","n":"2"}
+]
+`,
+}, {
+	testName: "array-column-numbers",
+	array:    true,
+	expect: `[
+{"0":"name","1":"desc, long","2":"n"},
+{"0":"Smith, J","1":"said \"hi\"","2":"1"},
+{"0":"O'Brien","1":"two\nlines","2":"2"}
+]
+`,
+}}
+
+func TestJSONWriter(t *testing.T) {
+	for _, test := range jsonWriterTests {
+		r := csv.NewReader(strings.NewReader(quotedInput))
+		var buf bytes.Buffer
+		w := newJSONWriter(&buf, test.fields, test.headers, test.array)
+		if err := copyRecords(w, r, test.fields, nil, nil); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.testName, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: unexpected error from Close: %v", test.testName, err)
+		}
+		if got := buf.String(); got != test.expect {
+			t.Errorf("%s: unexpected output; got %q want %q", test.testName, got, test.expect)
+		}
+	}
+}
+
+func TestJSONWriterEmptyArray(t *testing.T) {
+	var buf bytes.Buffer
+	w := newJSONWriter(&buf, nil, true, true)
+	if err := w.Write([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "[\n]\n"; got != want {
+		t.Fatalf("unexpected output; got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// stats accumulates per-column statistics over a stream of records.
+// Apart from the set of distinct values used to calculate cardinality,
+// which is limited to maxCard entries per column, the space used
+// is independent of the number of records.
+type stats struct {
+	names   []string
+	maxCard int
+	cols    []*colStats
+}
+
+// colStats holds the statistics for a single column.
+type colStats struct {
+	count int
+
+	// notInt, notFloat and notBool record whether
+	// any non-empty value has been seen that
+	// isn't of the respective type.
+	notInt   bool
+	notFloat bool
+	notBool  bool
+
+	// min and max hold the numeric range of the column
+	// while all the values have been numeric.
+	min, max float64
+
+	distinct   map[string]bool
+	cardCapped bool
+}
+
+func newStats(maxCard int) *stats {
+	return &stats{
+		maxCard: maxCard,
+	}
+}
+
+// add adds a record to the statistics.
+func (s *stats) add(rec []string) {
+	for len(s.cols) < len(rec) {
+		s.cols = append(s.cols, &colStats{
+			distinct: make(map[string]bool),
+		})
+	}
+	for i, v := range rec {
+		s.cols[i].add(v, s.maxCard)
+	}
+}
+
+func (c *colStats) add(v string, maxCard int) {
+	if v == "" {
+		return
+	}
+	c.count++
+	if !c.cardCapped && !c.distinct[v] {
+		if len(c.distinct) >= maxCard {
+			c.cardCapped = true
+			c.distinct = nil
+		} else {
+			c.distinct[v] = true
+		}
+	}
+	if !c.notBool {
+		switch strings.ToLower(v) {
+		case "true", "false":
+		default:
+			c.notBool = true
+		}
+	}
+	if !c.notInt {
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			c.notInt = true
+		}
+	}
+	if c.notFloat {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		c.notFloat = true
+		return
+	}
+	if c.count == 1 || f < c.min {
+		c.min = f
+	}
+	if c.count == 1 || f > c.max {
+		c.max = f
+	}
+}
+
+// typ returns the inferred type of the column.
+func (c *colStats) typ() string {
+	switch {
+	case c.count == 0:
+		return "empty"
+	case !c.notInt:
+		return "int"
+	case !c.notFloat:
+		return "float"
+	case !c.notBool:
+		return "bool"
+	}
+	return "string"
+}
+
+// write writes the statistics for all columns to w.
+func (s *stats) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "column\ttype\tcount\tmin\tmax\tcardinality\n")
+	for i, c := range s.cols {
+		name := strconv.Itoa(i)
+		if i < len(s.names) {
+			name = s.names[i]
+		}
+		min, max := "-", "-"
+		if t := c.typ(); t == "int" || t == "float" {
+			min = strconv.FormatFloat(c.min, 'g', -1, 64)
+			max = strconv.FormatFloat(c.max, 'g', -1, 64)
+		}
+		card := strconv.Itoa(len(c.distinct))
+		if c.cardCapped {
+			card = fmt.Sprintf(">%d", s.maxCard)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", name, c.typ(), c.count, min, max, card)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+const statsInput = `id,price,flag,name,mixed,none
+3,1,true,a,1,
+-1,2.5,FALSE,b,x,
+10,1e3,true,a,,
+`
+
+var copyRecordsStatsTests = []struct {
+	testName string
+	headers  bool
+	maxCard  int
+	fields   []int
+	expect   string
+}{{
+	testName: "headers",
+	headers:  true,
+	maxCard:  10,
+	expect: `
+column type   count min max  cardinality
+id     int    3     -1  10   3
+price  float  3     1   1000 3
+flag   bool   3     -   -    2
+name   string 3     -   -    2
+mixed  string 2     -   -    2
+none   empty  0     -   -    0
+`,
+}, {
+	testName: "capped",
+	headers:  true,
+	maxCard:  2,
+	expect: `
+column type   count min max  cardinality
+id     int    3     -1  10   >2
+price  float  3     1   1000 >2
+flag   bool   3     -   -    2
+name   string 3     -   -    2
+mixed  string 2     -   -    2
+none   empty  0     -   -    0
+`,
+}, {
+	testName: "no-headers",
+	maxCard:  10,
+	fields:   []int{0, 2},
+	expect: `
+column type   count min max cardinality
+0      string 4     -   -   4
+1      string 4     -   -   3
+`,
+}}
+
+func TestCopyRecordsStats(t *testing.T) {
+	defer func() {
+		*headers = false
+	}()
+	for _, test := range copyRecordsStatsTests {
+		*headers = test.headers
+		r := csv.NewReader(strings.NewReader(statsInput))
+		st := newStats(test.maxCard)
+		if err := copyRecords(nil, r, test.fields, nil, st); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.testName, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := st.write(&buf); err != nil {
+			t.Errorf("%s: cannot write stats: %v", test.testName, err)
+			continue
+		}
+		if got, want := buf.String(), test.expect[1:]; got != want {
+			t.Errorf("%s: unexpected output; got\n%s\nwant\n%s", test.testName, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/amz.v3/aws"
+	"gopkg.in/errgo.v1"
+)
+
+var profileFlag = flag.String("profile", "", "use credentials from the named profile in the shared credentials file")
+
+// getAuth returns the AWS credentials to use. If the -profile flag
+// is set, the credentials are read from that profile in the shared
+// credentials file; otherwise they're taken from the environment,
+// falling back to the profile named by $AWS_PROFILE (or "default")
+// in the shared credentials file.
+func getAuth() (aws.Auth, error) {
+	if *profileFlag != "" {
+		return sharedAuth(*profileFlag)
+	}
+	auth, envErr := aws.EnvAuth()
+	if envErr == nil {
+		return auth, nil
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	auth, fileErr := sharedAuth(profile)
+	if fileErr == nil {
+		return auth, nil
+	}
+	return aws.Auth{}, errgo.Newf("no AWS credentials found; tried environment (%v) and shared credentials file (%v)", envErr, fileErr)
+}
+
+// sharedAuth reads the credentials for the given profile from
+// the shared credentials file, as used by other AWS tools.
+// The file is $AWS_SHARED_CREDENTIALS_FILE if set, or
+// $HOME/.aws/credentials otherwise.
+func sharedAuth(profile string) (aws.Auth, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return aws.Auth{}, errgo.Mask(err)
+	}
+	defer f.Close()
+	var auth aws.Auth
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "aws_access_key_id":
+			auth.AccessKey = val
+		case "aws_secret_access_key":
+			auth.SecretKey = val
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return aws.Auth{}, errgo.Notef(err, "cannot read %s", path)
+	}
+	if !found {
+		return aws.Auth{}, errgo.Newf("profile %q not found in %s", profile, path)
+	}
+	if auth.AccessKey == "" || auth.SecretKey == "" {
+		return aws.Auth{}, errgo.Newf("incomplete credentials for profile %q in %s", profile, path)
+	}
+	return auth, nil
+}
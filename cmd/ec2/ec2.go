@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
@@ -9,7 +10,9 @@ import (
 	"gopkg.in/errgo.v1"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/juju/utils/parallel"
 )
@@ -26,6 +29,15 @@ var cmds []cmd
 
 var awsAuth aws.Auth
 
+var jsonFlag = flag.Bool("json", false, "print output of instances, groups, volumes and images commands as JSON")
+
+var yesFlag bool
+
+func init() {
+	flag.BoolVar(&yesFlag, "y", false, "do not ask for confirmation before terminating or deleting")
+	flag.BoolVar(&yesFlag, "yes", false, "same as -y")
+}
+
 func main() {
 	flag.Parse()
 	if flag.Arg(0) == "" {
@@ -59,9 +71,15 @@ func main() {
 		errorf("unknown command %q", flag.Arg(0))
 		os.Exit(2)
 	}
-	auth, err := aws.EnvAuth()
+	auth, err := getAuth()
 	if err != nil {
-		fatalf("envauth: %v", err)
+		fatalf("%v", err)
+	}
+	if *roleARNFlag != "" {
+		auth, sessionToken, err = assumeRole(auth, *roleARNFlag)
+		if err != nil {
+			fatalf("cannot assume role %q: %v", *roleARNFlag, err)
+		}
 	}
 	awsAuth = auth
 	if found.flags == nil {
@@ -76,7 +94,7 @@ func main() {
 	if !ok {
 		fatalf("no such region")
 	}
-	signer := aws.SignV4Factory(region.Name, "ec2")
+	signer := newSigner(region.Name, "ec2")
 	conn := ec2.New(auth, region, signer)
 	found.run(found, conn, found.flags.Args())
 }
@@ -110,13 +128,17 @@ func init() {
 }
 
 func groups(c cmd, conn *ec2.EC2, _ []string) {
-	resp, err := conn.SecurityGroups(nil, nil)
+	allGroups, err := describeSecurityGroups(conn)
 	check(err, "list groups")
+	if *jsonFlag {
+		printJSON(groupsJSON(allGroups))
+		return
+	}
 	var b bytes.Buffer
 	printf := func(f string, a ...interface{}) {
 		fmt.Fprintf(&b, f, a...)
 	}
-	for _, g := range resp.Groups {
+	for _, g := range allGroups {
 		switch {
 		case groupsFlags.vv:
 			printf("%s:%s %s %q\n", g.OwnerId, g.Name, g.Id, g.Description)
@@ -145,6 +167,7 @@ func groups(c cmd, conn *ec2.EC2, _ []string) {
 func init() {
 	flags := flag.NewFlagSet("instances", flag.ExitOnError)
 	addInstancesFlags(flags)
+	flags.BoolVar(&instancesFlags.allRegions, "allregions", false, "list instances in all regions, prefixed by region name")
 	cmds = append(cmds, cmd{
 		name:  "instances",
 		run:   instances,
@@ -153,29 +176,19 @@ func init() {
 }
 
 func instances(c cmd, conn *ec2.EC2, args []string) {
-	resp, err := conn.Instances(nil, nil)
+	if instancesFlags.allRegions {
+		allInstances(c, args)
+		return
+	}
+	allInsts, err := describeInstances(conn)
 	if err != nil {
 		fatalf("cannot get instances: %v", err)
 	}
-	var line []string
-	for _, r := range resp.Reservations {
-		for _, inst := range r.Instances {
-			if !instancesFlags.all && inst.State.Name == "terminated" {
-				continue
-			}
-			line = append(line[:0], inst.InstanceId)
-			if instancesFlags.state {
-				line = append(line, inst.State.Name)
-			}
-			if instancesFlags.addr {
-				if inst.DNSName == "" {
-					inst.DNSName = "none"
-				}
-				line = append(line, inst.DNSName)
-			}
-			fmt.Printf("%s\n", strings.Join(line, " "))
-		}
+	insts := make([]instanceResult, len(allInsts))
+	for i, inst := range allInsts {
+		insts[i] = instanceResult{Instance: inst}
 	}
+	printInstances(insts)
 }
 
 func init() {
@@ -190,12 +203,69 @@ func terminate(c cmd, conn *ec2.EC2, args []string) {
 	if len(args) == 0 {
 		return
 	}
+	if !yesFlag {
+		resp, err := conn.Instances(args, nil)
+		check(err, "get instances")
+		var items []string
+		for _, r := range resp.Reservations {
+			for _, inst := range r.Instances {
+				items = append(items, fmt.Sprintf("%s %q", inst.InstanceId, instanceName(inst)))
+			}
+		}
+		confirm("terminate instances", items)
+	}
 	_, err := conn.TerminateInstances(args)
 	if err != nil {
 		fatalf("cannot terminate instances: %v", err)
 	}
 }
 
+func init() {
+	cmds = append(cmds, cmd{
+		name: "start",
+		args: "[instance-id ...]",
+		run:  start,
+	})
+}
+
+func start(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	resp, err := conn.StartInstances(args...)
+	if err != nil {
+		fatalf("cannot start instances: %v", err)
+	}
+	printStateChanges(resp.StateChanges)
+}
+
+func init() {
+	cmds = append(cmds, cmd{
+		name: "stop",
+		args: "[instance-id ...]",
+		run:  stop,
+	})
+}
+
+func stop(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	resp, err := conn.StopInstances(args...)
+	if err != nil {
+		fatalf("cannot stop instances: %v", err)
+	}
+	printStateChanges(resp.StateChanges)
+}
+
+// printStateChanges prints the previous and current
+// state of each of the given instances.
+func printStateChanges(changes []ec2.InstanceStateChange) {
+	for _, sc := range changes {
+		fmt.Printf("%s %s -> %s\n", sc.InstanceId, sc.PreviousState.Name, sc.CurrentState.Name)
+	}
+}
+
 func init() {
 	cmds = append(cmds, cmd{
 		name: "delgroup",
@@ -205,6 +275,12 @@ func init() {
 }
 
 func delgroup(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	if !yesFlag {
+		confirm("delete security groups", args)
+	}
 	run := parallel.NewRun(40)
 	for _, g := range args {
 		g := g
@@ -292,6 +368,108 @@ func mkgroup(c cmd, conn *ec2.EC2, args []string) {
 	check(err, "create security group")
 }
 
+func init() {
+	flags := flag.NewFlagSet("volumes", flag.ExitOnError)
+	addVolumesFlags(flags)
+	cmds = append(cmds, cmd{
+		name:  "volumes",
+		run:   volumes,
+		flags: flags,
+	})
+}
+
+func volumes(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) != 0 {
+		c.usage()
+	}
+	allVols, err := describeVolumes(conn)
+	if err != nil {
+		fatalf("cannot get volumes: %v", err)
+	}
+	vols := make([]volumeResult, len(allVols))
+	for i, v := range allVols {
+		vols[i] = volumeResult{Volume: v}
+	}
+	printVolumes(vols)
+}
+
+var mkvolumeFlags struct {
+	volumeType string
+	attach     string
+}
+
+func init() {
+	flags := flag.NewFlagSet("mkvolume", flag.ExitOnError)
+	flags.StringVar(&mkvolumeFlags.volumeType, "type", "", "volume type (e.g. gp2, io1, standard)")
+	flags.StringVar(&mkvolumeFlags.attach, "attach", "", "attach the new volume as instance-id:device")
+	cmds = append(cmds, cmd{
+		name:  "mkvolume",
+		args:  "size-in-GiB availability-zone",
+		run:   mkvolume,
+		flags: flags,
+	})
+}
+
+func mkvolume(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) != 2 {
+		c.usage()
+	}
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size <= 0 {
+		fatalf("invalid volume size %q", args[0])
+	}
+	zone := args[1]
+	if !zoneInRegion(zone, conn.Region.Name) {
+		fatalf("availability zone %q is not in region %s", zone, conn.Region.Name)
+	}
+	var instId, device string
+	if mkvolumeFlags.attach != "" {
+		i := strings.Index(mkvolumeFlags.attach, ":")
+		if i <= 0 || i == len(mkvolumeFlags.attach)-1 {
+			fatalf("invalid -attach value %q; want instance-id:device", mkvolumeFlags.attach)
+		}
+		instId, device = mkvolumeFlags.attach[:i], mkvolumeFlags.attach[i+1:]
+	}
+	resp, err := conn.CreateVolume(ec2.CreateVolume{
+		AvailZone:  zone,
+		Size:       size,
+		VolumeType: mkvolumeFlags.volumeType,
+	})
+	check(err, "create volume")
+	fmt.Printf("%s\n", resp.Id)
+	if instId == "" {
+		return
+	}
+	waitVolumeAvailable(conn, resp.Id)
+	_, err = conn.AttachVolume(resp.Id, instId, device)
+	check(err, "attach volume %s to %s", resp.Id, instId)
+}
+
+// zoneInRegion reports whether the given availability zone
+// name is that of a zone in the given region: the region name
+// followed by a single lower case letter, as in us-east-1a.
+func zoneInRegion(zone, region string) bool {
+	if len(zone) != len(region)+1 || !strings.HasPrefix(zone, region) {
+		return false
+	}
+	c := zone[len(zone)-1]
+	return 'a' <= c && c <= 'z'
+}
+
+// waitVolumeAvailable waits for the volume with the given id
+// to become available so that it can be attached.
+func waitVolumeAvailable(conn *ec2.EC2, id string) {
+	const timeout = 2 * time.Minute
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(2 * time.Second) {
+		resp, err := conn.Volumes([]string{id}, nil)
+		check(err, "get volume %s", id)
+		if len(resp.Volumes) == 1 && resp.Volumes[0].Status == "available" {
+			return
+		}
+	}
+	fatalf("volume %s did not become available after %v", id, timeout)
+}
+
 func init() {
 	cmds = append(cmds, cmd{
 		name: "delvolume",
@@ -301,6 +479,12 @@ func init() {
 }
 
 func delVolume(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	if !yesFlag {
+		confirm("delete volumes", args)
+	}
 	run := parallel.NewRun(40)
 	for _, v := range args {
 		v := v
@@ -319,15 +503,18 @@ func delVolume(c cmd, conn *ec2.EC2, args []string) {
 }
 
 var instancesFlags struct {
-	addr  bool
-	state bool
-	all   bool
+	addr       bool
+	state      bool
+	all        bool
+	allRegions bool
+	uptime     bool
 }
 
 func addInstancesFlags(flags *flag.FlagSet) {
 	flags.BoolVar(&instancesFlags.all, "a", false, "print terminated instances too")
 	flags.BoolVar(&instancesFlags.addr, "addr", false, "print instance address")
 	flags.BoolVar(&instancesFlags.state, "state", false, "print instance state")
+	flags.BoolVar(&instancesFlags.uptime, "uptime", false, "print instance launch time and uptime (or state if not running)")
 }
 
 var ippermsFlags struct {
@@ -363,19 +550,14 @@ func ipPerms(args []string) (perms []ec2.IPPerm) {
 	var groups []ec2.UserSecurityGroup
 	var ips []string
 	for _, a := range args {
-		switch {
-		case ipPat.MatchString(a):
-			ips = append(ips, a)
-		case secGroupPat.MatchString(a):
-			groups = append(groups, ec2.UserSecurityGroup{Id: a})
-		case groupNamePat.MatchString(a):
-			m := groupNamePat.FindStringSubmatch(a)
-			groups = append(groups, ec2.UserSecurityGroup{
-				OwnerId: m[1],
-				Name:    m[2],
-			})
-		default:
-			fatalf("%q is neither security group id nor ip address", a)
+		ip, group, err := parseSource(a)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if ip != "" {
+			ips = append(ips, ip)
+		} else {
+			groups = append(groups, group)
 		}
 	}
 	return []ec2.IPPerm{{
@@ -388,6 +570,53 @@ func ipPerms(args []string) (perms []ec2.IPPerm) {
 	return
 }
 
+// parseSource parses the source of an ingress rule, which may be an
+// IP address range in CIDR notation, a security group id or
+// ownerid:groupname. If it's an IP address range, it returns it as
+// ip; otherwise it returns the security group.
+func parseSource(s string) (ip string, group ec2.UserSecurityGroup, err error) {
+	switch {
+	case ipPat.MatchString(s):
+		return s, group, nil
+	case secGroupPat.MatchString(s):
+		return "", ec2.UserSecurityGroup{Id: s}, nil
+	case groupNamePat.MatchString(s):
+		m := groupNamePat.FindStringSubmatch(s)
+		return "", ec2.UserSecurityGroup{
+			OwnerId: m[1],
+			Name:    m[2],
+		}, nil
+	}
+	return "", group, errgo.Newf("%q is neither security group id nor ip address", s)
+}
+
+// instanceName returns the value of the Name tag of the instance.
+func instanceName(inst ec2.Instance) string {
+	for _, t := range inst.Tags {
+		if t.Key == "Name" {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// confirm prints the given items and asks the user whether
+// to go ahead with the given action, exiting if they don't
+// answer yes.
+func confirm(action string, items []string) {
+	fmt.Fprintf(os.Stderr, "about to %s:\n", action)
+	for _, item := range items {
+		fmt.Fprintf(os.Stderr, "\t%s\n", item)
+	}
+	fmt.Fprintf(os.Stderr, "proceed? [y/N] ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return
+	}
+	fatalf("not confirmed")
+}
+
 func check(err error, e string, a ...interface{}) {
 	if err == nil {
 		return
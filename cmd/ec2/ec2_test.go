@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+var zoneInRegionTests = []struct {
+	zone   string
+	region string
+	expect bool
+}{
+	{"us-east-1a", "us-east-1", true},
+	{"eu-west-2c", "eu-west-2", true},
+	{"us-east-1", "us-east-1", false},
+	{"us-east-1ab", "us-east-1", false},
+	{"us-east-1A", "us-east-1", false},
+	{"us-east-10a", "us-east-1", false},
+	{"us-east-11", "us-east-1", false},
+	{"us-west-1a", "us-east-1", false},
+	{"", "us-east-1", false},
+}
+
+func TestZoneInRegion(t *testing.T) {
+	for _, test := range zoneInRegionTests {
+		if got := zoneInRegion(test.zone, test.region); got != test.expect {
+			t.Errorf("zoneInRegion(%q, %q) got %v want %v", test.zone, test.region, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/amz.v3/ec2"
+	"gopkg.in/errgo.v1"
+)
+
+var imagesFlags struct {
+	owner string
+	name  string
+}
+
+func init() {
+	flags := flag.NewFlagSet("images", flag.ExitOnError)
+	flags.StringVar(&imagesFlags.owner, "owner", "self", "list images owned by this account id or alias (self, amazon, aws-marketplace)")
+	flags.StringVar(&imagesFlags.name, "name", "", "only list images with names containing this string")
+	cmds = append(cmds, cmd{
+		name:  "images",
+		run:   images,
+		flags: flags,
+	})
+}
+
+// images lists AMIs, oldest first, printing the
+// id, name and creation date of each one.
+func images(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) != 0 {
+		c.usage()
+	}
+	imgs, err := describeImages(conn, imagesFlags.owner)
+	check(err, "list images")
+	j := 0
+	for _, img := range imgs {
+		if strings.Contains(img.Name, imagesFlags.name) {
+			imgs[j] = img
+			j++
+		}
+	}
+	imgs = imgs[:j]
+	sort.SliceStable(imgs, func(i, j int) bool {
+		return imgs[i].CreationDate < imgs[j].CreationDate
+	})
+	if *jsonFlag {
+		printJSON(imagesJSON(imgs))
+		return
+	}
+	for _, img := range imgs {
+		fmt.Printf("%s %q %s\n", img.Id, img.Name, img.CreationDate)
+	}
+}
+
+// The ec2 package's Images method can't select images by owner and
+// doesn't return their creation date, so we make the DescribeImages
+// request directly, fetching all the pages of results as the other
+// listing commands do.
+
+type imageInfo struct {
+	Id           string `xml:"imageId"`
+	Name         string `xml:"name"`
+	CreationDate string `xml:"creationDate"`
+}
+
+type imagesPage struct {
+	Images    []imageInfo `xml:"imagesSet>item"`
+	NextToken string      `xml:"nextToken"`
+}
+
+func (p *imagesPage) nextToken() string {
+	return p.NextToken
+}
+
+// describeImages returns all the images owned by the given owner.
+func describeImages(conn *ec2.EC2, owner string) ([]imageInfo, error) {
+	params := url.Values{
+		"Owner.1": {owner},
+	}
+	var pages []*imagesPage
+	err := ec2QueryAll(conn, "DescribeImages", params, func() page {
+		p := new(imagesPage)
+		pages = append(pages, p)
+		return p
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var imgs []imageInfo
+	for _, p := range pages {
+		imgs = append(imgs, p.Images...)
+	}
+	return imgs, nil
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/amz.v3/aws"
 	"gopkg.in/amz.v3/ec2"
@@ -51,12 +52,7 @@ func allInstances(c cmd, args []string) {
 		}
 		return inst0.InstanceId < inst1.InstanceId
 	})
-	for _, inst := range allInstances {
-		if !instancesFlags.all && inst.State.Name == "terminated" {
-			continue
-		}
-		fmt.Printf("%s %s\n", inst.regionName, inst)
-	}
+	printInstances(allInstances)
 }
 
 type instanceResult struct {
@@ -70,22 +66,64 @@ func (inst instanceResult) String() string {
 		s += " " + inst.State.Name
 	}
 	if instancesFlags.addr {
-		s += " " + inst.DNSName
+		if inst.DNSName == "" {
+			s += " none"
+		} else {
+			s += " " + inst.DNSName
+		}
+	}
+	if instancesFlags.uptime {
+		s += " " + launchTime(inst.Instance) + " " + uptime(inst.Instance)
 	}
 	return s
 }
 
+// launchTime returns the launch time of the given instance,
+// or "none" if it doesn't have one.
+func launchTime(inst ec2.Instance) string {
+	if inst.LaunchTime == "" {
+		return "none"
+	}
+	return inst.LaunchTime
+}
+
+// uptime returns how long the given instance has been running,
+// or its state if it is not running.
+func uptime(inst ec2.Instance) string {
+	if inst.State.Name != "running" {
+		return inst.State.Name
+	}
+	t, err := time.Parse(time.RFC3339, inst.LaunchTime)
+	if err != nil {
+		return "unknown"
+	}
+	return formatUptime(time.Since(t))
+}
+
+// formatUptime formats d to the nearest minute,
+// with days as the largest unit, for example "3d4h5m".
+func formatUptime(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	hours := d % (24 * time.Hour) / time.Hour
+	mins := d % time.Hour / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh%dm", days, hours, mins)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, mins)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
+
 func sendInstances(conn *ec2.EC2, instances chan<- instanceResult) error {
-	resp, err := conn.Instances(nil, nil)
+	insts, err := describeInstances(conn)
 	if err != nil {
 		return err
 	}
-	for _, r := range resp.Reservations {
-		for _, inst := range r.Instances {
-			instances <- instanceResult{
-				regionName: conn.Region.Name,
-				Instance:   inst,
-			}
+	for _, inst := range insts {
+		instances <- instanceResult{
+			regionName: conn.Region.Name,
+			Instance:   inst,
 		}
 	}
 	return nil
@@ -128,9 +166,7 @@ func allVolumes(c cmd, args []string) {
 		}
 		return v0.Id < v1.Id
 	})
-	for _, v := range allVolumes {
-		fmt.Printf("%s %s\n", v.regionName, v)
-	}
+	printVolumes(allVolumes)
 }
 
 type volumeResult struct {
@@ -150,11 +186,11 @@ func (v volumeResult) String() string {
 }
 
 func sendVolumes(conn *ec2.EC2, volumes chan<- volumeResult) error {
-	resp, err := conn.Volumes(nil, nil)
+	vols, err := describeVolumes(conn)
 	if err != nil {
 		return err
 	}
-	for _, v := range resp.Volumes {
+	for _, v := range vols {
 		volumes <- volumeResult{
 			regionName: conn.Region.Name,
 			Volume:     v,
@@ -169,7 +205,7 @@ func forAllRegions(f func(conn *ec2.EC2)) {
 		if differentAuthDomain(region.Name) {
 			continue
 		}
-		signer := aws.SignV4Factory(region.Name, "ec2")
+		signer := newSigner(region.Name, "ec2")
 		f(ec2.New(awsAuth, region, signer))
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/amz.v3/ec2"
+)
+
+// printInstances prints the given instances, omitting
+// terminated instances unless the -a flag is set.
+// If an instance has an associated region, the
+// region name is printed too.
+func printInstances(insts []instanceResult) {
+	out := []instanceJSON{}
+	for _, inst := range insts {
+		if !instancesFlags.all && inst.State.Name == "terminated" {
+			continue
+		}
+		if *jsonFlag {
+			j := instanceJSON{
+				Region: inst.regionName,
+				Id:     inst.InstanceId,
+				State:  inst.State.Name,
+				Addr:   inst.DNSName,
+			}
+			if instancesFlags.uptime {
+				j.LaunchTime = inst.LaunchTime
+				j.Uptime = uptime(inst.Instance)
+			}
+			out = append(out, j)
+			continue
+		}
+		if inst.regionName != "" {
+			fmt.Printf("%s %s\n", inst.regionName, inst)
+		} else {
+			fmt.Printf("%s\n", inst)
+		}
+	}
+	if *jsonFlag {
+		printJSON(out)
+	}
+}
+
+// printVolumes prints the given volumes. If a volume
+// has an associated region, the region name is printed too.
+func printVolumes(vols []volumeResult) {
+	if *jsonFlag {
+		out := make([]volumeJSON, len(vols))
+		for i, v := range vols {
+			out[i] = volumeJSON{
+				Region:     v.regionName,
+				Id:         v.Id,
+				Size:       v.Size,
+				CreateTime: v.CreateTime,
+			}
+		}
+		printJSON(out)
+		return
+	}
+	for _, v := range vols {
+		if v.regionName != "" {
+			fmt.Printf("%s %s\n", v.regionName, v)
+		} else {
+			fmt.Printf("%s\n", v)
+		}
+	}
+}
+
+type instanceJSON struct {
+	Region string `json:"region,omitempty"`
+	Id     string `json:"id"`
+	State  string `json:"state"`
+	Addr   string `json:"addr,omitempty"`
+	// LaunchTime and Uptime are only filled
+	// out when the -uptime flag is set.
+	LaunchTime string `json:"launchTime,omitempty"`
+	Uptime     string `json:"uptime,omitempty"`
+}
+
+type volumeJSON struct {
+	Region     string `json:"region,omitempty"`
+	Id         string `json:"id"`
+	Size       int    `json:"size"`
+	CreateTime string `json:"ctime"`
+}
+
+type imageJSON struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	CreationDate string `json:"ctime"`
+}
+
+func imagesJSON(imgs []imageInfo) []imageJSON {
+	out := make([]imageJSON, len(imgs))
+	for i, img := range imgs {
+		out[i] = imageJSON{
+			Id:           img.Id,
+			Name:         img.Name,
+			CreationDate: img.CreationDate,
+		}
+	}
+	return out
+}
+
+type groupJSON struct {
+	Name        string     `json:"name"`
+	Id          string     `json:"id"`
+	OwnerId     string     `json:"owner"`
+	Description string     `json:"description"`
+	Perms       []permJSON `json:"perms,omitempty"`
+}
+
+type permJSON struct {
+	Protocol     string   `json:"proto"`
+	FromPort     int      `json:"from"`
+	ToPort       int      `json:"to"`
+	SourceGroups []string `json:"groups,omitempty"`
+	SourceIPs    []string `json:"ips,omitempty"`
+}
+
+func groupsJSON(groups []ec2.SecurityGroupInfo) []groupJSON {
+	out := make([]groupJSON, len(groups))
+	for i, g := range groups {
+		out[i] = groupJSON{
+			Name:        g.Name,
+			Id:          g.Id,
+			OwnerId:     g.OwnerId,
+			Description: g.Description,
+		}
+		for _, p := range g.IPPerms {
+			perm := permJSON{
+				Protocol:  p.Protocol,
+				FromPort:  p.FromPort,
+				ToPort:    p.ToPort,
+				SourceIPs: p.SourceIPs,
+			}
+			for _, sg := range p.SourceGroups {
+				perm.SourceGroups = append(perm.SourceGroups, sg.Id)
+			}
+			out[i].Perms = append(out[i].Perms, perm)
+		}
+	}
+	return out
+}
+
+// printJSON prints v to the standard output as indented JSON.
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	check(err, "marshal JSON")
+	data = append(data, '\n')
+	os.Stdout.Write(data)
+}
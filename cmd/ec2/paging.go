@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"gopkg.in/amz.v3/ec2"
+	"gopkg.in/errgo.v1"
+)
+
+// The ec2 package fetches only the first page of results from the
+// Describe actions and doesn't expose the NextToken that's needed to
+// fetch the rest, so the listing commands make those requests
+// directly, fetching pages until there's no NextToken. The responses
+// have the same form as those decoded by the ec2 package, so they
+// can be unmarshaled into its types.
+
+// pageSize holds the MaxResults value sent with paged requests.
+// It's within the allowed range for all the actions we page through.
+const pageSize = 500
+
+// pagingVersion holds the API version used for paged requests.
+// Some actions, such as DescribeSecurityGroups, only accept
+// MaxResults and NextToken from this version.
+const pagingVersion = "2016-11-15"
+
+// page is implemented by the values that pages
+// of results are unmarshaled into.
+type page interface {
+	nextToken() string
+}
+
+// ec2QueryAll makes the given EC2 API request repeatedly until all
+// the pages of results have been fetched. It calls newPage to obtain
+// the value to unmarshal each page into.
+func ec2QueryAll(conn *ec2.EC2, action string, params url.Values, newPage func() page) error {
+	params.Set("Version", pagingVersion)
+	params.Set("MaxResults", strconv.Itoa(pageSize))
+	for {
+		p := newPage()
+		if err := ec2Query(conn, action, params, p); err != nil {
+			return errgo.Mask(err)
+		}
+		token := p.nextToken()
+		if token == "" {
+			return nil
+		}
+		params.Set("NextToken", token)
+	}
+}
+
+type instancesPage struct {
+	ec2.InstancesResp
+	NextToken string `xml:"nextToken"`
+}
+
+func (p *instancesPage) nextToken() string {
+	return p.NextToken
+}
+
+// describeInstances returns all the instances in conn's region.
+func describeInstances(conn *ec2.EC2) ([]ec2.Instance, error) {
+	var pages []*instancesPage
+	err := ec2QueryAll(conn, "DescribeInstances", url.Values{}, func() page {
+		p := new(instancesPage)
+		pages = append(pages, p)
+		return p
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var insts []ec2.Instance
+	for _, p := range pages {
+		for _, r := range p.Reservations {
+			insts = append(insts, r.Instances...)
+		}
+	}
+	return insts, nil
+}
+
+type securityGroupsPage struct {
+	ec2.SecurityGroupsResp
+	NextToken string `xml:"nextToken"`
+}
+
+func (p *securityGroupsPage) nextToken() string {
+	return p.NextToken
+}
+
+// describeSecurityGroups returns all the security groups
+// in conn's region.
+func describeSecurityGroups(conn *ec2.EC2) ([]ec2.SecurityGroupInfo, error) {
+	var pages []*securityGroupsPage
+	err := ec2QueryAll(conn, "DescribeSecurityGroups", url.Values{}, func() page {
+		p := new(securityGroupsPage)
+		pages = append(pages, p)
+		return p
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var groups []ec2.SecurityGroupInfo
+	for _, p := range pages {
+		groups = append(groups, p.Groups...)
+	}
+	return groups, nil
+}
+
+type volumesPage struct {
+	ec2.VolumesResp
+	NextToken string `xml:"nextToken"`
+}
+
+func (p *volumesPage) nextToken() string {
+	return p.NextToken
+}
+
+// describeVolumes returns all the volumes in conn's region.
+func describeVolumes(conn *ec2.EC2) ([]ec2.Volume, error) {
+	var pages []*volumesPage
+	err := ec2QueryAll(conn, "DescribeVolumes", url.Values{}, func() page {
+		p := new(volumesPage)
+		pages = append(pages, p)
+		return p
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var vols []ec2.Volume
+	for _, p := range pages {
+		vols = append(vols, p.Volumes...)
+	}
+	return vols, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"gopkg.in/amz.v3/aws"
+	"gopkg.in/amz.v3/ec2"
+)
+
+// newFakeEC2 returns a server that responds to the given action with
+// the given pages of results, marshaled as XML. Each page except the
+// last is returned with a NextToken that refers to the next page.
+func newFakeEC2(t *testing.T, action string, pages ...func(token string) interface{}) (*ec2.EC2, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if got := q.Get("Action"); got != action {
+			t.Errorf("unexpected action %q", got)
+		}
+		if got := q.Get("Version"); got != pagingVersion {
+			t.Errorf("unexpected version %q", got)
+		}
+		if got := q.Get("MaxResults"); got != strconv.Itoa(pageSize) {
+			t.Errorf("unexpected MaxResults %q", got)
+		}
+		i := 0
+		if token := q.Get("NextToken"); token != "" {
+			n, err := strconv.Atoi(token)
+			if err != nil || n < 1 || n >= len(pages) {
+				http.Error(w, "bad token", http.StatusBadRequest)
+				return
+			}
+			i = n
+		}
+		token := ""
+		if i < len(pages)-1 {
+			token = strconv.Itoa(i + 1)
+		}
+		data, err := xml.Marshal(pages[i](token))
+		if err != nil {
+			t.Errorf("cannot marshal page: %v", err)
+		}
+		w.Write(data)
+	}))
+	conn := &ec2.EC2{
+		Auth: aws.Auth{
+			AccessKey: "access",
+			SecretKey: "secret",
+		},
+		Region: aws.Region{
+			Name:        "test",
+			EC2Endpoint: srv.URL,
+		},
+	}
+	return conn, srv
+}
+
+func TestDescribeInstancesPages(t *testing.T) {
+	page := func(ids ...string) func(string) interface{} {
+		return func(token string) interface{} {
+			p := &instancesPage{
+				NextToken: token,
+			}
+			for _, id := range ids {
+				p.Reservations = append(p.Reservations, ec2.Reservation{
+					Instances: []ec2.Instance{{InstanceId: id}},
+				})
+			}
+			return p
+		}
+	}
+	conn, srv := newFakeEC2(t, "DescribeInstances", page("i-1", "i-2"), page("i-3"))
+	defer srv.Close()
+	insts, err := describeInstances(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, inst := range insts {
+		ids = append(ids, inst.InstanceId)
+	}
+	if want := []string{"i-1", "i-2", "i-3"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("unexpected instances; got %q want %q", ids, want)
+	}
+}
+
+func TestDescribeImagesPages(t *testing.T) {
+	page := func(ids ...string) func(string) interface{} {
+		return func(token string) interface{} {
+			p := &imagesPage{
+				NextToken: token,
+			}
+			for _, id := range ids {
+				p.Images = append(p.Images, imageInfo{Id: id})
+			}
+			return p
+		}
+	}
+	conn, srv := newFakeEC2(t, "DescribeImages", page("ami-1"), page("ami-2"), page("ami-3"))
+	defer srv.Close()
+	imgs, err := describeImages(conn, "self")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, img := range imgs {
+		ids = append(ids, img.Id)
+	}
+	if want := []string{"ami-1", "ami-2", "ami-3"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("unexpected images; got %q want %q", ids, want)
+	}
+}
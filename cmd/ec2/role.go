@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/amz.v3/aws"
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	roleARNFlag     = flag.String("role-arn", "", "assume the IAM role with the given ARN and use its temporary credentials")
+	roleSessionFlag = flag.String("role-session-name", "ec2", "session name to use when assuming a role with -role-arn")
+)
+
+// sessionToken holds the session token that must accompany
+// awsAuth when it holds temporary credentials.
+var sessionToken string
+
+// newSigner returns a request signer for the given region and
+// service that also adds the session token to each request
+// when temporary credentials are in use.
+func newSigner(regionName, service string) aws.Signer {
+	sign := aws.SignV4Factory(regionName, service)
+	if sessionToken == "" {
+		return sign
+	}
+	return func(req *http.Request, auth aws.Auth) error {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+		return sign(req, auth)
+	}
+}
+
+type assumeRoleResp struct {
+	Credentials struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+type stsErrorResp struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// assumeRole calls the STS AssumeRole action using the given base
+// credentials and returns the temporary credentials for the role with
+// the given ARN along with their session token.
+func assumeRole(auth aws.Auth, roleARN string) (aws.Auth, string, error) {
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {roleARN},
+		"RoleSessionName": {*roleSessionFlag},
+	}
+	req, err := http.NewRequest("GET", "https://sts.amazonaws.com/?"+params.Encode(), nil)
+	if err != nil {
+		return aws.Auth{}, "", errgo.Mask(err)
+	}
+	sign := aws.SignV4Factory("us-east-1", "sts")
+	if err := sign(req, auth); err != nil {
+		return aws.Auth{}, "", errgo.Notef(err, "cannot sign request")
+	}
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return aws.Auth{}, "", errgo.Mask(err)
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		var errResp stsErrorResp
+		if err := xml.NewDecoder(hresp.Body).Decode(&errResp); err != nil || errResp.Code == "" {
+			return aws.Auth{}, "", errgo.Newf("AssumeRole failed: %s", hresp.Status)
+		}
+		return aws.Auth{}, "", errgo.Newf("%s (%s)", errResp.Message, errResp.Code)
+	}
+	var resp assumeRoleResp
+	if err := xml.NewDecoder(hresp.Body).Decode(&resp); err != nil {
+		return aws.Auth{}, "", errgo.Notef(err, "cannot unmarshal AssumeRole response")
+	}
+	creds := resp.Credentials
+	if creds.AccessKeyId == "" || creds.SecretAccessKey == "" || creds.SessionToken == "" {
+		return aws.Auth{}, "", errgo.Newf("incomplete credentials in AssumeRole response")
+	}
+	return aws.Auth{
+		AccessKey: creds.AccessKeyId,
+		SecretKey: creds.SecretAccessKey,
+	}, creds.SessionToken, nil
+}
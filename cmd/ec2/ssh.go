@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+
+	"gopkg.in/amz.v3/ec2"
+)
+
+var sshFlags struct {
+	user string
+	key  string
+}
+
+func init() {
+	flags := flag.NewFlagSet("ssh", flag.ExitOnError)
+	flags.StringVar(&sshFlags.user, "user", "", "login name on the instance")
+	flags.StringVar(&sshFlags.key, "key", "", "identity file to use for authentication")
+	cmds = append(cmds, cmd{
+		name:  "ssh",
+		args:  "instance-id [-- ssh-args...]",
+		run:   sshCmd,
+		flags: flags,
+	})
+}
+
+func sshCmd(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) == 0 {
+		c.usage()
+	}
+	id, args := args[0], args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	resp, err := conn.Instances([]string{id}, nil)
+	check(err, "get instance")
+	var inst *ec2.Instance
+	for _, r := range resp.Reservations {
+		for i := range r.Instances {
+			if r.Instances[i].InstanceId == id {
+				inst = &r.Instances[i]
+			}
+		}
+	}
+	if inst == nil {
+		fatalf("instance %q not found", id)
+	}
+	if inst.DNSName == "" {
+		fatalf("instance %q has no public DNS name (state %s)", id, inst.State.Name)
+	}
+	host := inst.DNSName
+	if sshFlags.user != "" {
+		host = sshFlags.user + "@" + host
+	}
+	var sshArgs []string
+	if sshFlags.key != "" {
+		sshArgs = append(sshArgs, "-i", sshFlags.key)
+	}
+	sshArgs = append(sshArgs, host)
+	sshArgs = append(sshArgs, args...)
+	sshc := exec.Command("ssh", sshArgs...)
+	sshc.Stdin = os.Stdin
+	sshc.Stdout = os.Stdout
+	sshc.Stderr = os.Stderr
+	if err := sshc.Run(); err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			os.Exit(err.ExitCode())
+		}
+		fatalf("cannot run ssh: %v", err)
+	}
+}
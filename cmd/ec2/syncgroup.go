@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/amz.v3/ec2"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+)
+
+var syncgroupFlags struct {
+	dryRun bool
+}
+
+func init() {
+	flags := flag.NewFlagSet("syncgroup", flag.ExitOnError)
+	flags.BoolVar(&syncgroupFlags.dryRun, "n", false, "print the changes that would be made but don't make them")
+	cmds = append(cmds, cmd{
+		name:  "syncgroup",
+		args:  "group rulesfile",
+		run:   syncgroup,
+		flags: flags,
+	})
+}
+
+// ruleSpec holds an ingress rule as read from a rules file,
+// which may be in YAML or JSON format. Each source is in any
+// of the forms accepted by the auth command: an IP address
+// range in CIDR notation, a security group id, or
+// ownerid:groupname.
+//
+// For example, in YAML:
+//
+//	# Allow ssh from the internal network and group.
+//	- protocol: tcp
+//	  from: 22
+//	  to: 22
+//	  sources: [10.0.0.0/8, sg-1234abcd]
+//
+// or equivalently in JSON:
+//
+//	[{
+//		"protocol": "tcp",
+//		"from": 22,
+//		"to": 22,
+//		"sources": ["10.0.0.0/8", "sg-1234abcd"]
+//	}]
+type ruleSpec struct {
+	Protocol string   `json:"protocol" yaml:"protocol"`
+	FromPort int      `json:"from" yaml:"from"`
+	ToPort   int      `json:"to" yaml:"to"`
+	Sources  []string `json:"sources" yaml:"sources"`
+}
+
+// syncgroup reconciles the ingress rules of a security group with
+// those in a file, authorizing missing rules and revoking extra ones.
+func syncgroup(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) != 2 {
+		c.usage()
+	}
+	group := parseGroup(args[0])
+	data, err := ioutil.ReadFile(args[1])
+	check(err, "read rules")
+	want, err := parseRules(data)
+	if err != nil {
+		fatalf("cannot parse rules in %q: %v", args[1], err)
+	}
+	resp, err := conn.SecurityGroups([]ec2.SecurityGroup{group}, nil)
+	check(err, "get security group")
+	if len(resp.Groups) != 1 {
+		fatalf("found %d security groups matching %q", len(resp.Groups), args[0])
+	}
+	add, remove := diffRules(want, permAtoms(resp.Groups[0].IPPerms))
+	if len(add) == 0 && len(remove) == 0 {
+		return
+	}
+	for _, a := range add {
+		fmt.Printf("+ %s\n", a)
+	}
+	for _, a := range remove {
+		fmt.Printf("- %s\n", a)
+	}
+	if syncgroupFlags.dryRun {
+		return
+	}
+	if len(remove) > 0 && !yesFlag {
+		items := make([]string, len(remove))
+		for i, a := range remove {
+			items[i] = a.String()
+		}
+		confirm("revoke rules", items)
+	}
+	// Authorize first so that there's no window in which
+	// access allowed by both old and new rules is denied.
+	if len(add) > 0 {
+		_, err := conn.AuthorizeSecurityGroup(group, atomPerms(add))
+		check(err, "authorizeSecurityGroup")
+	}
+	if len(remove) > 0 {
+		_, err := conn.RevokeSecurityGroup(group, atomPerms(remove))
+		check(err, "revokeSecurityGroup")
+	}
+}
+
+// permAtom holds a single ingress rule with exactly one source.
+type permAtom struct {
+	protocol string
+	fromPort int
+	toPort   int
+	ip       string
+	group    ec2.UserSecurityGroup
+}
+
+func (a permAtom) String() string {
+	source := a.ip
+	if source == "" {
+		if a.group.Id != "" {
+			source = a.group.Id
+		} else {
+			source = a.group.OwnerId + ":" + a.group.Name
+		}
+	}
+	return fmt.Sprintf("-proto %s -from %d -to %d %s", a.protocol, a.fromPort, a.toPort, source)
+}
+
+// matches reports whether a and b refer to the same rule.
+// Security groups may be specified either by id or by
+// owner and name, so they match if either of those do.
+func (a permAtom) matches(b permAtom) bool {
+	if a.protocol != b.protocol || a.fromPort != b.fromPort || a.toPort != b.toPort || a.ip != b.ip {
+		return false
+	}
+	if a.ip != "" {
+		return true
+	}
+	if a.group.Id != "" && b.group.Id != "" {
+		return a.group.Id == b.group.Id
+	}
+	return a.group.Name == b.group.Name && a.group.OwnerId == b.group.OwnerId
+}
+
+// parseRules parses a YAML or JSON array of ruleSpec values
+// into the individual rules they describe.
+func parseRules(data []byte) ([]permAtom, error) {
+	specs, err := parseRuleSpecs(data)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var atoms []permAtom
+	for _, spec := range specs {
+		if spec.Protocol == "" {
+			return nil, errgo.Newf("rule with no protocol")
+		}
+		for _, s := range spec.Sources {
+			ip, group, err := parseSource(s)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			atoms = append(atoms, permAtom{
+				protocol: spec.Protocol,
+				fromPort: spec.FromPort,
+				toPort:   spec.ToPort,
+				ip:       ip,
+				group:    group,
+			})
+		}
+	}
+	return atoms, nil
+}
+
+// parseRuleSpecs parses the contents of a rules file. Almost all
+// JSON is also YAML, but not JSON indented with tabs, so if the data
+// isn't valid YAML, it's tried as JSON too. The JSON error is
+// returned if the data looks like JSON.
+func parseRuleSpecs(data []byte) ([]ruleSpec, error) {
+	var specs []ruleSpec
+	yamlErr := yaml.Unmarshal(data, &specs)
+	if yamlErr == nil {
+		return specs, nil
+	}
+	specs = nil
+	jsonErr := json.Unmarshal(data, &specs)
+	switch {
+	case jsonErr == nil:
+		return specs, nil
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")):
+		return nil, errgo.Mask(jsonErr)
+	}
+	return nil, errgo.Mask(yamlErr)
+}
+
+// permAtoms splits the given permissions into
+// rules with exactly one source each.
+func permAtoms(perms []ec2.IPPerm) []permAtom {
+	var atoms []permAtom
+	for _, p := range perms {
+		a := permAtom{
+			protocol: p.Protocol,
+			fromPort: p.FromPort,
+			toPort:   p.ToPort,
+		}
+		for _, ip := range p.SourceIPs {
+			a.ip = ip
+			atoms = append(atoms, a)
+		}
+		a.ip = ""
+		for _, g := range p.SourceGroups {
+			a.group = g
+			atoms = append(atoms, a)
+		}
+	}
+	return atoms
+}
+
+// atomPerms returns the permissions corresponding
+// to the given rules.
+func atomPerms(atoms []permAtom) []ec2.IPPerm {
+	perms := make([]ec2.IPPerm, len(atoms))
+	for i, a := range atoms {
+		perms[i] = ec2.IPPerm{
+			Protocol: a.protocol,
+			FromPort: a.fromPort,
+			ToPort:   a.toPort,
+		}
+		if a.ip != "" {
+			perms[i].SourceIPs = []string{a.ip}
+		} else {
+			perms[i].SourceGroups = []ec2.UserSecurityGroup{a.group}
+		}
+	}
+	return perms
+}
+
+// diffRules returns the rules in want that are not in have
+// and the rules in have that are not in want.
+func diffRules(want, have []permAtom) (add, remove []permAtom) {
+	for _, w := range want {
+		if !containsAtom(have, w) && !containsAtom(add, w) {
+			add = append(add, w)
+		}
+	}
+	for _, h := range have {
+		if !containsAtom(want, h) {
+			remove = append(remove, h)
+		}
+	}
+	return add, remove
+}
+
+func containsAtom(atoms []permAtom, a permAtom) bool {
+	for _, b := range atoms {
+		if a.matches(b) {
+			return true
+		}
+	}
+	return false
+}
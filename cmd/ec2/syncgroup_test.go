@@ -0,0 +1,291 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/amz.v3/ec2"
+)
+
+var parseRulesTests = []struct {
+	about       string
+	data        string
+	expect      []permAtom
+	expectError string
+}{{
+	about: "yaml",
+	data: `
+- protocol: tcp
+  from: 22
+  to: 22
+  sources: [10.0.0.0/8, sg-1234abcd]
+- protocol: udp
+  from: 53
+  to: 53
+  sources:
+    - 123456789012:dns
+`,
+	expect: []permAtom{{
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		ip:       "10.0.0.0/8",
+	}, {
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		group:    ec2.UserSecurityGroup{Id: "sg-1234abcd"},
+	}, {
+		protocol: "udp",
+		fromPort: 53,
+		toPort:   53,
+		group: ec2.UserSecurityGroup{
+			OwnerId: "123456789012",
+			Name:    "dns",
+		},
+	}},
+}, {
+	about: "json indented with tabs",
+	data: "[{\n" +
+		"\t\"protocol\": \"tcp\",\n" +
+		"\t\"from\": 80,\n" +
+		"\t\"to\": 443,\n" +
+		"\t\"sources\": [\"0.0.0.0/0\"]\n" +
+		"}]\n",
+	expect: []permAtom{{
+		protocol: "tcp",
+		fromPort: 80,
+		toPort:   443,
+		ip:       "0.0.0.0/0",
+	}},
+}, {
+	about:       "invalid json",
+	data:        `[{"protocol": "tcp",]`,
+	expectError: "invalid character ']' looking for beginning of object key string",
+}, {
+	about:       "no protocol",
+	data:        `[{"from": 22, "to": 22, "sources": ["10.0.0.0/8"]}]`,
+	expectError: "rule with no protocol",
+}, {
+	about:       "bad source",
+	data:        `[{"protocol": "tcp", "sources": ["nowhere"]}]`,
+	expectError: `"nowhere" is neither security group id nor ip address`,
+}}
+
+func TestParseRules(t *testing.T) {
+	for _, test := range parseRulesTests {
+		atoms, err := parseRules([]byte(test.data))
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("%s: got error %v want %q", test.about, err, test.expectError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.about, err)
+			continue
+		}
+		if !reflect.DeepEqual(atoms, test.expect) {
+			t.Errorf("%s: got %v want %v", test.about, atoms, test.expect)
+		}
+	}
+}
+
+func TestPermAtoms(t *testing.T) {
+	atoms := permAtoms([]ec2.IPPerm{{
+		Protocol:  "tcp",
+		FromPort:  22,
+		ToPort:    22,
+		SourceIPs: []string{"10.0.0.0/8", "192.168.0.0/16"},
+		SourceGroups: []ec2.UserSecurityGroup{{
+			Id:      "sg-1",
+			OwnerId: "123",
+			Name:    "web",
+		}},
+	}, {
+		Protocol:  "udp",
+		FromPort:  53,
+		ToPort:    53,
+		SourceIPs: []string{"0.0.0.0/0"},
+	}})
+	expect := []permAtom{{
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		ip:       "10.0.0.0/8",
+	}, {
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		ip:       "192.168.0.0/16",
+	}, {
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		group: ec2.UserSecurityGroup{
+			Id:      "sg-1",
+			OwnerId: "123",
+			Name:    "web",
+		},
+	}, {
+		protocol: "udp",
+		fromPort: 53,
+		toPort:   53,
+		ip:       "0.0.0.0/0",
+	}}
+	if !reflect.DeepEqual(atoms, expect) {
+		t.Fatalf("got %v want %v", atoms, expect)
+	}
+}
+
+func ipAtom(ip string) permAtom {
+	return permAtom{
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		ip:       ip,
+	}
+}
+
+func groupAtom(id, ownerId, name string) permAtom {
+	return permAtom{
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   22,
+		group: ec2.UserSecurityGroup{
+			Id:      id,
+			OwnerId: ownerId,
+			Name:    name,
+		},
+	}
+}
+
+var matchesTests = []struct {
+	about  string
+	a, b   permAtom
+	expect bool
+}{{
+	about:  "same cidr",
+	a:      ipAtom("10.0.0.0/8"),
+	b:      ipAtom("10.0.0.0/8"),
+	expect: true,
+}, {
+	about:  "different cidr",
+	a:      ipAtom("10.0.0.0/8"),
+	b:      ipAtom("10.0.0.0/16"),
+	expect: false,
+}, {
+	about: "different ports",
+	a:     ipAtom("10.0.0.0/8"),
+	b: permAtom{
+		protocol: "tcp",
+		fromPort: 22,
+		toPort:   23,
+		ip:       "10.0.0.0/8",
+	},
+	expect: false,
+}, {
+	about: "different protocol",
+	a:     ipAtom("10.0.0.0/8"),
+	b: permAtom{
+		protocol: "udp",
+		fromPort: 22,
+		toPort:   22,
+		ip:       "10.0.0.0/8",
+	},
+	expect: false,
+}, {
+	about:  "cidr and group",
+	a:      ipAtom("10.0.0.0/8"),
+	b:      groupAtom("sg-1", "", ""),
+	expect: false,
+}, {
+	about:  "same group id",
+	a:      groupAtom("sg-1", "", ""),
+	b:      groupAtom("sg-1", "123", "web"),
+	expect: true,
+}, {
+	about:  "different group ids with same name",
+	a:      groupAtom("sg-1", "123", "web"),
+	b:      groupAtom("sg-2", "123", "web"),
+	expect: false,
+}, {
+	about:  "ownerid:name against full group",
+	a:      groupAtom("", "123", "web"),
+	b:      groupAtom("sg-1", "123", "web"),
+	expect: true,
+}, {
+	about:  "ownerid:name with different owner",
+	a:      groupAtom("", "123", "web"),
+	b:      groupAtom("sg-1", "456", "web"),
+	expect: false,
+}, {
+	about:  "id against ownerid:name",
+	a:      groupAtom("sg-1", "", ""),
+	b:      groupAtom("", "123", "web"),
+	expect: false,
+}}
+
+func TestMatches(t *testing.T) {
+	for _, test := range matchesTests {
+		if got := test.a.matches(test.b); got != test.expect {
+			t.Errorf("%s: a.matches(b) got %v want %v", test.about, got, test.expect)
+		}
+		if got := test.b.matches(test.a); got != test.expect {
+			t.Errorf("%s: b.matches(a) got %v want %v", test.about, got, test.expect)
+		}
+	}
+}
+
+var diffRulesTests = []struct {
+	about        string
+	want, have   []permAtom
+	expectAdd    []permAtom
+	expectRemove []permAtom
+}{{
+	about: "no changes",
+	want:  []permAtom{ipAtom("10.0.0.0/8"), groupAtom("sg-1", "", "")},
+	have:  []permAtom{groupAtom("sg-1", "123", "web"), ipAtom("10.0.0.0/8")},
+}, {
+	about:     "add only",
+	want:      []permAtom{ipAtom("10.0.0.0/8"), ipAtom("192.168.0.0/16")},
+	have:      []permAtom{ipAtom("10.0.0.0/8")},
+	expectAdd: []permAtom{ipAtom("192.168.0.0/16")},
+}, {
+	about:        "revoke only",
+	want:         []permAtom{ipAtom("10.0.0.0/8")},
+	have:         []permAtom{ipAtom("10.0.0.0/8"), ipAtom("0.0.0.0/0"), groupAtom("sg-2", "123", "db")},
+	expectRemove: []permAtom{ipAtom("0.0.0.0/0"), groupAtom("sg-2", "123", "db")},
+}, {
+	about:        "revoke everything",
+	have:         []permAtom{ipAtom("0.0.0.0/0"), groupAtom("sg-2", "123", "db")},
+	expectRemove: []permAtom{ipAtom("0.0.0.0/0"), groupAtom("sg-2", "123", "db")},
+}, {
+	about:        "add and revoke",
+	want:         []permAtom{groupAtom("", "123", "web"), ipAtom("10.0.0.0/8")},
+	have:         []permAtom{groupAtom("sg-1", "123", "web"), ipAtom("0.0.0.0/0")},
+	expectAdd:    []permAtom{ipAtom("10.0.0.0/8")},
+	expectRemove: []permAtom{ipAtom("0.0.0.0/0")},
+}, {
+	about:     "duplicates in wanted rules added once",
+	want:      []permAtom{ipAtom("10.0.0.0/8"), ipAtom("10.0.0.0/8"), groupAtom("sg-1", "", ""), groupAtom("sg-1", "", "")},
+	expectAdd: []permAtom{ipAtom("10.0.0.0/8"), groupAtom("sg-1", "", "")},
+}, {
+	about:        "group named differently is replaced",
+	want:         []permAtom{groupAtom("", "123", "web")},
+	have:         []permAtom{groupAtom("sg-2", "123", "db")},
+	expectAdd:    []permAtom{groupAtom("", "123", "web")},
+	expectRemove: []permAtom{groupAtom("sg-2", "123", "db")},
+}}
+
+func TestDiffRules(t *testing.T) {
+	for _, test := range diffRulesTests {
+		add, remove := diffRules(test.want, test.have)
+		if !reflect.DeepEqual(add, test.expectAdd) {
+			t.Errorf("%s: got add %v want %v", test.about, add, test.expectAdd)
+		}
+		if !reflect.DeepEqual(remove, test.expectRemove) {
+			t.Errorf("%s: got remove %v want %v", test.about, remove, test.expectRemove)
+		}
+	}
+}
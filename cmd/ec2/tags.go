@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/amz.v3/ec2"
+	"gopkg.in/errgo.v1"
+)
+
+// resourceIdPat matches the ids of resources that can be tagged.
+var resourceIdPat = regexp.MustCompile(`^(i|vol|sg|snap|ami|vpc|subnet|eni)-[a-z0-9]+$`)
+
+func init() {
+	cmds = append(cmds, cmd{
+		name: "tags",
+		args: "resource-id",
+		run:  tags,
+	})
+}
+
+func tags(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) != 1 {
+		c.usage()
+	}
+	id := parseResourceId(args[0])
+	resp, err := describeTags(conn, id)
+	check(err, "get tags for %s", id)
+	for _, t := range resp {
+		fmt.Printf("%s=%s\n", t.Key, t.Value)
+	}
+}
+
+var tagFlags struct {
+	rm stringsFlag
+}
+
+func init() {
+	flags := flag.NewFlagSet("tag", flag.ExitOnError)
+	flags.Var(&tagFlags.rm, "rm", "remove the tag with the given key (may be repeated)")
+	cmds = append(cmds, cmd{
+		name:  "tag",
+		args:  "resource-id [key=value...]",
+		run:   tag,
+		flags: flags,
+	})
+}
+
+func tag(c cmd, conn *ec2.EC2, args []string) {
+	if len(args) < 1 || (len(args) == 1 && len(tagFlags.rm) == 0) {
+		c.usage()
+	}
+	id := parseResourceId(args[0])
+	var newTags []ec2.Tag
+	for _, a := range args[1:] {
+		i := strings.Index(a, "=")
+		if i <= 0 {
+			fatalf("invalid tag %q; want key=value", a)
+		}
+		newTags = append(newTags, ec2.Tag{
+			Key:   a[:i],
+			Value: a[i+1:],
+		})
+	}
+	if len(newTags) > 0 {
+		_, err := conn.CreateTags([]string{id}, newTags)
+		check(err, "create tags on %s", id)
+	}
+	if len(tagFlags.rm) > 0 {
+		err := deleteTags(conn, id, tagFlags.rm)
+		check(err, "delete tags from %s", id)
+	}
+}
+
+// parseResourceId checks that s looks like the id of
+// a taggable resource and returns it.
+func parseResourceId(s string) string {
+	if !resourceIdPat.MatchString(s) {
+		fatalf("%q is not a valid resource id", s)
+	}
+	return s
+}
+
+// The ec2 package has no support for DescribeTags or DeleteTags,
+// so we make those requests directly.
+
+type describeTagsResp struct {
+	Tags []ec2.Tag `xml:"tagSet>item"`
+}
+
+// describeTags returns all the tags on the resource with the given id.
+func describeTags(conn *ec2.EC2, id string) ([]ec2.Tag, error) {
+	params := url.Values{
+		"Filter.1.Name":    {"resource-id"},
+		"Filter.1.Value.1": {id},
+	}
+	var resp describeTagsResp
+	if err := ec2Query(conn, "DescribeTags", params, &resp); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return resp.Tags, nil
+}
+
+// deleteTags deletes the tags with the given keys from the
+// resource with the given id.
+func deleteTags(conn *ec2.EC2, id string, keys []string) error {
+	params := url.Values{
+		"ResourceId.1": {id},
+	}
+	for i, k := range keys {
+		params.Set(fmt.Sprintf("Tag.%d.Key", i+1), k)
+	}
+	return errgo.Mask(ec2Query(conn, "DeleteTags", params, nil))
+}
+
+type ec2ErrorResp struct {
+	Errors []struct {
+		Code    string
+		Message string
+	} `xml:"Errors>Error"`
+}
+
+// ec2Query makes a signed request for the given EC2 API action and
+// unmarshals the XML response into resp if it is non-nil. The
+// API version defaults to 2014-10-01 if params doesn't specify one.
+func ec2Query(conn *ec2.EC2, action string, params url.Values, resp interface{}) error {
+	params.Set("Action", action)
+	if params.Get("Version") == "" {
+		params.Set("Version", "2014-10-01")
+	}
+	req, err := http.NewRequest("GET", conn.Region.EC2Endpoint+"/?"+params.Encode(), nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	sign := newSigner(conn.Region.Name, "ec2")
+	if err := sign(req, conn.Auth); err != nil {
+		return errgo.Notef(err, "cannot sign request")
+	}
+	hresp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		var errResp ec2ErrorResp
+		if err := xml.NewDecoder(hresp.Body).Decode(&errResp); err != nil || len(errResp.Errors) == 0 {
+			return errgo.Newf("%s failed: %s", action, hresp.Status)
+		}
+		e := errResp.Errors[0]
+		return errgo.Newf("%s (%s)", e.Message, e.Code)
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(hresp.Body).Decode(resp); err != nil {
+		return errgo.Notef(err, "cannot unmarshal %s response", action)
+	}
+	return nil
+}
+
+// stringsFlag implements flag.Value by accumulating
+// all the values it is set to.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
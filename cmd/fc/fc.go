@@ -20,7 +20,7 @@
 // add more operands at the end of the last line to operate on the previous
 // result while keeping entire previous expression intact.
 //
-// Usage: fc -[bBoxcd] <postfix expression>
+// Usage: fc -[bBoxcdti] [-w bits] [-g digits] <postfix expression>
 //
 // Operand prefixes specify format of operand; available formats:
 //	decimal(default)
@@ -28,6 +28,7 @@
 //	octal(0)
 //	binary(0b)
 //	unicode character(@)
+//	time(hh:mm:ss or mm:ss), converted to seconds
 //
 // Flag specifies the output format:
 //
@@ -37,13 +38,39 @@
 //	-o octal
 //	-x hexadecimal
 //	-c unicode character
+//	-t time (hh:mm:ss)
+//
+// For binary and hexadecimal output, the -w flag specifies a fixed
+// width in bits (the value is truncated to that width and padded with
+// zeros) and the -g flag specifies that digits should be separated with
+// an underscore into groups of the given size, counting from the right.
+//
+// The -i flag starts an interactive session after any expression
+// on the command line has been evaluated. Each line read from the
+// standard input is evaluated as a postfix expression and the value
+// on the top of the stack is then printed. The stack and registers
+// are kept from one line to the next. If a line contains an error, it
+// is reported and the stack is left as it was before that line.
+// A line containing just "c" clears the stack and
+// a line containing just "q" ends the session.
 //
 // Operators are:
 //
 //     pi e nan NaN infinity Infinity inf ∞ swap dup rep ! % p * **
-//     + - / ^ _ >> shr << shl and or xor not sum acos asin atan atan2
+//     + - / ^ _ >> shr << shl and or xor not sum ? acos asin atan atan2
 //     ceil cos cosh deg exp fabs floor fmod ldexp log ln log10 log2
 //     pow rad sin sinh sqrt tan tanh x xx
+//
+// The ? operator pops an else-value, a then-value and a condition and
+// pushes the then-value if the condition is non-zero, or the
+// else-value otherwise, so "cond then else ?" selects between two
+// values. A NaN condition produces NaN.
+//
+// There are also ten registers, numbered 0 to 9:
+//
+//	sN  pop the top of the stack into register N
+//	rN  push the value of register N onto the stack
+//	clr set all registers to zero
 package main
 
 // version 2 - rewritten -- wrtp  1/91
@@ -52,8 +79,10 @@ package main
 // version 4 - Goifed, yeah!
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"regexp"
@@ -97,6 +126,7 @@ var ops = []op{
 	{"xor", xor},
 	{"not", not},
 	{"sum", sum},
+	{"?", choose},
 	{"acos", math.Acos},
 	{"asin", math.Asin},
 	{"atan", math.Atan},
@@ -123,6 +153,7 @@ var ops = []op{
 	{"tanh", math.Tanh},
 	{"x", mult},
 	{"xx", math.Pow},
+	{"clr", clearRegisters},
 }
 
 type cvt struct {
@@ -154,11 +185,15 @@ var conversions = []cvt{
 		func(s string) (v float64) {
 			v, err := strconv.ParseFloat(s, 64)
 			if err != nil {
-				fatalf("bad number %q", s)
+				errorf("bad number %q", s)
 			}
 			return
 		},
 	},
+	{
+		regexp.MustCompile(`^[0-9]+(:[0-9]+){1,2}(\.[0-9]+)?$`),
+		parseTime,
+	},
 	{
 		regexp.MustCompile("^@.$"),
 		func(s string) float64 {
@@ -173,6 +208,9 @@ var conversions = []cvt{
 var stack []float64
 var lastOp *op // used by rep operation
 
+// registers holds the values stored by the sN operators.
+var registers [10]float64
+
 const (
 	_ = iota
 	dec
@@ -181,14 +219,27 @@ const (
 	oct
 	hex
 	char
+	duration
 )
 
 var base = dec
 
+var (
+	// width holds the fixed width in bits of binary
+	// and hexadecimal output. If it's zero, the
+	// natural width of the number is used.
+	width int
+
+	// groupSize holds the number of digits in
+	// each group of binary or hexadecimal output.
+	// If it's zero, digits are not grouped.
+	groupSize int
+)
+
 func usage() {
 	b := new(bytes.Buffer)
-	fmt.Fprintf(b, "Usage: fc -[bBoxcd] <postfix expression>\n")
-	fmt.Fprintf(b, "Operands are decimal(default), hex(0x), octal(0), binary(0b),char(@)\n")
+	fmt.Fprintf(b, "Usage: fc -[bBoxcdti] [-w bits] [-g digits] <postfix expression>\n")
+	fmt.Fprintf(b, "Operands are decimal(default), hex(0x), octal(0), binary(0b),char(@), time(hh:mm:ss)\n")
 	fmt.Fprintf(b, "Operators are:\n")
 	cols := 0
 	for _, o := range ops {
@@ -203,6 +254,8 @@ func usage() {
 	if cols > 0 {
 		fmt.Fprintf(b, "\n")
 	}
+	fmt.Fprintf(b, "cond then else ? pushes then if cond is non-zero, else otherwise\n")
+	fmt.Fprintf(b, "-i reads expressions interactively from stdin; c clears the stack, q quits\n")
 	os.Stderr.Write(b.Bytes())
 	os.Exit(2)
 }
@@ -213,8 +266,13 @@ func main() {
 		return
 	}
 	args = args[1:]
-	a := args[0]
-	if len(a) > 1 && a[0] == '-' && !isNumber(a) {
+	interactive := false
+	for len(args) > 0 {
+		a := args[0]
+		if len(a) < 2 || a[0] != '-' || isNumber(a) {
+			break
+		}
+		args = args[1:]
 		switch a[1] {
 		case 'd':
 			base = dec
@@ -226,35 +284,127 @@ func main() {
 			base = bin
 		case 'c':
 			base = char
+		case 't':
+			base = duration
 		case 'B':
 			base = annotbin
+		case 'i':
+			interactive = true
+		case 'w':
+			width, args = intOption(a, args)
+		case 'g':
+			groupSize, args = intOption(a, args)
 		default:
 			fmt.Fprintf(os.Stderr, "fc: unknown option -%c\n", a[1])
 			usage()
 		}
-		args = args[1:]
 	}
 
+	if _, err := eval(args); err != nil {
+		fatalf("%v", err)
+	}
+	if interactive {
+		if err := repl(os.Stdin, os.Stdout); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	// print stack bottom first
+	for _, v := range stack {
+		printNum(v)
+	}
+}
+
+// intOption returns the non-negative integer value of the option a,
+// which may either follow the option letter directly or be
+// held in the next argument, and the remaining arguments.
+func intOption(a string, args []string) (int, []string) {
+	v := a[2:]
+	if v == "" {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "fc: option -%c needs a value\n", a[1])
+			usage()
+		}
+		v, args = args[0], args[1:]
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fatalf("invalid value %q for option -%c", v, a[1])
+	}
+	return n, args
+}
+
+// eval evaluates the given postfix expression, leaving the results
+// on the stack, and returns the resulting stack. If the expression
+// is invalid, it returns an error and leaves the stack as it was
+// before the expression was evaluated.
+func eval(tokens []string) (_ []float64, err error) {
+	saved := append([]float64(nil), stack...)
+	defer func() {
+		if e := recover(); e != nil {
+			ev, ok := e.(evalError)
+			if !ok {
+				panic(e)
+			}
+			stack = saved
+			err = ev
+		}
+	}()
 	// push numbers; execute operations
-	for ; len(args) > 0; args = args[1:] {
-		s := args[0]
+	for _, s := range tokens {
 		ok, v := number(s)
 		if ok {
 			push(v)
 		} else {
 			op := find(s)
 			if op == nil {
-				fatalf("unknown operator %q", s)
+				errorf("unknown operator %q", s)
 			}
 			op.exec()
 			lastOp = op
 		}
 	}
-
-	// print stack bottom first
-	for _, v := range stack {
-		printNum(v)
+	return stack, nil
+}
+
+// repl reads expressions from r a line at a time, evaluating
+// each one and printing the top of the resulting stack to w.
+// It returns when it reads a "q" command or reaches EOF.
+func repl(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "q":
+			return nil
+		case "c":
+			stack = nil
+			continue
+		}
+		if _, err := eval(strings.Fields(line)); err != nil {
+			fmt.Fprintf(os.Stderr, "fc: %v\n", err)
+			continue
+		}
+		if len(stack) > 0 {
+			fmt.Fprintln(w, numToStr(stack[len(stack)-1]))
+		}
 	}
+	return scanner.Err()
+}
+
+// evalError is used to abort evaluation of an expression.
+// It is recovered by eval.
+type evalError struct {
+	error
+}
+
+// errorf aborts the current evaluation with an error.
+// It must only be called within eval.
+func errorf(f string, a ...interface{}) {
+	panic(evalError{fmt.Errorf(f, a...)})
 }
 
 func printNum(v float64) float64 {
@@ -275,7 +425,9 @@ func numToStr(v float64) string {
 	case oct:
 		return fmt.Sprintf("%#o", int64(v))
 	case hex:
-		return fmt.Sprintf("%#x", int64(v))
+		return numToHex(int64(v))
+	case duration:
+		return formatTime(v)
 	}
 	fatalf("unknown base %d", base)
 	panic("not reached")
@@ -290,9 +442,59 @@ func formatFloat(f float64) string {
 	return strconv.FormatFloat(f, fmt, -1, 64)
 }
 
+// parseTime parses a time of the form hh:mm:ss or mm:ss,
+// where the seconds may have a fractional part,
+// and returns it as a number of seconds.
+func parseTime(s string) float64 {
+	var v float64
+	for _, f := range strings.Split(s, ":") {
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			errorf("bad time %q", s)
+		}
+		v = v*60 + x
+	}
+	return v
+}
+
+// formatTime formats the number of seconds v as hh:mm:ss.
+// Fractional seconds are retained.
+func formatTime(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) || math.Abs(v) >= 1<<63 {
+		return formatFloat(v)
+	}
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	// Split the shortest decimal representation of v
+	// so that the fractional part isn't subject to
+	// rounding errors when the minutes and hours
+	// are taken away.
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	frac := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		s, frac = s[:i], s[i:]
+	}
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return formatFloat(v)
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d%s", sign, secs/3600, secs/60%60, secs%60, frac)
+}
+
 // numToBinary returns  n as a binary number, always producing
-// a multiple of 8 binary digits.
+// a multiple of 8 binary digits, or exactly width digits
+// if width is set. Digits are grouped if groupSize is set.
 func numToBinary(v int64) string {
+	return group(binaryDigits(v), "_")
+}
+
+func binaryDigits(v int64) string {
+	if width > 0 {
+		return fixedWidth(v, 2, width)
+	}
 	s := strconv.FormatInt(v, 2)
 	w := (len(s) + 7) / 8
 	if w == 0 {
@@ -301,8 +503,58 @@ func numToBinary(v int64) string {
 	return pad(s, w, '0')
 }
 
+// numToHex returns v as a hexadecimal number. If width
+// is set, the number is truncated to width bits and
+// padded with zeros. Digits are grouped if groupSize is set.
+func numToHex(v int64) string {
+	if width > 0 {
+		return "0x" + group(fixedWidth(v, 16, (width+3)/4*4), "_")
+	}
+	if groupSize == 0 {
+		return fmt.Sprintf("%#x", v)
+	}
+	if v < 0 {
+		return "-0x" + group(strconv.FormatUint(uint64(-v), 16), "_")
+	}
+	return "0x" + group(strconv.FormatInt(v, 16), "_")
+}
+
+// fixedWidth returns the lowest bits of v in the given base
+// (2 or 16), padded with zeros to fill that number of bits.
+func fixedWidth(v int64, base int, bits int) string {
+	u := uint64(v)
+	if bits < 64 {
+		u &= 1<<uint(bits) - 1
+	}
+	s := strconv.FormatUint(u, base)
+	ndig := bits
+	if base == 16 {
+		ndig = bits / 4
+	}
+	if len(s) < ndig {
+		s = strings.Repeat("0", ndig-len(s)) + s
+	}
+	return s
+}
+
+// group separates s into groups of groupSize characters,
+// counting from the right.
+func group(s string, sep string) string {
+	if groupSize == 0 || len(s) <= groupSize {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if i > 0 && (len(s)-i)%groupSize == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 // pad pads the string s to width w with rune c.
-func pad(s string, w int, c int) string {
+func pad(s string, w int, c rune) string {
 	if w <= len(s) {
 		return s
 	}
@@ -311,30 +563,34 @@ func pad(s string, w int, c int) string {
 
 func numToAnnotatedBinary(n int64) string {
 	b := new(bytes.Buffer)
-	s := numToBinary(n)
+	s := binaryDigits(n)
 	ndig := len(s)
-	b.WriteString(s)
+	b.WriteString(group(s, "_"))
 	b.WriteString("\n")
+	line := new(bytes.Buffer)
 	for i := ndig - 1; i >= 0; i-- {
-		b.WriteRune('0' + rune(i)%10)
+		line.WriteRune('0' + rune(i)%10)
 	}
+	b.WriteString(group(line.String(), " "))
 	if ndig < 10 {
 		return b.String()
 	}
 	b.WriteRune('\n')
-	for i := ndig - 1; i >= 10; i-- {
-		if i%10 == 0 {
-			b.WriteRune('0' + rune(i)/10)
+	line.Reset()
+	for i := ndig - 1; i >= 0; i-- {
+		if i%10 == 0 && i >= 10 {
+			line.WriteRune('0' + rune(i)/10)
 		} else {
-			b.WriteString(" ")
+			line.WriteString(" ")
 		}
 	}
+	b.WriteString(strings.TrimRight(group(line.String(), " "), " "))
 	return b.String()
 }
 
 func ensure(n int, o *op) {
 	if len(stack) < n {
-		fatalf("Stack too small for op %q", o.name)
+		errorf("Stack too small for op %q", o.name)
 	}
 }
 
@@ -359,7 +615,7 @@ func (o *op) exec() {
 	case func([]float64) []float64:
 		stack = f(stack)
 	default:
-		fatalf("unknown operation type: %T", f)
+		errorf("unknown operation type: %T", f)
 	}
 }
 
@@ -383,6 +639,9 @@ func pop() float64 {
 }
 
 func find(s string) *op {
+	if o := registerOp(s); o != nil {
+		return o
+	}
 	neg := len(s) > 1 && s[0] == '-'
 	if neg {
 		s = s[1:]
@@ -402,6 +661,35 @@ func find(s string) *op {
 	return nil
 }
 
+// registerOp returns the store or recall operator
+// named by s, or nil if s does not name one.
+func registerOp(s string) *op {
+	if len(s) != 2 || s[1] < '0' || s[1] > '9' {
+		return nil
+	}
+	r := &registers[s[1]-'0']
+	switch s[0] {
+	case 's':
+		return &op{s, func(p []float64) []float64 {
+			if len(p) < 1 {
+				errorf("Stack too small for op %q", s)
+			}
+			*r = p[len(p)-1]
+			return p[:len(p)-1]
+		}}
+	case 'r':
+		return &op{s, func(p []float64) []float64 {
+			return append(p, *r)
+		}}
+	}
+	return nil
+}
+
+func clearRegisters(p []float64) []float64 {
+	registers = [10]float64{}
+	return p
+}
+
 func isNumber(s string) bool {
 	if len(s) > 0 && s[0] == '-' {
 		s = s[1:]
@@ -439,7 +727,7 @@ func number(s string) (bool, float64) {
 func btoi(s string, base int) int64 {
 	i, err := strconv.ParseInt(s, base, 64)
 	if err != nil {
-		fatalf("bad number %q", s)
+		errorf("bad number %q", s)
 	}
 	return i
 }
@@ -453,8 +741,8 @@ func swap(p []float64) []float64 {
 }
 
 func dup(p []float64) []float64 {
-	if len(p) < 0 {
-		fatalf("Stack too small for op %q", dup)
+	if len(p) < 1 {
+		errorf("Stack too small for op %q", "dup")
 	}
 	return append(p, p[len(p)-1])
 }
@@ -462,13 +750,13 @@ func dup(p []float64) []float64 {
 // repeat last operator until not enough elements left on stack
 func rep(p []float64) []float64 {
 	if lastOp == nil {
-		fatalf("No operator to rep")
+		errorf("No operator to rep")
 	}
 	switch lastOp.f.(type) {
 	case func(float64, float64) float64:
 	case func(int64, int64) int64:
 	default:
-		fatalf("Invalid operator for rep")
+		errorf("Invalid operator for rep")
 	}
 	for len(stack) > 1 {
 		lastOp.exec()
@@ -485,7 +773,30 @@ func sum(p []float64) []float64 {
 	return []float64{v}
 }
 
+// choose implements the ? operator: it replaces the
+// top three values, cond then else, by then if cond
+// is non-zero or else otherwise.
+func choose(p []float64) []float64 {
+	if len(p) < 3 {
+		errorf("Stack too small for op %q", "?")
+	}
+	n := len(p) - 3
+	cond, then, els := p[n], p[n+1], p[n+2]
+	switch {
+	case math.IsNaN(cond):
+		p[n] = math.NaN()
+	case cond != 0:
+		p[n] = then
+	default:
+		p[n] = els
+	}
+	return p[:n+1]
+}
+
 func mod(x, y int64) int64 {
+	if y == 0 {
+		errorf("division by zero")
+	}
 	return x % y
 }
 func plus(x, y float64) float64 {
@@ -529,9 +840,15 @@ func not(x int64) int64 {
 	return ^x
 }
 func shiftl(x, y int64) int64 {
+	if y < 0 {
+		errorf("negative shift count %d", y)
+	}
 	return x << uint(y)
 }
 func shiftr(x, y int64) int64 {
+	if y < 0 {
+		errorf("negative shift count %d", y)
+	}
 	return x >> uint(y)
 }
 func log2(x float64) float64 {
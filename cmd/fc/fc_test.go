@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var evalTests = []struct {
+	expr string
+	want []float64
+}{{
+	expr: "3 s0 r0 r0 *",
+	want: []float64{9},
+}, {
+	expr: "1 s1 2 s2 r2 r1 -",
+	want: []float64{1},
+}, {
+	expr: "5 s3 r3 clr r3",
+	want: []float64{5, 0},
+}, {
+	expr: "7 s9 8 s0 clr r9 r0 +",
+	want: []float64{0},
+}, {
+	expr: "1:30 45 +",
+	want: []float64{135},
+}, {
+	expr: "01:02:03.5 -0:30 +",
+	want: []float64{3693.5},
+}, {
+	expr: "1 10 20 ?",
+	want: []float64{10},
+}, {
+	expr: "0 10 20 ?",
+	want: []float64{20},
+}, {
+	expr: "5 3 2 - 10 20 ?",
+	want: []float64{5, 10},
+}, {
+	expr: "-0.5 1 2 ? inf -inf ?",
+	want: []float64{math.Inf(1)},
+}}
+
+func TestEval(t *testing.T) {
+	for _, test := range evalTests {
+		stack = nil
+		registers = [10]float64{}
+		got, err := eval(strings.Fields(test.expr))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestChooseNaN(t *testing.T) {
+	for _, expr := range []string{"nan 10 20 ?", "1 nan 20 ? 10 20 ?"} {
+		stack = nil
+		got, err := eval(strings.Fields(expr))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", expr, err)
+			continue
+		}
+		if len(got) != 1 || !math.IsNaN(got[0]) {
+			t.Errorf("%s: got %v want [NaN]", expr, got)
+		}
+	}
+}
+
+var evalErrorTests = []struct {
+	expr      string
+	expectErr string
+}{{
+	expr:      "1 2 frob",
+	expectErr: `unknown operator "frob"`,
+}, {
+	expr:      "1 +",
+	expectErr: `Stack too small for op "+"`,
+}, {
+	expr:      "dup",
+	expectErr: `Stack too small for op "dup"`,
+}, {
+	expr:      "1 2 ?",
+	expectErr: `Stack too small for op "?"`,
+}, {
+	expr:      "s0",
+	expectErr: `Stack too small for op "s0"`,
+}, {
+	expr:      "1 sqrt rep",
+	expectErr: `Invalid operator for rep`,
+}, {
+	expr:      "0x8000000000000000",
+	expectErr: `bad number "8000000000000000"`,
+}, {
+	expr:      "1 0 %",
+	expectErr: `division by zero`,
+}, {
+	expr:      "1 -1 >>",
+	expectErr: `negative shift count -1`,
+}, {
+	expr:      "1 -1 <<",
+	expectErr: `negative shift count -1`,
+}}
+
+func TestEvalErrors(t *testing.T) {
+	for _, test := range evalErrorTests {
+		stack = nil
+		lastOp = nil
+		got, err := eval(strings.Fields(test.expr))
+		if err == nil {
+			t.Errorf("%s: got %v, want error", test.expr, got)
+			continue
+		}
+		if err.Error() != test.expectErr {
+			t.Errorf("%s: got error %q want %q", test.expr, err, test.expectErr)
+		}
+		if len(stack) != 0 {
+			t.Errorf("%s: stack changed to %v", test.expr, stack)
+		}
+	}
+}
+
+func TestEvalContinuesAfterError(t *testing.T) {
+	stack = nil
+	if _, err := eval([]string{"99"}); err != nil {
+		t.Fatal(err)
+	}
+	// The stack is left as it was before the failed expression,
+	// so evaluation can continue with the next one.
+	if _, err := eval([]string{"1", "2", "frob"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	got, err := eval([]string{"1", "+"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestEvalRepanics(t *testing.T) {
+	stack = nil
+	oldOps := ops
+	defer func() {
+		ops = oldOps
+	}()
+	ops = append(ops[:len(ops):len(ops)], op{"boom", func([]float64) []float64 {
+		panic("boom")
+	}})
+	defer func() {
+		if e := recover(); e != "boom" {
+			t.Errorf("unexpected panic value %#v", e)
+		}
+	}()
+	eval([]string{"boom"})
+	t.Errorf("eval did not panic")
+}
+
+func TestREPL(t *testing.T) {
+	stack = nil
+	registers = [10]float64{}
+	oldBase := base
+	defer func() {
+		base = oldBase
+	}()
+	base = dec
+	input := `
+1 2 +
+10 *
+s0
+c
+frob
+1 0 %
+r0 1 +
+c
+
+q
+99
+`
+	var out bytes.Buffer
+	if err := repl(strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "3\n30\n31\n"
+	if got := out.String(); got != want {
+		t.Errorf("got output %q want %q", got, want)
+	}
+	if len(stack) != 0 {
+		t.Errorf("unexpected stack after q: %v", stack)
+	}
+}
+
+var numToStrTests = []struct {
+	base      int
+	width     int
+	groupSize int
+	v         float64
+	want      string
+}{
+	{base: bin, v: 300, want: "100101100"},
+	{base: bin, width: 16, v: 300, want: "0000000100101100"},
+	{base: bin, width: 16, groupSize: 4, v: 300, want: "0000_0001_0010_1100"},
+	{base: bin, width: 4, v: -1, want: "1111"},
+	{base: bin, groupSize: 4, v: 300, want: "1_0010_1100"},
+	{base: hex, v: 255, want: "0xff"},
+	{base: hex, width: 16, v: 255, want: "0x00ff"},
+	{base: hex, width: 16, groupSize: 2, v: -1, want: "0xff_ff"},
+	{base: hex, groupSize: 4, v: 1000000, want: "0xf_4240"},
+	{base: hex, groupSize: 4, v: -1000000, want: "-0xf_4240"},
+	{base: annotbin, groupSize: 4, v: 1000, want: "11_1110_1000\n98 7654 3210\n"},
+	{base: duration, v: 0, want: "00:00:00"},
+	{base: duration, v: 3723, want: "01:02:03"},
+	{base: duration, v: 90.1, want: "00:01:30.1"},
+	{base: duration, v: -3601.25, want: "-01:00:01.25"},
+	{base: duration, v: 100 * 3600, want: "100:00:00"},
+}
+
+func TestNumToStr(t *testing.T) {
+	defer func() {
+		base, width, groupSize = dec, 0, 0
+	}()
+	for _, test := range numToStrTests {
+		base, width, groupSize = test.base, test.width, test.groupSize
+		if got := numToStr(test.v); got != test.want {
+			t.Errorf("base %d, width %d, group %d, %v: got %q want %q", test.base, test.width, test.groupSize, test.v, got, test.want)
+		}
+	}
+}
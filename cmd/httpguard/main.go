@@ -8,17 +8,37 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/rogpeppe/misc/httpguard"
 	"golang.org/x/crypto/acme/autocert"
 )
 
 type config struct {
-	Hosts    map[string]string `json:"hosts"`
-	Password string            `json:"password"`
-	Port     int               `json:"port"`
+	Hosts      map[string]string `json:"hosts"`
+	Password   string            `json:"password"`
+	Port       int               `json:"port"`
+	Secret     string            `json:"secret"`
+	Cookie     string            `json:"cookie"`
+	HealthPort int               `json:"healthport"`
+	Headers    map[string]string `json:"headers"`
+	CORS       *struct {
+		Origins []string `json:"origins"`
+		Methods []string `json:"methods"`
+	} `json:"cors"`
+	TLS map[string]struct {
+		CA         string `json:"ca"`
+		ServerName string `json:"servername"`
+		Insecure   bool   `json:"insecure"`
+	} `json:"tls"`
+	Timeout string `json:"timeout"`
+	Breaker *struct {
+		Failures int    `json:"failures"`
+		Cooldown string `json:"cooldown"`
+	} `json:"breaker"`
 }
 
 var cacheDir = flag.String("d", "/tmp/autocert", "certificate directory cache")
@@ -31,11 +51,31 @@ usage: httpproxy <config>
 Example config:
 {
 	"password": "foo",
+	"secret": "some long random string",
 	"port": 8080,
+	"healthport": 8081,
+	"headers": {
+		"Strict-Transport-Security": "max-age=31536000"
+	},
+	"cors": {
+		"origins": ["https://app.example.com"],
+		"methods": ["GET", "POST"]
+	},
 	"hosts": {
 		"host1.ddns.net": "http://192.168.2.99:8080",
 		"host2.ddns.net": "http://192.168.2.101:80",
-		"host3.ddns.net": "http://192.168.2.100:80"
+		"host3.ddns.net": "https://192.168.2.100:443"
+	},
+	"tls": {
+		"host3.ddns.net": {
+			"ca": "/etc/httpguard/internal-ca.pem",
+			"servername": "host3.internal"
+		}
+	},
+	"timeout": "30s",
+	"breaker": {
+		"failures": 5,
+		"cooldown": "1m"
 	}
 }
 `[1:])
@@ -61,7 +101,53 @@ Example config:
 		Hosts:           cfg.Hosts,
 		Port:            cfg.Port,
 		Password:        cfg.Password,
+		Secret:          []byte(cfg.Secret),
+		CookieName:      cfg.Cookie,
+		HealthPort:      cfg.HealthPort,
+		ResponseHeaders: cfg.Headers,
 		AutocertManager: &m,
 	}
+	if cfg.CORS != nil {
+		p.CORS = &httpguard.CORSParams{
+			AllowedOrigins: cfg.CORS.Origins,
+			AllowedMethods: cfg.CORS.Methods,
+		}
+	}
+	for host, t := range cfg.TLS {
+		tp := &httpguard.UpstreamTLSParams{
+			ServerName:         t.ServerName,
+			InsecureSkipVerify: t.Insecure,
+		}
+		if t.CA != "" {
+			data, err := ioutil.ReadFile(t.CA)
+			if err != nil {
+				log.Fatal("cannot read CA certificates: ", err)
+			}
+			tp.CACerts = data
+		}
+		if p.UpstreamTLS == nil {
+			p.UpstreamTLS = make(map[string]*httpguard.UpstreamTLSParams)
+		}
+		p.UpstreamTLS[host] = tp
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			log.Fatal("bad timeout: ", err)
+		}
+		p.UpstreamTimeout = d
+	}
+	if cfg.Breaker != nil {
+		p.CircuitBreaker = &httpguard.CircuitBreakerParams{
+			MaxFailures: cfg.Breaker.Failures,
+		}
+		if cfg.Breaker.Cooldown != "" {
+			d, err := time.ParseDuration(cfg.Breaker.Cooldown)
+			if err != nil {
+				log.Fatal("bad breaker cooldown: ", err)
+			}
+			p.CircuitBreaker.Cooldown = d
+		}
+	}
 	log.Fatal("server exited: ", httpguard.Serve(p))
 }
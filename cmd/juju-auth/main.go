@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"strings"
@@ -28,7 +27,7 @@ var debug = flag.Bool("debug", false, "print debug messages")
 func main() {
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: juju-auth <controller>[:<model>]\n")
+		fmt.Fprintf(os.Stderr, "usage: juju-auth <controller>[:<model>]...\n")
 		fmt.Fprintf(os.Stderr, "Set BAKERY_AGENT_FILE to a path to the agent auth file\n")
 		info, _ := rdebug.ReadBuildInfo()
 		pretty.Println(info)
@@ -36,25 +35,41 @@ func main() {
 		os.Exit(2)
 	}
 	flag.Parse()
-	if flag.NArg() > 1 {
+	if flag.NArg() < 1 {
 		flag.Usage()
 	}
 	if *debug {
 		loggo.ConfigureLoggers("DEBUG")
 	}
-	controller := flag.Arg(0)
-	model := ""
-	cm := strings.SplitN(controller, ":", 2)
+	// Try all the controllers even if some fail,
+	// so that we authenticate to as many as possible.
+	var failed []string
+	for _, arg := range flag.Args() {
+		if err := run(arg); err != nil {
+			fmt.Fprintf(os.Stderr, "juju-auth: %s: %v\n", arg, err)
+			failed = append(failed, arg)
+			continue
+		}
+		fmt.Printf("%s: authenticated successfully\n", arg)
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "juju-auth: authentication failed for %d of %d controllers: %s\n", len(failed), flag.NArg(), strings.Join(failed, " "))
+		os.Exit(1)
+	}
+}
+
+// run authenticates to the controller specified
+// by arg, which is in the form <controller>[:<model>].
+func run(arg string) error {
+	controller, model := arg, ""
+	cm := strings.SplitN(arg, ":", 2)
 	if len(cm) > 1 {
 		controller, model = cm[0], cm[1]
 	}
 	if controller == "" {
-		log.Fatalf("controller must be non-empty")
+		return errgo.New("controller must be non-empty")
 	}
-	if err := jujuAuth(controller, model); err != nil {
-		fmt.Fprint(os.Stderr, "%v\n", err)
-	}
-	fmt.Println("authenticated successfully")
+	return jujuAuth(controller, model)
 }
 
 func jujuAuth(controller, model string) error {
@@ -67,20 +82,19 @@ func jujuAuth(controller, model string) error {
 		return errgo.Mask(err)
 	}
 	jarPath := jujuclient.JujuCookiePath(controller)
-	// Remove all cookies so we'll definitely refresh them.
-	os.Remove(jarPath)
+	// Start with an empty jar so that we'll definitely refresh
+	// the cookies, but keep it in a new file that replaces the
+	// existing one only when authentication succeeds, so that a
+	// failure doesn't lose cookies that might still work.
+	newJarPath := jarPath + ".new"
+	os.Remove(newJarPath)
+	defer os.Remove(newJarPath)
 	jar, err := cookiejar.New(&cookiejar.Options{
-		Filename: jarPath,
+		Filename: newJarPath,
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	defer func() {
-		if err := jar.Save(); err != nil {
-			log.Printf("cannot save cookie jar: %v", err)
-		}
-		fmt.Printf("saved authentication credentials to %s\n", jarPath)
-	}()
 	bclient := httpbakery.NewClient()
 	bclient.Jar = jar
 	bclient.Key = fromBakeryV2Key(authInfo.Key)
@@ -99,9 +113,18 @@ func jujuAuth(controller, model string) error {
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	if _, err := ctxt.DialModel("", ""); err != nil {
+	conn, err := ctxt.DialModel(controller, model)
+	if err != nil {
 		return errgo.Notef(err, "cannot dial model")
 	}
+	conn.Close()
+	if err := jar.Save(); err != nil {
+		return errgo.Notef(err, "cannot save cookie jar")
+	}
+	if err := os.Rename(newJarPath, jarPath); err != nil {
+		return errgo.Notef(err, "cannot replace cookie jar")
+	}
+	fmt.Printf("saved authentication credentials to %s\n", jarPath)
 	return nil
 }
 
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,15 +21,30 @@ import (
 	flag "launchpad.net/gnuflag"
 )
 
-var nflag = flag.Bool("n", false, "print ops but don't actually change anything")
+var (
+	nflag    = flag.Bool("n", false, "print ops but don't actually change anything")
+	jsonFlag = flag.Bool("json", false, "print the current status as JSON and exit")
+	force    = flag.Bool("force", false, "change voting rights even if it would lose quorum")
+)
 
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: juju-stateservers machine-id...\n")
+		fmt.Fprintf(os.Stderr, "       juju-stateservers -json\n")
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse(true)
+	if *jsonFlag {
+		if flag.NArg() > 0 {
+			flag.Usage()
+		}
+		if err := printStatusJSON(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot get status: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := setStateServers(flag.Args()); err != nil {
 		fmt.Fprintf(os.Stderr, "cannot set state servers: %v\n", err)
 		os.Exit(1)
@@ -81,6 +98,16 @@ func setStateServers0(ids []string, st *state.State, db *mgo.Database, runner *t
 		}
 		wantsVote[id] = true
 	}
+	members, err := replicasetMembers(db.Session)
+	if err != nil {
+		return err
+	}
+	if err := checkQuorum(ids, info.servers.VotingMachineIds, members); err != nil {
+		if !*force {
+			return fmt.Errorf("%v (use -force to override)", err)
+		}
+		fmt.Printf("warning: %v\n", err)
+	}
 	ops := []txn.Op{{
 		C:      "stateServers",
 		Id:     "e",
@@ -106,32 +133,190 @@ func setStateServers0(ids []string, st *state.State, db *mgo.Database, runner *t
 }
 
 func printReplicasetMembers(session *mgo.Session) error {
+	members, err := replicasetMembers(session)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if !m.HasStatus {
+			fmt.Printf("id %3v has no replica set status\n", m.Id)
+		}
+		fmt.Printf("id %3v; machine id %5q; address %50s; votes %v; healthy %v; state %v\n", m.Id, m.MachineId, m.Address, m.Votes, m.Healthy, m.State)
+	}
+	return nil
+}
+
+// checkQuorum checks that making the given machines the voting
+// set would leave a healthy majority of voting replica set
+// members, given the current voting machines and replica set members.
+// Machines that are not in the replica set or have no status are
+// treated as unhealthy.
+func checkQuorum(ids, currentVoting []string, members []memberInfo) error {
+	healthy := make(map[string]bool)
+	for _, m := range members {
+		if m.MachineId != "" && m.HasStatus && m.Healthy {
+			healthy[m.MachineId] = true
+		}
+	}
+	wantsVote := make(map[string]bool)
+	var unhealthy []string
+	for _, id := range ids {
+		wantsVote[id] = true
+		if !healthy[id] {
+			unhealthy = append(unhealthy, id)
+		}
+	}
+	var demoted []string
+	for _, id := range currentVoting {
+		if !wantsVote[id] {
+			demoted = append(demoted, id)
+		}
+	}
+	if len(demoted) == 0 {
+		// Not removing any votes, so we can't make things worse.
+		return nil
+	}
+	nhealthy := len(ids) - len(unhealthy)
+	if nhealthy > len(ids)/2 {
+		return nil
+	}
+	return fmt.Errorf("removing votes from machines %s would leave only %d healthy voting machines out of %d (unhealthy: %s); a majority is needed for quorum",
+		strings.Join(demoted, ", "),
+		nhealthy,
+		len(ids),
+		strings.Join(unhealthy, ", "),
+	)
+}
+
+// memberInfo holds information about a replica set member
+// combined with its current status.
+type memberInfo struct {
+	Id        int    `json:"id"`
+	MachineId string `json:"machine-id,omitempty"`
+	Address   string `json:"address"`
+	Votes     int    `json:"votes"`
+	HasStatus bool   `json:"has-status"`
+	Healthy   bool   `json:"healthy"`
+	State     string `json:"state,omitempty"`
+}
+
+// replicasetMembers returns information on all the
+// current members of the replica set.
+func replicasetMembers(session *mgo.Session) ([]memberInfo, error) {
 	members, err := replicaset.CurrentMembers(session)
 	if err != nil {
-		return fmt.Errorf("cannot get replica set members: %v", err)
+		return nil, fmt.Errorf("cannot get replica set members: %v", err)
 	}
 	statusResult, err := replicaset.CurrentStatus(session)
 	if err != nil {
-		return fmt.Errorf("cannot get replica set status: %v", err)
+		return nil, fmt.Errorf("cannot get replica set status: %v", err)
 	}
 	statuses := make(map[int]*replicaset.MemberStatus)
 	for i, status := range statusResult.Members {
 		statuses[status.Id] = &statusResult.Members[i]
 	}
-	for _, m := range members {
-		votes := 1
+	infos := make([]memberInfo, len(members))
+	for i, m := range members {
+		info := memberInfo{
+			Id:        m.Id,
+			MachineId: m.Tags["juju-machine-id"],
+			Address:   m.Address,
+			Votes:     1,
+		}
 		if m.Votes != nil {
-			votes = *m.Votes
+			info.Votes = *m.Votes
 		}
-		status := statuses[m.Id]
-		if status == nil {
-			fmt.Printf("id %3v has no replica set status\n", m.Id)
+		if status := statuses[m.Id]; status != nil {
+			info.HasStatus = true
+			info.Healthy = status.Healthy
+			info.State = status.State.String()
 		}
-		fmt.Printf("id %3v; machine id %5q; address %50s; votes %v; healthy %v; state %v\n", m.Id, m.Tags["juju-machine-id"], m.Address, votes, status.Healthy, status.State)
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+// statusDoc holds the status printed by the -json flag.
+type statusDoc struct {
+	MachineIds       []string        `json:"machine-ids"`
+	VotingMachineIds []string        `json:"voting-machine-ids"`
+	Machines         []machineStatus `json:"machines"`
+	// OtherMembers holds any replica set members
+	// that don't correspond to a known state server.
+	OtherMembers []memberInfo `json:"other-members,omitempty"`
+}
+
+type machineStatus struct {
+	Id        string      `json:"id"`
+	Jobs      []string    `json:"jobs"`
+	WantsVote bool        `json:"wants-vote"`
+	HasVote   bool        `json:"has-vote"`
+	Member    *memberInfo `json:"member,omitempty"`
+}
+
+func printStatusJSON() error {
+	st, db, _, err := openState()
+	if err != nil {
+		return err
+	}
+	doc, err := currentStatus(st, db)
+	if err != nil {
+		return err
 	}
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
 	return nil
 }
 
+// currentStatus returns the current status of all the state
+// servers, combining the machine information with its
+// replica set status.
+func currentStatus(st *state.State, db *mgo.Database) (*statusDoc, error) {
+	info, err := currentInfo(st, db)
+	if err != nil {
+		return nil, err
+	}
+	members, err := replicasetMembers(db.Session)
+	if err != nil {
+		return nil, err
+	}
+	doc := &statusDoc{
+		MachineIds:       info.servers.MachineIds,
+		VotingMachineIds: info.servers.VotingMachineIds,
+	}
+	byMachine := make(map[string]*memberInfo)
+	for i, m := range members {
+		if _, ok := info.machines[m.MachineId]; ok {
+			byMachine[m.MachineId] = &members[i]
+		} else {
+			doc.OtherMembers = append(doc.OtherMembers, m)
+		}
+	}
+	ids := make([]string, 0, len(info.machines))
+	for id := range info.machines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		m := info.machines[id]
+		var jobs []string
+		for _, job := range m.Jobs() {
+			jobs = append(jobs, job.String())
+		}
+		doc.Machines = append(doc.Machines, machineStatus{
+			Id:        id,
+			Jobs:      jobs,
+			WantsVote: m.WantsVote(),
+			HasVote:   m.HasVote(),
+			Member:    byMachine[id],
+		})
+	}
+	return doc, nil
+}
+
 func openState() (*state.State, *mgo.Database, *txn.Runner, error) {
 	stInfo, err := stateInfo()
 	if err != nil {
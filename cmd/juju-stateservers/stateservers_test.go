@@ -24,3 +24,73 @@ func (s *suite) TestSetStateServers(c *gc.C) {
 	err = setStateServers0([]string{"0", "1", "4"}, s.State, db, runner)
 	c.Assert(err, gc.IsNil)
 }
+
+type quorumSuite struct{}
+
+var _ = gc.Suite(&quorumSuite{})
+
+var checkQuorumTests = []struct {
+	about         string
+	ids           []string
+	currentVoting []string
+	members       []memberInfo
+	expectError   string
+}{{
+	about:         "all healthy",
+	ids:           []string{"0"},
+	currentVoting: []string{"0", "1", "2"},
+	members: []memberInfo{
+		{MachineId: "0", HasStatus: true, Healthy: true},
+		{MachineId: "1", HasStatus: true, Healthy: true},
+		{MachineId: "2", HasStatus: true, Healthy: true},
+	},
+}, {
+	about:         "healthy majority remains",
+	ids:           []string{"0", "1", "3"},
+	currentVoting: []string{"0", "1", "2"},
+	members: []memberInfo{
+		{MachineId: "0", HasStatus: true, Healthy: true},
+		{MachineId: "1", HasStatus: true, Healthy: true},
+		{MachineId: "2", HasStatus: true, Healthy: true},
+		{MachineId: "3", HasStatus: true},
+	},
+}, {
+	about:         "demoting healthy machine loses quorum",
+	ids:           []string{"0", "1", "3"},
+	currentVoting: []string{"0", "1", "2"},
+	members: []memberInfo{
+		{MachineId: "0", HasStatus: true, Healthy: true},
+		{MachineId: "1"},
+		{MachineId: "2", HasStatus: true, Healthy: true},
+		{MachineId: "3", HasStatus: true},
+	},
+	expectError: `removing votes from machines 2 would leave only 1 healthy voting machines out of 3 \(unhealthy: 1, 3\); a majority is needed for quorum`,
+}, {
+	about:         "machine not in replica set",
+	ids:           []string{"0"},
+	currentVoting: []string{"0", "1", "2"},
+	members: []memberInfo{
+		{MachineId: "1", HasStatus: true, Healthy: true},
+		{MachineId: "2", HasStatus: true, Healthy: true},
+	},
+	expectError: `removing votes from machines 1, 2 would leave only 0 healthy voting machines out of 1 \(unhealthy: 0\); .*`,
+}, {
+	about:         "only adding votes",
+	ids:           []string{"0", "1", "2"},
+	currentVoting: []string{"0"},
+	members: []memberInfo{
+		{MachineId: "0", HasStatus: true, Healthy: true},
+	},
+}}
+
+func (*quorumSuite) TestCheckQuorum(c *gc.C) {
+	for i, test := range checkQuorumTests {
+		c.Logf("test %d: %s", i, test.about)
+		err := checkQuorum(test.ids, test.currentVoting, test.members)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
@@ -28,4 +28,11 @@ type Method struct {
 	Doc    string          `json:",omitempty"`
 	Param  *jsontypes.Type `json:",omitempty"`
 	Result *jsontypes.Type `json:",omitempty"`
+
+	// ParamSchema and ResultSchema hold JSON Schema
+	// documents describing Param and Result.
+	// They are only filled in when jujuapidoc is run
+	// with the -schema flag.
+	ParamSchema  map[string]interface{} `json:",omitempty"`
+	ResultSchema map[string]interface{} `json:",omitempty"`
 }
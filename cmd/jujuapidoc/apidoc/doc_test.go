@@ -0,0 +1,53 @@
+package apidoc_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/rogpeppe/misc/cmd/jujuapidoc/apidoc"
+)
+
+func TestFacadeInfoRoundTrip(t *testing.T) {
+	f := apidoc.FacadeInfo{
+		Name:    "Client",
+		Version: 1,
+		Doc:     "Client holds client methods.",
+		Methods: []apidoc.Method{{
+			Name: "FullStatus",
+		}},
+		AvailableTo: []string{"controller-user", "model-user"},
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m["AvailableTo"], []interface{}{"controller-user", "model-user"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected AvailableTo in JSON; got %#v want %#v", got, want)
+	}
+	var f1 apidoc.FacadeInfo
+	if err := json.Unmarshal(data, &f1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f1, f) {
+		t.Fatalf("facade info did not round trip; got %#v want %#v", f1, f)
+	}
+}
+
+func TestFacadeInfoOmitsEmptyAvailableTo(t *testing.T) {
+	data, err := json.Marshal(apidoc.FacadeInfo{Name: "Admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["AvailableTo"]; ok {
+		t.Fatalf("unexpected AvailableTo field in %s", data)
+	}
+}
@@ -1,5 +1,6 @@
 // Code generated by go-bindata.
 // sources:
+// jujugenerateapidoc/2.3/prog.go
 // jujugenerateapidoc/prog.go
 // DO NOT EDIT!
 
@@ -27,6 +28,24 @@ type asset struct {
 	info  os.FileInfo
 }
 
+// jujugenerateapidoc23ProgGo reads file data from disk. It returns an error on failure.
+func jujugenerateapidoc23ProgGo() (*asset, error) {
+	path := "/home/rog/src/go/src/github.com/rogpeppe/misc/cmd/jujuapidoc/jujugenerateapidoc/2.3/prog.go"
+	name := "jujugenerateapidoc/2.3/prog.go"
+	bytes, err := bindataRead(path, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		err = fmt.Errorf("Error reading asset info %s at %s: %v", name, path, err)
+	}
+
+	a := &asset{bytes: bytes, info: fi}
+	return a, err
+}
+
 // jujugenerateapidocProgGo reads file data from disk. It returns an error on failure.
 func jujugenerateapidocProgGo() (*asset, error) {
 	path := "/home/rog/src/go/src/github.com/rogpeppe/misc/cmd/jujuapidoc/jujugenerateapidoc/prog.go"
@@ -97,6 +116,7 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
+	"jujugenerateapidoc/2.3/prog.go": jujugenerateapidoc23ProgGo,
 	"jujugenerateapidoc/prog.go": jujugenerateapidocProgGo,
 }
 
@@ -141,6 +161,10 @@ type bintree struct {
 }
 var _bintree = &bintree{nil, map[string]*bintree{
 	"jujugenerateapidoc": &bintree{nil, map[string]*bintree{
+		"2.3": &bintree{nil, map[string]*bintree{
+			"prog.go": &bintree{jujugenerateapidoc23ProgGo, map[string]*bintree{
+			}},
+		}},
 		"prog.go": &bintree{jujugenerateapidocProgGo, map[string]*bintree{
 		}},
 	}},
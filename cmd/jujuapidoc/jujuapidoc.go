@@ -7,22 +7,60 @@
 //
 // The resulting JSON output can be processed into HTML by
 // the jujuapidochtml command.
+//
+// By default, all facades are included. The -facade flag (which may
+// be repeated) restricts the output to the named facades, and the
+// -version flag restricts it to a single version of each facade.
+//
+// The -juju-version flag selects a generator program for a particular
+// Juju release. Currently the only one is 2.3, which works with an
+// unmodified Juju 2.3 tree but omits the Admin facade. To add another,
+// put it in jujugenerateapidoc/$version/prog.go and run go generate.
 package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/errgo.v1"
+
+	"github.com/rogpeppe/misc/cmd/jujuapidoc/apidoc"
+)
+
+//go:generate go-bindata --debug jujugenerateapidoc/...
+
+var (
+	schemaFlag  = flag.Bool("schema", false, "include JSON Schema documents for the params and results of each method")
+	versionFlag = flag.Int("version", -1, "only include this version of each facade (default all versions)")
+	jujuFlag    = flag.String("juju-version", "", "use the generator program for this Juju version (default the current Juju API)")
+	facadeFlag  stringsFlag
 )
 
-//go:generate go-bindata --debug jujugenerateapidoc
+func init() {
+	flag.Var(&facadeFlag, "facade", "only include the named facade; may be repeated (default all facades)")
+}
+
+// stringsFlag implements flag.Value by accumulating
+// all the values it's set to.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
 
 func main() {
 	flag.Parse()
@@ -38,7 +76,7 @@ func runMain() error {
 		return errgo.Mask(err)
 	}
 	defer os.RemoveAll(dir)
-	progData, err := Asset("jujugenerateapidoc/prog.go")
+	progData, err := generatorProg(*jujuFlag)
 	if err != nil {
 		return errgo.Mask(err)
 	}
@@ -68,11 +106,85 @@ func runMain() error {
 	if err := cmd.Run(); err != nil {
 		return errgo.Notef(err, "cannot build doc generator program")
 	}
-	cmd = exec.Command(filepath.Join(dir, "generate"))
+	// Pass on any filtering flags to the generator so that
+	// it can avoid doing work for facades we don't want.
+	var generateArgs []string
+	for _, name := range facadeFlag {
+		generateArgs = append(generateArgs, "-facade", name)
+	}
+	if *versionFlag >= 0 {
+		generateArgs = append(generateArgs, "-version", strconv.Itoa(*versionFlag))
+	}
+	var infoBuf bytes.Buffer
+	cmd = exec.Command(filepath.Join(dir, "generate"), generateArgs...)
 	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = &infoBuf
 	if err := cmd.Run(); err != nil {
 		return errgo.Notef(err, "generate info failed")
 	}
-	return nil
+	if !*schemaFlag {
+		_, err := os.Stdout.Write(infoBuf.Bytes())
+		return errgo.Mask(err)
+	}
+	var info apidoc.Info
+	if err := json.Unmarshal(infoBuf.Bytes(), &info); err != nil {
+		return errgo.Notef(err, "cannot unmarshal generated info")
+	}
+	if err := addSchemas(&info); err != nil {
+		return errgo.Mask(err)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	_, err = os.Stdout.Write(data)
+	return errgo.Mask(err)
+}
+
+const generatorDir = "jujugenerateapidoc"
+
+// generatorProg returns the source of the bundled generator
+// program for the given Juju version. The program for the current
+// Juju API is held in jujugenerateapidoc/prog.go; programs for
+// other Juju versions are held in jujugenerateapidoc/$version/prog.go.
+// If version is empty, the current program is returned.
+func generatorProg(version string) ([]byte, error) {
+	name, err := generatorAsset(version, AssetNames())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return Asset(name)
+}
+
+// generatorAsset returns the name of the asset holding the generator
+// program for the given Juju version, chosen from the given
+// asset names.
+func generatorAsset(version string, assetNames []string) (string, error) {
+	if version == "" {
+		return generatorDir + "/prog.go", nil
+	}
+	versions := generatorVersions(assetNames)
+	for _, v := range versions {
+		if v == version {
+			return generatorDir + "/" + version + "/prog.go", nil
+		}
+	}
+	if len(versions) == 0 {
+		return "", errgo.Newf("no generator for Juju version %q (only the default generator is available)", version)
+	}
+	return "", errgo.Newf("no generator for Juju version %q (available versions: %s)", version, strings.Join(versions, ", "))
+}
+
+// generatorVersions returns the sorted Juju versions
+// with generator programs in the given asset names.
+func generatorVersions(assetNames []string) []string {
+	var versions []string
+	for _, name := range assetNames {
+		parts := strings.Split(name, "/")
+		if len(parts) == 3 && parts[0] == generatorDir && parts[2] == "prog.go" {
+			versions = append(versions, parts[1])
+		}
+	}
+	sort.Strings(versions)
+	return versions
 }
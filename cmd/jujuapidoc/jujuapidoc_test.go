@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGeneratorVersions(t *testing.T) {
+	got := generatorVersions([]string{
+		"jujugenerateapidoc/prog.go",
+		"jujugenerateapidoc/2.3/prog.go",
+		"jujugenerateapidoc/2.1/prog.go",
+		"jujugenerateapidoc/2.1/other.go",
+		"other/2.2/prog.go",
+	})
+	want := []string{"2.1", "2.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+var generatorAssetTests = []struct {
+	version     string
+	expect      string
+	expectError string
+}{{
+	version: "",
+	expect:  "jujugenerateapidoc/prog.go",
+}, {
+	version: "2.3",
+	expect:  "jujugenerateapidoc/2.3/prog.go",
+}, {
+	version:     "1.25",
+	expectError: `no generator for Juju version "1.25" (available versions: 2.3)`,
+}}
+
+func TestGeneratorAsset(t *testing.T) {
+	for _, test := range generatorAssetTests {
+		name, err := generatorAsset(test.version, AssetNames())
+		if test.expectError != "" {
+			if err == nil || err.Error() != test.expectError {
+				t.Errorf("version %q: got error %v want %q", test.version, err, test.expectError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("version %q: unexpected error: %v", test.version, err)
+			continue
+		}
+		if name != test.expect {
+			t.Errorf("version %q: got %q want %q", test.version, name, test.expect)
+		}
+	}
+}
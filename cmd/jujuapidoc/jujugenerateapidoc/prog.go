@@ -7,6 +7,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -16,6 +17,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/juju/juju/apiserver"
 	"github.com/juju/juju/rpc/rpcreflect"
@@ -33,7 +35,30 @@ import (
 	"gopkg.in/juju/names.v2"
 )
 
+var (
+	versionFlag = flag.Int("version", -1, "only include this version of each facade (default all versions)")
+	facadeFlag  stringsFlag
+)
+
+func init() {
+	flag.Var(&facadeFlag, "facade", "only include the named facade; may be repeated (default all facades)")
+}
+
+// stringsFlag implements flag.Value by accumulating
+// all the values it's set to.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
 func main() {
+	flag.Parse()
 	info, err := generateInfo()
 	if err != nil {
 		log.Fatal(err)
@@ -67,6 +92,15 @@ func generateInfo() (*apidoc.Info, error) {
 	info := jsontypes.NewInfo()
 	ds := apiserver.AllFacades().ListDetails()
 	ds = append(ds, apiserver.AdminFacadeDetails()...)
+	// Filter the facades before doing any other work so
+	// that we don't extract doc comments unnecessarily.
+	selected := ds[:0]
+	for _, d := range ds {
+		if wantFacade(d.Name, d.Version) {
+			selected = append(selected, d)
+		}
+	}
+	ds = selected
 	for _, d := range ds {
 		t := rpcreflect.ObjTypeOf(d.Type)
 
@@ -122,6 +156,25 @@ func generateInfo() (*apidoc.Info, error) {
 	return apiInfo, nil
 }
 
+// wantFacade reports whether the given facade version is
+// selected by the -facade and -version flags.
+// When no facades are specified, all facades are selected;
+// when no version is specified, all versions are selected.
+func wantFacade(name string, version int) bool {
+	if *versionFlag >= 0 && version != *versionFlag {
+		return false
+	}
+	if len(facadeFlag) == 0 {
+		return true
+	}
+	for _, f := range facadeFlag {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
 var tmplFuncs = template.FuncMap{
 	"typeLink": func(t *jsontypes.Type) template.HTML {
 		if t == nil {
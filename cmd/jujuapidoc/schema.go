@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/rogpeppe/apicompat/jsontypes"
+	"gopkg.in/errgo.v1"
+
+	"github.com/rogpeppe/misc/cmd/jujuapidoc/apidoc"
+)
+
+// addSchemas fills in the ParamSchema and ResultSchema
+// fields of all the methods in info.
+func addSchemas(info *apidoc.Info) error {
+	for i := range info.Facades {
+		f := &info.Facades[i]
+		for j := range f.Methods {
+			m := &f.Methods[j]
+			var err error
+			if m.Param != nil {
+				m.ParamSchema, err = jsonSchema(info.TypeInfo, m.Param)
+				if err != nil {
+					return errgo.Notef(err, "cannot make schema for %s.%s params", f.Name, m.Name)
+				}
+			}
+			if m.Result != nil {
+				m.ResultSchema, err = jsonSchema(info.TypeInfo, m.Result)
+				if err != nil {
+					return errgo.Notef(err, "cannot make schema for %s.%s result", f.Name, m.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonSchema returns a self-contained JSON Schema document
+// describing the JSON encoding of the given type. References to named
+// types are resolved using info; each named type used is included
+// once in the "definitions" section of the document.
+func jsonSchema(info *jsontypes.Info, t *jsontypes.Type) (map[string]interface{}, error) {
+	g := &schemaGen{
+		info: info,
+		defs: make(map[string]interface{}),
+	}
+	s, err := g.schema(t)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	doc := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+	}
+	for k, v := range s {
+		doc[k] = v
+	}
+	if len(g.defs) > 0 {
+		doc["definitions"] = g.defs
+	}
+	return doc, nil
+}
+
+type schemaGen struct {
+	info *jsontypes.Info
+	// defs holds the definitions of all the named
+	// types encountered so far, keyed by type name.
+	defs map[string]interface{}
+}
+
+// schema returns the schema for t. Named types declared in
+// packages are added to g.defs and referred to by $ref.
+func (g *schemaGen) schema(t *jsontypes.Type) (map[string]interface{}, error) {
+	name := fmt.Sprint(t.Name)
+	if !strings.Contains(name, "#") {
+		// Predeclared or unnamed type.
+		return g.typeSchema(t)
+	}
+	ref := map[string]interface{}{
+		"$ref": "#/definitions/" + refEscaper.Replace(name),
+	}
+	if _, ok := g.defs[name]; ok {
+		return ref, nil
+	}
+	def, err := g.resolve(t)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// Add a placeholder so that recursive types terminate.
+	g.defs[name] = nil
+	s, err := g.typeSchema(def)
+	if err != nil {
+		delete(g.defs, name)
+		return nil, errgo.Notef(err, "%s", name)
+	}
+	g.defs[name] = s
+	return ref, nil
+}
+
+// resolve returns the full definition of t, looking
+// it up in the type info if t is only a reference.
+func (g *schemaGen) resolve(t *jsontypes.Type) (*jsontypes.Type, error) {
+	if t.Kind != "" {
+		return t, nil
+	}
+	def := g.info.Types[t.Name]
+	if def == nil {
+		return nil, errgo.Newf("type %s not found", t.Name)
+	}
+	return def, nil
+}
+
+// typeSchema returns the schema for the given type definition.
+func (g *schemaGen) typeSchema(t *jsontypes.Type) (map[string]interface{}, error) {
+	t, err := g.resolve(t)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if _, ok := t.Methods["MarshalJSON"]; ok {
+		// We can't tell what a custom marshaler will produce,
+		// except for some well known types.
+		if fmt.Sprint(t.Name) == "time#Time" {
+			return map[string]interface{}{
+				"type":   "string",
+				"format": "date-time",
+			}, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+	switch t.Kind {
+	case "bool":
+		return map[string]interface{}{"type": "boolean"}, nil
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr":
+		return map[string]interface{}{"type": "integer"}, nil
+	case "float32", "float64":
+		return map[string]interface{}{"type": "number"}, nil
+	case "string":
+		return map[string]interface{}{"type": "string"}, nil
+	case "interface":
+		return map[string]interface{}{}, nil
+	case "ptr":
+		return g.schema(t.Elem)
+	case "slice", "array":
+		if t.Elem.Kind == "uint8" {
+			// Byte slices are encoded as base64 strings.
+			return map[string]interface{}{"type": "string"}, nil
+		}
+		items, err := g.schema(t.Elem)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": items,
+		}, nil
+	case "map":
+		elem, err := g.schema(t.Elem)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": elem,
+		}, nil
+	case "struct":
+		props := make(map[string]interface{})
+		var required []string
+		if err := g.addFields(props, &required, t); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s, nil
+	default:
+		return nil, errgo.Newf("unsupported kind %q", t.Kind)
+	}
+}
+
+// addFields adds the JSON properties of the struct type t to props,
+// adding the names of required properties to *required.
+// Fields of embedded structs without a JSON name are
+// promoted as encoding/json does.
+func (g *schemaGen) addFields(props map[string]interface{}, required *[]string, t *jsontypes.Type) error {
+	for _, f := range t.Fields {
+		name, omitEmpty := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind == "ptr" {
+				ft = ft.Elem
+			}
+			ft, err := g.resolve(ft)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			if ft.Kind == "struct" {
+				if err := g.addFields(props, required, ft); err != nil {
+					return errgo.Mask(err)
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if !isExported(f.Name) && !f.Anonymous {
+			continue
+		}
+		s, err := g.schema(f.Type)
+		if err != nil {
+			return errgo.Notef(err, "field %s", f.Name)
+		}
+		props[name] = s
+		if !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// jsonFieldName returns the name of the field as
+// specified in its json tag, and whether the
+// omitempty option was specified.
+func jsonFieldName(f jsontypes.Field) (name string, omitEmpty bool) {
+	tag := reflect.StructTag(f.Tag).Get("json")
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty
+}
+
+// refEscaper escapes a definition name for use in a $ref
+// URI fragment holding a JSON pointer (RFC 6901).
+var refEscaper = strings.NewReplacer(
+	"~", "~0",
+	"/", "~1",
+	"#", "%23",
+	"%", "%25",
+)
+
+func isExported(name string) bool {
+	return name != "" && strings.ToUpper(name[:1]) == name[:1]
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/rogpeppe/apicompat/jsontypes"
+)
+
+var testTypeInfo = &jsontypes.Info{
+	Types: map[jsontypes.Name]*jsontypes.Type{
+		"example.com/params#Entities": {
+			Name: "example.com/params#Entities",
+			Kind: "struct",
+			Fields: []jsontypes.Field{{
+				Name: "Entities",
+				Tag:  `json:"entities"`,
+				Type: &jsontypes.Type{
+					Kind: "slice",
+					Elem: &jsontypes.Type{Name: "example.com/params#Entity"},
+				},
+			}},
+		},
+		"example.com/params#Entity": {
+			Name: "example.com/params#Entity",
+			Kind: "struct",
+			Fields: []jsontypes.Field{{
+				Name: "Tag",
+				Tag:  `json:"tag"`,
+				Type: &jsontypes.Type{Name: "string", Kind: "string"},
+			}, {
+				Name:      "Base",
+				Anonymous: true,
+				Type:      &jsontypes.Type{Name: "example.com/params#Base"},
+			}, {
+				Name: "Parent",
+				Tag:  `json:"parent,omitempty"`,
+				Type: &jsontypes.Type{
+					Kind: "ptr",
+					Elem: &jsontypes.Type{Name: "example.com/params#Entity"},
+				},
+			}, {
+				Name: "Ignored",
+				Tag:  `json:"-"`,
+				Type: &jsontypes.Type{Name: "string", Kind: "string"},
+			}},
+		},
+		"example.com/params#Base": {
+			Name: "example.com/params#Base",
+			Kind: "struct",
+			Fields: []jsontypes.Field{{
+				Name: "Count",
+				Type: &jsontypes.Type{Name: "int", Kind: "int"},
+			}, {
+				Name: "Data",
+				Tag:  `json:"data,omitempty"`,
+				Type: &jsontypes.Type{
+					Kind: "map",
+					Key:  &jsontypes.Type{Name: "string", Kind: "string"},
+					Elem: &jsontypes.Type{Kind: "interface"},
+				},
+			}},
+		},
+	},
+}
+
+var jsonSchemaTests = []struct {
+	testName    string
+	typ         *jsontypes.Type
+	expect      string
+	expectError string
+}{{
+	testName: "predeclared",
+	typ:      &jsontypes.Type{Name: "bool", Kind: "bool"},
+	expect: `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "boolean"
+	}`,
+}, {
+	testName: "named-with-recursion-and-embedding",
+	typ:      &jsontypes.Type{Name: "example.com/params#Entities"},
+	expect: `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$ref": "#/definitions/example.com~1params%23Entities",
+		"definitions": {
+			"example.com/params#Entities": {
+				"type": "object",
+				"properties": {
+					"entities": {
+						"type": "array",
+						"items": {"$ref": "#/definitions/example.com~1params%23Entity"}
+					}
+				},
+				"required": ["entities"],
+				"additionalProperties": false
+			},
+			"example.com/params#Entity": {
+				"type": "object",
+				"properties": {
+					"tag": {"type": "string"},
+					"Count": {"type": "integer"},
+					"data": {
+						"type": "object",
+						"additionalProperties": {}
+					},
+					"parent": {"$ref": "#/definitions/example.com~1params%23Entity"}
+				},
+				"required": ["tag", "Count"],
+				"additionalProperties": false
+			}
+		}
+	}`,
+}, {
+	testName:    "undefined-type",
+	typ:         &jsontypes.Type{Name: "example.com/params#Missing"},
+	expectError: `type example.com/params#Missing not found`,
+}}
+
+func TestJSONSchema(t *testing.T) {
+	for _, test := range jsonSchemaTests {
+		t.Run(test.testName, func(t *testing.T) {
+			got, err := jsonSchema(testTypeInfo, test.typ)
+			if test.expectError != "" {
+				if err == nil || err.Error() != test.expectError {
+					t.Fatalf("got error %v; want %q", err, test.expectError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Round-trip through JSON so that we can compare
+			// against the expected JSON.
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotv, wantv interface{}
+			if err := json.Unmarshal(data, &gotv); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(test.expect), &wantv); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotv, wantv) {
+				t.Fatalf("unexpected schema; got\n%s", data)
+			}
+		})
+	}
+}
@@ -1,5 +1,10 @@
 // The newjujuplugin command generates a skeleton for a multi-command
 // juju plugin.
+//
+// By default, the plugin is created as a new Go module in a
+// directory named after the last element of the given package path,
+// inside the current directory. With the -gopath flag, the plugin is
+// created inside $GOPATH/src instead.
 package main
 
 import (
@@ -10,6 +15,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"text/template"
 	"time"
@@ -29,7 +36,16 @@ type templateOneCmdArg struct {
 	*templateArg
 }
 
-var force = flag.Bool("f", false, "force overwrite of existing source files")
+var (
+	force  = flag.Bool("f", false, "force overwrite of existing source files")
+	gopath = flag.Bool("gopath", false, "create the plugin inside $GOPATH/src rather than as a new module")
+	dryRun bool
+)
+
+func init() {
+	flag.BoolVar(&dryRun, "n", false, "print the files that would be written without writing them")
+	flag.BoolVar(&dryRun, "dry-run", false, "same as -n")
+}
 
 func main() {
 	flag.Usage = func() {
@@ -46,6 +62,9 @@ func main() {
 		fail("package name in wrong form")
 	}
 	commands := flag.Args()[1:]
+	if err := checkCommandNames(commands); err != nil {
+		fail("%v", err)
+	}
 	arg := &templateArg{
 		CmdPackage: flag.Arg(0),
 		Name:       cmdPackage[lastHyphen+1:],
@@ -58,11 +77,16 @@ func main() {
 			templateArg:    arg,
 		})
 	}
-	gopath := os.Getenv("GOPATH")
-	if i := strings.Index(gopath, ":"); i > 0 {
-		gopath = gopath[0:i]
+	var dir string
+	if *gopath {
+		dir = gopathDir(cmdPackage)
+	} else {
+		dir = filepath.Base(filepath.FromSlash(cmdPackage))
+		writeFile(goModArg{
+			ModulePath: cmdPackage,
+			GoVersion:  goVersion(),
+		}, dir, "go.mod", goModTemplate)
 	}
-	dir := filepath.Join(gopath, "src", filepath.FromSlash(cmdPackage))
 	writeFile(arg, dir, "main.go", mainTemplate)
 
 	cmdDir := filepath.Join(dir, arg.Name+"cmd")
@@ -74,7 +98,78 @@ func main() {
 		writeFile(c, cmdDir, c.CmdNameLiteral+".go", onecmdTemplate)
 		writeFile(c, cmdDir, c.CmdNameLiteral+"_test.go", onecmdtestTemplate)
 	}
-	fmt.Println(dir)
+	if !dryRun {
+		fmt.Println(dir)
+	}
+}
+
+// gopathDir returns the directory for the given
+// package inside the first element of $GOPATH.
+func gopathDir(pkg string) string {
+	gopath := os.Getenv("GOPATH")
+	if i := strings.Index(gopath, string(filepath.ListSeparator)); i > 0 {
+		gopath = gopath[0:i]
+	}
+	return filepath.Join(gopath, "src", filepath.FromSlash(pkg))
+}
+
+type goModArg struct {
+	ModulePath string
+	GoVersion  string
+}
+
+var goModTemplate = newTemplate(`
+module {{.ModulePath}}
+
+go {{.GoVersion}}
+`)
+
+var goVersionPat = regexp.MustCompile(`^go([0-9]+\.[0-9]+)`)
+
+// goVersion returns the Go language version to use
+// in the generated go.mod file.
+func goVersion() string {
+	if m := goVersionPat.FindStringSubmatch(runtime.Version()); m != nil {
+		return m[1]
+	}
+	// Development version.
+	return "1.16"
+}
+
+var commandNamePat = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// reservedCommandNames holds command names that would
+// generate files that clash with the other generated files.
+var reservedCommandNames = map[string]bool{
+	"cmd":     true,
+	"main":    true,
+	"package": true,
+}
+
+// checkCommandNames checks that the given command names
+// are valid and that they don't clash with one another.
+func checkCommandNames(names []string) error {
+	found := make(map[string]string)
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("empty command name")
+		}
+		if !commandNamePat.MatchString(name) {
+			return fmt.Errorf("invalid command name %q (must match %s)", name, commandNamePat)
+		}
+		if reservedCommandNames[name] {
+			return fmt.Errorf("command name %q clashes with generated file name", name)
+		}
+		ident := fromLiteral(name)
+		if prev, ok := found[ident]; ok {
+			if prev == name {
+				return fmt.Errorf("duplicate command name %q", name)
+			}
+			return fmt.Errorf("command names %q and %q are too similar", prev, name)
+		}
+		found[ident] = name
+	}
+	return nil
 }
 
 func fromLiteral(s string) string {
@@ -91,21 +186,38 @@ func newTemplate(s string) *template.Template {
 
 func writeFile(arg interface{}, dir, file string, template *template.Template) {
 	var buf bytes.Buffer
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		fail("%v", err)
-	}
 	if err := template.Execute(&buf, arg); err != nil {
 		fail("cannot execute template for %s: %v", file, err)
 	}
 	path := filepath.Join(dir, file)
-	data, err := format.Source(buf.Bytes())
-	if err != nil {
-		fail("invalid source generated for %s: %v", path, err)
+	data := buf.Bytes()
+	if strings.HasSuffix(file, ".go") {
+		var err error
+		data, err = format.Source(data)
+		if err != nil {
+			fail("invalid source generated for %s: %v", path, err)
+		}
+	}
+	_, err := os.Stat(path)
+	exists := err == nil
+	if dryRun {
+		switch {
+		case !exists:
+			fmt.Printf("create %s\n", path)
+		case *force:
+			fmt.Printf("overwrite %s\n", path)
+		default:
+			fmt.Printf("skip %s (already exists)\n", path)
+		}
+		return
 	}
-	if _, err := os.Stat(path); err == nil && !*force {
+	if exists && !*force {
 		fmt.Printf("not overwriting %s\n", path)
 		return
 	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		fail("%v", err)
+	}
 	if err := ioutil.WriteFile(path, data, 0777); err != nil {
 		fail("%v", err)
 	}
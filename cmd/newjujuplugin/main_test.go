@@ -0,0 +1,58 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var checkCommandNamesTests = []struct {
+	names       []string
+	expectError string
+}{{
+	names: nil,
+}, {
+	names: []string{"list-things", "add-thing2", "x"},
+}, {
+	names:       []string{"list-thing", "list-thing"},
+	expectError: `duplicate command name "list-thing"`,
+}, {
+	names:       []string{"list-thing", "list--thing"},
+	expectError: `command names "list-thing" and "list--thing" are too similar`,
+}, {
+	names:       []string{""},
+	expectError: `empty command name`,
+}, {
+	names:       []string{"ListThing"},
+	expectError: `invalid command name "ListThing" \(must match \^\[a-z\]\[a-z0-9-\]\*\$\)`,
+}, {
+	names:       []string{"2things"},
+	expectError: `invalid command name "2things" .*`,
+}, {
+	names:       []string{"list_thing"},
+	expectError: `invalid command name "list_thing" .*`,
+}, {
+	names:       []string{"cmd"},
+	expectError: `command name "cmd" clashes with generated file name`,
+}, {
+	names:       []string{"main"},
+	expectError: `command name "main" clashes with generated file name`,
+}}
+
+func TestCheckCommandNames(t *testing.T) {
+	for _, test := range checkCommandNamesTests {
+		err := checkCommandNames(test.names)
+		if test.expectError == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", test.names, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%q: expected error, got nil", test.names)
+			continue
+		}
+		if !regexp.MustCompile("^(" + test.expectError + ")$").MatchString(err.Error()) {
+			t.Errorf("%q: got error %q want %q", test.names, err, test.expectError)
+		}
+	}
+}
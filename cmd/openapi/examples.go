@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+)
+
+// addExamples adds an example body to each request and response media
+// type in every path operation that has a schema but no example. The
+// examples are synthesized from the schemas by exampleFor.
+func (spec *openAPISpec) addExamples() {
+	for _, methods := range spec.Paths {
+		for _, op := range methods {
+			op, ok := op.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if body, ok := op["requestBody"].(map[string]interface{}); ok {
+				spec.addContentExamples(body)
+			}
+			responses, _ := op["responses"].(map[string]interface{})
+			for _, resp := range responses {
+				if resp, ok := resp.(map[string]interface{}); ok {
+					spec.addContentExamples(resp)
+				}
+			}
+		}
+	}
+}
+
+// addContentExamples adds examples to all the media types
+// in the content field of the given request body or response.
+func (spec *openAPISpec) addContentExamples(obj map[string]interface{}) {
+	content, _ := obj["content"].(map[string]interface{})
+	for _, media := range content {
+		media, ok := media.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		schema, ok := media["schema"]
+		if !ok || media["example"] != nil || media["examples"] != nil {
+			continue
+		}
+		if example := spec.exampleFor(schema, make(map[string]bool)); example != nil {
+			media["example"] = example
+		}
+	}
+}
+
+// exampleFor returns an example value that conforms to the given
+// schema: strings are "string", numbers are 0, arrays have a single
+// element and objects have all their properties. Any example or enum
+// values in the schema are used in preference.
+//
+// The visiting map holds the schema references that are being expanded
+// by callers; references that are already being expanded are cyclic and
+// produce a nil example, as do references that can't be resolved.
+func (spec *openAPISpec) exampleFor(schema interface{}, visiting map[string]bool) interface{} {
+	obj, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ref, ok := obj["$ref"].(string); ok {
+		if visiting[ref] || !strings.HasPrefix(ref, "#/components/schemas/") {
+			return nil
+		}
+		refSchema, ok := spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+		if !ok {
+			return nil
+		}
+		visiting[ref] = true
+		defer delete(visiting, ref)
+		return spec.exampleFor(refSchema, visiting)
+	}
+	if example, ok := obj["example"]; ok {
+		return example
+	}
+	if enum, ok := obj["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf, ok := obj["allOf"].([]interface{}); ok {
+		// Merge the properties of all the object examples.
+		merged := make(map[string]interface{})
+		for _, sub := range allOf {
+			if sub, ok := spec.exampleFor(sub, visiting).(map[string]interface{}); ok {
+				for key, val := range sub {
+					merged[key] = val
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alts, ok := obj[key].([]interface{}); ok && len(alts) > 0 {
+			return spec.exampleFor(alts[0], visiting)
+		}
+	}
+	typ, _ := obj["type"].(string)
+	if typ == "" && obj["properties"] != nil {
+		typ = "object"
+	}
+	switch typ {
+	case "object":
+		example := make(map[string]interface{})
+		props, _ := obj["properties"].(map[string]interface{})
+		for name, prop := range props {
+			if val := spec.exampleFor(prop, visiting); val != nil {
+				example[name] = val
+			}
+		}
+		return example
+	case "array":
+		item := spec.exampleFor(obj["items"], visiting)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case "string":
+		return "string"
+	case "number", "integer":
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+)
+
+var addExamplesTests = []struct {
+	testName string
+	data     string
+	expect   string
+}{{
+	testName: "nested-objects-and-ref",
+	data: `schema Account {
+	"type": "object",
+	"properties": {
+		"id": {
+			"type": "integer"
+		},
+		"owner": {
+			"type": "object",
+			"properties": {
+				"name": {
+					"type": "string"
+				},
+				"admin": {
+					"type": "boolean"
+				}
+			}
+		},
+		"tags": {
+			"type": "array",
+			"items": {
+				"$ref": "#/components/schemas/Tag"
+			}
+		}
+	}
+}
+schema Tag {
+	"type": "string",
+	"enum": ["red", "green"]
+}
+path /accounts post {
+	"requestBody": {
+		"content": {
+			"application/json": {
+				"schema": {
+					"$ref": "#/components/schemas/Account"
+				}
+			}
+		}
+	},
+	"responses": {
+		"200": {
+			"content": {
+				"application/json": {
+					"schema": {
+						"type": "array",
+						"items": {
+							"$ref": "#/components/schemas/Account"
+						}
+					}
+				}
+			}
+		},
+		"400": {
+			"content": {
+				"text/plain": {
+					"schema": {
+						"type": "string"
+					},
+					"example": "bad request"
+				}
+			}
+		}
+	}
+}`,
+	expect: `
+requestBody:
+  content:
+    application/json:
+      example:
+        id: 0
+        owner:
+          admin: false
+          name: string
+        tags: [red]
+responses:
+  "200":
+    content:
+      application/json:
+        example:
+        - id: 0
+          owner:
+            admin: false
+            name: string
+          tags: [red]
+  "400":
+    content:
+      text/plain:
+        example: bad request
+`,
+}, {
+	testName: "cyclic-ref",
+	data: `schema Node {
+	"type": "object",
+	"properties": {
+		"name": {
+			"type": "string"
+		},
+		"children": {
+			"type": "array",
+			"items": {
+				"$ref": "#/components/schemas/Node"
+			}
+		}
+	}
+}
+path /tree get {
+	"responses": {
+		"200": {
+			"content": {
+				"application/json": {
+					"schema": {
+						"$ref": "#/components/schemas/Node"
+					}
+				}
+			}
+		}
+	}
+}`,
+	expect: `
+responses:
+  "200":
+    content:
+      application/json:
+        example:
+          name: string
+          children: []
+`,
+}}
+
+func TestAddExamples(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range addExamplesTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var spec openAPISpec
+			err := spec.parse("somefile", []byte(test.data))
+			c.Assert(err, qt.Equals, nil)
+			spec.addExamples()
+			var op map[string]interface{}
+			for _, methods := range spec.Paths {
+				for _, m := range methods {
+					op = m.(map[string]interface{})
+				}
+			}
+			// Check only the examples, ignoring the schemas.
+			got := examplesOnly(op)
+			var want interface{}
+			err = yaml.Unmarshal([]byte(test.expect), &want)
+			c.Assert(err, qt.Equals, nil)
+			gotData, err := yaml.Marshal(got)
+			c.Assert(err, qt.Equals, nil)
+			var gotVal interface{}
+			err = yaml.Unmarshal(gotData, &gotVal)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(gotVal, qt.DeepEquals, want)
+		})
+	}
+}
+
+// examplesOnly returns a copy of obj with all schema
+// fields removed.
+func examplesOnly(obj interface{}) interface{} {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return obj
+	}
+	r := make(map[string]interface{})
+	for key, val := range m {
+		if key == "schema" {
+			continue
+		}
+		r[key] = val
+		if key != "example" {
+			r[key] = examplesOnly(val)
+		}
+	}
+	return r
+}
@@ -10,10 +10,16 @@ import (
 	yaml "gopkg.in/yaml.v1"
 )
 
+var (
+	examplesFlag = flag.Bool("examples", false, "add example request and response bodies generated from their schemas")
+	strictFlag   = flag.Bool("strict", false, "treat validation warnings as errors")
+	baseFlag     = flag.String("base", "", "existing OpenAPI YAML document to merge the definitions into")
+)
+
 func main() {
 	log.SetFlags(0)
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: openapi file...\n")
+		fmt.Fprintf(os.Stderr, "usage: openapi [-examples] [-strict] [-base file.yaml] file...\n")
 		os.Exit(2)
 	}
 	flag.Parse()
@@ -22,6 +28,15 @@ func main() {
 	}
 	var spec openAPISpec
 	spec.Version = "3.0.0"
+	if *baseFlag != "" {
+		data, err := ioutil.ReadFile(*baseFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := spec.parseBase(*baseFlag, data); err != nil {
+			log.Fatal(err)
+		}
+	}
 	for _, filename := range flag.Args() {
 		data, err := ioutil.ReadFile(filename)
 		if err != nil {
@@ -31,6 +46,27 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	if errs := spec.checkRefs(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}
+	if errs := spec.checkResponses(); len(errs) > 0 {
+		for _, err := range errs {
+			if *strictFlag {
+				log.Print(err)
+			} else {
+				log.Printf("warning: %v", err)
+			}
+		}
+		if *strictFlag {
+			os.Exit(1)
+		}
+	}
+	if *examplesFlag {
+		spec.addExamples()
+	}
 	data, err := yaml.Marshal(spec)
 	if err != nil {
 		log.Fatal(err)
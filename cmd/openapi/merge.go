@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	errgo "gopkg.in/errgo.v1"
+	yaml "gopkg.in/yaml.v1"
+)
+
+// parseBase adds the definitions from an existing OpenAPI document in
+// YAML format, such as one previously generated by this command, to
+// the spec. Only the version, info, paths and components are
+// retained. As with the DSL, a definition that conflicts with one
+// already in the spec is an error.
+func (spec *openAPISpec) parseBase(filename string, data []byte) error {
+	var base openAPISpec
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return errgo.Notef(err, "cannot parse %s", filename)
+	}
+	if base.Version != "" {
+		spec.Version = base.Version
+	}
+	add := func(k kind, args []string, obj interface{}) error {
+		v, err := jsonValue(obj)
+		if err != nil {
+			return errgo.Notef(err, "%s: %v %v", filename, k, args)
+		}
+		if err := spec.add(filename, k, args, v); err != nil {
+			return errgo.Notef(err, "%s", filename)
+		}
+		return nil
+	}
+	if base.Info != nil {
+		if err := add(kindInfo, nil, base.Info); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range sortedKeys(base.Components.Schemas) {
+		if err := add(kindSchema, []string{name}, base.Components.Schemas[name]); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range sortedKeys(base.Components.Parameters) {
+		if err := add(kindParameter, []string{name}, base.Components.Parameters[name]); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range sortedKeys(base.Components.Responses) {
+		if err := add(kindResponse, []string{name}, base.Components.Responses[name]); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, name := range sortedKeys(base.Components.SecuritySchemes) {
+		if err := add(kindSecurity, []string{name}, base.Components.SecuritySchemes[name]); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, path := range base.sortedPaths() {
+		for _, method := range sortedKeys(base.Paths[path]) {
+			if err := add(kindPath, []string{path, method}, base.Paths[path][method]); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	}
+	return nil
+}
+
+// jsonValue converts a value as decoded by yaml.Unmarshal
+// into the equivalent value as decoded by rjson.Unmarshal,
+// so that values from both sources can be compared.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, elem := range v {
+			skey, ok := key.(string)
+			if !ok {
+				return nil, errgo.Newf("non-string key %#v", key)
+			}
+			elem, err := jsonValue(elem)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			m[skey] = elem
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for key, elem := range v {
+			elem, err := jsonValue(elem)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			m[key] = elem
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, elem := range v {
+			elem, err := jsonValue(elem)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			a[i] = elem
+		}
+		return a, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float64, string, bool, nil:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected value of type %T", v)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	yaml "gopkg.in/yaml.v1"
+)
+
+var mergeTests = []struct {
+	testName    string
+	base        string
+	files       []string
+	expect      string
+	expectError string
+}{{
+	testName: "conflicting-paths-across-files",
+	files: []string{`path /x get {
+	"summary": "Get x"
+}`, `path /x put {
+	"summary": "Put x"
+}
+path /x get {
+	"summary": "Get something else"
+}`},
+	expectError: `file1:4:1: redefinition of get method for path "/x" \(previous definition at file0:1:1\)`,
+}, {
+	testName: "conflicting-schemas-across-files",
+	files: []string{`schema Foo {
+	"type": "object"
+}`, `schema Foo {
+	"type": "string"
+}`},
+	expectError: `file1:1:1: schema Foo redefined \(previous definition at file0:1:1\)`,
+}, {
+	testName: "identical-definitions-across-files",
+	files: []string{`schema Foo {
+	"type": "object"
+}`, `schema Foo {
+	"type": "object"
+}`},
+	expect: `
+openapi: 3.0.0
+components:
+  schemas:
+    Foo:
+      type: object
+`,
+}, {
+	testName: "base-merge",
+	base: `
+openapi: 3.0.1
+info:
+  title: Base
+paths:
+  /x:
+    get:
+      summary: Get x
+      responses:
+        "200":
+          description: OK
+components:
+  schemas:
+    Foo:
+      type: object
+      maxProperties: 3
+`,
+	files: []string{`path /x put {
+	"summary": "Put x"
+}
+schema Foo {
+	"type": "object",
+	"maxProperties": 3
+}
+schema Bar {
+	"type": "string"
+}`},
+	expect: `
+openapi: 3.0.1
+info:
+  title: Base
+paths:
+  /x:
+    get:
+      summary: Get x
+      responses:
+        "200":
+          description: OK
+    put:
+      summary: Put x
+components:
+  schemas:
+    Foo:
+      type: object
+      maxProperties: 3
+    Bar:
+      type: string
+`,
+}, {
+	testName: "base-conflict",
+	base: `
+paths:
+  /x:
+    get:
+      summary: Get x
+`,
+	files: []string{`path /x get {
+	"summary": "Get y"
+}`},
+	expectError: `file0:1:1: redefinition of get method for path "/x" \(previous definition at base.yaml\)`,
+}, {
+	testName: "base-info-conflict",
+	base: `
+info:
+  title: Base
+`,
+	files: []string{`info {
+	"title": "Other"
+}`},
+	expectError: `file0:1:1: info redefined \(previous definition at base.yaml\)`,
+}, {
+	testName: "bad-base-method",
+	base: `
+paths:
+  /x:
+    frobnicate: {}
+`,
+	expectError: `base.yaml: unknown method "frobnicate" for path "/x"`,
+}}
+
+func TestMerge(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range mergeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var spec openAPISpec
+			spec.Version = "3.0.0"
+			err := spec.parseBase("base.yaml", []byte(test.base))
+			for i, data := range test.files {
+				if err != nil {
+					break
+				}
+				err = spec.parse(fmt.Sprintf("file%d", i), []byte(data))
+			}
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			var want interface{}
+			err = yaml.Unmarshal([]byte(test.expect), &want)
+			c.Assert(err, qt.Equals, nil)
+			var got interface{}
+			gotData, err := yaml.Marshal(spec)
+			c.Assert(err, qt.Equals, nil)
+			err = yaml.Unmarshal(gotData, &got)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(got, qt.DeepEquals, want)
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"unicode"
 
@@ -14,6 +17,8 @@ import (
 
 type openAPIComponents struct {
 	Schemas         map[string]interface{} `yaml:"schemas,omitempty"`
+	Responses       map[string]interface{} `yaml:"responses,omitempty"`
+	Parameters      map[string]interface{} `yaml:"parameters,omitempty"`
 	SecuritySchemes map[string]interface{} `yaml:"securitySchemes,omitempty"`
 }
 
@@ -22,9 +27,21 @@ type openAPISpec struct {
 	Info       interface{}                       `yaml:"info,omitempty"`
 	Paths      map[string]map[string]interface{} `yaml:"paths,omitempty"`
 	Components openAPIComponents                 `yaml:"components"`
+
+	// definedAt maps from a description of each definition
+	// added to the spec (for example "schema Foo") to
+	// the place it was defined.
+	definedAt map[string]string
 }
 
 func (spec *openAPISpec) parse(filename string, data []byte) error {
+	return spec.parseIncluded(filename, data, nil)
+}
+
+// parseIncluded is like parse except that it is also given
+// the names of all the files that have included this one,
+// outermost first, so that include cycles can be detected.
+func (spec *openAPISpec) parseIncluded(filename string, data []byte, including []string) error {
 	r := &reader{
 		filename: filename,
 		buf:      data,
@@ -41,6 +58,12 @@ func (spec *openAPISpec) parse(filename string, data []byte) error {
 		if tok != tokenIdent {
 			return errgo.Newf("%s: unexpected token type %v", r.offsetToPos(r.tokenStart), tok)
 		}
+		if r.token == "@include" {
+			if err := spec.include(r, append(including, filename)); err != nil {
+				return errgo.Mask(err)
+			}
+			continue
+		}
 		k, ok := kinds[r.token]
 		if !ok {
 			return errgo.Newf("%s: unknown token %q", r.offsetToPos(r.tokenStart), r.token)
@@ -52,7 +75,7 @@ func (spec *openAPISpec) parse(filename string, data []byte) error {
 			tok, err := r.readToken()
 			if err != nil {
 				if errgo.Cause(err) == io.EOF {
-					return nil
+					return errgo.Newf("%s: unexpected EOF in %s definition", r.offsetToPos(lineStart), k)
 				}
 				return errgo.Mask(err)
 			}
@@ -62,90 +85,191 @@ func (spec *openAPISpec) parse(filename string, data []byte) error {
 			}
 			args = append(args, r.token)
 		}
-		if err := spec.add(k, args, obj); err != nil {
+		if err := spec.add(r.offsetToPos(lineStart), k, args, obj); err != nil {
 			return errgo.Notef(err, "%s", r.offsetToPos(lineStart))
 		}
 	}
 }
 
-func (spec *openAPISpec) add(k kind, args []string, obj interface{}) error {
+// include reads the path argument of an @include directive from r
+// and parses the file it refers to, resolved relative to the
+// directory of the including file.
+func (spec *openAPISpec) include(r *reader, including []string) error {
+	pos := r.offsetToPos(r.tokenStart)
+	tok, err := r.readToken()
+	if err != nil {
+		if errgo.Cause(err) == io.EOF {
+			return errgo.Newf("%s: unexpected EOF in @include directive", pos)
+		}
+		return errgo.Mask(err)
+	}
+	if tok != tokenIdent {
+		return errgo.Newf("%s: @include directive needs a file path", pos)
+	}
+	path := r.token
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(r.filename), path)
+	}
+	for i, f := range including {
+		if sameFile(f, path) {
+			cycle := append(including[i:], path)
+			return errgo.Newf("%s: include cycle: %s", pos, strings.Join(cycle, " -> "))
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errgo.Newf("%s: cannot read included file: %v", pos, err)
+	}
+	return spec.parseIncluded(path, data, including)
+}
+
+// sameFile reports whether the paths f1 and f2 refer to the same file.
+func sameFile(f1, f2 string) bool {
+	abs1, err1 := filepath.Abs(f1)
+	abs2, err2 := filepath.Abs(f2)
+	if err1 != nil || err2 != nil {
+		return filepath.Clean(f1) == filepath.Clean(f2)
+	}
+	return abs1 == abs2
+}
+
+// add adds the definition of the given kind to the spec. The pos
+// argument records where the definition came from so that any
+// conflicting redefinition can refer to it. Redefining something
+// with an identical value is allowed, so that the same definition
+// can safely be merged from several sources.
+func (spec *openAPISpec) add(pos string, k kind, args []string, obj interface{}) error {
 	if len(args) != argCount[k] {
 		return errgo.Newf("unexpected arg count for %v; got %d want %d", k, len(args), argCount[k])
 	}
+	var old interface{}
+	var redefined string
 	switch k {
 	case kindSchema:
 		name := args[0]
-		if spec.Components.Schemas[name] != nil {
-			return errgo.Newf("schema %s redefined", name)
+		old = spec.Components.Schemas[name]
+		redefined = fmt.Sprintf("schema %s redefined", name)
+	case kindSecurity:
+		name := args[0]
+		old = spec.Components.SecuritySchemes[name]
+		redefined = fmt.Sprintf("security scheme %s redefined", name)
+	case kindParameter:
+		name := args[0]
+		old = spec.Components.Parameters[name]
+		redefined = fmt.Sprintf("parameter %s redefined", name)
+	case kindResponse:
+		name := args[0]
+		old = spec.Components.Responses[name]
+		redefined = fmt.Sprintf("response %s redefined", name)
+	case kindPath:
+		path, method := args[0], args[1]
+		if !allowedMethods[method] {
+			return errgo.Newf("unknown method %q for path %q", args[1], args[0])
+		}
+		old = spec.Paths[path][method]
+		redefined = fmt.Sprintf("redefinition of %s method for path %q", method, path)
+	case kindInfo:
+		old = spec.Info
+		redefined = "info redefined"
+	default:
+		return errgo.Newf("unknown kind %v", k)
+	}
+	key := k.String() + " " + strings.Join(args, " ")
+	if old != nil {
+		if reflect.DeepEqual(old, obj) {
+			return nil
 		}
+		if prev := spec.definedAt[key]; prev != "" {
+			return errgo.Newf("%s (previous definition at %s)", redefined, prev)
+		}
+		return errgo.New(redefined)
+	}
+	switch k {
+	case kindSchema:
 		if spec.Components.Schemas == nil {
 			spec.Components.Schemas = make(map[string]interface{})
 		}
-		spec.Components.Schemas[name] = obj
+		spec.Components.Schemas[args[0]] = obj
 	case kindSecurity:
-		name := args[0]
-		if spec.Components.SecuritySchemes[name] != nil {
-			return errgo.Newf("security scheme %s redefined", name)
-		}
 		if spec.Components.SecuritySchemes == nil {
 			spec.Components.SecuritySchemes = make(map[string]interface{})
 		}
-		spec.Components.SecuritySchemes[name] = obj
-	case kindPath:
-		path, method := args[0], args[1]
-		if !allowedMethods[method] {
-			return errgo.Newf("unknown method %q for path %q", args[1], args[0])
+		spec.Components.SecuritySchemes[args[0]] = obj
+	case kindParameter:
+		if spec.Components.Parameters == nil {
+			spec.Components.Parameters = make(map[string]interface{})
 		}
+		spec.Components.Parameters[args[0]] = obj
+	case kindResponse:
+		if spec.Components.Responses == nil {
+			spec.Components.Responses = make(map[string]interface{})
+		}
+		spec.Components.Responses[args[0]] = obj
+	case kindPath:
 		if spec.Paths == nil {
 			spec.Paths = make(map[string]map[string]interface{})
 		}
-		if spec.Paths[path][method] != nil {
-			return errgo.Newf("redefinition of %s method for path %q", method, path)
+		if spec.Paths[args[0]] == nil {
+			spec.Paths[args[0]] = make(map[string]interface{})
 		}
-		if spec.Paths[path] == nil {
-			spec.Paths[path] = make(map[string]interface{})
-		}
-		spec.Paths[path][method] = obj
+		spec.Paths[args[0]][args[1]] = obj
 	case kindInfo:
-		if spec.Info != nil {
-			return errgo.Newf("info redefined")
-		}
 		spec.Info = obj
-	default:
-		return errgo.Newf("unknown kind %v", k)
 	}
+	if spec.definedAt == nil {
+		spec.definedAt = make(map[string]string)
+	}
+	spec.definedAt[key] = pos
 	return nil
 }
 
 var allowedMethods = map[string]bool{
-	"get":    true,
-	"post":   true,
-	"delete": true,
-	"head":   true,
+	"get":     true,
+	"put":     true,
+	"post":    true,
+	"patch":   true,
+	"delete":  true,
+	"head":    true,
+	"options": true,
 }
 
 type kind int
 
+func (k kind) String() string {
+	for name, k1 := range kinds {
+		if k1 == k {
+			return name
+		}
+	}
+	return fmt.Sprintf("kind%d", int(k))
+}
+
 const (
 	_ kind = iota
 	kindSchema
 	kindSecurity
 	kindPath
 	kindInfo
+	kindParameter
+	kindResponse
 )
 
 var kinds = map[string]kind{
-	"info":     kindInfo,
-	"schema":   kindSchema,
-	"security": kindSecurity,
-	"path":     kindPath,
+	"info":      kindInfo,
+	"schema":    kindSchema,
+	"security":  kindSecurity,
+	"path":      kindPath,
+	"parameter": kindParameter,
+	"response":  kindResponse,
 }
 
 var argCount = map[kind]int{
-	kindSchema:   1,
-	kindSecurity: 1,
-	kindPath:     2,
-	kindInfo:     0,
+	kindSchema:    1,
+	kindSecurity:  1,
+	kindPath:      2,
+	kindInfo:      0,
+	kindParameter: 1,
+	kindResponse:  1,
 }
 
 type token int
@@ -189,6 +313,11 @@ func (r *reader) readToken() (token, error) {
 	r.tokenStart = r.offset()
 	for {
 		c, _, err := r.r.ReadRune()
+		if err == io.EOF && r.builder.Len() > 0 {
+			// The token is terminated by the end of the file.
+			r.token = r.builder.String()
+			return tokenIdent, nil
+		}
 		if err != nil {
 			return 0, err
 		}
@@ -216,7 +345,7 @@ func (r *reader) readObject() (token, error) {
 		c, _, err := r.r.ReadRune()
 		if err != nil {
 			if err == io.EOF {
-				err = io.ErrUnexpectedEOF
+				return 0, errgo.Newf("%s: unterminated object (no closing brace at start of line)", r.offsetToPos(startOffset))
 			}
 			return 0, errgo.Mask(err)
 		}
@@ -255,19 +384,14 @@ func (r *reader) offset() int {
 	return len(r.buf) - r.r.Len()
 }
 
+// offsetToPos returns the position of the given byte offset
+// in the form filename:line:column, where both line and
+// column are 1-based.
 func (r *reader) offsetToPos(off int) string {
-	line := 1
-	start := 0
-	for i, b := range r.buf {
-		if b != '\n' {
-			continue
-		}
-		if i >= off {
-			lineOff := off - start
-			return fmt.Sprintf("%s:%d:%d", r.filename, line, lineOff)
-		}
-		line++
-		start = i
+	if off > len(r.buf) {
+		off = len(r.buf)
 	}
-	return fmt.Sprintf("%s:%d", r.filename, line)
+	line := 1 + bytes.Count(r.buf[:off], []byte("\n"))
+	col := off - (bytes.LastIndexByte(r.buf[:off], '\n') + 1) + 1
+	return fmt.Sprintf("%s:%d:%d", r.filename, line, col)
 }
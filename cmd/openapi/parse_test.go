@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -36,6 +39,152 @@ components:
         contractInfo:
           $ref: "#/components/schemas/ContractInfo"
 `,
+}, {
+	testName: "put-method",
+	data: `path /x put {
+	"summary": "Replace x",
+	"responses": {
+		"200": {
+			"description": "OK"
+		}
+	}
+}`,
+	expect: `
+components: {}
+paths:
+  /x:
+    put:
+      summary: Replace x
+      responses:
+        "200":
+          description: OK
+`,
+}, {
+	testName: "patch-and-options-methods",
+	data: `path /x patch {
+	"summary": "Update x"
+}
+path /x options {
+	"summary": "Options for x"
+}`,
+	expect: `
+components: {}
+paths:
+  /x:
+    patch:
+      summary: Update x
+    options:
+      summary: Options for x
+`,
+}, {
+	testName: "unknown-method",
+	data: `path /x frobnicate {
+	"summary": "Frob x"
+}`,
+	expectError: `somefile:1:1: unknown method "frobnicate" for path "/x"`,
+}, {
+	testName: "redefined-schema",
+	data: `schema Foo {
+	"type": "object"
+}
+schema Foo {
+	"type": "string"
+}`,
+	expectError: `somefile:4:1: schema Foo redefined \(previous definition at somefile:1:1\)`,
+}, {
+	testName: "identical-redefinition",
+	data: `schema Foo {
+	"type": "object"
+}
+schema Foo {
+	"type": "object"
+}`,
+	expect: `
+components:
+  schemas:
+    Foo:
+      type: object
+`,
+}, {
+	testName: "shared-parameter-and-response",
+	data: `parameter Limit {
+	"name": "limit",
+	"in": "query",
+	"schema": {
+		"type": "integer"
+	}
+}
+response NotFound {
+	"description": "Not found"
+}
+path /x get {
+	"parameters": [{
+		"$ref": "#/components/parameters/Limit"
+	}],
+	"responses": {
+		"404": {
+			"$ref": "#/components/responses/NotFound"
+		}
+	}
+}`,
+	expect: `
+components:
+  parameters:
+    Limit:
+      name: limit
+      in: query
+      schema:
+        type: integer
+  responses:
+    NotFound:
+      description: Not found
+paths:
+  /x:
+    get:
+      parameters:
+      - $ref: "#/components/parameters/Limit"
+      responses:
+        "404":
+          $ref: "#/components/responses/NotFound"
+`,
+}, {
+	testName: "redefined-parameter",
+	data: `parameter Limit {
+	"name": "limit",
+	"in": "query"
+}
+parameter Limit {
+	"name": "limit",
+	"in": "header"
+}`,
+	expectError: `somefile:5:1: parameter Limit redefined \(previous definition at somefile:1:1\)`,
+}, {
+	testName: "redefined-response",
+	data: `response NotFound {
+	"description": "Not found"
+}
+response NotFound {
+	"description": "Gone"
+}`,
+	expectError: `somefile:4:1: response NotFound redefined \(previous definition at somefile:1:1\)`,
+}, {
+	testName: "missing-object",
+	data: `schema Foo {
+	"type": "object"
+}
+schema Bar`,
+	expectError: `somefile:4:1: unexpected EOF in schema definition`,
+}, {
+	testName: "unterminated-object",
+	data: `info {
+	"title": "x"`,
+	expectError: `somefile:1:6: unterminated object \(no closing brace at start of line\)`,
+}, {
+	testName: "rjson-syntax-error",
+	data: `info {
+	"title" "x"
+}`,
+	expectError: `somefile:2:11: invalid character '"' after object key`,
 }}
 
 func TestParse(t *testing.T) {
@@ -62,3 +211,99 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+var includeTests = []struct {
+	testName    string
+	files       map[string]string
+	expect      string
+	expectError string
+}{{
+	testName: "include-relative-to-including-file",
+	files: map[string]string{
+		"main": `@include schemas/foo
+path /x get {
+	"summary": "Get x"
+}`,
+		"schemas/foo": `schema Foo {
+	"type": "object"
+}
+@include bar`,
+		"schemas/bar": `schema Bar {
+	"type": "string"
+}`,
+	},
+	expect: `
+components:
+  schemas:
+    Foo:
+      type: object
+    Bar:
+      type: string
+paths:
+  /x:
+    get:
+      summary: Get x
+`,
+}, {
+	testName: "include-cycle",
+	files: map[string]string{
+		"main": `@include a`,
+		"a":    `@include b`,
+		"b":    `@include a`,
+	},
+	expectError: `.*b:1:1: include cycle: .*a -> .*b -> .*a`,
+}, {
+	testName: "include-self",
+	files: map[string]string{
+		"main": `@include main`,
+	},
+	expectError: `.*main:1:1: include cycle: .*main -> .*main`,
+}, {
+	testName: "include-missing-file",
+	files: map[string]string{
+		"main": `
+@include nothere`,
+	},
+	expectError: `.*main:2:1: cannot read included file: .*`,
+}, {
+	testName: "include-without-path",
+	files: map[string]string{
+		"main": `@include`,
+	},
+	expectError: `.*main:1:1: unexpected EOF in @include directive`,
+}}
+
+func TestInclude(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range includeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			dir, err := ioutil.TempDir("", "")
+			c.Assert(err, qt.Equals, nil)
+			defer os.RemoveAll(dir)
+			for name, data := range test.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				err := os.MkdirAll(filepath.Dir(path), 0777)
+				c.Assert(err, qt.Equals, nil)
+				err = ioutil.WriteFile(path, []byte(data), 0666)
+				c.Assert(err, qt.Equals, nil)
+			}
+			var spec openAPISpec
+			mainFile := filepath.Join(dir, "main")
+			err = spec.parse(mainFile, []byte(test.files["main"]))
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			var want interface{}
+			err = yaml.Unmarshal([]byte(test.expect), &want)
+			c.Assert(err, qt.Equals, nil)
+			var got interface{}
+			gotData, err := yaml.Marshal(spec)
+			c.Assert(err, qt.Equals, nil)
+			err = yaml.Unmarshal([]byte(gotData), &got)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(got, qt.DeepEquals, want)
+		})
+	}
+}
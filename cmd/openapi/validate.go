@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	errgo "gopkg.in/errgo.v1"
+)
+
+// checkRefs checks that every local $ref in the spec points to a
+// defined schema, parameter, response or security scheme. It
+// returns an error for each dangling reference found.
+func (spec *openAPISpec) checkRefs() []error {
+	var errs []error
+	check := func(where string, obj interface{}) {
+		walkRefs(obj, "", func(path, ref string) {
+			if err := spec.checkRef(ref); err != nil {
+				loc := where
+				if path != "" {
+					loc += " at " + path
+				}
+				errs = append(errs, errgo.Notef(err, "%s", loc))
+			}
+		})
+	}
+	for _, name := range sortedKeys(spec.Components.Schemas) {
+		check("schema "+name, spec.Components.Schemas[name])
+	}
+	for _, name := range sortedKeys(spec.Components.Parameters) {
+		check("parameter "+name, spec.Components.Parameters[name])
+	}
+	for _, name := range sortedKeys(spec.Components.Responses) {
+		check("response "+name, spec.Components.Responses[name])
+	}
+	for _, name := range sortedKeys(spec.Components.SecuritySchemes) {
+		check("security scheme "+name, spec.Components.SecuritySchemes[name])
+	}
+	for _, path := range spec.sortedPaths() {
+		for _, method := range sortedKeys(spec.Paths[path]) {
+			check(fmt.Sprintf("path %s %s", path, method), spec.Paths[path][method])
+		}
+	}
+	return errs
+}
+
+// checkResponses checks that every path operation declares at least
+// one response, as required by OpenAPI. It returns an error for each
+// operation that does not.
+func (spec *openAPISpec) checkResponses() []error {
+	var errs []error
+	for _, path := range spec.sortedPaths() {
+		for _, method := range sortedKeys(spec.Paths[path]) {
+			op, _ := spec.Paths[path][method].(map[string]interface{})
+			if responses, _ := op["responses"].(map[string]interface{}); len(responses) == 0 {
+				errs = append(errs, errgo.Newf("path %s %s: no responses declared", path, method))
+			}
+		}
+	}
+	return errs
+}
+
+// sortedPaths returns all the paths in the spec in sorted order.
+func (spec *openAPISpec) sortedPaths() []string {
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// checkRef checks that the given $ref value refers to something
+// defined in the spec. References to other documents are not checked.
+func (spec *openAPISpec) checkRef(ref string) error {
+	if !strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	var defined map[string]interface{}
+	name := ""
+	switch {
+	case strings.HasPrefix(ref, "#/components/schemas/"):
+		defined = spec.Components.Schemas
+		name = strings.TrimPrefix(ref, "#/components/schemas/")
+	case strings.HasPrefix(ref, "#/components/parameters/"):
+		defined = spec.Components.Parameters
+		name = strings.TrimPrefix(ref, "#/components/parameters/")
+	case strings.HasPrefix(ref, "#/components/responses/"):
+		defined = spec.Components.Responses
+		name = strings.TrimPrefix(ref, "#/components/responses/")
+	case strings.HasPrefix(ref, "#/components/securitySchemes/"):
+		defined = spec.Components.SecuritySchemes
+		name = strings.TrimPrefix(ref, "#/components/securitySchemes/")
+	default:
+		return errgo.Newf("unsupported reference %q", ref)
+	}
+	if _, ok := defined[name]; !ok {
+		return errgo.Newf("reference to undefined %q", ref)
+	}
+	return nil
+}
+
+// walkRefs calls f for each $ref found in obj, which is
+// a value as decoded by rjson.Unmarshal. The path argument
+// to f holds the dot-separated location of the object
+// containing the $ref, relative to obj.
+func walkRefs(obj interface{}, path string, f func(path, ref string)) {
+	switch obj := obj.(type) {
+	case map[string]interface{}:
+		if ref, ok := obj["$ref"].(string); ok {
+			f(path, ref)
+		}
+		for _, key := range sortedKeys(obj) {
+			walkRefs(obj[key], joinPath(path, key), f)
+		}
+	case []interface{}:
+		for i, elem := range obj {
+			walkRefs(elem, joinPath(path, fmt.Sprint(i)), f)
+		}
+	}
+}
+
+func joinPath(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "." + elem
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
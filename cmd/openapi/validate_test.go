@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var checkRefsTests = []struct {
+	testName     string
+	data         string
+	expectErrors []string
+}{{
+	testName: "all-defined",
+	data: `schema Foo {
+	"properties": {
+		"bar": {
+			"$ref": "#/components/schemas/Bar"
+		}
+	}
+}
+schema Bar {
+	"type": "string"
+}
+security auth {
+	"type": "http"
+}
+parameter Limit {
+	"name": "limit",
+	"in": "query",
+	"schema": {
+		"$ref": "#/components/schemas/Bar"
+	}
+}
+response NotFound {
+	"description": "Not found"
+}
+path /x get {
+	"parameters": [{
+		"$ref": "#/components/parameters/Limit"
+	}],
+	"responses": {
+		"404": {
+			"$ref": "#/components/responses/NotFound"
+		},
+		"200": {
+			"content": {
+				"application/json": {
+					"schema": {
+						"$ref": "#/components/schemas/Foo"
+					}
+				}
+			}
+		}
+	}
+}`,
+}, {
+	testName: "dangling",
+	data: `schema Foo {
+	"properties": {
+		"bar": {
+			"$ref": "#/components/schemas/Bar"
+		},
+		"baz": {
+			"items": [{
+				"$ref": "#/components/schemas/Baz"
+			}]
+		}
+	}
+}
+path /x get {
+	"$ref": "#/components/securitySchemes/auth"
+}
+path /x post {
+	"$ref": "#/components/examples/Bad"
+}
+path /x put {
+	"parameters": [{
+		"$ref": "#/components/parameters/Offset"
+	}],
+	"responses": {
+		"404": {
+			"$ref": "#/components/responses/NotFound"
+		}
+	}
+}
+path /x delete {
+	"$ref": "other.yaml#/components/schemas/Foo"
+}`,
+	expectErrors: []string{
+		`schema Foo at properties.bar: reference to undefined "#/components/schemas/Bar"`,
+		`schema Foo at properties.baz.items.0: reference to undefined "#/components/schemas/Baz"`,
+		`path /x get: reference to undefined "#/components/securitySchemes/auth"`,
+		`path /x post: unsupported reference "#/components/examples/Bad"`,
+		`path /x put at parameters.0: reference to undefined "#/components/parameters/Offset"`,
+		`path /x put at responses.404: reference to undefined "#/components/responses/NotFound"`,
+	},
+}}
+
+func TestCheckRefs(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range checkRefsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var spec openAPISpec
+			err := spec.parse("somefile", []byte(test.data))
+			c.Assert(err, qt.Equals, nil)
+			var errStrs []string
+			for _, err := range spec.checkRefs() {
+				errStrs = append(errStrs, err.Error())
+			}
+			c.Assert(errStrs, qt.DeepEquals, test.expectErrors)
+		})
+	}
+}
+
+var checkResponsesTests = []struct {
+	testName     string
+	data         string
+	expectErrors []string
+}{{
+	testName: "all-have-responses",
+	data: `path /x get {
+	"responses": {
+		"200": {
+			"description": "OK"
+		}
+	}
+}`,
+}, {
+	testName: "missing-responses",
+	data: `path /x get {
+	"summary": "Get x"
+}
+path /x put {
+	"responses": {}
+}
+path /x post {
+	"responses": {
+		"204": {
+			"description": "No content"
+		}
+	}
+}
+path /a delete {
+	"summary": "Delete a"
+}`,
+	expectErrors: []string{
+		`path /a delete: no responses declared`,
+		`path /x get: no responses declared`,
+		`path /x put: no responses declared`,
+	},
+}}
+
+func TestCheckResponses(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range checkResponsesTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var spec openAPISpec
+			err := spec.parse("somefile", []byte(test.data))
+			c.Assert(err, qt.Equals, nil)
+			var errStrs []string
+			for _, err := range spec.checkResponses() {
+				errStrs = append(errStrs, err.Error())
+			}
+			c.Assert(errStrs, qt.DeepEquals, test.expectErrors)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var timestampTests = []struct {
+	events string
+	yaml   string
+}{{
+	events: `=VAL :2001-12-15T02:59:43.1Z`,
+	yaml:   `2001-12-15T02:59:43.1Z`,
+}, {
+	events: `=VAL :2002-12-14`,
+	yaml:   `2002-12-14`,
+}, {
+	events: `=VAL :2001-12-14 21:59:43.10`,
+	yaml:   `2001-12-14 21:59:43.10`,
+}, {
+	events: `=VAL <tag:yaml.org,2002:timestamp> :2001-12-15T02:59:43.1Z`,
+	yaml:   `!!timestamp 2001-12-15T02:59:43.1Z`,
+}, {
+	events: `=VAL <tag:yaml.org,2002:timestamp> :2002-12-14`,
+	yaml:   `!!timestamp 2002-12-14`,
+}, {
+	events: `=VAL <tag:yaml.org,2002:timestamp> :2001-12-14 21:59:43.10`,
+	yaml:   `!!timestamp 2001-12-14 21:59:43.10`,
+}, {
+	events: `=VAL :2001-12-14x`,
+	yaml:   `2001-12-14x`,
+}, {
+	events: `=VAL :20011-12-14`,
+	yaml:   `20011-12-14`,
+}}
+
+func TestTimestamps(t *testing.T) {
+	for _, test := range timestampTests {
+		events := "+STR\n+DOC\n" + test.events + "\n-DOC\n-STR\n"
+		v, err := valueFromEvents(strings.NewReader(events))
+		if err != nil {
+			t.Errorf("%q: cannot make value: %v", test.events, err)
+			continue
+		}
+		var yv interface{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &yv); err != nil {
+			t.Errorf("%q: cannot unmarshal: %v", test.yaml, err)
+			continue
+		}
+		if diff := cmp.Diff(v, []interface{}{yv}); diff != "" {
+			t.Errorf("%q: value differs from yaml.v2: %s", test.events, diff)
+		}
+	}
+}
+
+func TestResolveTimestamp(t *testing.T) {
+	tag, v := resolve("", "2002-12-14")
+	if tag != yaml_TIMESTAMP_TAG {
+		t.Fatalf("unexpected tag %q", tag)
+	}
+	if want := time.Date(2002, 12, 14, 0, 0, 0, 0, time.UTC); !v.(time.Time).Equal(want) {
+		t.Fatalf("got %v want %v", v, want)
+	}
+}
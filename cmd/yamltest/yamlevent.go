@@ -100,15 +100,18 @@ func check(path string) error {
 	if *generate {
 		return generateJSON(path, v, sections)
 	}
-	// go-yaml can't currently read multiple documents,
-	// so check only the first one.
-	v1 := v.([]interface{})
-	if len(v1) == 0 {
+	docs := v.([]interface{})
+	inYAML := unquoteSection(sections["in-yaml"])
+	if len(docs) > 1 {
+		// Unmarshal tests can only represent a single
+		// document, so don't generate one on failure.
+		return errgo.Mask(checkYAMLDocs(path, inYAML, docs))
+	}
+	if len(docs) == 0 {
 		v = nil
 	} else {
-		v = v1[0]
+		v = docs[0]
 	}
-	inYAML := unquoteSection(sections["in-yaml"])
 	if err := checkYAML(path, inYAML, v); err == nil || !*tests {
 		return errgo.Mask(err)
 	}
@@ -226,6 +229,29 @@ func checkYAML(path string, inYAML string, expectv interface{}) error {
 	return errgo.Newf("YAML differs from expected output: %v (got %v want %v)", diff, pretty.Sprint(yv), pretty.Sprint(expectv))
 }
 
+// checkYAMLDocs checks that inYAML decodes to the
+// documents in expectDocs.
+func checkYAMLDocs(path string, inYAML string, expectDocs []interface{}) error {
+	dec := yaml.NewDecoder(strings.NewReader(inYAML))
+	var docs []interface{}
+	for {
+		var yv interface{}
+		err := dec.Decode(&yv)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot unmarshal YAML document %d in %q", len(docs), inYAML)
+		}
+		docs = append(docs, yv)
+	}
+	diff := cmp.Diff(docs, expectDocs)
+	if diff == "" {
+		return nil
+	}
+	return errgo.Newf("YAML differs from expected output: %v (got %v want %v)", diff, pretty.Sprint(docs), pretty.Sprint(expectDocs))
+}
+
 func valueFromEvents(r io.Reader) (v interface{}, rerr error) {
 	defer func() {
 		err := recover()
@@ -434,14 +460,15 @@ func doSequence(r *eventReader, refs refMap) interface{} {
 }
 
 var acceptableTagsForGenerate = map[string]bool{
-	"":             true,
-	yaml_NULL_TAG:  true,
-	yaml_BOOL_TAG:  true,
-	yaml_STR_TAG:   true,
-	yaml_INT_TAG:   true,
-	yaml_FLOAT_TAG: true,
-	yaml_SEQ_TAG:   true,
-	yaml_MAP_TAG:   true,
+	"":                 true,
+	yaml_NULL_TAG:      true,
+	yaml_BOOL_TAG:      true,
+	yaml_STR_TAG:       true,
+	yaml_INT_TAG:       true,
+	yaml_FLOAT_TAG:     true,
+	yaml_SEQ_TAG:       true,
+	yaml_MAP_TAG:       true,
+	yaml_TIMESTAMP_TAG: true,
 }
 
 func doVal(r *eventReader, refs refMap) interface{} {
@@ -452,7 +479,14 @@ func doVal(r *eventReader, refs refMap) interface{} {
 	var val interface{}
 	switch e.quote {
 	case ':':
-		_, val = resolve(e.tag, e.val)
+		var rtag string
+		rtag, val = resolve(e.tag, e.val)
+		if rtag == yaml_TIMESTAMP_TAG {
+			// For backward compatibility, gopkg.in/yaml.v2
+			// unmarshals timestamps into interface{} values
+			// as strings, so do the same.
+			val = e.val
+		}
 	default:
 		val = e.val
 	}
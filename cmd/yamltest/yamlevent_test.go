@@ -0,0 +1,253 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckMultipleDocuments(t *testing.T) {
+	if err := check("testdata/multidoc.tml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var valueFromEventsTests = []struct {
+	testName    string
+	events      string
+	expect      []interface{}
+	expectError string
+}{{
+	testName: "empty-stream",
+	events: `
++STR
+-STR
+`,
+}, {
+	testName: "scalars",
+	events: `
++STR
++DOC
++SEQ
+=VAL :1
+=VAL :1.5
+=VAL :true
+=VAL :~
+=VAL 'true
+=VAL "a\tb
+=VAL <tag:yaml.org,2002:str> :1
+-SEQ
+-DOC
+-STR
+`,
+	expect: []interface{}{
+		[]interface{}{1, 1.5, true, nil, "true", "a\tb", "1"},
+	},
+}, {
+	testName: "anchored-scalar-alias",
+	events: `
++STR
++DOC
++SEQ
+=VAL &a :hello
+=ALI *a
+-SEQ
+-DOC
+-STR
+`,
+	expect: []interface{}{
+		[]interface{}{"hello", "hello"},
+	},
+}, {
+	testName: "alias-to-map",
+	events: `
++STR
++DOC
++MAP
+=VAL :a
++MAP &anchor <tag:yaml.org,2002:map>
+=VAL :x
+=VAL :1
+-MAP
+=VAL :b
+=ALI *anchor
+-MAP
+-DOC
+-STR
+`,
+	expect: []interface{}{
+		map[interface{}]interface{}{
+			"a": map[interface{}]interface{}{"x": 1},
+			"b": map[interface{}]interface{}{"x": 1},
+		},
+	},
+}, {
+	testName: "alias-to-sequence",
+	events: `
++STR
++DOC
++SEQ
++SEQ &s
+=VAL :x
+-SEQ
+=ALI *s
+-SEQ
+-DOC
+-STR
+`,
+	expect: []interface{}{
+		[]interface{}{
+			[]interface{}{"x"},
+			[]interface{}{"x"},
+		},
+	},
+}, {
+	// Merge keys are not expanded; the "<<" key
+	// is kept with the aliased map as its value.
+	testName: "merge-key",
+	events: `
++STR
++DOC
++SEQ
++MAP &base
+=VAL :x
+=VAL :1
+-MAP
++MAP
+=VAL :<<
+=ALI *base
+=VAL :y
+=VAL :2
+-MAP
+-SEQ
+-DOC
+-STR
+`,
+	expect: []interface{}{
+		[]interface{}{
+			map[interface{}]interface{}{"x": 1},
+			map[interface{}]interface{}{
+				"<<": map[interface{}]interface{}{"x": 1},
+				"y":  2,
+			},
+		},
+	},
+}, {
+	testName: "anchors-are-per-document",
+	events: `
++STR
++DOC
+=VAL &a :x
+-DOC
++DOC
+=ALI *a
+-DOC
+-STR
+`,
+	expectError: `reference to undefined anchor "a"`,
+}, {
+	testName: "undefined-alias",
+	events: `
++STR
++DOC
+=ALI *nothing
+-DOC
+-STR
+`,
+	expectError: `reference to undefined anchor "nothing"`,
+}, {
+	testName: "unbalanced-map",
+	events: `
++STR
++DOC
++MAP
+=VAL :a
+-DOC
+-STR
+`,
+	expectError: `unexpected event; want map, value, sequence or alias, got "-DOC" .*`,
+}}
+
+func TestValueFromEvents(t *testing.T) {
+	for _, test := range valueFromEventsTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v, err := valueFromEvents(strings.NewReader(strings.TrimPrefix(test.events, "\n")))
+			if test.expectError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q, got value %#v", test.expectError, v)
+				}
+				if !regexpMatches(test.expectError, err.Error()) {
+					t.Fatalf("error mismatch; got %q want %q", err, test.expectError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(v, test.expect); diff != "" {
+				t.Fatalf("unexpected value: %s", diff)
+			}
+		})
+	}
+}
+
+var parseEventTests = []struct {
+	event       string
+	expect      event
+	expectError string
+}{{
+	event:  `+MAP &a <tag:yaml.org,2002:map>`,
+	expect: mapEvent{anchor: "a", tag: yaml_MAP_TAG},
+}, {
+	event:  `+SEQ <!foo>`,
+	expect: sequenceEvent{tag: "!foo"},
+}, {
+	event:  `=VAL &v <tag:yaml.org,2002:str> "a b\nc`,
+	expect: valEvent{anchor: "v", tag: yaml_STR_TAG, quote: '"', val: "a b\nc"},
+}, {
+	event:  `=VAL :`,
+	expect: valEvent{quote: ':'},
+}, {
+	event:  `=ALI *x`,
+	expect: aliasEvent{anchor: "x"},
+}, {
+	event:  `-MAP`,
+	expect: endEvent{kind_: kindMap},
+}, {
+	event:       `+MAP &a extra`,
+	expectError: `extra fields at end of map event "\+MAP &a extra"`,
+}, {
+	event:       `=VAL &a`,
+	expectError: `no value in value event "=VAL &a"`,
+}, {
+	event:       `=ALI x`,
+	expectError: `unexpected alias value in "=ALI x"`,
+}, {
+	event:       `+FOO`,
+	expectError: `unknown event name in "\+FOO"`,
+}}
+
+func TestParseEvent(t *testing.T) {
+	for _, test := range parseEventTests {
+		e, err := parseEvent(test.event)
+		if test.expectError != "" {
+			if err == nil || !regexpMatches(test.expectError, err.Error()) {
+				t.Errorf("%q: got error %v want %q", test.event, err, test.expectError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.event, err)
+			continue
+		}
+		if e != test.expect {
+			t.Errorf("%q: got %#v want %#v", test.event, e, test.expect)
+		}
+	}
+}
+
+func regexpMatches(pat, s string) bool {
+	return regexp.MustCompile("^(" + pat + ")$").MatchString(s)
+}
@@ -7,10 +7,12 @@
 package drum
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 )
 
@@ -41,6 +43,34 @@ type Track struct {
 	// Beats holds all the beats in the track.
 	// The value at Beats[t % NumBeats] specifies whether a beat is made at time t.
 	Beats [NumBeats]bool
+
+	// Gain holds the gain to apply to the track's sound, in
+	// decibels. Zero leaves the sound unchanged.
+	//
+	// Gain and MaxVoices are only stored in patterns whose
+	// version supports track metadata (see HasTrackMetadata).
+	Gain float32
+
+	// MaxVoices holds the maximum number of instances of the
+	// track's sound that may play simultaneously, at most 255.
+	// Zero means there is no limit.
+	MaxVoices int
+}
+
+// HasTrackMetadata reports whether patterns with the given version
+// hold per-track metadata (the Gain and MaxVoices fields of Track).
+// This is true for versions with a major version number of 2 or
+// more, such as "2.0"; classic files such as "0.808-alpha" don't
+// hold any.
+func HasTrackMetadata(version string) bool {
+	major := 0
+	for i := 0; i < len(version) && version[i] >= '0' && version[i] <= '9'; i++ {
+		major = major*10 + int(version[i]-'0')
+		if major >= 2 {
+			return true
+		}
+	}
+	return false
 }
 
 // DecodeFile decodes the drum machine pattern found at the provided
@@ -64,6 +94,8 @@ func DecodeFile(path string) (*Pattern, error) {
 //	channel: (int32, little-endian) * 4 bytes
 //	trackname: (namelen(int8), string[len])
 //	beats: (0x0 | 0x1) * 4 * 4
+//	gain: (float32, little endian), 4 bytes, only if HasTrackMetadata(version)
+//	maxvoices: (uint8), 1 byte, only if HasTrackMetadata(version)
 // ]
 
 const signature = "SPLICE"
@@ -80,6 +112,11 @@ type chanHeader struct {
 	NameLen byte
 }
 
+type trackMetadata struct {
+	Gain      float32
+	MaxVoices uint8
+}
+
 // Decode decodes the drum machine pattern read
 // from the given reader.
 func Decode(r io.Reader) (*Pattern, error) {
@@ -87,8 +124,8 @@ func Decode(r io.Reader) (*Pattern, error) {
 	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
 		return nil, fmt.Errorf("cannot read header: %v", err)
 	}
-	if sig := string(h.Sig[:]); sig != signature {
-		return nil, fmt.Errorf("unexpected header, got %q, want %q", sig, signature)
+	if string(h.Sig[:]) != signature {
+		return nil, fmt.Errorf("unexpected header, got %q, want %q", unpad(h.Sig[:]), signature)
 	}
 	length := int64(binary.BigEndian.Uint64(h.Len[:]))
 	r = io.LimitReader(r, length - int64(len(h.Version)) - 4)
@@ -125,10 +162,35 @@ func Decode(r io.Reader) (*Pattern, error) {
 			}
 			t.Beats[i] = beat != 0
 		}
+		if HasTrackMetadata(p.Version) {
+			var meta trackMetadata
+			if err := binary.Read(r, binary.LittleEndian, &meta); err != nil {
+				return nil, fmt.Errorf("cannot read metadata for channel %q: %v", t.Name, err)
+			}
+			t.Gain = meta.Gain
+			t.MaxVoices = int(meta.MaxVoices)
+		}
 		p.Tracks = append(p.Tracks, t)
 	}
 }
 
+// DecodeAll decodes a sequence of concatenated drum machine
+// patterns read from the given reader, stopping at EOF.
+func DecodeAll(r io.Reader) ([]*Pattern, error) {
+	br := bufio.NewReader(r)
+	var ps []*Pattern
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return ps, nil
+		}
+		p, err := Decode(br)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d: %v", len(ps), err)
+		}
+		ps = append(ps, p)
+	}
+}
+
 func (p *Pattern) String() string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "Saved with HW Version: %s\n", p.Version)
@@ -151,22 +213,20 @@ const defaultVersion = "1.0"
 // The Version field in the pattern may be empty,
 // in which case version 1.0 will be used.
 func (p *Pattern) MarshalBinary() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	var h header
 	copy(h.Sig[:], signature)
-	h.
-	h.Unknown = 0x57
-	if p.Version == "" {
-		copy(h.Version[:], defaultVersion)
-	} else {
-		copy(h.Version[:], p.Version)
+	version := p.Version
+	if version == "" {
+		version = defaultVersion
 	}
+	copy(h.Version[:], version)
 	h.Tempo = p.Tempo
 	binary.Write(&buf, binary.LittleEndian, h)
 	for _, t := range p.Tracks {
-		if len(t.Name) > 255 {
-			return nil, fmt.Errorf("track %d has name too long (%q)", t.Channel, t.Name)
-		}
 		binary.Write(&buf, binary.LittleEndian, chanHeader{
 			Channel: int32(t.Channel),
 			NameLen: byte(len(t.Name)),
@@ -179,8 +239,115 @@ func (p *Pattern) MarshalBinary() ([]byte, error) {
 				buf.WriteByte(0)
 			}
 		}
+		if HasTrackMetadata(version) {
+			binary.Write(&buf, binary.LittleEndian, trackMetadata{
+				Gain:      t.Gain,
+				MaxVoices: uint8(t.MaxVoices),
+			})
+		}
+	}
+	data := buf.Bytes()
+	// The length covers everything after the length field itself.
+	binary.BigEndian.PutUint64(data[len(h.Sig):], uint64(len(data)-len(h.Sig)-len(h.Len)))
+	return data, nil
+}
+
+// Validate checks that the pattern can be encoded with
+// MarshalBinary. Track names must be at most 255 bytes long,
+// channel numbers must be non-negative and fit in 32 bits,
+// and the tempo must be positive. Tracks may only have
+// metadata if the pattern's version supports it.
+//
+// Every track holds exactly NumBeats beats, so
+// the beat counts are always consistent.
+func (p *Pattern) Validate() error {
+	if !(p.Tempo > 0) || math.IsInf(float64(p.Tempo), 1) {
+		return fmt.Errorf("invalid tempo %g", p.Tempo)
+	}
+	if len(p.Version) > len(header{}.Version) {
+		return fmt.Errorf("version %q too long", p.Version)
+	}
+	for _, t := range p.Tracks {
+		if len(t.Name) > 255 {
+			return fmt.Errorf("track %d has name too long (%q)", t.Channel, t.Name)
+		}
+		if t.Channel < 0 || t.Channel > math.MaxInt32 {
+			return fmt.Errorf("track %q has invalid channel number %d", t.Name, t.Channel)
+		}
+		if t.MaxVoices < 0 || t.MaxVoices > 255 {
+			return fmt.Errorf("track %q has invalid voice limit %d", t.Name, t.MaxVoices)
+		}
+		if math.IsNaN(float64(t.Gain)) || math.IsInf(float64(t.Gain), 0) {
+			return fmt.Errorf("track %q has invalid gain %g", t.Name, t.Gain)
+		}
+		if (t.Gain != 0 || t.MaxVoices != 0) && !HasTrackMetadata(p.Version) {
+			return fmt.Errorf("track %q has metadata but version %q does not support it", t.Name, p.Version)
+		}
+	}
+	return nil
+}
+
+// Compact returns a copy of the pattern without any tracks that have
+// no beats, which makes the encoded form of sparse patterns smaller.
+// The pattern itself is not changed.
+func (p *Pattern) Compact() *Pattern {
+	p1 := *p
+	p1.Tracks = make([]Track, 0, len(p.Tracks))
+	for _, t := range p.Tracks {
+		if t.Beats != [NumBeats]bool{} {
+			p1.Tracks = append(p1.Tracks, t)
+		}
+	}
+	return &p1
+}
+
+// Merge returns a new pattern that overlays the tracks of other onto
+// those of p, which is useful for layering patterns such as a base
+// groove and a fill. A track in other with the same name as a track in
+// p is merged into the first such track by adding its beats, keeping
+// the channel and metadata of the track in p. The other tracks in
+// other are appended, keeping their channel numbers unless they're
+// already used, in which case they're renumbered after the highest
+// channel in use. The result has the version of p.
+//
+// The patterns must have the same tempo. Every track holds exactly
+// NumBeats beats, so the beat counts always match. Neither pattern
+// is changed.
+func (p *Pattern) Merge(other *Pattern) (*Pattern, error) {
+	if p.Tempo != other.Tempo {
+		return nil, fmt.Errorf("cannot merge patterns with different tempos (%g and %g)", p.Tempo, other.Tempo)
+	}
+	p1 := *p
+	p1.Tracks = append([]Track(nil), p.Tracks...)
+	byName := make(map[string]int)
+	usedChannels := make(map[int]bool)
+	maxChannel := -1
+	for i, t := range p1.Tracks {
+		if _, ok := byName[t.Name]; !ok {
+			byName[t.Name] = i
+		}
+		usedChannels[t.Channel] = true
+		if t.Channel > maxChannel {
+			maxChannel = t.Channel
+		}
+	}
+	for _, t := range other.Tracks {
+		if i, ok := byName[t.Name]; ok {
+			for j, beat := range t.Beats {
+				p1.Tracks[i].Beats[j] = p1.Tracks[i].Beats[j] || beat
+			}
+			continue
+		}
+		if usedChannels[t.Channel] {
+			t.Channel = maxChannel + 1
+		}
+		usedChannels[t.Channel] = true
+		if t.Channel > maxChannel {
+			maxChannel = t.Channel
+		}
+		p1.Tracks = append(p1.Tracks, t)
 	}
-	return buf.Bytes(), nil
+	return &p1, nil
 }
 
 // writeBeats writes the beats in a track in |--x-| format.
@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
@@ -181,6 +183,8 @@ Tempo: 999
 `,
 	expectError: `cannot read channel header: unexpected EOF`,
 }, {
+	// The declared length is honoured whatever the version,
+	// so trailing data is ignored, as DecodeAll relies on.
 	name: "spurious data with non-0.708 version",
 	data: `
 00000000  53 50 4c 49 43 45 00 00  00 00 00 00 00 57 30 2e  |SPLICE.......W0.|
@@ -193,7 +197,11 @@ Tempo: 999
 00000070  69 48 61 74 01 00 01 00  01 00 01 00 01 00 01 00  |iHat............|
 00000080  01 00 01 00                                       |....|
 `,
-	expectError: `cannot read channel name, size 67: unexpected EOF`,
+	expectOutput: `Saved with HW Version: 0.709-alpha
+Tempo: 999
+(1) Kick	|x---|----|x---|----|
+(2) HiHat	|x-x-|x-x-|x-x-|x-x-|
+`,
 }, {
 	name: "truncated channel beats",
 	data: `
@@ -282,6 +290,273 @@ func TestMarshalBinaryWithNoVersion(t *testing.T) {
 	}
 }
 
+func TestCompact(t *testing.T) {
+	p := &drum.Pattern{
+		Version: "0.808-alpha",
+		Tempo:   120,
+		Tracks: []drum.Track{{
+			Channel: 0,
+			Name:    "kick",
+			Beats:   [drum.NumBeats]bool{0: true, 8: true},
+		}, {
+			Channel: 1,
+			Name:    "snare",
+		}, {
+			Channel: 2,
+			Name:    "hh",
+			Beats:   [drum.NumBeats]bool{15: true},
+		}, {
+			Channel: 3,
+			Name:    "cowbell",
+		}},
+	}
+	orig := p.String()
+	data, err := p.Compact().MarshalBinary()
+	if err != nil {
+		t.Fatalf("cannot marshal: %v", err)
+	}
+	full, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("cannot marshal: %v", err)
+	}
+	if len(data) >= len(full) {
+		t.Fatalf("compacted encoding is not smaller (%d bytes vs %d)", len(data), len(full))
+	}
+	p1, err := drum.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	want := []drum.Track{p.Tracks[0], p.Tracks[2]}
+	if !reflect.DeepEqual(p1.Tracks, want) {
+		t.Fatalf("unexpected tracks after round trip; got %#v want %#v", p1.Tracks, want)
+	}
+	if p1.Version != p.Version || p1.Tempo != p.Tempo {
+		t.Fatalf("unexpected header after round trip; got %q %g", p1.Version, p1.Tempo)
+	}
+	if p.String() != orig {
+		t.Fatalf("Compact modified the original pattern")
+	}
+}
+
+var mergeTests = []struct {
+	about       string
+	p, other    []drum.Track
+	expect      []drum.Track
+	expectError string
+}{{
+	about: "overlapping tracks",
+	p: []drum.Track{{
+		Channel: 0,
+		Name:    "kick",
+		Beats:   [drum.NumBeats]bool{0: true, 8: true},
+		Gain:    -3,
+	}, {
+		Channel: 1,
+		Name:    "snare",
+		Beats:   [drum.NumBeats]bool{4: true, 12: true},
+	}},
+	other: []drum.Track{{
+		Channel: 5,
+		Name:    "snare",
+		Beats:   [drum.NumBeats]bool{12: true, 14: true, 15: true},
+	}, {
+		Channel: 6,
+		Name:    "kick",
+		Beats:   [drum.NumBeats]bool{10: true},
+		Gain:    2,
+	}},
+	expect: []drum.Track{{
+		Channel: 0,
+		Name:    "kick",
+		Beats:   [drum.NumBeats]bool{0: true, 8: true, 10: true},
+		Gain:    -3,
+	}, {
+		Channel: 1,
+		Name:    "snare",
+		Beats:   [drum.NumBeats]bool{4: true, 12: true, 14: true, 15: true},
+	}},
+}, {
+	about: "disjoint tracks",
+	p: []drum.Track{{
+		Channel: 0,
+		Name:    "kick",
+		Beats:   [drum.NumBeats]bool{0: true},
+	}, {
+		Channel: 3,
+		Name:    "snare",
+		Beats:   [drum.NumBeats]bool{4: true},
+	}},
+	other: []drum.Track{{
+		Channel: 0,
+		Name:    "hh",
+		Beats:   [drum.NumBeats]bool{2: true},
+	}, {
+		Channel: 1,
+		Name:    "cowbell",
+		Beats:   [drum.NumBeats]bool{6: true},
+	}, {
+		Channel: 3,
+		Name:    "clap",
+		Beats:   [drum.NumBeats]bool{7: true},
+	}},
+	expect: []drum.Track{{
+		Channel: 0,
+		Name:    "kick",
+		Beats:   [drum.NumBeats]bool{0: true},
+	}, {
+		Channel: 3,
+		Name:    "snare",
+		Beats:   [drum.NumBeats]bool{4: true},
+	}, {
+		Channel: 4,
+		Name:    "hh",
+		Beats:   [drum.NumBeats]bool{2: true},
+	}, {
+		Channel: 1,
+		Name:    "cowbell",
+		Beats:   [drum.NumBeats]bool{6: true},
+	}, {
+		Channel: 5,
+		Name:    "clap",
+		Beats:   [drum.NumBeats]bool{7: true},
+	}},
+}, {
+	about: "empty pattern",
+	other: []drum.Track{{
+		Channel: 2,
+		Name:    "hh",
+		Beats:   [drum.NumBeats]bool{2: true},
+	}},
+	expect: []drum.Track{{
+		Channel: 2,
+		Name:    "hh",
+		Beats:   [drum.NumBeats]bool{2: true},
+	}},
+}}
+
+func TestMerge(t *testing.T) {
+	for _, test := range mergeTests {
+		p := &drum.Pattern{
+			Version: "0.808-alpha",
+			Tempo:   120,
+			Tracks:  test.p,
+		}
+		other := &drum.Pattern{
+			Version: "0.909",
+			Tempo:   120,
+			Tracks:  test.other,
+		}
+		pOrig, otherOrig := p.String(), other.String()
+		merged, err := p.Merge(other)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.about, err)
+			continue
+		}
+		if !reflect.DeepEqual(merged.Tracks, test.expect) {
+			t.Errorf("%s: unexpected tracks; got %#v want %#v", test.about, merged.Tracks, test.expect)
+		}
+		if merged.Version != p.Version || merged.Tempo != p.Tempo {
+			t.Errorf("%s: unexpected header; got %q %g", test.about, merged.Version, merged.Tempo)
+		}
+		if p.String() != pOrig || other.String() != otherOrig {
+			t.Errorf("%s: Merge modified a pattern", test.about)
+		}
+	}
+}
+
+func TestMergeWithDifferentTempo(t *testing.T) {
+	p := &drum.Pattern{Tempo: 120}
+	other := &drum.Pattern{Tempo: 98.5}
+	_, err := p.Merge(other)
+	want := "cannot merge patterns with different tempos (120 and 98.5)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("unexpected error; got %v want %q", err, want)
+	}
+}
+
+func TestTrackMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		about   string
+		pattern drum.Pattern
+	}{{
+		about: "classic version without metadata",
+		pattern: drum.Pattern{
+			Version: "0.808-alpha",
+			Tempo:   120,
+			Tracks: []drum.Track{{
+				Channel: 1,
+				Name:    "kick",
+				Beats:   [drum.NumBeats]bool{0: true, 8: true},
+			}, {
+				Channel: 2,
+				Name:    "snare",
+				Beats:   [drum.NumBeats]bool{4: true, 12: true},
+			}},
+		},
+	}, {
+		about: "new version with metadata",
+		pattern: drum.Pattern{
+			Version: "2.0",
+			Tempo:   98.4,
+			Tracks: []drum.Track{{
+				Channel:   1,
+				Name:      "kick",
+				Beats:     [drum.NumBeats]bool{0: true, 8: true},
+				Gain:      -3.5,
+				MaxVoices: 2,
+			}, {
+				Channel: 2,
+				Name:    "snare",
+				Beats:   [drum.NumBeats]bool{4: true, 12: true},
+			}},
+		},
+	}}
+	for i, test := range tests {
+		t.Logf("test %d: %s", i, test.about)
+		data, err := test.pattern.MarshalBinary()
+		if err != nil {
+			t.Fatalf("cannot marshal: %v", err)
+		}
+		wantLen := 6 + 8 + 32 + 4
+		for _, tr := range test.pattern.Tracks {
+			wantLen += 4 + 1 + len(tr.Name) + drum.NumBeats
+			if drum.HasTrackMetadata(test.pattern.Version) {
+				wantLen += 4 + 1
+			}
+		}
+		if len(data) != wantLen {
+			t.Fatalf("unexpected encoded length; got %d want %d", len(data), wantLen)
+		}
+		p, err := drum.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("cannot decode: %v", err)
+		}
+		if !reflect.DeepEqual(*p, test.pattern) {
+			t.Fatalf("round trip mismatch; got %#v want %#v", *p, test.pattern)
+		}
+	}
+}
+
+func TestHasTrackMetadata(t *testing.T) {
+	tests := []struct {
+		version string
+		expect  bool
+	}{
+		{"", false},
+		{"0.808-alpha", false},
+		{"1.0", false},
+		{"2", true},
+		{"2.0", true},
+		{"10.1-beta", true},
+		{"v2.0", false},
+	}
+	for _, test := range tests {
+		if got := drum.HasTrackMetadata(test.version); got != test.expect {
+			t.Errorf("HasTrackMetadata(%q) = %v; want %v", test.version, got, test.expect)
+		}
+	}
+}
+
 func TestMarshalBinaryWithTrackNameTooLong(t *testing.T) {
 	longName := strings.Repeat("a", 300)
 	p := &drum.Pattern{
@@ -371,3 +646,140 @@ var cowbellExampleData = undump(`
 000000c0  65 6c 6c 00 00 00 00 00  00 00 00 00 00 01 00 00  |ell.............|
 000000d0  00 00 00                                          |...|
 `)
+
+var validateTests = []struct {
+	about       string
+	pattern     drum.Pattern
+	expectError string
+}{{
+	about: "valid",
+	pattern: drum.Pattern{
+		Tempo: 120,
+		Tracks: []drum.Track{{
+			Channel: 0,
+			Name:    "kick",
+		}, {
+			Channel: 1,
+			Name:    strings.Repeat("a", 255),
+		}},
+	},
+}, {
+	about: "zero tempo",
+	pattern: drum.Pattern{
+		Tempo: 0,
+	},
+	expectError: `invalid tempo 0`,
+}, {
+	about: "negative tempo",
+	pattern: drum.Pattern{
+		Tempo: -10,
+	},
+	expectError: `invalid tempo -10`,
+}, {
+	about: "NaN tempo",
+	pattern: drum.Pattern{
+		Tempo: float32(math.NaN()),
+	},
+	expectError: `invalid tempo NaN`,
+}, {
+	about: "version too long",
+	pattern: drum.Pattern{
+		Version: strings.Repeat("v", 33),
+		Tempo:   120,
+	},
+	expectError: `version "v+" too long`,
+}, {
+	about: "track name too long",
+	pattern: drum.Pattern{
+		Tempo: 120,
+		Tracks: []drum.Track{{
+			Channel: 3,
+			Name:    strings.Repeat("a", 256),
+		}},
+	},
+	expectError: `track 3 has name too long \("a+"\)`,
+}, {
+	about: "negative channel",
+	pattern: drum.Pattern{
+		Tempo: 120,
+		Tracks: []drum.Track{{
+			Channel: -1,
+			Name:    "kick",
+		}},
+	},
+	expectError: `track "kick" has invalid channel number -1`,
+}, {
+	about: "metadata with classic version",
+	pattern: drum.Pattern{
+		Version: "0.808-alpha",
+		Tempo:   120,
+		Tracks: []drum.Track{{
+			Name: "kick",
+			Gain: -3,
+		}},
+	},
+	expectError: `track "kick" has metadata but version "0.808-alpha" does not support it`,
+}, {
+	about: "voice limit out of range",
+	pattern: drum.Pattern{
+		Version: "2.0",
+		Tempo:   120,
+		Tracks: []drum.Track{{
+			Name:      "kick",
+			MaxVoices: 256,
+		}},
+	},
+	expectError: `track "kick" has invalid voice limit 256`,
+}}
+
+func TestValidate(t *testing.T) {
+	for i, test := range validateTests {
+		t.Logf("test %d: %s", i, test.about)
+		err := test.pattern.Validate()
+		if test.expectError == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("got no error; expected error matching %q", test.expectError)
+		}
+		ok, err1 := regexp.MatchString("^("+test.expectError+")$", err.Error())
+		if err1 != nil {
+			t.Fatalf("bad error pattern in test: %v", err1)
+		}
+		if !ok {
+			t.Fatalf("got unexpected error %q; want %q", err.Error(), test.expectError)
+		}
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	data0 := undump(decodeTests[0].data)
+	data1 := undump(decodeTests[1].data)
+	ps, err := drum.DecodeAll(bytes.NewReader(append(append([]byte(nil), data0...), data1...)))
+	if err != nil {
+		t.Fatalf("cannot decode: %v", err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("got %d patterns; want 2", len(ps))
+	}
+	for i, p := range ps {
+		if got, want := p.String(), decodeTests[i].expectOutput; got != want {
+			t.Fatalf("unexpected output for pattern %d\nGot\n%s\nWant\n%s\n", i, got, want)
+		}
+	}
+
+	// An empty reader holds no patterns.
+	ps, err = drum.DecodeAll(bytes.NewReader(nil))
+	if err != nil || len(ps) != 0 {
+		t.Fatalf("got %v, %v from empty reader; want no patterns", ps, err)
+	}
+
+	// A truncated second pattern is an error.
+	_, err = drum.DecodeAll(bytes.NewReader(append(append([]byte(nil), data0...), data1[:10]...)))
+	if want := "pattern 1: cannot read header: unexpected EOF"; err == nil || err.Error() != want {
+		t.Fatalf("got error %v; want %q", err, want)
+	}
+}
@@ -7,6 +7,8 @@ package drummachine
 
 import (
 	"fmt"
+	"math"
+	"sort"
 
 	"github.com/nf/sigourney/audio"
 
@@ -18,30 +20,169 @@ import (
 // in samples per second.
 const SampleRate = 44100
 
+// Machine is a drum machine module that repeatedly plays a drum
+// pattern. It implements audio.Processor. Its Mute and Stop methods may
+// be called while Process is running in another goroutine.
+type Machine struct {
+	seq *sequencer.Sequencer
+
+	// trackIndex maps from track name to the indexes
+	// of all the tracks in the pattern with that name.
+	trackIndex map[string][]int
+
+	// tempos holds the times of the beats played
+	// by the machine.
+	tempos *tempoMap
+}
+
 // New returns a new drum machine module that will repeatedly play
 // the drum pattern p using the given patch samples.
-func New(p *drum.Pattern, patchByName map[string][]audio.Sample) (audio.Processor, error) {
+func New(p *drum.Pattern, patchByName map[string][]audio.Sample) (*Machine, error) {
 	return newWithBeatDuration(p, patchByName, tempoToBeatDuration(p.Tempo))
 }
 
+// TempoChange represents a change of tempo at a given beat.
+type TempoChange struct {
+	// Beat holds the number of the beat at which the tempo
+	// changes, counting from zero at the first beat played.
+	// Each cycle of a pattern is drum.NumBeats beats long.
+	Beat int64
+
+	// Tempo holds the new tempo in beats per minute.
+	Tempo float32
+}
+
+// NewWithTempoMap is like New except that the tempo varies over time,
+// which can be used for accelerando and ritardando effects. The
+// pattern's own tempo is used up until the first tempo change; after
+// that each change applies until the next one. The changes must be
+// ordered by strictly increasing beat number.
+func NewWithTempoMap(p *drum.Pattern, patchByName map[string][]audio.Sample, changes []TempoChange) (*Machine, error) {
+	tempos, err := newTempoMap(p.Tempo, changes)
+	if err != nil {
+		return nil, err
+	}
+	return newWithTempoMap(p, patchByName, tempos)
+}
+
+// Process implements audio.Processor.Process.
+func (m *Machine) Process(out []audio.Sample) {
+	m.seq.Process(out)
+}
+
+// Mute sets whether the track with the given name is muted. A muted
+// track starts no new sounds, but any sound it's already playing
+// will continue to the end. If there is no such track, Mute does
+// nothing.
+func (m *Machine) Mute(trackName string, muted bool) {
+	for _, i := range m.trackIndex[trackName] {
+		m.seq.Mute(i, muted)
+	}
+}
+
+// Stop arranges for the drum machine to stop cleanly at the end of the
+// current cycle of the pattern - no new sounds will be started after
+// that, although sounds already playing will continue to the end. If
+// the machine is exactly at the start of a cycle, no more sounds will
+// be started at all.
+func (m *Machine) Stop() {
+	beat := m.tempos.beatAtOrAfter(m.seq.Time())
+	cycle := (beat + drum.NumBeats - 1) / drum.NumBeats * drum.NumBeats
+	m.seq.StopAt(m.tempos.beatTime(cycle))
+}
+
+// BeatDuration returns the length of each beat in samples. If the
+// tempo varies, it returns the length of the beats at the start of the
+// pattern.
+func (m *Machine) BeatDuration() int64 {
+	return m.tempos.segments[0].beatDuration
+}
+
+// CycleLength returns the length in samples of one cycle of the
+// pattern, which is drum.NumBeats beats long. If the tempo varies,
+// it returns the length of the first cycle.
+func (m *Machine) CycleLength() int64 {
+	return m.tempos.beatTime(drum.NumBeats)
+}
+
 func tempoToBeatDuration(tempo float32) int64 {
 	return int64(SampleRate/(tempo/60) + 0.5)
 }
 
+// NewStereo is like New except that it produces stereo output
+// with interleaved left and right samples. The pan position of each
+// track is taken from panByName, keyed by track name, ranging from -1
+// (fully left) to 1 (fully right). Tracks without an entry in panByName
+// are placed in the center.
+func NewStereo(p *drum.Pattern, patchByName map[string][]audio.Sample, panByName map[string]float64) (*Machine, error) {
+	return newStereoWithBeatDuration(p, patchByName, panByName, tempoToBeatDuration(p.Tempo))
+}
+
 // newWithBeatDuration is like New but allows the beat duration
 // to be specified directly which is useful for testing.
-func newWithBeatDuration(p *drum.Pattern, patchByName map[string][]audio.Sample, beatDuration int64) (audio.Processor, error) {
+func newWithBeatDuration(p *drum.Pattern, patchByName map[string][]audio.Sample, beatDuration int64) (*Machine, error) {
+	return newWithTempoMap(p, patchByName, constantTempo(beatDuration))
+}
+
+// newWithTempoMap is like NewWithTempoMap but allows the
+// beat times to be specified directly which is useful for testing.
+func newWithTempoMap(p *drum.Pattern, patchByName map[string][]audio.Sample, tempos *tempoMap) (*Machine, error) {
+	tracks, patches, err := newTracks(p, patchByName, tempos)
+	if err != nil {
+		return nil, err
+	}
+	return newMachine(p, sequencer.New(tracks, patches), tempos), nil
+}
+
+// newStereoWithBeatDuration is like NewStereo but allows the beat duration
+// to be specified directly which is useful for testing.
+func newStereoWithBeatDuration(p *drum.Pattern, patchByName map[string][]audio.Sample, panByName map[string]float64, beatDuration int64) (*Machine, error) {
+	tempos := constantTempo(beatDuration)
+	tracks, patches, err := newTracks(p, patchByName, tempos)
+	if err != nil {
+		return nil, err
+	}
+	pans := make([]float64, len(p.Tracks))
+	for i, tr := range p.Tracks {
+		pan := panByName[tr.Name]
+		if pan < -1 || pan > 1 {
+			return nil, fmt.Errorf("pan %g for drum sound %q out of range", pan, tr.Name)
+		}
+		pans[i] = pan
+	}
+	return newMachine(p, sequencer.NewStereo(tracks, patches, pans), tempos), nil
+}
+
+func newMachine(p *drum.Pattern, seq *sequencer.Sequencer, tempos *tempoMap) *Machine {
+	m := &Machine{
+		seq:        seq,
+		trackIndex: make(map[string][]int),
+		tempos:     tempos,
+	}
+	for i, tr := range p.Tracks {
+		m.trackIndex[tr.Name] = append(m.trackIndex[tr.Name], i)
+	}
+	return m
+}
+
+// newTracks returns the sequencer sources and their
+// associated patches for all the tracks in p.
+func newTracks(p *drum.Pattern, patchByName map[string][]audio.Sample, tempos *tempoMap) ([]sequencer.Source, [][]audio.Sample, error) {
 	tracks := make([]sequencer.Source, len(p.Tracks))
 	patches := make([][]audio.Sample, len(p.Tracks))
 	for i, tr := range p.Tracks {
 		patch := patchByName[tr.Name]
 		if len(patch) == 0 {
-			return nil, fmt.Errorf("drum sound %q not found", tr.Name)
+			return nil, nil, fmt.Errorf("drum sound %q not found", tr.Name)
 		}
 		patches[i] = patch
-		tracks[i] = newTrack(tr, beatDuration)
+		if len(tempos.segments) == 1 {
+			tracks[i] = newTrack(tr, tempos.segments[0].beatDuration)
+		} else {
+			tracks[i] = newTempoTrack(tr, tempos)
+		}
 	}
-	return sequencer.New(tracks, patches), nil
+	return tracks, patches, nil
 }
 
 func newTrack(tr drum.Track, beatDuration int64) sequencer.Source {
@@ -57,3 +198,144 @@ func newTrack(tr drum.Track, beatDuration int64) sequencer.Source {
 	}
 	return source
 }
+
+// tempoTrack implements sequencer.Source for a track
+// whose beat times are taken from a tempo map.
+type tempoTrack struct {
+	tempos *tempoMap
+
+	// beats holds the offsets of the beats
+	// from the start of a cycle.
+	beats []int64
+
+	// index holds the index in beats of the next beat.
+	index int
+
+	// cycle holds the number of the first beat
+	// of the current cycle.
+	cycle int64
+}
+
+func newTempoTrack(tr drum.Track, tempos *tempoMap) sequencer.Source {
+	t := &tempoTrack{
+		tempos: tempos,
+	}
+	for i, beat := range tr.Beats {
+		if beat {
+			t.beats = append(t.beats, int64(i))
+		}
+	}
+	return t
+}
+
+// Next implements sequencer.Source.Next.
+func (t *tempoTrack) Next() int64 {
+	if len(t.beats) == 0 {
+		// No beats - we'll remain silent.
+		return math.MaxInt64
+	}
+	next := t.tempos.beatTime(t.cycle + t.beats[t.index])
+	t.index++
+	if t.index == len(t.beats) {
+		t.index = 0
+		t.cycle += drum.NumBeats
+	}
+	return next
+}
+
+// tempoMap maps between beat numbers and times in samples.
+// It is not modified after creation.
+type tempoMap struct {
+	// segments holds the segments of constant tempo in
+	// increasing order of beat. The first segment
+	// always starts at beat zero.
+	segments []tempoSegment
+}
+
+type tempoSegment struct {
+	// beat holds the first beat of the segment.
+	beat int64
+
+	// time holds the time of that beat in samples.
+	time int64
+
+	// beatDuration holds the length of each
+	// beat in the segment in samples.
+	beatDuration int64
+}
+
+// newTempoMap returns a tempo map that starts with the given
+// tempo and then changes tempo as described by changes.
+func newTempoMap(tempo float32, changes []TempoChange) (*tempoMap, error) {
+	d, err := beatDuration(tempo)
+	if err != nil {
+		return nil, err
+	}
+	m := constantTempo(d)
+	for _, c := range changes {
+		d, err := beatDuration(c.Tempo)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.add(c.Beat, d); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// beatDuration is like tempoToBeatDuration except that it
+// returns an error if the tempo is out of range.
+func beatDuration(tempo float32) (int64, error) {
+	if !(tempo > 0) {
+		return 0, fmt.Errorf("invalid tempo %g", tempo)
+	}
+	d := tempoToBeatDuration(tempo)
+	if d < 1 {
+		return 0, fmt.Errorf("tempo %g too fast", tempo)
+	}
+	return d, nil
+}
+
+// constantTempo returns a tempo map where every
+// beat is beatDuration samples long.
+func constantTempo(beatDuration int64) *tempoMap {
+	return &tempoMap{
+		segments: []tempoSegment{{
+			beatDuration: beatDuration,
+		}},
+	}
+}
+
+// add adds a segment to the end of the map where each beat from the
+// given beat onwards is beatDuration samples long.
+func (m *tempoMap) add(beat int64, beatDuration int64) error {
+	if last := m.segments[len(m.segments)-1]; beat <= last.beat {
+		return fmt.Errorf("tempo change at beat %d out of order", beat)
+	}
+	m.segments = append(m.segments, tempoSegment{
+		beat:         beat,
+		time:         m.beatTime(beat),
+		beatDuration: beatDuration,
+	})
+	return nil
+}
+
+// beatTime returns the time of the given beat in samples.
+func (m *tempoMap) beatTime(beat int64) int64 {
+	i := sort.Search(len(m.segments), func(i int) bool {
+		return m.segments[i].beat > beat
+	}) - 1
+	seg := m.segments[i]
+	return seg.time + (beat-seg.beat)*seg.beatDuration
+}
+
+// beatAtOrAfter returns the number of the first beat
+// that's played at or after the given time.
+func (m *tempoMap) beatAtOrAfter(t int64) int64 {
+	i := sort.Search(len(m.segments), func(i int) bool {
+		return m.segments[i].time > t
+	}) - 1
+	seg := m.segments[i]
+	return seg.beat + (t-seg.time+seg.beatDuration-1)/seg.beatDuration
+}
@@ -1,6 +1,7 @@
 package drummachine
 
 import (
+	"math"
 	"reflect"
 	"testing"
 
@@ -108,4 +109,241 @@ func TestSequencer(t *testing.T) {
 	}
 }
 
+func TestMute(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tracks: []drum.Track{{
+			Name:  "a",
+			Beats: [drum.NumBeats]bool{0: true},
+		}, {
+			Name:  "b",
+			Beats: [drum.NumBeats]bool{1: true},
+		}},
+	}
+	patches := map[string][]audio.Sample{
+		"a": {1, 1},
+		"b": {2, 2},
+	}
+	m, err := newWithBeatDuration(pattern, patches, 2)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	// cycle returns the first few samples of
+	// the next cycle of the pattern.
+	cycle := func() []audio.Sample {
+		out := make([]audio.Sample, drum.NumBeats*2)
+		m.Process(out)
+		return out[0:4]
+	}
+	both := []audio.Sample{1, 1, 2, 2}
+	if got := cycle(); !reflect.DeepEqual(got, both) {
+		t.Fatalf("unexpected output before mute; got %v want %v", got, both)
+	}
+	m.Mute("b", true)
+	// Muting a track that doesn't exist is ignored.
+	m.Mute("nonexistent", true)
+	onlyA := []audio.Sample{1, 1, 0, 0}
+	for i := 0; i < 2; i++ {
+		if got := cycle(); !reflect.DeepEqual(got, onlyA) {
+			t.Fatalf("unexpected output after mute; got %v want %v", got, onlyA)
+		}
+	}
+	m.Mute("b", false)
+	if got := cycle(); !reflect.DeepEqual(got, both) {
+		t.Fatalf("unexpected output after unmute; got %v want %v", got, both)
+	}
+}
+
+func TestStop(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tracks: []drum.Track{{
+			Name:  "a",
+			Beats: [drum.NumBeats]bool{0: true, 15: true},
+		}},
+	}
+	m, err := newWithBeatDuration(pattern, map[string][]audio.Sample{"a": {1, 2, 3}}, 1)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	out := make([]audio.Sample, 4)
+	m.Process(out)
+	m.Stop()
+	// The rest of the current cycle plays, including the
+	// tail of the sound started at its final beat, but
+	// nothing after that.
+	out = make([]audio.Sample, drum.NumBeats*2)
+	m.Process(out)
+	expect := make([]audio.Sample, len(out))
+	expect[11], expect[12], expect[13] = 1, 2, 3
+	if !reflect.DeepEqual(out, expect) {
+		t.Fatalf("unexpected output after stop; got %v want %v", out, expect)
+	}
+}
+
+func TestTempoChange(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tracks: []drum.Track{{
+			Name:  "a",
+			Beats: [drum.NumBeats]bool{0: true, 4: true, 8: true, 12: true},
+		}},
+	}
+	// Beats are 2 samples long for the first cycle
+	// and 1 sample long after that.
+	tempos := constantTempo(2)
+	if err := tempos.add(drum.NumBeats, 1); err != nil {
+		t.Fatal(err)
+	}
+	m, err := newWithTempoMap(pattern, map[string][]audio.Sample{"a": {1}}, tempos)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	out := make([]audio.Sample, 70)
+	m.Process(out)
+	var got []int
+	for i, x := range out {
+		if x != 0 {
+			got = append(got, i)
+		}
+	}
+	expect := []int{0, 8, 16, 24, 32, 36, 40, 44, 48, 52, 56, 60, 64, 68}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("unexpected beat times; got %v want %v", got, expect)
+	}
+
+	// Stopping part way through the second cycle
+	// stops at the end of that cycle.
+	m, err = newWithTempoMap(pattern, map[string][]audio.Sample{"a": {1}}, tempos)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	m.Process(make([]audio.Sample, 34))
+	m.Stop()
+	out = make([]audio.Sample, 30)
+	m.Process(out)
+	got = nil
+	for i, x := range out {
+		if x != 0 {
+			got = append(got, i+34)
+		}
+	}
+	expect = []int{36, 40, 44}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("unexpected beat times after stop; got %v want %v", got, expect)
+	}
+}
+
+func TestCycleLength(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tempo: 120,
+		Tracks: []drum.Track{{
+			Name:  "a",
+			Beats: [drum.NumBeats]bool{0: true},
+		}},
+	}
+	m, err := New(pattern, map[string][]audio.Sample{"a": {1}})
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	// At 120 beats per minute, each beat is half a second long.
+	if got, want := m.BeatDuration(), int64(SampleRate/2); got != want {
+		t.Errorf("unexpected beat duration; got %d want %d", got, want)
+	}
+	if got, want := m.CycleLength(), drum.NumBeats*m.BeatDuration(); got != want {
+		t.Errorf("unexpected cycle length; got %d want %d", got, want)
+	}
+
+	// With a tempo change part way through the first cycle,
+	// the cycle length reflects both tempos.
+	tempos := constantTempo(2)
+	if err := tempos.add(drum.NumBeats/2, 1); err != nil {
+		t.Fatal(err)
+	}
+	m, err = newWithTempoMap(pattern, map[string][]audio.Sample{"a": {1}}, tempos)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	if got, want := m.BeatDuration(), int64(2); got != want {
+		t.Errorf("unexpected beat duration; got %d want %d", got, want)
+	}
+	if got, want := m.CycleLength(), int64(drum.NumBeats/2*2+drum.NumBeats/2); got != want {
+		t.Errorf("unexpected cycle length; got %d want %d", got, want)
+	}
+}
+
+func TestNewWithTempoMapError(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tempo: 120,
+	}
+	_, err := NewWithTempoMap(pattern, nil, []TempoChange{{Beat: 16, Tempo: 100}, {Beat: 8, Tempo: 90}})
+	if err == nil || err.Error() != "tempo change at beat 8 out of order" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = NewWithTempoMap(pattern, nil, []TempoChange{{Beat: 16, Tempo: 0}})
+	if err == nil || err.Error() != "invalid tempo 0" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
 // TODO test with silent tracks, silent patterns and drum sounds that aren't present.
+
+func TestStereoSequencer(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tracks: []drum.Track{{
+			Name:  "a",
+			Beats: [drum.NumBeats]bool{0: true},
+		}, {
+			Name:  "b",
+			Beats: [drum.NumBeats]bool{1: true},
+		}, {
+			Name:  "c",
+			Beats: [drum.NumBeats]bool{2: true},
+		}},
+	}
+	patches := map[string][]audio.Sample{
+		"a": {4, 3},
+		"b": {2, 1},
+		"c": {2},
+	}
+	pans := map[string]float64{
+		"a": -1,
+		"b": 1,
+		// c is not mentioned, so it's in the center.
+	}
+	proc, err := newStereoWithBeatDuration(pattern, patches, pans, 2)
+	if err != nil {
+		t.Fatalf("cannot make processor: %v", err)
+	}
+	c := audio.Sample(math.Sqrt2)
+	expect := []audio.Sample{
+		4, 0, 3, 0, // a, fully left
+		0, 2, 0, 1, // b, fully right
+		c, c, 0, 0, // c, center
+		0, 0, 0, 0,
+	}
+	// Process in odd-sized chunks of frames to check that
+	// the interleaving is maintained across calls.
+	out := make([]audio.Sample, len(expect))
+	for i := 0; i < len(out); i += 6 {
+		end := i + 6
+		if end > len(out) {
+			end = len(out)
+		}
+		proc.Process(out[i:end])
+	}
+	for i := range expect {
+		if math.Abs(float64(out[i]-expect[i])) > 1e-9 {
+			t.Fatalf("unexpected output; got %v want %v", out, expect)
+		}
+	}
+}
+
+func TestStereoPanOutOfRange(t *testing.T) {
+	pattern := &drum.Pattern{
+		Tracks: []drum.Track{{
+			Name: "a",
+		}},
+	}
+	_, err := newStereoWithBeatDuration(pattern, map[string][]audio.Sample{"a": {1}}, map[string]float64{"a": 2}, 2)
+	if err == nil || err.Error() != `pan 2 for drum sound "a" out of range` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package drummachine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nf/sigourney/audio"
+
+	"github.com/rogpeppe/misc/drum/sequencer"
+)
+
+// Clicks played by the metronome: a higher and louder click
+// for the first beat of each bar and a lower one for the others.
+var (
+	accentClick = click(1760, 0.8)
+	normalClick = click(880, 0.5)
+)
+
+// Metronome returns an audio source that clicks on every beat at the
+// given tempo in beats per minute, with an accented click on the first
+// beat of each bar of beatsPerBar beats. It can be mixed with the
+// output of a Machine to practice along with a pattern.
+//
+// Metronome panics if the tempo is out of range or beatsPerBar is less
+// than one.
+func Metronome(tempo float32, beatsPerBar int) audio.Processor {
+	d, err := beatDuration(tempo)
+	if err != nil {
+		panic(err)
+	}
+	return newMetronome(d, beatsPerBar)
+}
+
+// newMetronome is like Metronome but allows the beat duration
+// to be specified directly which is useful for testing.
+func newMetronome(beatDuration int64, beatsPerBar int) *sequencer.Sequencer {
+	accent, normal := metronomeSources(beatDuration, beatsPerBar)
+	return sequencer.New(
+		[]sequencer.Source{accent, normal},
+		[][]audio.Sample{accentClick, normalClick},
+	)
+}
+
+// metronomeSources returns the sources for the accented first beat of
+// each bar and for the remaining beats.
+func metronomeSources(beatDuration int64, beatsPerBar int) (accent, normal sequencer.Source) {
+	if beatsPerBar < 1 {
+		panic(fmt.Errorf("invalid beats per bar %d", beatsPerBar))
+	}
+	barDuration := int64(beatsPerBar) * beatDuration
+	beats := make([]int64, beatsPerBar-1)
+	for i := range beats {
+		beats[i] = int64(i+1) * beatDuration
+	}
+	accent, err := sequencer.Repeat([]int64{0}, barDuration)
+	if err != nil {
+		panic(err)
+	}
+	normal, err = sequencer.Repeat(beats, barDuration)
+	if err != nil {
+		panic(err)
+	}
+	return accent, normal
+}
+
+// click returns a short decaying sine wave burst
+// of the given frequency in Hz and peak gain.
+func click(freq, gain float64) []audio.Sample {
+	const duration = SampleRate / 50
+	s := make([]audio.Sample, duration)
+	for i := range s {
+		t := float64(i) / SampleRate
+		s[i] = audio.Sample(gain * math.Sin(2*math.Pi*freq*t) * math.Exp(-200*t))
+	}
+	return s
+}
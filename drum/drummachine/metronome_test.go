@@ -0,0 +1,64 @@
+package drummachine
+
+import (
+	"testing"
+
+	"github.com/nf/sigourney/audio"
+)
+
+func TestMetronomeSources(t *testing.T) {
+	accent, normal := metronomeSources(10, 4)
+	for i, expect := range []int64{0, 40, 80} {
+		if got := accent.Next(); got != expect {
+			t.Errorf("incorrect accented click at step %d, got %d want %d", i, got, expect)
+		}
+	}
+	for i, expect := range []int64{10, 20, 30, 50, 60, 70, 90} {
+		if got := normal.Next(); got != expect {
+			t.Errorf("incorrect normal click at step %d, got %d want %d", i, got, expect)
+		}
+	}
+
+	// With one beat per bar, every click is accented.
+	accent, normal = metronomeSources(10, 1)
+	for i, expect := range []int64{0, 10, 20} {
+		if got := accent.Next(); got != expect {
+			t.Errorf("incorrect accented click at step %d, got %d want %d", i, got, expect)
+		}
+	}
+	if got := normal.Next(); got != 0x7fffffffffffffff {
+		t.Errorf("unexpected normal click at %d", got)
+	}
+}
+
+func TestMetronome(t *testing.T) {
+	const beatDuration = 1000
+	m := newMetronome(beatDuration, 3)
+	out := make([]audio.Sample, 3*beatDuration)
+	m.Process(out)
+	for beat := 0; beat < 3; beat++ {
+		expect := normalClick
+		if beat == 0 {
+			expect = accentClick
+		}
+		got := out[beat*beatDuration : (beat+1)*beatDuration]
+		for i, s := range got {
+			want := audio.Sample(0)
+			if i < len(expect) {
+				want = expect[i]
+			}
+			if s != want {
+				t.Fatalf("beat %d: unexpected sample %d; got %v want %v", beat, i, s, want)
+			}
+		}
+	}
+}
+
+func TestMetronomeInvalidBeatsPerBar(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic")
+		}
+	}()
+	Metronome(120, 0)
+}
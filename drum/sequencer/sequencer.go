@@ -5,22 +5,48 @@ package sequencer
 import (
 	"container/heap"
 	"fmt"
+	"math"
+	"sync"
 
 	"github.com/nf/sigourney/audio"
 )
 
-// sequencer implements the Sigourney sequencer module.
-type sequencer struct {
+// Sequencer implements the Sigourney sequencer module.
+// Its methods may be called concurrently with Process.
+type Sequencer struct {
+	// mu guards the fields below. Process holds
+	// it while producing output, so that sources
+	// can be muted from other goroutines.
+	mu sync.Mutex
+
 	// sources holds a heap of all the sources,
 	// with the closest event in sources[0].
 	sources sequence
 
 	// current holds all the patches that are currently
 	// playing.
-	current [][]audio.Sample
+	current []playing
+
+	// stereo holds whether the output is
+	// interleaved left and right samples.
+	stereo bool
 
 	// t holds the current sample time.
 	t int64
+
+	// stopAt holds the sample time from which
+	// no more patches will be started.
+	stopAt int64
+}
+
+// playing holds a patch that is currently playing.
+type playing struct {
+	// samples holds the samples remaining to be played.
+	samples []audio.Sample
+
+	// left and right hold the gains for the left and right
+	// channels. They are only used in stereo mode.
+	left, right audio.Sample
 }
 
 // New returns a new sequencer module that sequences
@@ -28,19 +54,54 @@ type sequencer struct {
 // For each value in sources, there must be an associated value
 // at the same index in patches that holds the patch to use for
 // the given source.
-func New(sources []Source, patches [][]audio.Sample) audio.Processor {
+func New(sources []Source, patches [][]audio.Sample) *Sequencer {
+	return newSequencer(sources, patches, nil)
+}
+
+// NewStereo is like New except that the resulting module
+// produces stereo output, writing interleaved left and right
+// samples, so the buffer passed to Process must have an even
+// length. For each source, there must be an associated value
+// in pans that holds the pan position of the source, from -1
+// (fully left) to 1 (fully right).
+func NewStereo(sources []Source, patches [][]audio.Sample, pans []float64) *Sequencer {
+	if len(pans) != len(sources) {
+		panic("not enough pan values for the number of sources")
+	}
+	return newSequencer(sources, patches, pans)
+}
+
+func newSequencer(sources []Source, patches [][]audio.Sample, pans []float64) *Sequencer {
 	if len(sources) != len(patches) {
 		panic("not enough patch samples for the number of sources")
 	}
-	var seq sequencer
+	seq := &Sequencer{
+		stereo: pans != nil,
+		stopAt: maxInt64,
+	}
 	for i, src := range sources {
-		seq.sources = append(seq.sources, &sourceInfo{
+		info := &sourceInfo{
+			index:  i,
 			next:   src.Next(),
 			source: src,
 			patch:  patches[i],
-		})
+		}
+		if pans != nil {
+			info.left, info.right = panGains(pans[i])
+		}
+		seq.sources = append(seq.sources, info)
 	}
-	return &seq
+	return seq
+}
+
+// panGains returns the left and right channel gains for the
+// given pan position, using a constant power pan law
+// so that the perceived loudness doesn't change as
+// a sound moves across the stereo field.
+func panGains(pan float64) (left, right audio.Sample) {
+	pan = math.Max(-1, math.Min(1, pan))
+	angle := (pan + 1) * math.Pi / 4
+	return audio.Sample(math.Cos(angle)), audio.Sample(math.Sin(angle))
 }
 
 // sourceInfo holds runtime info about the state of a
@@ -48,12 +109,24 @@ func New(sources []Source, patches [][]audio.Sample) audio.Processor {
 type sourceInfo struct {
 	source Source
 
+	// index holds the index of the source in
+	// the slice passed to New.
+	index int
+
+	// muted holds whether the source's
+	// patch is currently silenced.
+	muted bool
+
 	// next holds the most recent value returned by
 	// source.Next.
 	next int64
 
 	// patch holds the patch associated with the source.
 	patch []audio.Sample
+
+	// left and right hold the channel gains
+	// for the source when in stereo mode.
+	left, right audio.Sample
 }
 
 // sequence implements a time-ordered heap
@@ -95,12 +168,61 @@ type Source interface {
 
 const maxInt64 = int64(0x7fffffffffffffff)
 
-func (seq *sequencer) Process(out []audio.Sample) {
+// Mute sets whether the source at the given index in the slice
+// passed to New is muted. While a source is muted, its patch will
+// not be started, although any instances of the patch that are
+// already playing will continue to the end.
+func (seq *Sequencer) Mute(index int, muted bool) {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	for _, src := range seq.sources {
+		if src.index == index {
+			src.muted = muted
+			return
+		}
+	}
+	panic("source index out of range")
+}
+
+// StopAt arranges for no more patches to be started from the
+// given sample time onwards. Patches that are already playing
+// will continue to the end.
+func (seq *Sequencer) StopAt(t int64) {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	seq.stopAt = t
+}
+
+// Time returns the current sample time - the number of sample frames
+// that have been produced so far.
+func (seq *Sequencer) Time() int64 {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	return seq.t
+}
+
+// Process implements audio.Processor.Process.
+func (seq *Sequencer) Process(out []audio.Sample) {
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	channels := 1
+	if seq.stereo {
+		if len(out)%2 != 0 {
+			panic("odd length buffer for stereo output")
+		}
+		channels = 2
+	}
 	for len(out) > 0 {
 		for seq.t == seq.sources[0].next {
 			// The next event is triggered.
 			src := heap.Pop(&seq.sources).(*sourceInfo)
-			seq.current = append(seq.current, src.patch)
+			if !src.muted && seq.t < seq.stopAt {
+				seq.current = append(seq.current, playing{
+					samples: src.patch,
+					left:    src.left,
+					right:   src.right,
+				})
+			}
 			next := src.source.Next()
 			if next == src.next {
 				panic("source has returned non-increasing next value")
@@ -109,30 +231,41 @@ func (seq *sequencer) Process(out []audio.Sample) {
 			heap.Push(&seq.sources, src)
 		}
 		n := seq.sources[0].next - seq.t
-		if n > int64(len(out)) {
-			n = int64(len(out))
+		if frames := int64(len(out) / channels); n > frames {
+			n = frames
 		}
 		seq.processn(out, int(n))
-		out = out[n:]
+		out = out[n*int64(channels):]
 	}
 }
 
-// processn processes n samples into out.
+// processn processes n sample frames into out.
 // It updates seq.t and seq.current.
-func (seq *sequencer) processn(out []audio.Sample, n int) {
-	zero(out[0:n])
+func (seq *Sequencer) processn(out []audio.Sample, n int) {
+	if seq.stereo {
+		zero(out[0 : 2*n])
+	} else {
+		zero(out[0:n])
+	}
 	remove := false
-	for i, samples := range seq.current {
+	for i, p := range seq.current {
 		n := n
-		if n >= len(samples) {
+		if n >= len(p.samples) {
 			remove = true
-			n = len(samples)
+			n = len(p.samples)
 		}
-		// TODO optimize this inner loop.
-		for i, sample := range samples[0:n] {
-			out[i] += sample
+		// TODO optimize these inner loops.
+		if seq.stereo {
+			for i, sample := range p.samples[0:n] {
+				out[2*i] += sample * p.left
+				out[2*i+1] += sample * p.right
+			}
+		} else {
+			for i, sample := range p.samples[0:n] {
+				out[i] += sample
+			}
 		}
-		seq.current[i] = samples[n:]
+		seq.current[i].samples = p.samples[n:]
 	}
 	seq.t += int64(n)
 
@@ -140,9 +273,9 @@ func (seq *sequencer) processn(out []audio.Sample, n int) {
 		return
 	}
 	j := 0
-	for _, samples := range seq.current {
-		if len(samples) != 0 {
-			seq.current[j] = samples
+	for _, p := range seq.current {
+		if len(p.samples) != 0 {
+			seq.current[j] = p
 			j++
 		}
 	}
@@ -0,0 +1,144 @@
+package drum
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hexBeats holds the number of beats encoded by
+// each digit in the hexadecimal representation.
+const hexBeats = 4
+
+// StringHex is like String except that the beats of each track are
+// written compactly as hexadecimal digits, each digit encoding a group
+// of four beats as a bitmask with the first beat in the most
+// significant bit. For example, the beats |x---|x-x-|----|---x|
+// are written as 8a01.
+//
+// The result can be parsed with ParseText.
+func (p *Pattern) StringHex() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Saved with HW Version: %s\n", p.Version)
+	fmt.Fprintf(&buf, "Tempo: %g\n", p.Tempo)
+	for _, t := range p.Tracks {
+		fmt.Fprintf(&buf, "(%d) %s\t", t.Channel, t.Name)
+		for i := 0; i < len(t.Beats); i += hexBeats {
+			digit := 0
+			for j := 0; j < hexBeats; j++ {
+				digit <<= 1
+				if t.Beats[i+j] {
+					digit |= 1
+				}
+			}
+			buf.WriteByte("0123456789abcdef"[digit])
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// ParseText parses a pattern in the format produced by
+// Pattern.String or Pattern.StringHex. The two beat
+// formats may be mixed within the same pattern.
+func ParseText(s string) (*Pattern, error) {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("pattern too short")
+	}
+	var p Pattern
+	const versionPrefix = "Saved with HW Version: "
+	if !strings.HasPrefix(lines[0], versionPrefix) {
+		return nil, fmt.Errorf("line 1: missing version")
+	}
+	p.Version = strings.TrimPrefix(lines[0], versionPrefix)
+	const tempoPrefix = "Tempo: "
+	if !strings.HasPrefix(lines[1], tempoPrefix) {
+		return nil, fmt.Errorf("line 2: missing tempo")
+	}
+	tempo, err := strconv.ParseFloat(strings.TrimPrefix(lines[1], tempoPrefix), 32)
+	if err != nil {
+		return nil, fmt.Errorf("line 2: invalid tempo: %v", err)
+	}
+	p.Tempo = float32(tempo)
+	for i, line := range lines[2:] {
+		t, err := parseTrack(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+3, err)
+		}
+		p.Tracks = append(p.Tracks, t)
+	}
+	return &p, nil
+}
+
+// parseTrack parses a single track line of the form
+// "(channel) name\tbeats".
+func parseTrack(line string) (Track, error) {
+	var t Track
+	if !strings.HasPrefix(line, "(") {
+		return t, fmt.Errorf("missing channel number")
+	}
+	i := strings.Index(line, ") ")
+	if i == -1 {
+		return t, fmt.Errorf("missing channel number")
+	}
+	channel, err := strconv.Atoi(line[1:i])
+	if err != nil {
+		return t, fmt.Errorf("invalid channel number %q", line[1:i])
+	}
+	t.Channel = channel
+	line = line[i+2:]
+	i = strings.LastIndex(line, "\t")
+	if i == -1 {
+		return t, fmt.Errorf("missing beats")
+	}
+	t.Name = line[:i]
+	beats := line[i+1:]
+	if strings.HasPrefix(beats, "|") {
+		err = parseBars(t.Beats[:], beats)
+	} else {
+		err = parseHexBeats(t.Beats[:], beats)
+	}
+	if err != nil {
+		return t, fmt.Errorf("track %q: %v", t.Name, err)
+	}
+	return t, nil
+}
+
+// parseBars parses beats in the |x---|x---| format
+// produced by writeBeats into the given slice.
+func parseBars(beats []bool, s string) error {
+	s = strings.Replace(s, "|", "", -1)
+	if len(s) != len(beats) {
+		return fmt.Errorf("got %d beats; want %d", len(s), len(beats))
+	}
+	for i, c := range s {
+		switch c {
+		case 'x':
+			beats[i] = true
+		case '-':
+		default:
+			return fmt.Errorf("invalid beat character %q", c)
+		}
+	}
+	return nil
+}
+
+// parseHexBeats parses beats in the hexadecimal format
+// produced by StringHex into the given slice.
+func parseHexBeats(beats []bool, s string) error {
+	if want := len(beats) / hexBeats; len(s) != want {
+		return fmt.Errorf("got %d hex digits; want %d", len(s), want)
+	}
+	for i := 0; i < len(s); i++ {
+		digit, err := strconv.ParseUint(s[i:i+1], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid hex digit %q", s[i])
+		}
+		for j := 0; j < hexBeats; j++ {
+			beats[i*hexBeats+j] = digit&(1<<uint(hexBeats-1-j)) != 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package drum_test
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/rogpeppe/misc/drum"
+)
+
+func TestStringHex(t *testing.T) {
+	p := &drum.Pattern{
+		Version: "0.808-alpha",
+		Tempo:   98.4,
+		Tracks: []drum.Track{{
+			Channel: 1,
+			Name:    "kick",
+			Beats:   [drum.NumBeats]bool{0: true, 4: true, 6: true, 15: true},
+		}, {
+			Channel: 12,
+			Name:    "hh open",
+		}},
+	}
+	want := "Saved with HW Version: 0.808-alpha\n" +
+		"Tempo: 98.4\n" +
+		"(1) kick\t8a01\n" +
+		"(12) hh open\t0000\n"
+	if got := p.StringHex(); got != want {
+		t.Fatalf("unexpected output\nGot\n%s\nWant\n%s\n", got, want)
+	}
+}
+
+func TestParseTextRoundTrip(t *testing.T) {
+	for i, test := range decodeTests {
+		if test.expectError != "" {
+			continue
+		}
+		p, err := drum.Decode(bytes.NewReader(undump(test.data)))
+		if err != nil {
+			t.Fatalf("test %d: cannot decode: %v", i, err)
+		}
+		for _, s := range []string{p.String(), p.StringHex()} {
+			p1, err := drum.ParseText(s)
+			if err != nil {
+				t.Fatalf("test %d: cannot parse %q: %v", i, s, err)
+			}
+			if !reflect.DeepEqual(p1, p) {
+				t.Fatalf("test %d: round trip of %q gave %#v; want %#v", i, s, p1, p)
+			}
+		}
+	}
+}
+
+var parseTextErrorTests = []struct {
+	about       string
+	text        string
+	expectError string
+}{{
+	about:       "too few hex digits",
+	text:        "Saved with HW Version: 0.808\nTempo: 120\n(0) kick\t888\n",
+	expectError: `line 3: track "kick": got 3 hex digits; want 4`,
+}, {
+	about:       "too many hex digits",
+	text:        "Saved with HW Version: 0.808\nTempo: 120\n(0) kick\t88888\n",
+	expectError: `line 3: track "kick": got 5 hex digits; want 4`,
+}, {
+	about:       "bad hex digit",
+	text:        "Saved with HW Version: 0.808\nTempo: 120\n(0) kick\t88g8\n",
+	expectError: `line 3: track "kick": invalid hex digit 'g'`,
+}, {
+	about:       "wrong number of bar beats",
+	text:        "Saved with HW Version: 0.808\nTempo: 120\n(0) kick\t|x---|x---|\n",
+	expectError: `line 3: track "kick": got 8 beats; want 16`,
+}, {
+	about:       "bad tempo",
+	text:        "Saved with HW Version: 0.808\nTempo: fast\n",
+	expectError: `line 2: invalid tempo: .*`,
+}, {
+	about:       "missing version",
+	text:        "Tempo: 120\n(0) kick\t8888\n",
+	expectError: `line 1: missing version`,
+}, {
+	about:       "missing channel",
+	text:        "Saved with HW Version: 0.808\nTempo: 120\nkick\t8888\n",
+	expectError: `line 3: missing channel number`,
+}}
+
+func TestParseTextError(t *testing.T) {
+	for i, test := range parseTextErrorTests {
+		t.Logf("test %d: %s", i, test.about)
+		p, err := drum.ParseText(test.text)
+		if err == nil {
+			t.Fatalf("got no error; expected error matching %q", test.expectError)
+		}
+		ok, err1 := regexp.MatchString("^("+test.expectError+")$", err.Error())
+		if err1 != nil {
+			t.Fatalf("bad error pattern in test: %v", err1)
+		}
+		if !ok {
+			t.Fatalf("got unexpected error %q; want %q", err.Error(), test.expectError)
+		}
+		if p != nil {
+			t.Fatalf("non-nil return from error-returning ParseText")
+		}
+	}
+}
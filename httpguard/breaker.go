@@ -0,0 +1,133 @@
+package httpguard
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// CircuitBreakerParams holds the configuration of the circuit
+// breaker that stops requests being sent to a target that is
+// failing.
+//
+// A request fails when its target can't be reached or doesn't
+// respond within Params.UpstreamTimeout. After MaxFailures
+// consecutive failures for a host, the breaker opens and requests
+// to that host fail immediately with a 503 Service Unavailable
+// status for the Cooldown period. After that, requests are sent to
+// the target again: the breaker closes when one succeeds and opens
+// again as soon as one fails.
+type CircuitBreakerParams struct {
+	// MaxFailures holds the number of consecutive failures
+	// after which the breaker opens. If this is zero, 5 is used.
+	MaxFailures int
+	// Cooldown holds how long the breaker stays open.
+	// If this is zero, 30 seconds is used.
+	Cooldown time.Duration
+}
+
+var errCircuitOpen = errgo.New("circuit breaker open")
+
+// breakerTransport implements http.RoundTripper by
+// tracking failures of requests made with an underlying
+// transport, failing requests without trying them
+// while the circuit breaker for their host is open.
+type breakerTransport struct {
+	transport   http.RoundTripper
+	maxFailures int
+	cooldown    time.Duration
+	now         func() time.Time
+
+	mu sync.Mutex
+	// hosts holds the state of the breaker for
+	// each virtual host that has had a failure.
+	hosts map[string]*hostBreaker
+}
+
+type hostBreaker struct {
+	// failures holds the number of consecutive failures.
+	failures int
+	// openUntil holds when the breaker closes
+	// again after opening.
+	openUntil time.Time
+}
+
+// newBreakerTransport returns a transport that uses t to make requests,
+// applying the circuit breaker described by p. The now function
+// is used to find out the current time.
+func newBreakerTransport(t http.RoundTripper, p *CircuitBreakerParams, now func() time.Time) *breakerTransport {
+	bt := &breakerTransport{
+		transport:   t,
+		maxFailures: p.MaxFailures,
+		cooldown:    p.Cooldown,
+		now:         now,
+		hosts:       make(map[string]*hostBreaker),
+	}
+	if bt.maxFailures <= 0 {
+		bt.maxFailures = 5
+	}
+	if bt.cooldown <= 0 {
+		bt.cooldown = 30 * time.Second
+	}
+	return bt
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+// Like targetTransport, it relies on the director leaving
+// req.Host unchanged.
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.isOpen(req.Host) {
+		return nil, errCircuitOpen
+	}
+	resp, err := t.transport.RoundTrip(req)
+	// A request abandoned by the client says nothing
+	// about the health of the target.
+	if err == nil || req.Context().Err() == nil {
+		t.record(req.Host, err == nil)
+	}
+	return resp, err
+}
+
+// isOpen reports whether the breaker for the given host is open.
+func (t *breakerTransport) isOpen(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.hosts[host]
+	return b != nil && t.now().Before(b.openUntil)
+}
+
+// record records the outcome of a request to the given host.
+func (t *breakerTransport) record(host string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ok {
+		delete(t.hosts, host)
+		return
+	}
+	b := t.hosts[host]
+	if b == nil {
+		b = new(hostBreaker)
+		t.hosts[host] = b
+	}
+	b.failures++
+	if b.failures >= t.maxFailures {
+		log.Printf("circuit breaker for %q open after %d failures", host, b.failures)
+		b.openUntil = t.now().Add(t.cooldown)
+	}
+}
+
+// proxyErrorHandler is used as the reverse proxy's error handler. It
+// responds with a 503 Service Unavailable status when the circuit
+// breaker is open and 502 Bad Gateway otherwise, as the default error
+// handler does.
+func proxyErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	log.Printf("proxy error: %v", err)
+	if errgo.Cause(err) == errCircuitOpen {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -3,6 +3,8 @@
 package httpguard
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"log"
@@ -11,6 +13,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 	"gopkg.in/errgo.v1"
@@ -21,17 +24,73 @@ type Params struct {
 	// to the destination target URL. The URL scheme
 	// may only be "http" or "https" and its path
 	// must be empty.
-	Hosts           map[string]string
+	Hosts map[string]string
 	// Password holds the password that the
 	// client must provide to gain access.
 	// If this is empty, no authentication will take
 	// place - httpguard will just act as a proxy.
-	Password        string `json:"password"`
+	Password string `json:"password"`
 	// Port holds the port to listen on.
-	Port            int
+	Port int
+	// Secret holds the key used to sign authentication
+	// cookies. If this is empty, a random key will be used,
+	// so clients will need to authenticate again
+	// when the server restarts.
+	Secret []byte
+	// CookieName holds the name of the authentication cookie.
+	// If this is empty, a name derived from Secret will be used.
+	CookieName string
+	// CookieMaxAge holds how long an authentication cookie
+	// remains valid for. If this is zero, a month is used.
+	CookieMaxAge time.Duration
 	// AutocertManager holds the autocert manager to use.
 	// It should at least have Prompt and Cache set.
 	AutocertManager *autocert.Manager
+	// HealthPath holds the path of the health-check endpoint,
+	// which responds with 200 OK whenever the guard is running.
+	// If this is empty, "/healthz" is used.
+	HealthPath string
+	// ReadyPath holds the path of the readiness endpoint,
+	// which responds with 200 OK when at least one of the
+	// target hosts accepts connections.
+	// If this is empty, "/readyz" is used.
+	//
+	// The health and readiness endpoints require no
+	// authentication, and are only served for requests
+	// to hosts that aren't in Hosts, so that they can't
+	// shadow a proxied path.
+	ReadyPath string
+	// HealthPort holds a port on which to serve
+	// the health and readiness endpoints over plain HTTP.
+	// If it's zero, they're only served on the main port.
+	HealthPort int
+	// ResponseHeaders holds headers to add to all proxied
+	// responses, such as Strict-Transport-Security.
+	// They override any headers of the same name
+	// set by the backend.
+	ResponseHeaders map[string]string
+	// CORS holds the cross-origin resource sharing
+	// configuration. If it's nil, no CORS headers are
+	// added and preflight requests are passed through
+	// to the backend like any other request.
+	CORS *CORSParams
+	// UpstreamTLS holds a map from virtual hostname
+	// to the TLS configuration to use when connecting
+	// to its target, which must have the "https" scheme.
+	// Targets without an entry use the default
+	// configuration.
+	UpstreamTLS map[string]*UpstreamTLSParams
+	// UpstreamTimeout holds the maximum time to wait for
+	// a target to respond to a proxied request, from sending
+	// the request to receiving the response headers, so that
+	// a hung target can't hold connections open indefinitely.
+	// Websocket connections are not affected.
+	// If this is zero, there is no limit.
+	UpstreamTimeout time.Duration
+	// CircuitBreaker holds the configuration of the circuit
+	// breaker for proxied requests. If it's nil, requests are
+	// always sent to the target however often it fails.
+	CircuitBreaker *CircuitBreakerParams
 }
 
 type params struct {
@@ -42,6 +101,10 @@ type params struct {
 type target struct {
 	scheme string
 	host   string
+	// tlsConfig holds the TLS configuration to use
+	// when connecting to the target. If it's nil,
+	// the default configuration is used.
+	tlsConfig *tls.Config
 }
 
 // Serve starts serving the httpguard server.
@@ -54,6 +117,9 @@ func Serve(p0 Params) error {
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	if err := addUpstreamTLS(targets, p.UpstreamTLS); err != nil {
+		return errgo.Mask(err)
+	}
 	p.targets = targets
 	if p.AutocertManager == nil {
 		return errgo.New("no autocert manager provided")
@@ -64,6 +130,24 @@ func Serve(p0 Params) error {
 	if p.Port == 0 {
 		p.Port = 443
 	}
+	if len(p.Secret) == 0 {
+		p.Secret = make([]byte, 32)
+		if _, err := rand.Read(p.Secret); err != nil {
+			return errgo.Notef(err, "cannot generate secret")
+		}
+	}
+	if p.CookieName == "" {
+		p.CookieName = cookieNameForSecret(p.Secret)
+	}
+	if p.CookieMaxAge == 0 {
+		p.CookieMaxAge = 28 * 24 * time.Hour
+	}
+	if p.HealthPath == "" {
+		p.HealthPath = "/healthz"
+	}
+	if p.ReadyPath == "" {
+		p.ReadyPath = "/readyz"
+	}
 
 	tlsConfig := &tls.Config{
 		GetCertificate: func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -85,6 +169,15 @@ func Serve(p0 Params) error {
 		},
 	}
 	srv := newServer(p)
+	if p.HealthPort != 0 {
+		go func() {
+			healthSrv := &http.Server{
+				Addr:    fmt.Sprintf(":%d", p.HealthPort),
+				Handler: http.HandlerFunc(srv.serveHealthOnly),
+			}
+			log.Printf("health server exited: %v", healthSrv.ListenAndServe())
+		}()
+	}
 	httpSrv := &http.Server{
 		Addr:      fmt.Sprintf(":%d", p.Port),
 		Handler:   srv,
@@ -97,19 +190,45 @@ type server struct {
 	tlsConfig *tls.Config
 	p         params
 	proxy     http.Handler
+	signer    *tokenSigner
+	// now returns the current time. It is a field
+	// so that it can be changed for tests.
+	now func() time.Time
 }
 
 func newServer(p params) *server {
 	srv := &server{
 		p: p,
+		signer: &tokenSigner{
+			secret:   p.Secret,
+			password: p.Password,
+		},
+		now: time.Now,
+	}
+	var transport http.RoundTripper = newTargetTransport(p.targets, p.UpstreamTimeout)
+	if p.CircuitBreaker != nil {
+		transport = newBreakerTransport(transport, p.CircuitBreaker, func() time.Time {
+			return srv.now()
+		})
 	}
 	srv.proxy = &httputil.ReverseProxy{
-		Director: srv.director,
+		Director:     srv.director,
+		Transport:    transport,
+		ErrorHandler: proxyErrorHandler,
 	}
 	return srv
 }
 
 func (srv *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, ok := srv.p.targets[req.Host]; !ok && srv.serveHealth(w, req) {
+		return
+	}
+	if _, ok := srv.p.targets[req.Host]; ok && srv.p.CORS != nil && isPreflight(req) {
+		// Browsers don't send credentials with preflight
+		// requests, so answer them before authenticating.
+		srv.servePreflight(w, req)
+		return
+	}
 	if err := srv.auth(w, req); err != nil {
 		log.Printf("auth error: %v", err)
 		http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -123,44 +242,109 @@ func (srv *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	srv.proxy.ServeHTTP(w, req)
+	srv.proxy.ServeHTTP(&headerWriter{
+		ResponseWriter: w,
+		srv:            srv,
+		origin:         req.Header.Get("Origin"),
+	}, req)
+}
+
+// serveHealthOnly serves the health and readiness endpoints
+// and nothing else.
+func (srv *server) serveHealthOnly(w http.ResponseWriter, req *http.Request) {
+	if !srv.serveHealth(w, req) {
+		http.NotFound(w, req)
+	}
+}
+
+// serveHealth serves the request if it's for the health or readiness
+// endpoint and reports whether it has done so.
+func (srv *server) serveHealth(w http.ResponseWriter, req *http.Request) bool {
+	switch req.URL.Path {
+	case srv.p.HealthPath:
+		fmt.Fprintln(w, "ok")
+	case srv.p.ReadyPath:
+		if !srv.ready() {
+			http.Error(w, "no backends available", http.StatusServiceUnavailable)
+			return true
+		}
+		fmt.Fprintln(w, "ok")
+	default:
+		return false
+	}
+	return true
 }
 
-const cookieName = "httpproxy-dsafvljfnqpeoifnldavldksjnsa" // TODO think
+// readyTimeout holds the maximum time to wait
+// for a backend to accept a connection when
+// checking readiness.
+const readyTimeout = 2 * time.Second
+
+// ready reports whether at least one of the target
+// hosts accepts connections.
+func (srv *server) ready() bool {
+	if len(srv.p.targets) == 0 {
+		return false
+	}
+	c := make(chan bool, len(srv.p.targets))
+	for _, t := range srv.p.targets {
+		t := t
+		go func() {
+			conn, err := net.DialTimeout("tcp", t.host, readyTimeout)
+			if err != nil {
+				c <- false
+				return
+			}
+			conn.Close()
+			c <- true
+		}()
+	}
+	for range srv.p.targets {
+		if <-c {
+			return true
+		}
+	}
+	return false
+}
 
 func (srv *server) auth(w http.ResponseWriter, req *http.Request) error {
+	// Check the host first so that neither the reverse proxy
+	// nor the websocket proxy can see a request without a target,
+	// even when authenticated by cookie or no password is required.
+	if _, ok := srv.p.targets[req.Host]; !ok {
+		return errgo.Newf("unknown host %q", req.Host)
+	}
 	if srv.p.Password == "" {
 		return nil
 	}
-	cookie, err := req.Cookie(cookieName)
+	cookie, err := req.Cookie(srv.p.CookieName)
 	if err == nil {
-		log.Printf("cookie password %q", cookie.Value)
-		if cookie.Value == srv.p.Password {
+		err := srv.signer.verify(cookie.Value, srv.now())
+		if err == nil {
 			return nil
 		}
-		log.Printf("cookie auth failed; value %q", cookie.Value)
+		log.Printf("cookie auth failed: %v", err)
 	}
 	if err := srv.passwordAuth(w, req); err != nil {
 		return errgo.Mask(err)
 	}
-	if _, ok := srv.p.targets[req.Host]; !ok {
-		return errgo.Newf("unknown host %q", req.Host)
-	}
 	return nil
 }
 
 func (srv *server) passwordAuth(w http.ResponseWriter, req *http.Request) error {
 	values, _ := url.ParseQuery(req.URL.RawQuery)
-	log.Printf("url password %q", values.Get("pass"))
 	// TODO serve up a password-entry form instead.
 	// TODO distinguish between expired creds and wrong creds?
-	if values.Get("pass") != srv.p.Password {
+	if !hmac.Equal([]byte(values.Get("pass")), []byte(srv.p.Password)) {
 		return errgo.New("invalid password")
 	}
+	maxAge := srv.p.CookieMaxAge
 	setCookie(w.Header(), &http.Cookie{
-		Name:   cookieName,
-		Value:  srv.p.Password,    // TODO should probably not store password in plaintext.
-		MaxAge: 28 * 24 * 60 * 60, // a month
+		Name:     srv.p.CookieName,
+		Value:    srv.signer.newToken(srv.now().Add(maxAge)),
+		MaxAge:   int(maxAge / time.Second),
+		Secure:   true,
+		HttpOnly: true,
 	})
 	return nil
 }
@@ -231,3 +415,24 @@ func parseURLs(urls map[string]string) (map[string]target, error) {
 	}
 	return targets, nil
 }
+
+// addUpstreamTLS sets the TLS configuration of the
+// targets from the given upstream TLS parameters.
+func addUpstreamTLS(targets map[string]target, params map[string]*UpstreamTLSParams) error {
+	for name, tp := range params {
+		t, ok := targets[name]
+		if !ok {
+			return errgo.Newf("TLS parameters for unknown host %q", name)
+		}
+		if t.scheme != "https" {
+			return errgo.Newf("TLS parameters for non-https host %q", name)
+		}
+		cfg, err := tp.tlsConfig()
+		if err != nil {
+			return errgo.Notef(err, "bad TLS parameters for host %q", name)
+		}
+		t.tlsConfig = cfg
+		targets[name] = t
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+package httpguard
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebsocketUnknownHost(t *testing.T) {
+	var p params
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: "localhost:80"},
+	}
+	srv := newServer(p)
+	req := httptest.NewRequest("GET", "https://other.com/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.Password = "secret"
+	p.HealthPath = "/healthz"
+	p.ReadyPath = "/readyz"
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: u.Host},
+	}
+	srv := newServer(p)
+
+	tests := []struct {
+		url        string
+		expectCode int
+	}{
+		{"http://10.0.0.1/healthz", http.StatusOK},
+		{"http://10.0.0.1/readyz", http.StatusOK},
+		{"http://10.0.0.1/other", http.StatusUnauthorized},
+		// The endpoints don't shadow paths on proxied hosts.
+		{"http://example.com/healthz", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != test.expectCode {
+			t.Errorf("%s: got status %d want %d", test.url, w.Code, test.expectCode)
+		}
+	}
+
+	// When no backend is available, the guard isn't ready
+	// but is still healthy.
+	backend.Close()
+	for _, test := range []struct {
+		path       string
+		expectCode int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		srv.serveHealthOnly(w, req)
+		if w.Code != test.expectCode {
+			t.Errorf("%s after backend close: got status %d want %d", test.path, w.Code, test.expectCode)
+		}
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Frame-Options", "ALLOW")
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.ResponseHeaders = map[string]string{
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Frame-Options":           "DENY",
+	}
+	p.CORS = &CORSParams{
+		AllowedOrigins: []string{"https://app.example.com"},
+	}
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: u.Host},
+	}
+	srv := newServer(p)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	if got := w.Body.String(); got != "backend" {
+		t.Errorf("unexpected body %q", got)
+	}
+	for name, want := range map[string]string{
+		"Strict-Transport-Security":   "max-age=31536000",
+		"X-Frame-Options":             "DENY",
+		"Access-Control-Allow-Origin": "https://app.example.com",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("header %s: got %q want %q", name, got, want)
+		}
+	}
+
+	// A disallowed origin gets no CORS headers.
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("unexpected Access-Control-Allow-Origin %q", got)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	backendCalled := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		backendCalled = true
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.Password = "secret"
+	p.CORS = &CORSParams{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+	}
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: u.Host},
+	}
+	srv := newServer(p)
+
+	tests := []struct {
+		origin        string
+		expectCode    int
+		expectOrigin  string
+		expectMethods string
+	}{{
+		origin:        "https://app.example.com",
+		expectCode:    http.StatusNoContent,
+		expectOrigin:  "https://app.example.com",
+		expectMethods: "GET, PUT",
+	}, {
+		origin:     "https://evil.example.com",
+		expectCode: http.StatusForbidden,
+	}}
+	for _, test := range tests {
+		req := httptest.NewRequest("OPTIONS", "http://example.com/foo", nil)
+		req.Header.Set("Origin", test.origin)
+		req.Header.Set("Access-Control-Request-Method", "PUT")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != test.expectCode {
+			t.Errorf("%s: got status %d want %d", test.origin, w.Code, test.expectCode)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.expectOrigin {
+			t.Errorf("%s: got allowed origin %q want %q", test.origin, got, test.expectOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != test.expectMethods {
+			t.Errorf("%s: got allowed methods %q want %q", test.origin, got, test.expectMethods)
+		}
+	}
+	if backendCalled {
+		t.Errorf("preflight request reached the backend")
+	}
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.CORS = &CORSParams{
+		AllowedOrigins: []string{"*", "https://app.example.com"},
+	}
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: u.Host},
+	}
+	srv := newServer(p)
+
+	tests := []struct {
+		origin            string
+		expectOrigin      string
+		expectCredentials string
+	}{{
+		origin:            "https://app.example.com",
+		expectOrigin:      "https://app.example.com",
+		expectCredentials: "true",
+	}, {
+		origin:       "https://evil.example.com",
+		expectOrigin: "*",
+	}}
+	for _, test := range tests {
+		for _, method := range []string{"GET", "OPTIONS"} {
+			req := httptest.NewRequest(method, "http://example.com/", nil)
+			req.Header.Set("Origin", test.origin)
+			if method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.expectOrigin {
+				t.Errorf("%s %s: got allowed origin %q want %q", method, test.origin, got, test.expectOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != test.expectCredentials {
+				t.Errorf("%s %s: got allow credentials %q want %q", method, test.origin, got, test.expectCredentials)
+			}
+		}
+	}
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	var serverName string
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serverName = req.TLS.ServerName
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: backend.Certificate().Raw,
+	})
+
+	tests := []struct {
+		about            string
+		params           *UpstreamTLSParams
+		expectCode       int
+		expectServerName string
+	}{{
+		about:      "default configuration doesn't trust the backend",
+		expectCode: http.StatusBadGateway,
+	}, {
+		about: "custom CA",
+		params: &UpstreamTLSParams{
+			CACerts: caCert,
+		},
+		expectCode: http.StatusOK,
+	}, {
+		about: "custom CA with server name",
+		params: &UpstreamTLSParams{
+			CACerts:    caCert,
+			ServerName: "example.com",
+		},
+		expectCode:       http.StatusOK,
+		expectServerName: "example.com",
+	}, {
+		about: "server name not in certificate",
+		params: &UpstreamTLSParams{
+			CACerts:    caCert,
+			ServerName: "other.com",
+		},
+		expectCode: http.StatusBadGateway,
+	}, {
+		about: "insecure",
+		params: &UpstreamTLSParams{
+			InsecureSkipVerify: true,
+		},
+		expectCode: http.StatusOK,
+	}}
+	for _, test := range tests {
+		serverName = ""
+		targets, err := parseURLs(map[string]string{
+			"example.com": "https://" + u.Host,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.params != nil {
+			err := addUpstreamTLS(targets, map[string]*UpstreamTLSParams{
+				"example.com": test.params,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var p params
+		p.targets = targets
+		srv := newServer(p)
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != test.expectCode {
+			t.Errorf("%s: got status %d want %d", test.about, w.Code, test.expectCode)
+			continue
+		}
+		if serverName != test.expectServerName {
+			t.Errorf("%s: got server name %q want %q", test.about, serverName, test.expectServerName)
+		}
+	}
+}
+
+func TestAddUpstreamTLSErrors(t *testing.T) {
+	tests := []struct {
+		params      map[string]*UpstreamTLSParams
+		expectError string
+	}{{
+		params: map[string]*UpstreamTLSParams{
+			"unknown.com": {},
+		},
+		expectError: `TLS parameters for unknown host "unknown.com"`,
+	}, {
+		params: map[string]*UpstreamTLSParams{
+			"plain.com": {},
+		},
+		expectError: `TLS parameters for non-https host "plain.com"`,
+	}, {
+		params: map[string]*UpstreamTLSParams{
+			"secure.com": {CACerts: []byte("not a certificate")},
+		},
+		expectError: `bad TLS parameters for host "secure.com": no CA certificates found`,
+	}}
+	for _, test := range tests {
+		targets, err := parseURLs(map[string]string{
+			"plain.com":  "http://10.0.0.1",
+			"secure.com": "https://10.0.0.2",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = addUpstreamTLS(targets, test.params)
+		if err == nil || err.Error() != test.expectError {
+			t.Errorf("got error %v want %q", err, test.expectError)
+		}
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	defer close(release)
+	targets, err := parseURLs(map[string]string{
+		"example.com": backend.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.targets = targets
+	p.UpstreamTimeout = 50 * time.Millisecond
+	srv := newServer(p)
+
+	req := httptest.NewRequest("GET", "http://example.com/fast", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d want %d", w.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest("GET", "http://example.com/slow", nil)
+	w = httptest.NewRecorder()
+	start := time.Now()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("got status %d want %d", w.Code, http.StatusBadGateway)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("request took too long (%v)", d)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		failing = true
+		calls   = 0
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if failing {
+			// Drop the connection without responding.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			conn.Close()
+			return
+		}
+		w.Write([]byte("backend"))
+	}))
+	// Don't let the transport retry requests on
+	// a reused connection.
+	backend.Config.SetKeepAlivesEnabled(false)
+	defer backend.Close()
+	targets, err := parseURLs(map[string]string{
+		"example.com": backend.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p params
+	p.targets = targets
+	p.CircuitBreaker = &CircuitBreakerParams{
+		MaxFailures: 3,
+		Cooldown:    time.Minute,
+	}
+	srv := newServer(p)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time {
+		return now
+	}
+	assertRequest := func(about string, expectCode, expectCalls int) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		mu.Lock()
+		defer mu.Unlock()
+		if w.Code != expectCode {
+			t.Fatalf("%s: got status %d want %d", about, w.Code, expectCode)
+		}
+		if calls != expectCalls {
+			t.Fatalf("%s: got %d backend calls want %d", about, calls, expectCalls)
+		}
+	}
+	setFailing := func(f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failing = f
+	}
+	assertRequest("first failure", http.StatusBadGateway, 1)
+	assertRequest("second failure", http.StatusBadGateway, 2)
+	assertRequest("third failure", http.StatusBadGateway, 3)
+	assertRequest("breaker open", http.StatusServiceUnavailable, 3)
+
+	setFailing(false)
+	now = now.Add(59 * time.Second)
+	assertRequest("breaker still open", http.StatusServiceUnavailable, 3)
+
+	now = now.Add(time.Second)
+	assertRequest("breaker closed after cooldown", http.StatusOK, 4)
+
+	// After a success, the failure count starts again.
+	setFailing(true)
+	assertRequest("failure after closing", http.StatusBadGateway, 5)
+	assertRequest("second failure after closing", http.StatusBadGateway, 6)
+	setFailing(false)
+	assertRequest("breaker not open", http.StatusOK, 7)
+
+	// When a request fails after the cooldown,
+	// the breaker opens again immediately.
+	setFailing(true)
+	for i := 0; i < 3; i++ {
+		assertRequest("failure", http.StatusBadGateway, 8+i)
+	}
+	assertRequest("breaker reopened", http.StatusServiceUnavailable, 10)
+	now = now.Add(time.Minute)
+	assertRequest("failure after cooldown", http.StatusBadGateway, 11)
+	assertRequest("breaker reopened immediately", http.StatusServiceUnavailable, 11)
+}
@@ -0,0 +1,141 @@
+package httpguard
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSParams holds the cross-origin resource sharing
+// configuration for the guard.
+type CORSParams struct {
+	// AllowedOrigins holds the origins that are allowed
+	// to make cross-origin requests. The value "*" allows
+	// any origin, but only without credentials: browsers
+	// won't send the authentication cookie with requests from
+	// origins that match only "*", so they can't read
+	// guarded responses.
+	AllowedOrigins []string
+	// AllowedMethods holds the methods allowed in
+	// cross-origin requests. If this is empty,
+	// GET, HEAD and POST are allowed.
+	AllowedMethods []string
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// allowOrigin reports whether the given origin is allowed to make
+// cross-origin requests and, if so, whether it may make them with
+// credentials, which is only the case for origins that are
+// explicitly listed.
+func (c *CORSParams) allowOrigin(origin string) (allowed, credentials bool) {
+	if origin == "" {
+		return false, false
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == origin {
+			return true, true
+		}
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// setOriginHeaders sets the headers that allow the given origin
+// to make a cross-origin request, and reports whether it is allowed.
+func (c *CORSParams) setOriginHeaders(h http.Header, origin string) bool {
+	allowed, credentials := c.allowOrigin(origin)
+	if !allowed {
+		return false
+	}
+	if credentials {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Del("Access-Control-Allow-Credentials")
+	}
+	h.Add("Vary", "Origin")
+	return true
+}
+
+func (c *CORSParams) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+// isPreflight reports whether req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// servePreflight answers a CORS preflight request directly
+// without consulting the backend.
+func (srv *server) servePreflight(w http.ResponseWriter, req *http.Request) {
+	cors := srv.p.CORS
+	origin := req.Header.Get("Origin")
+	h := w.Header()
+	if !cors.setOriginHeaders(h, origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(cors.methods(), ", "))
+	if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headerWriter wraps an http.ResponseWriter so that the
+// configured response headers are added to the response
+// after the reverse proxy has copied the backend's headers,
+// overriding any that the backend has set.
+type headerWriter struct {
+	http.ResponseWriter
+	srv         *server
+	origin      string
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setHeaders()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(buf []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// Flush implements http.Flusher so that the reverse
+// proxy can flush streamed responses.
+func (w *headerWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *headerWriter) setHeaders() {
+	h := w.Header()
+	for name, value := range w.srv.p.ResponseHeaders {
+		h.Set(name, value)
+	}
+	if cors := w.srv.p.CORS; cors != nil {
+		cors.setOriginHeaders(h, w.origin)
+	}
+}
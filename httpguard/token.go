@@ -0,0 +1,69 @@
+package httpguard
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// tokenSigner creates and verifies the tokens stored in
+// the authentication cookie. A token holds its expiry time
+// and an HMAC of that time keyed on the server secret,
+// so the password itself is never stored in the cookie.
+type tokenSigner struct {
+	secret []byte
+	// password holds the password that the token
+	// grants access for. It is included in the MAC
+	// so that changing the password invalidates all
+	// existing tokens.
+	password string
+}
+
+const macSize = sha256.Size
+
+// newToken returns a new token that expires at the given time.
+func (s *tokenSigner) newToken(expiry time.Time) string {
+	buf := make([]byte, 8, 8+macSize)
+	binary.BigEndian.PutUint64(buf, uint64(expiry.Unix()))
+	buf = append(buf, s.mac(buf)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// verify checks that the given token was created by s
+// and has not expired at the given time.
+func (s *tokenSigner) verify(token string, now time.Time) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != 8+macSize {
+		return errgo.New("malformed token")
+	}
+	if !hmac.Equal(data[8:], s.mac(data[:8])) {
+		return errgo.New("invalid token signature")
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+	if !now.Before(expiry) {
+		return errgo.Newf("token expired at %v", expiry)
+	}
+	return nil
+}
+
+func (s *tokenSigner) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(s.password))
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// cookieNameForSecret returns a cookie name derived from the
+// given secret, so that different guards sharing a domain
+// don't trample on one another's cookies.
+func cookieNameForSecret(secret []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("cookie-name"))
+	return "httpguard-" + hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,141 @@
+package httpguard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTokenRoundTrip(t *testing.T) {
+	s := &tokenSigner{secret: []byte("secret"), password: "pass"}
+	tok := s.newToken(epoch.Add(time.Hour))
+	if strings.Contains(tok, "pass") {
+		t.Fatalf("token %q contains password", tok)
+	}
+	if err := s.verify(tok, epoch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	s := &tokenSigner{secret: []byte("secret"), password: "pass"}
+	tok := s.newToken(epoch.Add(time.Hour))
+	err := s.verify(tok, epoch.Add(time.Hour))
+	if err == nil || !strings.HasPrefix(err.Error(), "token expired") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+var tokenTamperTests = []struct {
+	about       string
+	tamper      func(s *tokenSigner, tok string) string
+	expectError string
+}{{
+	about: "different secret",
+	tamper: func(s *tokenSigner, tok string) string {
+		s.secret = []byte("other")
+		return tok
+	},
+	expectError: "invalid token signature",
+}, {
+	about: "different password",
+	tamper: func(s *tokenSigner, tok string) string {
+		s.password = "other"
+		return tok
+	},
+	expectError: "invalid token signature",
+}, {
+	about: "modified expiry",
+	tamper: func(s *tokenSigner, tok string) string {
+		// Change the first character, which is part of the expiry time.
+		if tok[0] == 'A' {
+			return "B" + tok[1:]
+		}
+		return "A" + tok[1:]
+	},
+	expectError: "invalid token signature",
+}, {
+	about: "truncated",
+	tamper: func(s *tokenSigner, tok string) string {
+		return tok[:len(tok)-2]
+	},
+	expectError: "malformed token",
+}, {
+	about: "bad base64",
+	tamper: func(s *tokenSigner, tok string) string {
+		return "!" + tok[1:]
+	},
+	expectError: "malformed token",
+}, {
+	about: "plaintext password",
+	tamper: func(s *tokenSigner, tok string) string {
+		return s.password
+	},
+	expectError: "malformed token",
+}}
+
+func TestTokenTampering(t *testing.T) {
+	for _, test := range tokenTamperTests {
+		t.Run(test.about, func(t *testing.T) {
+			s := &tokenSigner{secret: []byte("secret"), password: "pass"}
+			tok := test.tamper(s, s.newToken(epoch.Add(time.Hour)))
+			err := s.verify(tok, epoch)
+			if err == nil || err.Error() != test.expectError {
+				t.Fatalf("got error %v want %q", err, test.expectError)
+			}
+		})
+	}
+}
+
+func TestAuthCookie(t *testing.T) {
+	var p params
+	p.Password = "pass"
+	p.Secret = []byte("secret")
+	p.CookieName = cookieNameForSecret(p.Secret)
+	p.CookieMaxAge = time.Hour
+	p.targets = map[string]target{
+		"example.com": {scheme: "http", host: "localhost:80"},
+	}
+	srv := newServer(p)
+	now := epoch
+	srv.now = func() time.Time { return now }
+
+	// Without a cookie or password, access is denied.
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	if err := srv.auth(httptest.NewRecorder(), req); err == nil {
+		t.Fatalf("expected error with no credentials")
+	}
+
+	// The password results in a cookie that doesn't contain it.
+	req = httptest.NewRequest("GET", "https://example.com/?pass=pass", nil)
+	w := httptest.NewRecorder()
+	if err := srv.auth(w, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != p.CookieName {
+		t.Fatalf("unexpected cookie name %q", cookie.Name)
+	}
+	if cookie.Value == p.Password {
+		t.Fatalf("cookie holds plaintext password")
+	}
+
+	// The cookie grants access until it expires.
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	if err := srv.auth(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := srv.auth(httptest.NewRecorder(), req); err == nil {
+		t.Fatalf("expected error with expired cookie")
+	}
+}
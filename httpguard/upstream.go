@@ -0,0 +1,75 @@
+package httpguard
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// UpstreamTLSParams holds the TLS configuration used
+// when connecting to an https target.
+type UpstreamTLSParams struct {
+	// CACerts holds PEM-encoded certificates of the
+	// authorities trusted to sign the target's certificate.
+	// If this is empty, the system roots are used.
+	CACerts []byte
+	// ServerName holds the name sent to the target with SNI
+	// and used to verify its certificate. If this is empty,
+	// the host name from the target URL is used, which
+	// is no good when the target is addressed by IP address.
+	ServerName string
+	// InsecureSkipVerify disables verification of the target's
+	// certificate. This should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// tlsConfig returns the TLS client configuration
+// described by p.
+func (p *UpstreamTLSParams) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         p.ServerName,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+	if len(p.CACerts) > 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(p.CACerts) {
+			return nil, errgo.New("no CA certificates found")
+		}
+	}
+	return cfg, nil
+}
+
+// targetTransport implements http.RoundTripper by using
+// a transport specific to the virtual host of each request,
+// so that each target can have its own TLS configuration.
+type targetTransport map[string]http.RoundTripper
+
+// newTargetTransport returns a transport that uses a
+// custom TLS configuration for all the targets that have one
+// and waits at most the given time for response headers
+// unless it's zero.
+func newTargetTransport(targets map[string]target, timeout time.Duration) targetTransport {
+	t := make(targetTransport)
+	for name, target := range targets {
+		if target.tlsConfig == nil && timeout == 0 {
+			continue
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = target.tlsConfig
+		transport.ResponseHeaderTimeout = timeout
+		t[name] = transport
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+// It relies on the director leaving req.Host unchanged.
+func (t targetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t[req.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
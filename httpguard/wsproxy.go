@@ -19,14 +19,14 @@ func serveWSProxy(target target, w http.ResponseWriter, r *http.Request) error {
 	var err error
 	switch target.scheme {
 	case "https":
-		d, err = tls.Dial("tcp", target.host, nil)
+		d, err = tls.Dial("tcp", target.host, target.tlsConfig)
 	case "http":
 		d, err = net.Dial("tcp", target.host)
 	default:
 		panic("unreachable")
 	}
 	if err != nil {
-		return errgo.Notef(err, "error dialing websocket backend %s", target)
+		return errgo.Notef(err, "error dialing websocket backend %s", target.host)
 	}
 
 	nc, _, err := hj.Hijack()
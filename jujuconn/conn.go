@@ -3,7 +3,15 @@
 package jujuconn
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/juju/errors"
 	"github.com/juju/juju/api"
@@ -11,7 +19,9 @@ import (
 	"github.com/juju/juju/jujuclient"
 	"github.com/juju/persistent-cookiejar"
 	"github.com/juju/utils"
+	"gopkg.in/macaroon-bakery.v2-unstable/bakery"
 	"gopkg.in/macaroon-bakery.v2-unstable/httpbakery"
+	"gopkg.in/macaroon-bakery.v2-unstable/httpbakery/agent"
 )
 
 var (
@@ -20,26 +30,74 @@ var (
 )
 
 type Params struct {
+	// BakeryClient holds the client used to acquire macaroons.
+	// If it is nil, a client will be created for each controller
+	// that keeps cookies in a persistent cookie jar (see
+	// CookieFile) and uses either a web browser or agent
+	// authentication (see AgentFile) to log in.
 	BakeryClient *httpbakery.Client
+
+	// CookieFile holds the path of the cookie jar used for all
+	// controllers when BakeryClient is nil. If it is empty, the
+	// file named by $JUJU_COOKIEFILE is used if that's set;
+	// otherwise each controller has its own jar in the file that
+	// the juju command uses for it (see jujuclient.JujuCookiePath).
+	//
+	// A jar is loaded when a controller is first dialed and saved
+	// when the context is closed, so discharge macaroons are reused
+	// across invocations.
+	CookieFile string
+
+	// AgentFile holds the path to an agent authentication file
+	// as used by the bakery agent package. If it is non-empty
+	// and BakeryClient is nil, agent authentication will be used
+	// for all the agents in the file, and any login that requires
+	// user interaction will fail rather than opening a web browser.
+	AgentFile string
+
+	// Retry configures how dialing is retried after a transient
+	// failure, such as when the controller refuses connections
+	// during failover. If Retry.Attempts is zero, dials are
+	// not retried.
+	Retry RetryParams
+}
+
+// RetryParams holds parameters for retrying failed dials.
+// Only errors that indicate that the controller is temporarily
+// unavailable (connection refused or timed out) are retried; other
+// errors, such as authentication failures, are returned immediately.
+type RetryParams struct {
+	// Attempts holds the maximum number of times to try
+	// dialing, including the first attempt.
+	Attempts int
+
+	// Delay holds the delay before the first retry. Each
+	// subsequent delay is twice as long as the last.
+	// If it is zero, one second is used.
+	Delay time.Duration
+
+	// MaxDelay holds the maximum delay between retries.
+	// If it is zero, there is no maximum.
+	MaxDelay time.Duration
 }
 
 func NewContextWithParams(p Params) (*Context, error) {
 	if err := Init(); err != nil {
 		return nil, errors.Trace(err)
 	}
-	var ctxt Context
-	if p.BakeryClient == nil {
-		jar, err := cookiejar.New(nil)
+	ctxt := Context{
+		bakeryClient: p.BakeryClient,
+		cookieFile:   p.CookieFile,
+		clients:      make(map[string]*bakeryClient),
+	}
+	if p.BakeryClient == nil && p.AgentFile != "" {
+		info, err := readAgentFile(p.AgentFile)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		ctxt.jar = jar
-		p.BakeryClient = httpbakery.NewClient()
-		p.BakeryClient.Jar = jar
-		p.BakeryClient.VisitWebPage = httpbakery.OpenWebBrowser
+		ctxt.agentInfo = info
 	}
 	dialOpts := api.DefaultDialOpts()
-	dialOpts.BakeryClient = p.BakeryClient
 	store := jujuclient.NewFileClientStore()
 	cstore, err := newCacheStore(store)
 	if err != nil {
@@ -47,22 +105,176 @@ func NewContextWithParams(p Params) (*Context, error) {
 	}
 	ctxt.store = cstore
 	ctxt.dialOpts = dialOpts
+	ctxt.retry = p.Retry
 	return &ctxt, nil
 }
 
+// cookieFile returns the path of the cookie jar file to use for the
+// given controller, given the value of Params.CookieFile.
+func cookieFile(path, controller string) string {
+	if path != "" {
+		return path
+	}
+	if path := os.Getenv("JUJU_COOKIEFILE"); path != "" {
+		return path
+	}
+	return jujuclient.JujuCookiePath(controller)
+}
+
 type Context struct {
 	store    *cacheStore
-	jar      *cookiejar.Jar
 	dialOpts api.DialOpts
+	retry    RetryParams
+
+	// bakeryClient holds the client from Params.BakeryClient.
+	// If it is nil, the clients below are used instead.
+	bakeryClient *httpbakery.Client
+
+	// cookieFile and agentInfo hold the cookie file and
+	// the contents of the agent file from Params.
+	cookieFile string
+	agentInfo  *agentAuthInfo
+
+	// mu guards clients.
+	mu sync.Mutex
+	// clients holds the bakery client created for
+	// each cookie jar file, keyed by file name.
+	clients map[string]*bakeryClient
 }
 
+// bakeryClient holds a bakery client and the persistent
+// cookie jar that it uses.
+type bakeryClient struct {
+	client *httpbakery.Client
+	jar    *cookiejar.Jar
+}
+
+// NewContext returns a new context suitable for interactive use:
+// if a login is required, it will open a web browser to do so.
+// Cookies are kept in the juju command's cookie jar for each
+// controller (see Params.CookieFile) and saved when the context
+// is closed.
 func NewContext() (*Context, error) {
 	return NewContextWithParams(Params{})
 }
 
+// NewAgentContext returns a new context suitable for non-interactive
+// use, such as in server-side tools, that authenticates using
+// the agent credentials in the given agent file. If agentFile is
+// empty, the file named by the $BAKERY_AGENT_FILE environment
+// variable is used.
+//
+// If a login requires user interaction, dialing will fail
+// rather than opening a web browser.
+func NewAgentContext(agentFile string) (*Context, error) {
+	if agentFile == "" {
+		agentFile = os.Getenv("BAKERY_AGENT_FILE")
+		if agentFile == "" {
+			return nil, errors.New("no agent file specified and $BAKERY_AGENT_FILE not set")
+		}
+	}
+	return NewContextWithParams(Params{
+		AgentFile: agentFile,
+	})
+}
+
+// agentAuthInfo holds the contents of an agent authentication file.
+type agentAuthInfo struct {
+	Key    *bakery.KeyPair `json:"key"`
+	Agents []agentInfo     `json:"agents"`
+}
+
+// agentInfo holds the details of an agent login
+// to the discharger at the given URL.
+type agentInfo struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+}
+
+// readAgentFile reads the agent credentials from the
+// given agent file.
+func readAgentFile(agentFile string) (*agentAuthInfo, error) {
+	data, err := ioutil.ReadFile(agentFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read agent file")
+	}
+	var info agentAuthInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.Annotatef(err, "cannot parse agent file %q", agentFile)
+	}
+	if info.Key == nil {
+		return nil, errors.Errorf("no key found in agent file %q", agentFile)
+	}
+	if len(info.Agents) == 0 {
+		return nil, errors.Errorf("no agents found in agent file %q", agentFile)
+	}
+	return &info, nil
+}
+
+// setUpAgentAuth configures bclient to log in with the given
+// agent credentials. The agent cookies are added to bclient.Jar,
+// so this must be called after the jar has been set.
+func setUpAgentAuth(bclient *httpbakery.Client, info *agentAuthInfo) error {
+	bclient.Key = info.Key
+	// Agent logins never need to visit a web page, so if one is
+	// requested, it's because there's no agent configured for the
+	// discharger.
+	bclient.VisitWebPage = func(u *url.URL) error {
+		return errors.Errorf("interactive login required at %v but only agent authentication is configured", u)
+	}
+	for _, a := range info.Agents {
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return errors.Annotatef(err, "invalid agent URL %q", a.URL)
+		}
+		if err := agent.SetUpAuth(bclient, u, a.Username); err != nil {
+			return errors.Annotatef(err, "cannot set up agent authentication for %q", a.URL)
+		}
+	}
+	return nil
+}
+
+// bakeryClientFor returns the bakery client to use when
+// dialing the given controller.
+func (ctxt *Context) bakeryClientFor(controller string) (*httpbakery.Client, error) {
+	if ctxt.bakeryClient != nil {
+		return ctxt.bakeryClient, nil
+	}
+	path := cookieFile(ctxt.cookieFile, controller)
+	ctxt.mu.Lock()
+	defer ctxt.mu.Unlock()
+	if c := ctxt.clients[path]; c != nil {
+		return c.client, nil
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{
+		Filename: path,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot load cookie jar")
+	}
+	bclient := httpbakery.NewClient()
+	bclient.Jar = jar
+	if ctxt.agentInfo == nil {
+		bclient.VisitWebPage = httpbakery.OpenWebBrowser
+	} else if err := setUpAgentAuth(bclient, ctxt.agentInfo); err != nil {
+		return nil, errors.Trace(err)
+	}
+	ctxt.clients[path] = &bakeryClient{
+		client: bclient,
+		jar:    jar,
+	}
+	return bclient, nil
+}
+
+// Close releases the resources associated with the context,
+// saving any cookies acquired while using it.
 func (ctxt *Context) Close() error {
-	if ctxt.jar != nil {
-		return ctxt.jar.Save()
+	ctxt.mu.Lock()
+	defer ctxt.mu.Unlock()
+	for path, c := range ctxt.clients {
+		if err := c.jar.Save(); err != nil {
+			return errors.Annotatef(err, "cannot save cookie jar %q", path)
+		}
 	}
 	return nil
 }
@@ -96,6 +308,72 @@ func DialController(controller string) (api.Connection, error) {
 	return ctxt.DialController(controller)
 }
 
+// WithModel is like Context.WithModel except that it uses a new
+// context created with NewContext, which is closed before it returns.
+func WithModel(controller, model string, f func(api.Connection) error) error {
+	return withContext(func(ctxt *Context) error {
+		return ctxt.WithModel(controller, model, f)
+	})
+}
+
+// WithController is like Context.WithController except that it uses a
+// new context created with NewContext, which is closed before it
+// returns.
+func WithController(controller string, f func(api.Connection) error) error {
+	return withContext(func(ctxt *Context) error {
+		return ctxt.WithController(controller, f)
+	})
+}
+
+// withContext calls f with a new context, closing it afterwards.
+// If f succeeds, any error from closing the context is returned.
+func withContext(f func(*Context) error) (err error) {
+	ctxt, err := NewContext()
+	if err != nil {
+		return errors.Annotatef(err, "cannot make context")
+	}
+	defer func() {
+		if cerr := ctxt.Close(); cerr != nil && err == nil {
+			err = errors.Annotatef(cerr, "cannot close context")
+		}
+	}()
+	return errors.Trace(f(ctxt))
+}
+
+// WithModel dials the given controller and model as for DialModel,
+// calls f with the resulting connection and returns the error it
+// returns. The connection is closed when f returns, even if it panics,
+// so f must not retain it.
+func (ctxt *Context) WithModel(controller, model string, f func(api.Connection) error) error {
+	return withConn(func() (api.Connection, error) {
+		return ctxt.DialModel(controller, model)
+	}, f)
+}
+
+// WithController is like WithModel but makes a controller-only
+// connection as for DialController.
+func (ctxt *Context) WithController(controller string, f func(api.Connection) error) error {
+	return withConn(func() (api.Connection, error) {
+		return ctxt.DialController(controller)
+	}, f)
+}
+
+// withConn calls f with a connection obtained by calling dial,
+// closing it afterwards. If f succeeds, any error from closing
+// the connection is returned.
+func withConn(dial func() (api.Connection, error), f func(api.Connection) error) (err error) {
+	conn, err := dial()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = errors.Annotatef(cerr, "cannot close connection")
+		}
+	}()
+	return f(conn)
+}
+
 // DialModel makes an API connection to the given controller
 // and model names. If the controller name is empty,
 // the default controller will be used. If the model name
@@ -148,6 +426,23 @@ func (ctxt *Context) DialController(controller string) (api.Connection, error) {
 	return d.Dial()
 }
 
+// PingController dials the controller with the given name (the current
+// controller if it is empty) and returns the time taken for a single
+// API ping round trip. The time taken to dial the controller is not
+// included.
+func (ctxt *Context) PingController(controller string) (time.Duration, error) {
+	conn, err := ctxt.DialController(controller)
+	if err != nil {
+		return 0, errors.Annotatef(err, "cannot dial controller")
+	}
+	defer conn.Close()
+	t0 := time.Now()
+	if err := conn.Ping(); err != nil {
+		return 0, errors.Annotatef(err, "ping failed")
+	}
+	return time.Since(t0), nil
+}
+
 func (ctxt *Context) ControllerDialer(controller string) (*Dialer, error) {
 	if controller == "" {
 		c, err := ctxt.store.origStore.CurrentController()
@@ -182,12 +477,63 @@ type Dialer struct {
 	modelUUID  string
 }
 
+// Dial makes an API connection, retrying as configured by
+// Params.Retry.
 func (d *Dialer) Dial() (api.Connection, error) {
+	return d.DialContext(context.Background())
+}
+
+// DialContext is like Dial except that it stops retrying, returning
+// the most recent error, if the given context is done before a
+// connection is made. A dial attempt already in progress is not
+// interrupted.
+func (d *Dialer) DialContext(ctx context.Context) (api.Connection, error) {
+	return dialWithRetry(ctx, d.ctxt.retry, d.dial, time.After)
+}
+
+// dialWithRetry implements Dialer.DialContext by calling dial until it
+// succeeds or fails as described by retry. It calls after to wait for
+// the delay between attempts.
+func dialWithRetry(ctx context.Context, retry RetryParams, dial func() (api.Connection, error), after func(time.Duration) <-chan time.Time) (api.Connection, error) {
+	delay := retry.Delay
+	if delay == 0 {
+		delay = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		c, err := dial()
+		if err == nil {
+			return c, nil
+		}
+		if attempt >= retry.Attempts || !isRetryable(err) {
+			return nil, errors.Trace(err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return nil, errors.Annotatef(err, "giving up after %d attempts", attempt)
+		}
+		select {
+		case <-after(delay):
+		case <-ctx.Done():
+			return nil, errors.Annotatef(err, "giving up after %d attempts", attempt)
+		}
+		delay *= 2
+		if retry.MaxDelay > 0 && delay > retry.MaxDelay {
+			delay = retry.MaxDelay
+		}
+	}
+}
+
+func (d *Dialer) dial() (api.Connection, error) {
+	dialOpts := d.ctxt.dialOpts
+	bclient, err := d.ctxt.bakeryClientFor(d.controller)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dialOpts.BakeryClient = bclient
 	c, err := juju.NewAPIConnection(juju.NewAPIConnectionParams{
 		ControllerName: d.controller,
 		Store:          d.ctxt.store,
 		OpenAPI:        api.Open,
-		DialOpts:       d.ctxt.dialOpts,
+		DialOpts:       dialOpts,
 		AccountDetails: d.account,
 		ModelUUID:      d.modelUUID,
 	})
@@ -197,6 +543,29 @@ func (d *Dialer) Dial() (api.Connection, error) {
 	return c, nil
 }
 
+// isRetryable reports whether the given dial error
+// is likely to be transient.
+func isRetryable(err error) bool {
+	err = errors.Cause(err)
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+		if sysErr, ok := err.(*os.SyscallError); ok {
+			err = sysErr.Err
+		}
+	}
+	switch err {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
 // cacheStore avoids disk access when dialing.
 // It implements only those methods required by
 // juju.NewAPIConnection.
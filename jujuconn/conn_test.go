@@ -0,0 +1,419 @@
+package jujuconn
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/juju/osenv"
+	"github.com/juju/juju/jujuclient"
+)
+
+// fakeConn is an api.Connection that records when it is closed.
+// Calling any other method will panic.
+type fakeConn struct {
+	api.Connection
+	closed   bool
+	closeErr error
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return c.closeErr
+}
+
+func TestWithConnClosesAfterReturn(t *testing.T) {
+	conn := &fakeConn{}
+	called := false
+	err := withConn(func() (api.Connection, error) {
+		return conn, nil
+	}, func(c api.Connection) error {
+		if c != conn {
+			t.Errorf("unexpected connection %v", c)
+		}
+		if conn.closed {
+			t.Errorf("connection closed before f returned")
+		}
+		called = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatalf("f was not called")
+	}
+	if !conn.closed {
+		t.Fatalf("connection not closed")
+	}
+}
+
+func TestWithConnClosesAfterError(t *testing.T) {
+	conn := &fakeConn{
+		closeErr: errors.New("close error"),
+	}
+	ferr := errors.New("some error")
+	err := withConn(func() (api.Connection, error) {
+		return conn, nil
+	}, func(api.Connection) error {
+		return ferr
+	})
+	if err != ferr {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.closed {
+		t.Fatalf("connection not closed")
+	}
+}
+
+func TestWithConnReturnsCloseError(t *testing.T) {
+	conn := &fakeConn{
+		closeErr: errors.New("close error"),
+	}
+	err := withConn(func() (api.Connection, error) {
+		return conn, nil
+	}, func(api.Connection) error {
+		return nil
+	})
+	if err == nil || err.Error() != "cannot close connection: close error" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWithConnClosesOnPanic(t *testing.T) {
+	conn := &fakeConn{}
+	func() {
+		defer func() {
+			if r := recover(); r != "oops" {
+				t.Errorf("unexpected panic value %v", r)
+			}
+		}()
+		withConn(func() (api.Connection, error) {
+			return conn, nil
+		}, func(api.Connection) error {
+			panic("oops")
+		})
+	}()
+	if !conn.closed {
+		t.Fatalf("connection not closed after panic")
+	}
+}
+
+func TestWithConnDialError(t *testing.T) {
+	called := false
+	err := withConn(func() (api.Connection, error) {
+		return nil, errors.New("dial error")
+	}, func(api.Connection) error {
+		called = true
+		return nil
+	})
+	if err == nil || err.Error() != "dial error" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called {
+		t.Fatalf("f called after dial failure")
+	}
+}
+
+var cookieFileTests = []struct {
+	about      string
+	path       string
+	env        string
+	controller string
+	expect     string
+}{{
+	about:      "explicit path",
+	path:       "/tmp/cookies",
+	env:        "/tmp/envcookies",
+	controller: "ctl",
+	expect:     "/tmp/cookies",
+}, {
+	about:      "environment variable",
+	env:        "/tmp/envcookies",
+	controller: "ctl",
+	expect:     "/tmp/envcookies",
+}, {
+	about:      "per-controller juju cookie file",
+	controller: "ctl",
+	expect:     jujuclient.JujuCookiePath("ctl"),
+}}
+
+func TestCookieFile(t *testing.T) {
+	defer os.Setenv("JUJU_COOKIEFILE", os.Getenv("JUJU_COOKIEFILE"))
+	for _, test := range cookieFileTests {
+		os.Setenv("JUJU_COOKIEFILE", test.env)
+		if got := cookieFile(test.path, test.controller); got != test.expect {
+			t.Errorf("%s: got %q want %q", test.about, got, test.expect)
+		}
+	}
+}
+
+func TestBakeryClientPerController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jujuconn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer osenv.SetJujuXDGDataHome(osenv.SetJujuXDGDataHome(dir))
+	defer os.Setenv("JUJU_COOKIEFILE", os.Getenv("JUJU_COOKIEFILE"))
+	os.Unsetenv("JUJU_COOKIEFILE")
+	if err := os.Mkdir(filepath.Join(dir, "cookies"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ctxt := &Context{
+		clients: make(map[string]*bakeryClient),
+	}
+	c1, err := ctxt.bakeryClientFor("ctl1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c1again, err := ctxt.bakeryClientFor("ctl1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c1again != c1 {
+		t.Fatalf("client not reused for the same controller")
+	}
+	c2, err := ctxt.bakeryClientFor("ctl2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c2 == c1 || c2.Jar == c1.Jar {
+		t.Fatalf("client or jar shared between controllers")
+	}
+	for _, controller := range []string{"ctl1", "ctl2"} {
+		path := jujuclient.JujuCookiePath(controller)
+		if filepath.Dir(path) != filepath.Join(dir, "cookies") {
+			t.Fatalf("unexpected cookie path %q", path)
+		}
+		if ctxt.clients[path] == nil {
+			t.Errorf("no client for cookie file %q", path)
+		}
+	}
+	if err := ctxt.Close(); err != nil {
+		t.Fatalf("cannot close context: %v", err)
+	}
+}
+
+// timeoutError implements net.Error for a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func dialError(err error) error {
+	return &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: &os.SyscallError{
+			Syscall: "connect",
+			Err:     err,
+		},
+	}
+}
+
+var isRetryableTests = []struct {
+	about  string
+	err    error
+	expect bool
+}{{
+	about:  "connection refused",
+	err:    dialError(syscall.ECONNREFUSED),
+	expect: true,
+}, {
+	about:  "annotated connection refused",
+	err:    errors.Annotate(dialError(syscall.ECONNREFUSED), "cannot dial"),
+	expect: true,
+}, {
+	about: "connection refused in URL error",
+	err: &url.Error{
+		Op:  "Get",
+		URL: "wss://0.1.2.3:17070/api",
+		Err: dialError(syscall.ECONNREFUSED),
+	},
+	expect: true,
+}, {
+	about:  "connection reset",
+	err:    dialError(syscall.ECONNRESET),
+	expect: true,
+}, {
+	about:  "host unreachable",
+	err:    dialError(syscall.EHOSTUNREACH),
+	expect: true,
+}, {
+	about: "timeout",
+	err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: timeoutError{},
+	},
+	expect: true,
+}, {
+	about: "timeout in URL error",
+	err: &url.Error{
+		Op:  "Get",
+		URL: "wss://0.1.2.3:17070/api",
+		Err: timeoutError{},
+	},
+	expect: true,
+}, {
+	about:  "authentication failure",
+	err:    errors.Unauthorizedf("invalid entity name or password"),
+	expect: false,
+}, {
+	about:  "annotated authentication failure",
+	err:    errors.Annotate(errors.Unauthorizedf("invalid entity name or password"), "cannot log in"),
+	expect: false,
+}, {
+	about:  "permission denied",
+	err:    dialError(syscall.EACCES),
+	expect: false,
+}, {
+	about:  "other error",
+	err:    errors.New("something went wrong"),
+	expect: false,
+}}
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range isRetryableTests {
+		if got := isRetryable(test.err); got != test.expect {
+			t.Errorf("%s: got %v want %v", test.about, got, test.expect)
+		}
+	}
+}
+
+// fakeDialer records calls to its dial and after methods,
+// which are suitable for passing to dialWithRetry.
+type fakeDialer struct {
+	// errs holds the errors returned by successive dial calls.
+	// When there are none left, dial succeeds.
+	errs   []error
+	dials  int
+	delays []time.Duration
+}
+
+func (d *fakeDialer) dial() (api.Connection, error) {
+	d.dials++
+	if len(d.errs) == 0 {
+		return &fakeConn{}, nil
+	}
+	err := d.errs[0]
+	d.errs = d.errs[1:]
+	return nil, err
+}
+
+func (d *fakeDialer) after(delay time.Duration) <-chan time.Time {
+	d.delays = append(d.delays, delay)
+	c := make(chan time.Time, 1)
+	c <- time.Time{}
+	return c
+}
+
+func refusedErrors(n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = dialError(syscall.ECONNREFUSED)
+	}
+	return errs
+}
+
+var dialWithRetryTests = []struct {
+	about        string
+	retry        RetryParams
+	errs         []error
+	expectDials  int
+	expectDelays []time.Duration
+	expectError  bool
+}{{
+	about:       "no retries",
+	errs:        refusedErrors(1),
+	expectDials: 1,
+	expectError: true,
+}, {
+	about: "success after retries",
+	retry: RetryParams{
+		Attempts: 5,
+		Delay:    time.Second,
+	},
+	errs:         refusedErrors(2),
+	expectDials:  3,
+	expectDelays: []time.Duration{time.Second, 2 * time.Second},
+}, {
+	about: "attempts exhausted with capped delay",
+	retry: RetryParams{
+		Attempts: 5,
+		Delay:    time.Second,
+		MaxDelay: 3 * time.Second,
+	},
+	errs:         refusedErrors(10),
+	expectDials:  5,
+	expectDelays: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+	expectError:  true,
+}, {
+	about: "default delay",
+	retry: RetryParams{
+		Attempts: 3,
+	},
+	errs:         refusedErrors(10),
+	expectDials:  3,
+	expectDelays: []time.Duration{time.Second, 2 * time.Second},
+	expectError:  true,
+}, {
+	about: "authentication failure not retried",
+	retry: RetryParams{
+		Attempts: 5,
+	},
+	errs:        []error{errors.Unauthorizedf("invalid entity name or password")},
+	expectDials: 1,
+	expectError: true,
+}}
+
+func TestDialWithRetry(t *testing.T) {
+	for _, test := range dialWithRetryTests {
+		d := &fakeDialer{
+			errs: test.errs,
+		}
+		conn, err := dialWithRetry(context.Background(), test.retry, d.dial, d.after)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected error, got none", test.about)
+			}
+		} else if err != nil || conn == nil {
+			t.Errorf("%s: unexpected error %v", test.about, err)
+		}
+		if d.dials != test.expectDials {
+			t.Errorf("%s: got %d dials want %d", test.about, d.dials, test.expectDials)
+		}
+		if !reflect.DeepEqual(d.delays, test.expectDelays) {
+			t.Errorf("%s: got delays %v want %v", test.about, d.delays, test.expectDelays)
+		}
+	}
+}
+
+func TestDialWithRetryCancel(t *testing.T) {
+	d := &fakeDialer{
+		errs: refusedErrors(10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := dialWithRetry(ctx, RetryParams{Attempts: 5}, d.dial, func(time.Duration) <-chan time.Time {
+		return nil
+	})
+	if err == nil || err.Error() != "giving up after 1 attempts: dial tcp: connect: connection refused" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.dials != 1 {
+		t.Fatalf("got %d dials want 1", d.dials)
+	}
+}
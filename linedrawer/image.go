@@ -0,0 +1,86 @@
+package linedrawer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// ImageLineDrawer is a LineDrawer that renders
+// lines into an image rather than onto the screen.
+type ImageLineDrawer interface {
+	LineDrawer
+
+	// Image returns the image holding the most recently drawn
+	// lines, one pixel per cell, with the oldest line at the top.
+	// The image is updated in place by subsequent calls to DrawLine.
+	Image() *image.RGBA
+
+	// EncodePNG writes the current image to w in PNG format.
+	EncodePNG(w io.Writer) error
+}
+
+// NewImageDrawer returns an ImageLineDrawer that renders lines
+// of numCells cells into an image numCells pixels wide and numRows
+// pixels high. When more than numRows lines have been drawn, the
+// image scrolls so that it always shows the most recent lines.
+//
+// State i is shown as palette[i]; if palette is nil, the same
+// default palette used by Main is used. It panics if the palette
+// does not have at least numStates entries.
+func NewImageDrawer(numCells, numStates, numRows int, palette []color.RGBA) ImageLineDrawer {
+	if palette == nil {
+		palette = defaultPalette
+	}
+	if numStates > len(palette) {
+		panic(fmt.Sprintf("too many states for available colors (%d states, %d colors)", numStates, len(palette)))
+	}
+	return &imageDrawer{
+		numCells: numCells,
+		palette:  palette[:numStates],
+		img:      image.NewRGBA(image.Rect(0, 0, numCells, numRows)),
+	}
+}
+
+type imageDrawer struct {
+	numCells int
+	palette  []color.RGBA
+	img      *image.RGBA
+
+	// numDrawn holds the number of rows currently
+	// drawn in img.
+	numDrawn int
+}
+
+// DrawLine implements LineDrawer.DrawLine.
+func (d *imageDrawer) DrawLine(cells []int) {
+	if len(cells) != d.numCells {
+		panic("unexpected cell count")
+	}
+	numRows := d.img.Rect.Dy()
+	if numRows == 0 {
+		return
+	}
+	row := d.numDrawn
+	if row == numRows {
+		// The image is full; scroll it up by one row.
+		copy(d.img.Pix, d.img.Pix[d.img.Stride:])
+		row--
+	} else {
+		d.numDrawn++
+	}
+	off := d.img.PixOffset(0, row)
+	fillRow(d.img.Pix[off:off+4*d.numCells], cells, d.palette)
+}
+
+// Image implements ImageLineDrawer.Image.
+func (d *imageDrawer) Image() *image.RGBA {
+	return d.img
+}
+
+// EncodePNG implements ImageLineDrawer.EncodePNG.
+func (d *imageDrawer) EncodePNG(w io.Writer) error {
+	return png.Encode(w, d.img)
+}
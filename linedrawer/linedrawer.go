@@ -51,9 +51,15 @@ func setLogging(on bool) {
 
 const (
 	tickDuration = time.Second / 30
+
+	// historyRows holds the maximum number of rows
+	// retained for scrolling back through.
+	historyRows = 10000
 )
 
-var colors = []color.RGBA{
+// defaultPalette holds the colors used when no
+// palette is explicitly provided.
+var defaultPalette = []color.RGBA{
 	colorBlack.rgba(),
 	colorRed.rgba(),
 	colorOrchid.rgba(),
@@ -63,9 +69,21 @@ var colors = []color.RGBA{
 type NewFunc func(numCells, numStates int) (LineDrawer, error)
 
 func Main(f func(NewFunc)) {
+	MainWithPalette(nil, f)
+}
+
+// MainWithPalette is like Main except that line drawers
+// created by the NewFunc will use the given palette to
+// display cell states: state i is shown as palette[i].
+// If palette is nil, a default palette of four colors is used.
+func MainWithPalette(palette []color.RGBA, f func(NewFunc)) {
+	if palette == nil {
+		palette = defaultPalette
+	}
 	driver.Main(func(s screen.Screen) {
 		ctxt := context{
-			screen: s,
+			screen:  s,
+			palette: palette,
 		}
 		f(ctxt.new)
 	})
@@ -74,6 +92,7 @@ func Main(f func(NewFunc)) {
 type drawer struct {
 	numCells  int
 	numStates int
+	palette   []color.RGBA
 
 	paintNotifier paintNotifier
 	mu            sync.Mutex
@@ -91,6 +110,11 @@ type drawer struct {
 	// TODO this should probably be specified in pixels not rows.
 	rowDisplay int
 
+	// scrolled holds whether the user has scrolled back
+	// through the history. When it's true, rowDisplay
+	// does not follow newly drawn rows.
+	scrolled bool
+
 	// numRows hows the number of rows that can be displayed on the
 	// screen. Each buffer holds this many rows of pixels.
 	numRows int
@@ -116,12 +140,13 @@ type drawer struct {
 }
 
 type context struct {
-	screen screen.Screen
+	screen  screen.Screen
+	palette []color.RGBA
 }
 
 func (ctxt *context) new(numCells, numStates int) (LineDrawer, error) {
-	if numStates > len(colors) {
-		return nil, errgo.Newf("too many states for available colors")
+	if numStates > len(ctxt.palette) {
+		return nil, errgo.Newf("too many states for available colors (%d states, %d colors)", numStates, len(ctxt.palette))
 	}
 	w, err := ctxt.screen.NewWindow(nil)
 	if err != nil {
@@ -133,6 +158,7 @@ func (ctxt *context) new(numCells, numStates int) (LineDrawer, error) {
 
 		numCells:  numCells,
 		numStates: numStates,
+		palette:   ctxt.palette,
 	}
 	d.paintNotifier.setQueue(w)
 	go d.paintNotifier.run()
@@ -196,6 +222,20 @@ func (p *paintNotifier) painted() {
 	p.paintedGeneration = p.generation
 }
 
+// main runs the event loop for the window.
+//
+// The escape key quits; the spacebar pauses and resumes display
+// updates; the L key toggles logging.
+//
+// The up and down arrow keys scroll back and forward through
+// the retained history by a row at a time, and the page up and
+// page down keys by a screenful at a time. The home key goes
+// to the oldest retained row and the end key returns to the
+// most recent rows. While scrolled back, the display does not
+// follow new rows; scrolling forward to the most recent rows
+// resumes following them. Scrolling redraws the display even
+// when paused, so the history can be reviewed while the
+// display is frozen.
 func (d *drawer) main() {
 	paused := false
 	logging := false
@@ -219,6 +259,11 @@ func (d *drawer) main() {
 				logging = !logging
 				setLogging(logging)
 			}
+			if e.Direction != key.DirRelease && d.scrollKey(e.Code) {
+				d.paint()
+				d.win.Publish()
+				d.paintNotifier.painted()
+			}
 
 		case paint.Event:
 			if paused {
@@ -240,6 +285,59 @@ func (d *drawer) main() {
 	}
 }
 
+// scrollKey scrolls the display according to the
+// given key code and reports whether the key
+// was a scrolling key.
+func (d *drawer) scrollKey(code key.Code) bool {
+	switch code {
+	case key.CodeUpArrow:
+		d.scroll(-1)
+	case key.CodeDownArrow:
+		d.scroll(1)
+	case key.CodePageUp:
+		d.scroll(-d.numRows)
+	case key.CodePageDown:
+		d.scroll(d.numRows)
+	case key.CodeHome:
+		d.scroll(-historyRows)
+	case key.CodeEnd:
+		d.scroll(historyRows)
+	default:
+		return false
+	}
+	return true
+}
+
+// scroll moves the displayed rows by the given number of rows,
+// keeping the display within the retained history.
+func (d *drawer) scroll(delta int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	live := d.liveRow()
+	d.rowDisplay += delta
+	if d.rowDisplay < d.row0 {
+		d.rowDisplay = d.row0
+	}
+	if d.rowDisplay >= live {
+		d.rowDisplay = live
+		d.scrolled = false
+	} else {
+		d.scrolled = true
+	}
+	logf("scrolled to row %d (live %d)", d.rowDisplay, live)
+}
+
+// liveRow returns the row to display at the top of
+// the screen when following the most recent rows.
+// It must be called with d.mu held.
+func (d *drawer) liveRow() int {
+	row := d.row0 + len(d.rows) - d.numRows
+	if row < d.row0 {
+		row = d.row0
+	}
+	return row
+}
+
 type releaser interface {
 	Release()
 }
@@ -353,12 +451,17 @@ func (d *drawer) fillBuffers() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if len(d.rows) > d.numRows {
-		extra := len(d.rows) - d.numRows
+	if len(d.rows) > historyRows {
+		extra := len(d.rows) - historyRows
 		d.rows = d.rows[extra:]
 		d.row0 += extra
 	}
-	d.rowDisplay = d.row0
+	if !d.scrolled {
+		d.rowDisplay = d.liveRow()
+	} else if d.rowDisplay < d.row0 {
+		// The rows we were looking at have been discarded.
+		d.rowDisplay = d.row0
+	}
 	row1 := d.row0 + len(d.rows)
 	logf("filling buffers; rows %d %d; bufp %d %d; display rows %d", d.row0, row1, d.bufp0, d.bufp1, d.numRows)
 
@@ -379,7 +482,7 @@ func (d *drawer) fillBuffers() {
 	}
 	logf("use: %d %d", usep0, usep1)
 	var fillp0, fillp1 int
-	if usep0 < usep1 {
+	if usep0 < usep1 && dp0 >= d.bufp0 {
 		// We can use [usep0, usep1]
 		if usep0-d.bufp0 > d.numRows {
 			// The cached area we can use starts
@@ -392,7 +495,9 @@ func (d *drawer) fillBuffers() {
 		}
 		fillp0, fillp1 = usep1, dp1
 	} else {
-		// No buffered rows. Start filling from the start of buf0.
+		// No buffered rows (or we've scrolled back before
+		// the start of the buffered rows). Start filling
+		// from the start of buf0.
 		fillp0, fillp1 = dp0, dp1
 		d.bufp0 = d.rowDisplay
 		d.bufp1 = d.rowDisplay
@@ -441,11 +546,18 @@ func (d *drawer) fillBuffers() {
 }
 
 func (d *drawer) fillRow(pix []byte, cells []int) {
+	fillRow(pix, cells, d.palette[:d.numStates])
+}
+
+// fillRow fills pix with the RGBA pixels representing
+// the given cells, one pixel per cell. The color of
+// a cell in state i is palette[i].
+func fillRow(pix []byte, cells []int, palette []color.RGBA) {
 	for i, c := range cells {
-		if c < 0 || c >= d.numStates {
+		if c < 0 || c >= len(palette) {
 			panic("cell value out of range")
 		}
-		rgb := colors[c]
+		rgb := palette[c]
 		pix1 := pix[i*4:]
 		pix1[0] = rgb.R
 		pix1[1] = rgb.G
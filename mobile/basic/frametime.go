@@ -0,0 +1,108 @@
+// +build darwin linux
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/exp/gl/glutil"
+	"golang.org/x/mobile/geom"
+)
+
+// frameTimeSamples holds the number of frames that
+// the average frame time is calculated over.
+const frameTimeSamples = 30
+
+// frameTime draws the average time between frames,
+// just above the debug.FPS display.
+type frameTime struct {
+	sz       size.Event
+	images   *glutil.Images
+	m        *glutil.Image
+	lastDraw time.Time
+	// samples holds the most recent frame times
+	// in a circular buffer.
+	samples [frameTimeSamples]time.Duration
+	n       int
+}
+
+func newFrameTime(images *glutil.Images) *frameTime {
+	return &frameTime{
+		images:   images,
+		lastDraw: time.Now(),
+	}
+}
+
+// average returns the average of the recorded frame times.
+func (f *frameTime) average() time.Duration {
+	n := f.n
+	if n > len(f.samples) {
+		n = len(f.samples)
+	}
+	if n == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range f.samples[:n] {
+		total += d
+	}
+	return total / time.Duration(n)
+}
+
+// Draw records the time since the last frame and
+// draws the average frame time in milliseconds.
+func (f *frameTime) Draw(sz size.Event) {
+	const (
+		fpsH       = 9 // Height of the debug.FPS display.
+		chars      = 10
+		imgW, imgH = chars*7 + 2, 15
+	)
+	now := time.Now()
+	f.samples[f.n%len(f.samples)] = now.Sub(f.lastDraw)
+	f.n++
+	f.lastDraw = now
+
+	if sz.WidthPx == 0 && sz.HeightPx == 0 {
+		return
+	}
+	if f.sz != sz {
+		f.sz = sz
+		if f.m != nil {
+			f.m.Release()
+		}
+		f.m = f.images.NewImage(imgW, imgH)
+	}
+	draw.Draw(f.m.RGBA, f.m.RGBA.Bounds(), image.White, image.Point{}, draw.Src)
+	d := font.Drawer{
+		Dst:  f.m.RGBA,
+		Src:  image.Black,
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(1, basicfont.Face7x13.Ascent+1),
+	}
+	ms := float64(f.average()) / float64(time.Millisecond)
+	d.DrawString(fmt.Sprintf("%5.1f ms", ms))
+	f.m.Upload()
+	top := sz.HeightPt - fpsH - imgH
+	f.m.Draw(
+		sz,
+		geom.Point{0, top},
+		geom.Point{imgW, top},
+		geom.Point{0, top + imgH},
+		f.m.RGBA.Bounds(),
+	)
+}
+
+func (f *frameTime) Release() {
+	if f.m != nil {
+		f.m.Release()
+		f.m = nil
+		f.images = nil
+	}
+}
@@ -4,7 +4,10 @@
 
 // +build darwin linux
 
-// An app that draws a green triangle on a red background.
+// An app that draws a triangle on a colored background.
+// Touching the top or bottom half of the screen (or pressing
+// c or b on the desktop) cycles the triangle or background
+// color respectively.
 //
 // Note: This demo is an early preview of Go 1.5. In order to build this
 // program as an Android APK using the gomobile tool.
@@ -26,19 +29,31 @@
 // You can also run the application on your desktop by running the command
 // below. (Note: It currently doesn't work on Windows.)
 //   $ go install golang.org/x/mobile/example/basic && basic
+//
+// The frame rate is limited to 30 frames per second by default
+// to save battery. Because mobile apps can't easily be given
+// flags, the limit is set at build time; for example to allow
+// 60 frames per second:
+//
+//   $ gomobile build -ldflags '-X main.maxFPS=60' github.com/rogpeppe/misc/mobile/basic
+//
+// Setting maxFPS to 0 paints as fast as possible.
 package main
 
 import (
 	"encoding/binary"
 	"log"
 	"math"
+	"strconv"
 	"sync"
 	"time"
 
 	"golang.org/x/mobile/app"
+	"golang.org/x/mobile/event/key"
 	"golang.org/x/mobile/event/lifecycle"
 	"golang.org/x/mobile/event/paint"
 	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
 	"golang.org/x/mobile/exp/app/debug"
 	"golang.org/x/mobile/exp/f32"
 	"golang.org/x/mobile/exp/gl/glutil"
@@ -48,6 +63,7 @@ import (
 var (
 	images   *glutil.Images
 	fps      *debug.FPS
+	frameT   *frameTime
 	program  gl.Program
 	position gl.Attrib
 	offset   gl.Uniform
@@ -56,12 +72,51 @@ var (
 
 	green float32
 	state data
+
+	// triangleColor and backgroundColor hold the
+	// current indexes into palette.
+	triangleColor   = 0
+	backgroundColor = 1
 )
 
+// maxFPS holds the maximum number of frames painted per
+// second. It is a string so that it can be set with
+// the linker's -X flag.
+var maxFPS = "30"
+
+// frameTicker returns a ticker that fires at
+// the rate given by maxFPS, or nil if the frame
+// rate is not limited.
+func frameTicker() *time.Ticker {
+	n, err := strconv.Atoi(maxFPS)
+	if err != nil {
+		log.Printf("invalid maxFPS %q", maxFPS)
+		return nil
+	}
+	if n <= 0 {
+		return nil
+	}
+	return time.NewTicker(time.Second / time.Duration(n))
+}
+
+// palette holds the colors that the triangle and
+// background cycle through.
+var palette = []struct {
+	r, g, b float32
+}{
+	{1, 1, 1}, // white
+	{1, 0, 0}, // red
+	{0, 1, 0}, // green
+	{0, 0, 1}, // blue
+	{1, 1, 0}, // yellow
+	{0, 0, 0}, // black
+}
+
 func main() {
 	app.Main(func(a app.App) {
 		var glctx gl.Context
 		var sz size.Event
+		ticker := frameTicker()
 		for e := range a.Events() {
 			switch e := a.Filter(e).(type) {
 			case lifecycle.Event:
@@ -83,7 +138,7 @@ func main() {
 			case paint.Event:
 				if glctx == nil || e.External {
 					// As we are actively painting as fast as
-					// we can (usually 60 FPS), skip any paint
+					// we're allowed to, skip any paint
 					// events sent by the system.
 					continue
 				}
@@ -91,10 +146,40 @@ func main() {
 				onPaint(glctx, sz)
 				a.Publish()
 				// Drive the animation by preparing to paint the next frame
-				// after this one is shown.
-				a.Send(paint.Event{})
-				//			case touch.Event:
-				//				state.setTouch(point{e.X, e.Y})
+				// after this one is shown, waiting for the next tick
+				// if the frame rate is limited.
+				if ticker == nil {
+					a.Send(paint.Event{})
+					continue
+				}
+				go func() {
+					<-ticker.C
+					a.Send(paint.Event{})
+				}()
+			case touch.Event:
+				// Touching the top half of the screen changes the
+				// triangle color; touching the bottom half
+				// changes the background.
+				if e.Type != touch.TypeBegin {
+					continue
+				}
+				if e.Y < float32(sz.HeightPx)/2 {
+					triangleColor = nextColor(triangleColor)
+				} else {
+					backgroundColor = nextColor(backgroundColor)
+				}
+			case key.Event:
+				// On the desktop, the c and b keys change
+				// the triangle and background colors.
+				if e.Direction != key.DirPress {
+					continue
+				}
+				switch e.Rune {
+				case 'c':
+					triangleColor = nextColor(triangleColor)
+				case 'b':
+					backgroundColor = nextColor(backgroundColor)
+				}
 			default:
 				log.Printf("unrecognised event %#v", e)
 			}
@@ -102,6 +187,10 @@ func main() {
 	})
 }
 
+func nextColor(i int) int {
+	return (i + 1) % len(palette)
+}
+
 func touchSetter(initial, size point) {
 	t0 := time.Now()
 	for {
@@ -135,22 +224,26 @@ func onStart(glctx gl.Context) {
 
 	images = glutil.NewImages(glctx)
 	fps = debug.NewFPS(images)
+	frameT = newFrameTime(images)
 }
 
 func onStop(glctx gl.Context) {
 	glctx.DeleteProgram(program)
 	glctx.DeleteBuffer(buf)
 	fps.Release()
+	frameT.Release()
 	images.Release()
 }
 
 func onPaint(glctx gl.Context, sz size.Event) {
-	glctx.ClearColor(1, 0, 0, 1)
+	bg := palette[backgroundColor]
+	glctx.ClearColor(bg.r, bg.g, bg.b, 1)
 	glctx.Clear(gl.COLOR_BUFFER_BIT)
 
 	glctx.UseProgram(program)
 
-	glctx.Uniform4f(color, 1,1,1, 1)
+	fg := palette[triangleColor]
+	glctx.Uniform4f(color, fg.r, fg.g, fg.b, 1)
 
 	touchPt := state.currentTouch()
 	glctx.Uniform2f(offset, touchPt.x/float32(sz.WidthPx), touchPt.y/float32(sz.HeightPx))
@@ -162,6 +255,7 @@ func onPaint(glctx gl.Context, sz size.Event) {
 	glctx.DisableVertexAttribArray(position)
 
 	fps.Draw(sz)
+	frameT.Draw(sz)
 }
 
 var triangleData = f32.Bytes(binary.LittleEndian,
@@ -47,7 +47,7 @@ func (c *Client) SetBrightness(leds Set, level uint8) error {
 			total = 255
 		}
 		buf[0] = byte(i + 1)
-		buf[1] = gamma[total]
+		buf[1] = glow.profile[total]
 		glow.conn.Write(buf)
 	}
 	glow.conn.Write(update)
@@ -12,25 +12,79 @@ import (
 const addr = 0x54 // address is the I2C address of the device.
 
 // PiGlow represents a PiGlow device.
+//
+// A PiGlow is safe to use concurrently from multiple goroutines. The
+// writes made by each method call are sent to the device without being
+// interleaved with those of any other call, so, for example, an
+// animation driven by Run in one goroutine can be interrupted by
+// SetBrightness calls from another without corrupting either.
 type PiGlow struct {
-	conn    *i2c.Device
+	// mu guards clients and profile and serializes
+	// all writes to conn.
 	mu      sync.Mutex
+	conn    *i2c.Device
 	clients []*Client
+	profile BrightnessProfile
+}
+
+// BrightnessProfile maps from the brightness levels passed to
+// SetBrightness to the raw values sent to the device. It can be
+// used to match the output of the LEDs to a preferred perceptual
+// curve.
+type BrightnessProfile [256]byte
+
+// GammaProfile returns the default brightness profile, which
+// applies gamma correction so that changes in level appear
+// roughly uniform.
+func GammaProfile() BrightnessProfile {
+	return gamma
+}
+
+// LinearProfile returns a brightness profile that
+// passes levels through to the device unchanged.
+func LinearProfile() BrightnessProfile {
+	var prof BrightnessProfile
+	for i := range prof {
+		prof[i] = byte(i)
+	}
+	return prof
+}
+
+// SetProfile sets the brightness profile used by subsequent
+// calls to SetBrightness, including those made by clients.
+// LEDs that have already been set are not changed.
+func (p *PiGlow) SetProfile(prof BrightnessProfile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profile = prof
 }
 
 // Reset resets the internal registers
 func (p *PiGlow) Reset() error {
-	return p.conn.Write([]byte{0x17, 0xFF})
+	return p.write([]byte{0x17, 0xFF})
 }
 
 // Shutdown sets the software shutdown mode of the PiGlow
 func (p *PiGlow) Shutdown() error {
-	return p.conn.Write([]byte{0x00, 0x00})
+	return p.write([]byte{0x00, 0x00})
 }
 
 // Enable enables the PiGlow for normal operations
 func (p *PiGlow) Enable() error {
-	return p.conn.Write([]byte{0x00, 0x01})
+	return p.write([]byte{0x00, 0x01})
+}
+
+// write writes all the given messages to the device while
+// holding p.mu, stopping at the first error.
+func (p *PiGlow) write(msgs ...[]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, msg := range msgs {
+		if err := p.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Setup enables normal operations, resets the internal registers, and enables
@@ -62,12 +116,17 @@ func Open(o driver.Opener) (*PiGlow, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PiGlow{conn: conn}, nil
+	return &PiGlow{
+		conn:    conn,
+		profile: gamma,
+	}, nil
 }
 
 // Close frees the underlying resources. It must be called once
 // the PiGlow is no longer in use.
 func (p *PiGlow) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.conn.Close()
 }
 
@@ -92,10 +151,7 @@ func (p *PiGlow) SetLEDControlRegister(register, enables int) error {
 		return fmt.Errorf("%d is an unknown register", register)
 	}
 
-	if err := p.conn.Write([]byte{address, byte(enables)}); err != nil {
-		return err
-	}
-	return p.conn.Write([]byte{0x16, 0xFF})
+	return p.write([]byte{address, byte(enables)}, update)
 }
 
 var update = []byte{0x16, 0xFF}
@@ -106,8 +162,16 @@ func (p *PiGlow) SetBrightness(leds Set, level uint8) error {
 	if leds == 0 {
 		return nil
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.setBrightness(leds, level)
+}
+
+// setBrightness is the internal version of SetBrightness.
+// It must be called with p.mu held.
+func (p *PiGlow) setBrightness(leds Set, level uint8) error {
 	buf := make([]byte, 2)
-	buf[1] = gamma[level]
+	buf[1] = p.profile[level]
 	for i := LED(0); i < NumLEDs; i++ {
 		if leds&(1<<uint(i)) == 0 {
 			continue
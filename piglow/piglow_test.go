@@ -2,9 +2,12 @@ package piglow
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/exp/io/i2c/driver"
 )
@@ -209,6 +212,33 @@ func TestSetLEDBrightness(t *testing.T) {
 	}
 }
 
+func TestSetProfile(t *testing.T) {
+	device, buf := openPiGlow(t)
+	device.SetProfile(LinearProfile())
+	for _, level := range []uint8{0, 1, 100, 255} {
+		buf.Reset()
+		if err := device.SetBrightness(LED(3).LEDs(), level); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, []byte{0x04, level, 0x16, 0xFF}, buf.Bytes())
+	}
+
+	// Clients use the profile too.
+	c := device.Client()
+	buf.Reset()
+	if err := c.SetBrightness(LED(3).LEDs(), 100); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, []byte{0x04, 100, 0x16, 0xFF}, buf.Bytes())
+
+	device.SetProfile(GammaProfile())
+	buf.Reset()
+	if err := device.SetBrightness(LED(3).LEDs(), 100); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, []byte{0x04, gamma[100], 0x16, 0xFF}, buf.Bytes())
+}
+
 func TestReset(t *testing.T) {
 	device, buf := openPiGlow(t)
 	if err := device.Reset(); err != nil {
@@ -277,3 +307,77 @@ func TestSetup(t *testing.T) {
 	}
 	assert(t, got, buf.Bytes())
 }
+
+func TestRun(t *testing.T) {
+	device, buf := openPiGlow(t)
+	frames := []Frame{
+		{0: 255},
+		{1: 255},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := device.Run(ctx, frames, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each frame sets the LEDs in groups of equal level,
+	// ordered by the first LED in each group.
+	frameBytes := func(lit LED) []byte {
+		litBytes := []byte{byte(lit + 1), 0xFF, 0x16, 0xFF}
+		var offBytes []byte
+		for i := LED(0); i < NumLEDs; i++ {
+			if i != lit {
+				offBytes = append(offBytes, byte(i+1), 0)
+			}
+		}
+		offBytes = append(offBytes, 0x16, 0xFF)
+		if lit == 0 {
+			return append(litBytes, offBytes...)
+		}
+		return append(offBytes, litBytes...)
+	}
+	clear := make([]byte, 0, NumLEDs*2+2)
+	for i := LED(0); i < NumLEDs; i++ {
+		clear = append(clear, byte(i+1), 0)
+	}
+	clear = append(clear, 0x16, 0xFF)
+
+	got := buf.Bytes()
+	want0, want1 := frameBytes(0), frameBytes(1)
+	if !bytes.HasPrefix(got, append(want0, want1...)) {
+		t.Fatalf("first frames not written; got %v", got)
+	}
+	if !bytes.HasSuffix(got, clear) {
+		t.Fatalf("LEDs not cleared at end; got %v", got)
+	}
+}
+
+func TestConcurrentSetBrightness(t *testing.T) {
+	device, buf := openPiGlow(t)
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			leds := SetOf(LED(i%int(NumLEDs)), LED((i+1)%int(NumLEDs)))
+			if err := device.SetBrightness(leds, uint8(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Each call should have produced two LED writes
+	// followed by an update, without being interleaved
+	// with any other call.
+	got := buf.Bytes()
+	if len(got) != n*6 {
+		t.Fatalf("unexpected output length %d; want %d", len(got), n*6)
+	}
+	for ; len(got) > 0; got = got[6:] {
+		if got[1] != got[3] || !bytes.Equal(got[4:6], update) {
+			t.Fatalf("interleaved writes in %v", got[:6])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package piglow
+
+import (
+	"context"
+	"time"
+)
+
+// Frame holds the brightness level of each LED in one frame
+// of an animation, indexed by LED. LEDs beyond the end
+// of the slice are turned off.
+type Frame []uint8
+
+// Run shows each of the given frames in sequence, one every interval,
+// repeating from the start when the last frame has been shown.
+// It returns when the context is cancelled, turning off all the LEDs
+// before it does so. It returns nil when the context is cancelled
+// or an error if the PiGlow could not be written to.
+func (p *PiGlow) Run(ctx context.Context, frames []Frame, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		if len(frames) > 0 {
+			if err := p.setFrame(frames[i%len(frames)]); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return p.SetBrightness(allSet, 0)
+		case <-ticker.C:
+		}
+	}
+}
+
+// setFrame sets all the LEDs to the levels in the given frame,
+// setting the brightness once for each distinct level.
+// The whole frame is written atomically with respect
+// to other users of the PiGlow.
+func (p *PiGlow) setFrame(f Frame) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var done Set
+	for i := LED(0); i < NumLEDs; i++ {
+		if done.Has(i) {
+			continue
+		}
+		level := f.level(i)
+		var leds Set
+		for j := i; j < NumLEDs; j++ {
+			if f.level(j) == level {
+				leds = leds.With(j)
+			}
+		}
+		if err := p.setBrightness(leds, level); err != nil {
+			return err
+		}
+		done |= leds
+	}
+	return nil
+}
+
+func (f Frame) level(led LED) uint8 {
+	if int(led) >= len(f) {
+		return 0
+	}
+	return f[led]
+}
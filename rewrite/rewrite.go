@@ -14,6 +14,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -364,11 +365,6 @@ func updateImports(pkg *Package, file *ast.File) *ast.File {
 }
 
 func updateFile(path string, fileNode *ast.File, fset *token.FileSet) (bool, error) {
-	var buf bytes.Buffer
-	err := format.Node(&buf, fset, fileNode)
-	if err != nil {
-		return false, errgo.Notef(err, "cannot format data for %q", path)
-	}
 	f, err := os.OpenFile(path, os.O_RDWR, 0666)
 	if err != nil {
 		return false, errgo.Mask(err)
@@ -379,7 +375,11 @@ func updateFile(path string, fileNode *ast.File, fset *token.FileSet) (bool, err
 	if err != nil {
 		return false, errgo.Mask(err)
 	}
-	if bytes.Equal(buf.Bytes(), oldData) {
+	newData, err := formatFile(fset, fileNode, oldData)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot format data for %q", path)
+	}
+	if bytes.Equal(newData, oldData) {
 		return false, nil
 	}
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
@@ -388,8 +388,181 @@ func updateFile(path string, fileNode *ast.File, fset *token.FileSet) (bool, err
 	if err := f.Truncate(0); err != nil {
 		return false, errgo.Notef(err, "cannot truncate %q", path)
 	}
-	if _, err := f.Write(buf.Bytes()); err != nil {
+	if _, err := f.Write(newData); err != nil {
 		return false, errgo.Notef(err, "cannot write %q", path)
 	}
 	return true, nil
 }
+
+// formatFile formats the given file. The build constraints and cgo
+// preamble found in oldData, the original contents of the file, are
+// preserved verbatim even if the comments holding them have become
+// detached from the syntax tree.
+func formatFile(fset *token.FileSet, fileNode *ast.File, oldData []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, fileNode); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	data := buf.Bytes()
+	data = preserveBuildConstraints(data, oldData)
+	data, err := preserveCgoPreamble(data, cgoPreamble(oldData))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+// buildConstraints returns the offsets of the block of build
+// constraint lines ("//go:build" and "// +build" lines) found
+// before the package clause in data. The block runs from the
+// start of the first constraint line to the end of the last one.
+// It returns -1, -1 if there are no build constraints.
+func buildConstraints(data []byte) (start, end int) {
+	start, end = -1, -1
+	forLeadingLines(data, func(line []byte, offset int) {
+		if isBuildConstraint(line) {
+			if start == -1 {
+				start = offset
+			}
+			end = offset + len(line)
+		}
+	})
+	return start, end
+}
+
+// preserveBuildConstraints makes sure that data holds the build
+// constraint block from oldData verbatim. Any other build constraint
+// lines that data holds before its package clause are removed. The
+// block is put back after the same leading comments that preceded it
+// in oldData if they're still there, or at the top of the file
+// otherwise.
+func preserveBuildConstraints(data, oldData []byte) []byte {
+	oldStart, oldEnd := buildConstraints(oldData)
+	if oldStart == -1 {
+		return data
+	}
+	constraints := oldData[oldStart:oldEnd]
+	if start, end := buildConstraints(data); start != -1 && bytes.Equal(data[start:end], constraints) {
+		return data
+	}
+	var rest bytes.Buffer
+	end := 0
+	forLeadingLines(data, func(line []byte, offset int) {
+		if !isBuildConstraint(line) {
+			rest.Write(line)
+		}
+		end = offset + len(line)
+	})
+	rest.Write(data[end:])
+	prefix := oldData[:oldStart]
+	if !bytes.HasPrefix(rest.Bytes(), prefix) {
+		prefix = nil
+	}
+	var buf bytes.Buffer
+	buf.Write(prefix)
+	buf.Write(constraints)
+	// Build constraints must be followed by a blank line.
+	buf.WriteString("\n")
+	buf.Write(bytes.TrimLeft(rest.Bytes()[len(prefix):], "\n"))
+	return buf.Bytes()
+}
+
+// forLeadingLines calls f for each line (including its
+// trailing newline) that is blank or a line comment,
+// stopping at the first line that is not, which will
+// usually be the package clause.
+func forLeadingLines(data []byte, f func(line []byte, offset int)) {
+	for offset := 0; offset < len(data); {
+		line := data[offset:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i+1]
+		}
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && !bytes.HasPrefix(trimmed, []byte("//")) {
+			return
+		}
+		f(line, offset)
+		offset += len(line)
+	}
+}
+
+func isBuildConstraint(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return bytes.HasPrefix(line, []byte("//go:build ")) ||
+		bytes.HasPrefix(line, []byte("// +build "))
+}
+
+var (
+	importCPat        = regexp.MustCompile(`(?m)^import "C"\n`)
+	groupedImportCPat = regexp.MustCompile(`(?m)^\t"C"\n`)
+	firstImportPat    = regexp.MustCompile(`(?m)^import[ (]`)
+	emptyImportPat    = regexp.MustCompile(`(?m)^import \(\n\)\n\n?`)
+	packagePat        = regexp.MustCompile(`(?m)^package .*\n\n?`)
+)
+
+// cgoPreamble returns the comment that immediately
+// precedes the `import "C"` declaration in data,
+// or nil if there is none.
+func cgoPreamble(data []byte) []byte {
+	loc := importCPat.FindIndex(data)
+	if loc == nil {
+		return nil
+	}
+	// Walk backwards over the comment lines immediately
+	// preceding the import declaration.
+	start := loc[0]
+	if bytes.HasSuffix(data[:start], []byte("*/\n")) {
+		i := bytes.LastIndex(data[:start], []byte("/*"))
+		if i == -1 {
+			return nil
+		}
+		i = bytes.LastIndexByte(data[:i], '\n') + 1
+		return data[i:start]
+	}
+	for start > 0 {
+		i := bytes.LastIndexByte(data[:start-1], '\n') + 1
+		if !bytes.HasPrefix(bytes.TrimSpace(data[i:start]), []byte("//")) {
+			break
+		}
+		start = i
+	}
+	return data[start:loc[0]]
+}
+
+// preserveCgoPreamble makes sure that the `import "C"`
+// declaration in data is immediately preceded by the given
+// preamble, moving the import out of any import group if
+// necessary so that the preamble can be attached to it.
+func preserveCgoPreamble(data, preamble []byte) ([]byte, error) {
+	if len(preamble) == 0 || bytes.Equal(cgoPreamble(data), preamble) {
+		return data, nil
+	}
+	// Remove the preamble from wherever it might have ended up.
+	data = bytes.Replace(data, preamble, nil, 1)
+	if loc := importCPat.FindIndex(data); loc != nil {
+		data = append(data[:loc[0]:loc[0]], data[loc[1]:]...)
+	} else if loc := groupedImportCPat.FindIndex(data); loc != nil {
+		data = append(data[:loc[0]:loc[0]], data[loc[1]:]...)
+		data = emptyImportPat.ReplaceAll(data, nil)
+	}
+	// Insert the import before any other imports, or after
+	// the package clause if there are none.
+	var insert int
+	if loc := firstImportPat.FindIndex(data); loc != nil {
+		insert = loc[0]
+	} else if loc := packagePat.FindIndex(data); loc != nil {
+		insert = loc[1]
+	} else {
+		return nil, errgo.Newf("cannot find package clause")
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:insert])
+	buf.Write(preamble)
+	buf.WriteString("import \"C\"\n\n")
+	buf.Write(data[insert:])
+	newData, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot reformat after restoring cgo preamble")
+	}
+	return newData, nil
+}
@@ -0,0 +1,89 @@
+package rewrite
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rogpeppe/misc/rewrite/apply"
+)
+
+var directiveFiles = []string{
+	"oldbuild.go",
+	"gobuild.go",
+	"cgo.go",
+}
+
+func TestFormatFilePreservesDirectives(t *testing.T) {
+	for _, name := range directiveFiles {
+		oldData, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name, oldData, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file = apply.Apply(file, nil, nil).(*ast.File)
+		ast.SortImports(fset, file)
+		newData, err := formatFile(fset, file, oldData)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(newData, oldData) {
+			t.Errorf("%s: no-op rewrite changed file; got:\n%s\nwant:\n%s", name, newData, oldData)
+		}
+	}
+}
+
+func TestFormatFileRestoresDetachedDirectives(t *testing.T) {
+	for _, name := range directiveFiles {
+		oldData, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name, oldData, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Simulate a rewrite that loses track of the
+		// directive comments by removing them from the tree.
+		comments := file.Comments[:0]
+		for _, g := range file.Comments {
+			if !isDirectiveComment(g) {
+				comments = append(comments, g)
+			}
+		}
+		file.Comments = comments
+		for _, decl := range file.Decls {
+			if decl, ok := decl.(*ast.GenDecl); ok && decl.Doc != nil && isDirectiveComment(decl.Doc) {
+				decl.Doc = nil
+			}
+		}
+		newData, err := formatFile(fset, file, oldData)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(newData, oldData) {
+			t.Errorf("%s: directives not restored; got:\n%s\nwant:\n%s", name, newData, oldData)
+		}
+	}
+}
+
+func isDirectiveComment(g *ast.CommentGroup) bool {
+	for _, c := range g.List {
+		if strings.HasPrefix(c.Text, "//go:build") ||
+			strings.HasPrefix(c.Text, "// +build") ||
+			strings.HasPrefix(c.Text, "// #cgo") {
+			return true
+		}
+	}
+	return false
+}
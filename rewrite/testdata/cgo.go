@@ -0,0 +1,16 @@
+//go:build cgo
+
+package p
+
+// #cgo CFLAGS: -DFOO=1
+// #cgo linux LDFLAGS: -lm
+// #include <math.h>
+import "C"
+
+import "fmt"
+
+// Sqrt returns the square root of x.
+func Sqrt(x float64) float64 {
+	fmt.Println("calling C")
+	return float64(C.sqrt(C.double(x)))
+}
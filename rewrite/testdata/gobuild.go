@@ -0,0 +1,14 @@
+//go:build linux && !appengine
+// +build linux,!appengine
+
+package p
+
+import (
+	"fmt"
+	"os"
+)
+
+// F prints a greeting.
+func F() {
+	fmt.Fprintln(os.Stdout, "hello")
+}
@@ -0,0 +1,13 @@
+// Copyright 2017 Someone. All rights reserved.
+
+// +build linux darwin
+// +build !appengine
+
+// Package p has old-style build constraints.
+package p
+
+import "fmt"
+
+func F() {
+	fmt.Println("hello")
+}
@@ -4,10 +4,16 @@
 // Sample requests can continue to run in the background, so even
 // if a sample isn't retrieved before the deadline expires, useful
 // information can still be obtained.
+//
+// A Sampler is parameterized by the type of its keys, K, and the type of
+// the values it samples, V. StringSampler provides the original
+// string-keyed behaviour with untyped values.
 package sampler
 
 import (
 	"context"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -15,7 +21,7 @@ import (
 )
 
 // Params holds the parameters for the New function.
-type Params struct {
+type Params[K comparable, V any] struct {
 	// Get is used to acquire a value for a given key. The done
 	// channel is closed to indicate that the Get request should
 	// terminate.
@@ -26,39 +32,107 @@ type Params struct {
 	//
 	// Note also that Sampler.Get will not start two concurrent
 	// Get requests for the same key.
-	Get func(done <-chan struct{}, key string) (interface{}, error)
+	Get func(done <-chan struct{}, key K) (V, error)
+
+	// KeyString returns a string that uniquely identifies the
+	// given key. It is used to share concurrent Get requests for
+	// the same key. It may be nil only when K is string, in which
+	// case the key itself is used.
+	KeyString func(key K) string
 
 	// MaxRequestDuration holds the maximum amount of time a request
 	// will run for. If this is zero, a request may block forever.
 	// This may be used to stop anomalously long requests from
 	// stopping others from being started.
 	MaxRequestDuration time.Duration
+
+	// OnUpdate, if non-nil, is called with the new sample whenever
+	// a completed Get request records a sample for the given key,
+	// including requests that complete after Sampler.Get has
+	// returned. This can be used to push updates to consumers
+	// without polling.
+	//
+	// OnUpdate is called without any locks held, so it may call
+	// back into the Sampler, but it may be called from multiple
+	// goroutines concurrently. The sample must not be modified.
+	OnUpdate func(key K, s *Sample[V])
+
+	// MinBackoff holds the initial backoff interval for keys whose
+	// Get requests fail. After a failure, Sampler.Get will not start
+	// a new Get request for the key until a randomly jittered
+	// interval of between half and all of the backoff interval has
+	// elapsed, returning the most recent sample instead. The
+	// interval doubles with each consecutive failure, up to
+	// MaxBackoff, and is reset when a request succeeds.
+	//
+	// If MinBackoff is zero, there is no backoff. GetN is not
+	// subject to backoff, although its results count towards it.
+	MinBackoff time.Duration
+
+	// MaxBackoff holds the maximum backoff interval.
+	// If it is zero, the interval is not limited.
+	MaxBackoff time.Duration
 }
 
+// StringSampler is a Sampler with string keys and values of any type.
+type StringSampler = Sampler[string, interface{}]
+
+// StringParams holds the parameters for a StringSampler.
+type StringParams = Params[string, interface{}]
+
+// StringSample holds a sample acquired by a StringSampler.
+type StringSample = Sample[interface{}]
+
 // New returns a new Sampler using the given parameters.
-func New(p Params) *Sampler {
+func New[K comparable, V any](p Params[K, V]) *Sampler[K, V] {
 	if p.Get == nil {
 		panic("no Get provided")
 	}
-	return &Sampler{
-		p:      p,
-		recent: make(map[string]*Sample),
+	if p.KeyString == nil {
+		var k K
+		if _, ok := any(k).(string); !ok {
+			panic("no KeyString provided")
+		}
+		p.KeyString = func(k K) string {
+			return any(k).(string)
+		}
+	}
+	return &Sampler[K, V]{
+		p:       p,
+		recent:  make(map[K]*Sample[V]),
+		backoff: make(map[K]*backoff),
 	}
 }
 
 // Sampler allows the sampling of a set of meters over time.
-type Sampler struct {
-	p      Params
-	group  singleflight.Group
+type Sampler[K comparable, V any] struct {
+	p     Params[K, V]
+	group singleflight.Group
+
+	// mu guards the fields below it.
 	mu     sync.Mutex
-	recent map[string]*Sample
+	recent map[K]*Sample[V]
+
+	// backoff holds the backoff state for
+	// keys whose most recent request failed.
+	backoff map[K]*backoff
+}
+
+// backoff holds the backoff state for a single key.
+type backoff struct {
+	// failures holds the number of consecutive failures.
+	failures int
+
+	// until holds the time before which no new
+	// request should be started.
+	until time.Time
 }
 
 // Sample holds data that was received at a particular time.
-type Sample struct {
+type Sample[V any] struct {
 	// Value holds the most recently acquired value.
-	// It will be nil if no value has yet been acquired.
-	Value interface{}
+	// It will be the zero value if no value has yet been acquired.
+	Value V
 	// Time holds the time that the value was acquired.
 	// It will be zero if no value has yet been acquired.
 	Time time.Time
@@ -71,9 +145,9 @@ type Sample struct {
 	ErrorTime time.Time
 }
 
-type result struct {
+type result[V any] struct {
 	index  int
-	sample *Sample
+	sample *Sample[V]
 }
 
 // Get tries to acquire a sample for all the given keys. If the context
@@ -84,12 +158,12 @@ type result struct {
 // an key.
 //
 // Get may be called concurrently.
-func (sampler *Sampler) Get(ctx context.Context, keys ...string) []*Sample {
-	results := make(chan result, len(keys))
+func (sampler *Sampler[K, V]) Get(ctx context.Context, keys ...K) []*Sample[V] {
+	results := make(chan result[V], len(keys))
 	for i, key := range keys {
 		go sampler.sendResult(ctx, i, key, results)
 	}
-	samples := make([]*Sample, len(keys))
+	samples := make([]*Sample[V], len(keys))
 	numSamples := 0
 	for numSamples < len(samples) {
 		select {
@@ -111,41 +185,197 @@ func (sampler *Sampler) Get(ctx context.Context, keys ...string) []*Sample {
 	return samples
 }
 
-func (sampler *Sampler) sendResult(ctx context.Context, index int, key string, results chan<- result) {
-	s := sampler.getOne(ctx, key)
-	if s == nil {
-		sampler.mu.Lock()
-		defer sampler.mu.Unlock()
-		s0 := sampler.recent[key]
-		if s.Error == nil || s0 == nil {
-			sampler.recent[key] = s
-		} else {
-			// Maintain the most recent encountered error.
-			s0.Error = s.Error
-			s0.ErrorTime = s.Time
-			s = s0
+// GetN is like Get except that it acquires up to n samples for a
+// single key, running n concurrent Get requests. Unlike Get, the
+// requests are not shared with any other concurrent requests for the
+// same key. This can be useful for noisy sources where the caller
+// wants to take an average or median of several readings.
+//
+// Each underlying request is limited to MaxRequestDuration. If the
+// context is cancelled, GetN returns immediately with the samples
+// acquired so far. Only samples that were successfully acquired are
+// returned.
+//
+// Each sample is recorded as the most recent value for the key as
+// it completes, including those that complete after GetN has
+// returned, so the value returned by a later Get for the key
+// after the context is cancelled will be the last one to complete.
+//
+// GetN returns nil if n is not positive.
+func (sampler *Sampler[K, V]) GetN(ctx context.Context, n int, key K) []*Sample[V] {
+	if n <= 0 {
+		return nil
+	}
+	results := make(chan *Sample[V], n)
+	for i := 0; i < n; i++ {
+		go func() {
+			s := sampler.getUnshared(key)
+			if s != nil {
+				sampler.record(key, s)
+			}
+			results <- s
+		}()
+	}
+	var samples []*Sample[V]
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			return samples
+		case s := <-results:
+			if s != nil && s.Error == nil {
+				samples = append(samples, s)
+			}
 		}
 	}
-	results <- result{
+	return samples
+}
+
+func (sampler *Sampler[K, V]) sendResult(ctx context.Context, index int, key K, results chan<- result[V]) {
+	s, ok := sampler.backingOff(key)
+	if !ok {
+		s = sampler.getOne(ctx, key)
+	}
+	results <- result[V]{
 		index:  index,
 		sample: s,
 	}
 }
 
-func (sampler *Sampler) getOne(ctx context.Context, key string) *Sample {
+// record records s as the most recent sample for the given key,
+// calls OnUpdate if set and returns the sample that should be reported
+// to the caller.
+func (sampler *Sampler[K, V]) record(key K, s *Sample[V]) *Sample[V] {
+	s = sampler.store(key, s)
+	if sampler.p.OnUpdate != nil {
+		sampler.p.OnUpdate(key, s)
+	}
+	return s
+}
+
+// backingOff reports whether no new request should be started for
+// the given key because of recent failures. If so, it also returns
+// the most recent sample for the key.
+func (sampler *Sampler[K, V]) backingOff(key K) (*Sample[V], bool) {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	b := sampler.backoff[key]
+	if b == nil || !time.Now().Before(b.until) {
+		return nil, false
+	}
+	return sampler.recent[key], true
+}
+
+// updateBackoff updates the backoff state for the given key
+// after the sample s has been acquired. It must be called
+// with sampler.mu held.
+func (sampler *Sampler[K, V]) updateBackoff(key K, s *Sample[V]) {
+	if sampler.p.MinBackoff <= 0 {
+		return
+	}
+	if s.Error == nil {
+		delete(sampler.backoff, key)
+		return
+	}
+	b := sampler.backoff[key]
+	if b == nil {
+		b = new(backoff)
+		sampler.backoff[key] = b
+	}
+	b.failures++
+	maxBackoff := sampler.p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = math.MaxInt64 / 2
+	}
+	d := sampler.p.MinBackoff
+	for i := 1; i < b.failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	// Add jitter so that requests for keys that
+	// failed together don't stay in lock step.
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	b.until = s.Time.Add(d)
+}
+
+// store stores s as the most recent sample for the given key
+// and returns the sample that was stored.
+func (sampler *Sampler[K, V]) store(key K, s *Sample[V]) *Sample[V] {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	sampler.updateBackoff(key, s)
+	s0 := sampler.recent[key]
+	if s.Error == nil || s0 == nil {
+		sampler.recent[key] = s
+		return s
+	}
+	// Maintain the most recent encountered error. We make a
+	// copy because s0 may already have been returned to a caller.
+	s1 := *s0
+	s1.Error = s.Error
+	s1.ErrorTime = s.Time
+	sampler.recent[key] = &s1
+	return &s1
+}
+
+// getUnshared is like getOne except that it always starts a new
+// request rather than sharing an existing one for the same key.
+// It returns nil if the request takes longer than MaxRequestDuration.
+func (sampler *Sampler[K, V]) getUnshared(key K) *Sample[V] {
+	done := make(chan struct{})
+	defer close(done)
+	rc := make(chan *Sample[V], 1)
+	go func() {
+		val, err := sampler.p.Get(done, key)
+		rc <- &Sample[V]{
+			Time:  time.Now(),
+			Value: val,
+			Error: err,
+		}
+	}()
+	var expiry <-chan time.Time
+	if sampler.p.MaxRequestDuration > 0 {
+		timer := time.NewTimer(sampler.p.MaxRequestDuration)
+		defer timer.Stop()
+		expiry = timer.C
+	}
+	select {
+	case s := <-rc:
+		return s
+	case <-expiry:
+		return nil
+	}
+}
+
+// getOne acquires a sample for the given key, sharing the request
+// with any other concurrent callers for the same key. The sample is
+// recorded once for each completed request, however many callers
+// share it, and the recorded sample is returned to all of them.
+// It returns nil if the request takes longer than MaxRequestDuration.
+func (sampler *Sampler[K, V]) getOne(ctx context.Context, key K) *Sample[V] {
 	done := make(chan struct{})
 	defer close(done)
-	rc := sampler.group.DoChan(key, func() (interface{}, error) {
+	skey := sampler.p.KeyString(key)
+	rc := sampler.group.DoChan(skey, func() (interface{}, error) {
 		// TODO it might be nice to pass a context to Get so that
 		// we can cancel it when MaxRequestDuration expires,
 		// but should we create one from context.Background
 		// or derive it from ctx but with an extended deadline?
 		val, err := sampler.p.Get(done, key)
-		return &Sample{
+		select {
+		case <-done:
+			// The caller that started the request has given up
+			// on it, so the result (probably an error caused
+			// by closing done) isn't worth recording.
+			return (*Sample[V])(nil), nil
+		default:
+		}
+		return sampler.record(key, &Sample[V]{
 			Time:  time.Now(),
 			Value: val,
 			Error: err,
-		}, nil
+		}), nil
 	})
 	var expiry <-chan time.Time
 	if sampler.p.MaxRequestDuration > 0 {
@@ -155,7 +385,7 @@ func (sampler *Sampler) getOne(ctx context.Context, key string) *Sample {
 	}
 	select {
 	case r := <-rc:
-		return r.Val.(*Sample)
+		return r.Val.(*Sample[V])
 	case <-expiry:
 		// It's possible that in between timing out and calling Forget,
 		// the sample completes and a new poll request is launched
@@ -163,7 +393,7 @@ func (sampler *Sampler) getOne(ctx context.Context, key string) *Sample {
 		// that should only mean that we might have two concurrent requests
 		// that might both set their result values at a similar time,
 		// no biggy.
-		sampler.group.Forget(key)
+		sampler.group.Forget(skey)
 		return nil
 	}
 }
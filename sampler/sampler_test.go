@@ -0,0 +1,210 @@
+package sampler_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rogpeppe/misc/sampler"
+)
+
+func TestBackoff(t *testing.T) {
+	var calls int64
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			atomic.AddInt64(&calls, 1)
+			return nil, errors.New("always fails")
+		},
+		MinBackoff: 5 * time.Millisecond,
+		MaxBackoff: time.Second,
+	})
+	const n = 100
+	for i := 0; i < n; i++ {
+		samples := s.Get(context.Background(), "k")
+		if samples[0] == nil || samples[0].Error == nil {
+			t.Fatalf("call %d: expected error sample, got %#v", i, samples[0])
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The backoff intervals double from 5ms, so in the 100ms or
+	// so taken by the loop, there should only be a handful of calls.
+	if got := atomic.LoadInt64(&calls); got < 2 || got > n/5 {
+		t.Fatalf("unexpected number of Get calls; got %d", got)
+	}
+}
+
+func TestBackoffResetOnSuccess(t *testing.T) {
+	fail := int32(1)
+	var calls int64
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			atomic.AddInt64(&calls, 1)
+			if atomic.LoadInt32(&fail) != 0 {
+				return nil, errors.New("failure")
+			}
+			return "ok", nil
+		},
+		MinBackoff: time.Hour,
+	})
+	s.Get(context.Background(), "k")
+	// While backing off, the cached error is returned
+	// without calling Get again.
+	samples := s.Get(context.Background(), "k")
+	if samples[0] == nil || samples[0].Error == nil {
+		t.Fatalf("expected error sample, got %#v", samples[0])
+	}
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("unexpected number of Get calls; got %d want 1", got)
+	}
+	// A success from GetN resets the backoff.
+	atomic.StoreInt32(&fail, 0)
+	s.GetN(context.Background(), 1, "k")
+	samples = s.Get(context.Background(), "k")
+	if samples[0] == nil || samples[0].Error != nil || samples[0].Value != "ok" {
+		t.Fatalf("expected successful sample, got %#v", samples[0])
+	}
+	if got := atomic.LoadInt64(&calls); got != 3 {
+		t.Fatalf("unexpected number of Get calls; got %d want 3", got)
+	}
+}
+
+func TestOnUpdateOncePerFetch(t *testing.T) {
+	var calls, updates int64
+	release := make(chan struct{})
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			atomic.AddInt64(&calls, 1)
+			<-release
+			return "value", nil
+		},
+		OnUpdate: func(key string, s *sampler.StringSample) {
+			atomic.AddInt64(&updates, 1)
+		},
+	})
+	const n = 5
+	var wg sync.WaitGroup
+	samples := make([]*sampler.StringSample, n)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			samples[i] = s.Get(context.Background(), "k")[0]
+		}()
+	}
+	// Give all the callers time to join the same request.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("unexpected number of Get calls; got %d want 1", got)
+	}
+	if got := atomic.LoadInt64(&updates); got != 1 {
+		t.Fatalf("unexpected number of OnUpdate calls; got %d want 1", got)
+	}
+	for i, sample := range samples {
+		if sample == nil || sample != samples[0] || sample.Value != "value" {
+			t.Fatalf("unexpected sample %d: %#v", i, sample)
+		}
+	}
+
+	// A subsequent fetch causes another update.
+	s.Get(context.Background(), "k")
+	if got := atomic.LoadInt64(&updates); got != 2 {
+		t.Fatalf("unexpected number of OnUpdate calls; got %d want 2", got)
+	}
+}
+
+func TestGetNNonPositive(t *testing.T) {
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			t.Errorf("Get called")
+			return nil, nil
+		},
+	})
+	for _, n := range []int{0, -1} {
+		if samples := s.GetN(context.Background(), n, "k"); samples != nil {
+			t.Errorf("GetN(%d) returned %#v", n, samples)
+		}
+	}
+}
+
+func TestGetNConcurrent(t *testing.T) {
+	const n = 4
+	var started sync.WaitGroup
+	started.Add(n)
+	var calls int64
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			i := atomic.AddInt64(&calls, 1)
+			// Don't return until all the requests have started,
+			// which will only happen if they run concurrently.
+			started.Done()
+			started.Wait()
+			if i == 1 {
+				return nil, errors.New("failure")
+			}
+			return i, nil
+		},
+	})
+	samples := s.GetN(context.Background(), n, "k")
+	if len(samples) != n-1 {
+		t.Fatalf("unexpected sample count; got %d want %d", len(samples), n-1)
+	}
+	for _, sample := range samples {
+		if sample.Error != nil {
+			t.Errorf("unexpected error sample %#v", sample)
+		}
+	}
+}
+
+func TestGetNMaxRequestDuration(t *testing.T) {
+	var calls int64
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			if atomic.AddInt64(&calls, 1) == 1 {
+				<-done
+				return nil, errors.New("abandoned")
+			}
+			return "ok", nil
+		},
+		MaxRequestDuration: 20 * time.Millisecond,
+	})
+	samples := s.GetN(context.Background(), 3, "k")
+	if len(samples) != 2 {
+		t.Fatalf("unexpected sample count; got %d want 2", len(samples))
+	}
+	for _, sample := range samples {
+		if sample.Value != "ok" {
+			t.Errorf("unexpected sample %#v", sample)
+		}
+	}
+}
+
+func TestGetNCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var calls int64
+	s := sampler.New(sampler.StringParams{
+		Get: func(done <-chan struct{}, key string) (interface{}, error) {
+			if atomic.AddInt64(&calls, 1) == 1 {
+				return "fast", nil
+			}
+			<-release
+			return "slow", nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	samples := s.GetN(ctx, 3, "k")
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("GetN took too long (%v)", d)
+	}
+	if len(samples) != 1 || samples[0].Value != "fast" {
+		t.Fatalf("unexpected samples %#v", samples)
+	}
+}